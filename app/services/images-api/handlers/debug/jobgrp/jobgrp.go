@@ -0,0 +1,131 @@
+// Package jobgrp maintains the group of handlers for managing background jobs.
+package jobgrp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"go.uber.org/zap"
+)
+
+// Handlers manages the set of job endpoints. Like the other debug endpoints
+// they are only served on the debug host.
+type Handlers struct {
+	Log  *zap.SugaredLogger
+	Jobs jobs.Store
+}
+
+// Query lists the most recent jobs, filtered by the queue and status
+// parameters. The limit parameter sets how many are listed.
+func (h Handlers) Query(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respond(w, r, http.StatusMethodNotAllowed, errorResponse("method not allowed"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var filter jobs.QueryFilter
+	if queue := r.URL.Query().Get("queue"); queue != "" {
+		filter.Queue = &queue
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter.Status = &status
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			h.respond(w, r, http.StatusBadRequest, errorResponse("limit must be between 1 and 500"))
+			return
+		}
+		limit = n
+	}
+
+	found, err := h.Jobs.Query(ctx, filter, limit)
+	if err != nil {
+		h.Log.Errorw("jobs", "status", "querying jobs", "ERROR", err)
+		h.respond(w, r, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, found)
+}
+
+// Manage retries or cancels the job named in the path, which has the form
+// /debug/jobs/{id}/retry or /debug/jobs/{id}/cancel.
+func (h Handlers) Manage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.respond(w, r, http.StatusMethodNotAllowed, errorResponse("method not allowed"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/debug/jobs/"), "/")
+
+	var job jobs.Job
+	var err error
+	switch action {
+	case "retry":
+		job, err = h.Jobs.Retry(ctx, id, time.Now().UTC())
+	case "cancel":
+		job, err = h.Jobs.Cancel(ctx, id, time.Now().UTC())
+	default:
+		h.respond(w, r, http.StatusNotFound, errorResponse("unknown action"))
+		return
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrInvalidID):
+			h.respond(w, r, http.StatusBadRequest, errorResponse(err.Error()))
+		case errors.Is(err, jobs.ErrNotFound):
+			h.respond(w, r, http.StatusNotFound, errorResponse(err.Error()))
+		case errors.Is(err, jobs.ErrNotRetryable), errors.Is(err, jobs.ErrNotCancellable):
+			h.respond(w, r, http.StatusConflict, errorResponse(err.Error()))
+		default:
+			h.Log.Errorw("jobs", "status", action+" job", "id", id, "ERROR", err)
+			h.respond(w, r, http.StatusInternalServerError, errorResponse("internal error"))
+		}
+		return
+	}
+
+	h.respond(w, r, http.StatusOK, job)
+}
+
+// respond writes the data as JSON and logs the request.
+func (h Handlers) respond(w http.ResponseWriter, r *http.Request, statusCode int, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		h.Log.Errorw("jobs", "ERROR", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(jsonData); err != nil {
+		h.Log.Errorw("jobs", "ERROR", err)
+	}
+
+	h.Log.Infow("jobs", "statusCode", statusCode, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
+}
+
+// errorResponse is the body of a failed request.
+func errorResponse(msg string) any {
+	return struct {
+		Error string `json:"error"`
+	}{
+		Error: msg,
+	}
+}
@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"time"
 
 	"github.com/fadhilijuma/images/app/services/images-api/handlers/debug/checkgrp"
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/debug/jobgrp"
 	v1 "github.com/fadhilijuma/images/app/services/images-api/handlers/v1"
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/webhook"
+	"github.com/fadhilijuma/images/business/sys/jobs"
 	"github.com/fadhilijuma/images/business/web/v1/mid"
 	"github.com/fadhilijuma/images/foundation/web"
 	"github.com/jmoiron/sqlx"
@@ -33,7 +38,26 @@ type APIMuxConfig struct {
 	Shutdown chan os.Signal
 	Log      *zap.SugaredLogger
 	//Auth     *auth.Auth
-	DB *sqlx.DB
+	DB               *sqlx.DB
+	Blobs            image.BlobStore
+	Presets          []image.Preset
+	TransformCache   image.TransformCache
+	TransformLimits  image.TransformLimits
+	EmbedEditorial   bool
+	Similarity       image.Similarity
+	Sharing          image.Sharing
+	Quotas           image.Quotas
+	Fetcher          image.Fetcher
+	Webhooks         webhook.Core
+	UploadDir        string
+	UploadMaxSize    int64
+	UploadTTL        time.Duration
+	StreamPoll       time.Duration
+	StreamHeartbeat  time.Duration
+	StreamDuration   time.Duration
+	IdempotencyTTL   time.Duration
+	IdempotencyLease time.Duration
+	IdempotencyBody  int64
 }
 
 // APIMux constructs a http.Handler with all application routes defined.
@@ -78,7 +102,26 @@ func APIMux(cfg APIMuxConfig, options ...func(opts *Options)) http.Handler {
 	v1.Routes(app, v1.Config{
 		Log: cfg.Log,
 		//Auth: cfg.Auth,
-		DB: cfg.DB,
+		DB:               cfg.DB,
+		Blobs:            cfg.Blobs,
+		Presets:          cfg.Presets,
+		TransformCache:   cfg.TransformCache,
+		TransformLimits:  cfg.TransformLimits,
+		EmbedEditorial:   cfg.EmbedEditorial,
+		Similarity:       cfg.Similarity,
+		Sharing:          cfg.Sharing,
+		Quotas:           cfg.Quotas,
+		Fetcher:          cfg.Fetcher,
+		Webhooks:         cfg.Webhooks,
+		UploadDir:        cfg.UploadDir,
+		UploadMaxSize:    cfg.UploadMaxSize,
+		UploadTTL:        cfg.UploadTTL,
+		StreamPoll:       cfg.StreamPoll,
+		StreamHeartbeat:  cfg.StreamHeartbeat,
+		StreamDuration:   cfg.StreamDuration,
+		IdempotencyTTL:   cfg.IdempotencyTTL,
+		IdempotencyLease: cfg.IdempotencyLease,
+		IdempotencyBody:  cfg.IdempotencyBody,
 	})
 
 	return app
@@ -118,5 +161,13 @@ func DebugMux(build string, log *zap.SugaredLogger, db *sqlx.DB) http.Handler {
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
 
+	// Register debug job management endpoints.
+	jgh := jobgrp.Handlers{
+		Log:  log,
+		Jobs: jobs.NewStore(log, db),
+	}
+	mux.HandleFunc("/debug/jobs", jgh.Query)
+	mux.HandleFunc("/debug/jobs/", jgh.Manage)
+
 	return mux
 }
@@ -0,0 +1,46 @@
+// Package auditgrp maintains the group of handlers for audit log access.
+package auditgrp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Handlers manages the set of audit log endpoints.
+type Handlers struct {
+	Audit audit.Core
+}
+
+// Query returns a page of the audit log, newest first, optionally only the
+// entries of an actor, of an entity and within a time range.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+
+	entries, next, err := h.Audit.QueryPage(ctx, filter, page)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query the audit log: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, entries, next), http.StatusOK)
+}
@@ -0,0 +1,55 @@
+package auditgrp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// parseFilter reads the audit log query filter from the query string.
+func parseFilter(r *http.Request) (audit.QueryFilter, error) {
+	values := r.URL.Query()
+
+	var filter audit.QueryFilter
+	var fields validate.FieldErrors
+
+	if v := values.Get("actor_id"); v != "" {
+		filter.ActorID = &v
+	}
+	if v := values.Get("entity"); v != "" {
+		filter.Entity = &v
+	}
+	if v := values.Get("entity_id"); v != "" {
+		filter.EntityID = &v
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	} {
+		v := values.Get(param.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: param.name, Error: fmt.Sprintf("invalid RFC 3339 time %q", v)})
+			continue
+		}
+		t = t.UTC()
+		*param.dest = &t
+	}
+
+	if len(fields) > 0 {
+		return audit.QueryFilter{}, fields
+	}
+
+	return filter, nil
+}
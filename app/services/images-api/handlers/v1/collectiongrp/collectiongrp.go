@@ -0,0 +1,407 @@
+// Package collectiongrp maintains the group of handlers for collection access.
+package collectiongrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fadhilijuma/images/business/core/collection"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/business/web/auth"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Handlers manages the set of Collection endpoints.
+type Handlers struct {
+	Collection collection.Core
+}
+
+// Create adds a new Collection owned by the authenticated user.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var nc collection.NewCollection
+	if err := web.Decode(r, &nc); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+	nc.UserID = claims.Subject
+
+	col, err := h.Collection.Create(ctx, nc, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrUserNotFound):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("creating new collection, nc[%+v]: %w", nc, err)
+		}
+	}
+
+	return web.Respond(ctx, w, col, http.StatusCreated)
+}
+
+// Update modifies a Collection the authenticated user owns.
+func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var uc collection.UpdateCollection
+	if err := web.Decode(r, &uc); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	col, err := h.Collection.Update(ctx, id, uc, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID), errors.Is(err, collection.ErrUserNotFound):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] Collection[%+v]: %w", id, &uc, err)
+		}
+	}
+
+	return web.Respond(ctx, w, col, http.StatusOK)
+}
+
+// Delete removes a Collection the authenticated user owns.
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+
+	col, err := h.Collection.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+
+			// Deleting a collection that doesn't exist leaves the system
+			// in the state the client asked for.
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		default:
+			return fmt.Errorf("querying collection[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking to delete a Collection you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && col.UserID != claims.Subject {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	if err := h.Collection.Delete(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Query returns a page of the Collections the authenticated user owns or has
+// been shared. Admins see every collection, and access=public lists the
+// public collections of every user.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	var filter collection.QueryFilter
+	if v := r.URL.Query().Get("access"); v != "" {
+		filter.Access = &v
+	}
+
+	// If you are not an admin you only see the collections you can view.
+	if !claims.Authorized(auth.RoleAdmin) && (filter.Access == nil || *filter.Access != collection.AccessPublic) {
+		filter.MemberID = &claims.Subject
+	}
+
+	cols, next, err := h.Collection.QueryPage(ctx, filter, page)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for collections: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, cols, next), http.StatusOK)
+}
+
+// QueryByID returns a Collection the authenticated user can view.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	col, err := h.queryVisible(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, col, http.StatusOK)
+}
+
+// QueryItems returns the images in a Collection in order.
+func (h Handlers) QueryItems(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	viewerID, viewerOrganizationID, err := viewer(ctx)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.Collection.QueryItems(ctx, id, viewerID, viewerOrganizationID)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, items, http.StatusOK)
+}
+
+// AddItems appends images to a Collection the authenticated user owns.
+func (h Handlers) AddItems(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ni collection.NewItems
+	if err := web.Decode(r, &ni); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	// If you are not an admin you can only add the images you can view.
+	viewerID, viewerOrganizationID, err := viewer(ctx)
+	if err != nil {
+		return err
+	}
+	ni.ViewerID = viewerID
+	ni.ViewerOrganizationID = viewerOrganizationID
+
+	items, err := h.Collection.AddItems(ctx, id, ni, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID), errors.Is(err, collection.ErrImageNotFound):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] items[%+v]: %w", id, ni, err)
+		}
+	}
+
+	return web.Respond(ctx, w, items, http.StatusOK)
+}
+
+// RemoveItem removes an image from a Collection the authenticated user owns.
+func (h Handlers) RemoveItem(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	imageID := web.Param(r, "image_id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	if err := h.Collection.RemoveItem(ctx, id, imageID); err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] imageID[%s]: %w", id, imageID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Reorder puts the images of a Collection the authenticated user owns in a
+// new order.
+func (h Handlers) Reorder(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var order collection.Order
+	if err := web.Decode(r, &order); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	items, err := h.Collection.Reorder(ctx, id, order)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID), errors.Is(err, collection.ErrInvalidOrder):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] order[%+v]: %w", id, order, err)
+		}
+	}
+
+	return web.Respond(ctx, w, items, http.StatusOK)
+}
+
+// Download streams a ZIP archive of the original content of the images in a
+// Collection the authenticated user can view.
+func (h Handlers) Download(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	col, err := h.queryVisible(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	viewerID, viewerOrganizationID, err := viewer(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Set the status code for the request logger middleware.
+	web.SetStatusCode(ctx, http.StatusOK)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", col.ID+".zip"))
+	w.WriteHeader(http.StatusOK)
+
+	// The response has started so a failure can only be logged, and the
+	// client sees a truncated archive.
+	if err := h.Collection.WriteZip(ctx, w, id, viewerID, viewerOrganizationID); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// queryVisible finds a Collection, failing unless the authenticated user can
+// view it.
+func (h Handlers) queryVisible(ctx context.Context, id string) (collection.Collection, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return collection.Collection{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	col, err := h.query(ctx, id)
+	if err != nil {
+		return collection.Collection{}, err
+	}
+
+	// If you are not an admin and looking at a Collection you can't view.
+	if !claims.Authorized(auth.RoleAdmin) && !col.VisibleTo(claims.Subject) {
+		return collection.Collection{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return col, nil
+}
+
+// queryOwned finds a Collection, failing unless the authenticated user owns
+// it.
+func (h Handlers) queryOwned(ctx context.Context, id string) (collection.Collection, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return collection.Collection{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	col, err := h.query(ctx, id)
+	if err != nil {
+		return collection.Collection{}, err
+	}
+
+	// If you are not an admin and looking to change a Collection you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && col.UserID != claims.Subject {
+		return collection.Collection{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return col, nil
+}
+
+// query finds a Collection, mapping the errors to responses.
+func (h Handlers) query(ctx context.Context, id string) (collection.Collection, error) {
+	col, err := h.Collection.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, collection.ErrInvalidID):
+			return collection.Collection{}, v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, collection.ErrNotFound):
+			return collection.Collection{}, v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return collection.Collection{}, fmt.Errorf("querying collection[%s]: %w", id, err)
+		}
+	}
+
+	return col, nil
+}
+
+// viewer returns the user and organization the images an authenticated user
+// can view are limited to, or nils for an admin who can view them all.
+func viewer(ctx context.Context) (*string, *string, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return nil, nil, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	if claims.Authorized(auth.RoleAdmin) {
+		return nil, nil, nil
+	}
+
+	var organizationID *string
+	if claims.OrganizationID != "" {
+		organizationID = &claims.OrganizationID
+	}
+
+	return &claims.Subject, organizationID, nil
+}
@@ -0,0 +1,166 @@
+// Package eventgrp maintains the group of handlers for streaming the changes
+// recorded in the events outbox.
+package eventgrp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/business/web/auth"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// ErrInvalidEventID is returned when the event to resume from isn't in its
+// proper form.
+var ErrInvalidEventID = errors.New("last event ID is not in its proper form")
+
+// batchSize is the most events read from the outbox at a time.
+const batchSize = 100
+
+// Handlers manages the set of event endpoints.
+type Handlers struct {
+	Outbox       outbox.Store
+	PollInterval time.Duration
+	Heartbeat    time.Duration
+	Duration     time.Duration
+}
+
+// Stream sends the events recorded from now on as Server-Sent Events, or
+// those after the event named by the Last-Event-ID header when a client
+// resumes. Admins see every event, other users only the events about them and
+// their images. A comment is sent when there's nothing else to send, so
+// proxies don't close the connection. The stream ends after a while, since
+// the server's write timeout would end it anyway, and the client reconnects
+// from the last event it saw.
+func (h Handlers) Stream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	// If you are not an admin you only see the changes about you.
+	var filter outbox.QueryFilter
+	if !claims.Authorized(auth.RoleAdmin) {
+		filter.UserID = &claims.Subject
+	}
+
+	last, err := h.lastEventID(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported by the response writer")
+	}
+
+	// Set the status code for the request logger middleware.
+	web.SetStatusCode(ctx, http.StatusOK)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The response has started so from here on a client that went away is
+	// the only reason writing fails, which leaves nothing to report.
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", h.PollInterval.Milliseconds()); err != nil {
+		return nil
+	}
+	flusher.Flush()
+
+	poll := time.NewTicker(h.PollInterval)
+	defer poll.Stop()
+
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+
+	end := time.NewTimer(h.Duration)
+	defer end.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-end.C:
+			return nil
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+
+		case <-poll.C:
+			for {
+				evts, err := h.Outbox.QueryAfter(ctx, filter, last, batchSize)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("querying events after[%d]: %w", last, err)
+				}
+
+				for _, evt := range evts {
+					if err := send(w, evt); err != nil {
+						return nil
+					}
+					last = evt.ID
+				}
+				flusher.Flush()
+
+				if len(evts) < batchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// =============================================================================
+
+// lastEventID finds the event a client resumes after, from the Last-Event-ID
+// header sent when an EventSource reconnects, or the last_event_id query
+// parameter when it can't be sent. A new stream starts after the last event
+// recorded.
+func (h Handlers) lastEventID(ctx context.Context, r *http.Request) (int64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+
+	if v == "" {
+		last, err := h.Outbox.QueryLastID(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("querying last event: %w", err)
+		}
+		return last, nil
+	}
+
+	last, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || last < 0 {
+		return 0, v1Web.NewRequestError(ErrInvalidEventID, http.StatusBadRequest)
+	}
+
+	return last, nil
+}
+
+// send writes an event in the Server-Sent Events format. The event is
+// encoded on a single line, so it's a single data field.
+func send(w http.ResponseWriter, evt outbox.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Event, data)
+	return err
+}
@@ -0,0 +1,105 @@
+package imagegrp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// parseFilter reads the image query filter from the query string.
+func parseFilter(r *http.Request) (image.QueryFilter, error) {
+	values := r.URL.Query()
+
+	var filter image.QueryFilter
+	var fields validate.FieldErrors
+
+	if v := values.Get("user_id"); v != "" {
+		filter.UserID = &v
+	}
+
+	if v := values.Get("mime_type"); v != "" {
+		filter.MimeType = &v
+	}
+
+	if v := values.Get("format"); v != "" {
+		filter.Format = &v
+	}
+
+	if v := values.Get("camera_make"); v != "" {
+		filter.CameraMake = &v
+	}
+
+	if v := values.Get("camera_model"); v != "" {
+		filter.CameraModel = &v
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **int
+	}{
+		{"min_width", &filter.MinWidth},
+		{"min_height", &filter.MinHeight},
+	} {
+		v := values.Get(param.name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: param.name, Error: fmt.Sprintf("invalid number %q", v)})
+			continue
+		}
+		*param.dest = &n
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"uploaded_after", &filter.UploadedAfter},
+		{"uploaded_before", &filter.UploadedBefore},
+		{"captured_after", &filter.CapturedAfter},
+		{"captured_before", &filter.CapturedBefore},
+	} {
+		v := values.Get(param.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: param.name, Error: fmt.Sprintf("invalid RFC 3339 time %q", v)})
+			continue
+		}
+		t = t.UTC()
+		*param.dest = &t
+	}
+
+	if v := values.Get("has_gps"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: "has_gps", Error: fmt.Sprintf("invalid boolean %q", v)})
+		} else {
+			filter.HasGPS = &b
+		}
+	}
+
+	if tags := values["tag"]; len(tags) > 0 {
+		filter.Tags = tags
+	}
+
+	if v := values.Get("tag_match"); v != "" {
+		filter.TagMatch = &v
+	}
+
+	if len(fields) > 0 {
+		return image.QueryFilter{}, fields
+	}
+
+	return filter, nil
+}
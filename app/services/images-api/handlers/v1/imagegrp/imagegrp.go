@@ -5,12 +5,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/sys/fetch"
+	"github.com/fadhilijuma/images/business/sys/validate"
 	"github.com/fadhilijuma/images/business/web/auth"
 	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/imaging"
 	"github.com/fadhilijuma/images/foundation/web"
 )
 
@@ -19,24 +23,134 @@ type Handlers struct {
 	Image image.Core
 }
 
-// Create adds a new Image to the system.
+// Create adds a new Image to the system from a multipart form upload. The
+// image content is streamed from the form part named "file" and the
+// visibility parameter sets who may view it.
 func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("expecting a multipart form: %w", err), http.StatusBadRequest)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return v1Web.NewRequestError(errors.New("multipart form is missing the file part"), http.StatusBadRequest)
+			}
+			return v1Web.NewRequestError(fmt.Errorf("reading multipart form: %w", err), http.StatusBadRequest)
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		defer part.Close()
+		return h.create(ctx, w, part, r.URL.Query().Get("visibility"))
+	}
+}
+
+// CreateRaw adds a new Image to the system using the request body as the
+// image content and the visibility parameter to set who may view it.
+func (h Handlers) CreateRaw(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return h.create(ctx, w, r.Body, r.URL.Query().Get("visibility"))
+}
+
+// create stores the content as a new Image owned by the authenticated user
+// with the specified visibility, which defaults to private.
+func (h Handlers) create(ctx context.Context, w http.ResponseWriter, content io.Reader, visibility string) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	ni := image.NewImage{
+		UserID:         claims.Subject,
+		OrganizationID: claims.OrganizationID,
+		Visibility:     visibility,
+	}
+
+	img, err := h.Image.Create(ctx, ni, content, v.Now)
+	if err != nil {
+		return createError(w, ni, err)
+	}
+
+	return web.Respond(ctx, w, img, http.StatusCreated)
+}
+
+// CreateFromURL adds a new Image to the system by downloading it from the
+// image_url in the request body. Addresses on the internal network are
+// refused, as are downloads that are too large, too slow or not images.
+func (h Handlers) CreateFromURL(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	v, err := web.GetValues(ctx)
 	if err != nil {
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	var np image.NewImage
-	if err := web.Decode(r, &np); err != nil {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var ni image.NewImage
+	if err := web.Decode(r, &ni); err != nil {
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
-	prod, err := h.Image.Create(ctx, np, v.Now)
+	// The image always belongs to the user asking for it.
+	ni.UserID = claims.Subject
+	ni.OrganizationID = claims.OrganizationID
+
+	img, err := h.Image.CreateFromURL(ctx, ni, v.Now)
 	if err != nil {
-		return fmt.Errorf("creating new image, np[%+v]: %w", np, err)
+		switch {
+		case errors.Is(err, image.ErrMissingURL),
+			errors.Is(err, fetch.ErrInvalidURL),
+			errors.Is(err, fetch.ErrBlockedAddress),
+			errors.Is(err, fetch.ErrTooManyRedirects):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, fetch.ErrTooLarge):
+			return v1Web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+		case errors.Is(err, fetch.ErrUnsupportedType):
+			return v1Web.NewRequestError(err, http.StatusUnsupportedMediaType)
+		case errors.Is(err, fetch.ErrStatus):
+			return v1Web.NewRequestError(err, http.StatusBadGateway)
+		case errors.Is(err, fetch.ErrTimeout):
+			return v1Web.NewRequestError(err, http.StatusGatewayTimeout)
+		case errors.Is(err, image.ErrFetchDisabled):
+			return v1Web.NewRequestError(err, http.StatusNotImplemented)
+		}
+		return createError(w, ni, err)
 	}
 
-	return web.Respond(ctx, w, prod, http.StatusCreated)
+	return web.Respond(ctx, w, img, http.StatusCreated)
+}
+
+// createError maps the errors from creating an image to responses.
+func createError(w http.ResponseWriter, ni image.NewImage, err error) error {
+	var de *image.DuplicateError
+	var qe *image.QuotaError
+	switch {
+	case validate.IsFieldErrors(err):
+		return err
+	case errors.As(err, &de):
+		w.Header().Set("Location", "/v1/images/"+de.ImageID)
+		return v1Web.NewRequestErrorFields(err, http.StatusConflict, map[string]string{"image_id": de.ImageID})
+	case errors.As(err, &qe):
+		return v1Web.NewRequestErrorFields(err, http.StatusRequestEntityTooLarge, quotaFields(qe))
+	case errors.Is(err, image.ErrUnsupportedType):
+		return v1Web.NewRequestError(err, http.StatusUnsupportedMediaType)
+	case errors.Is(err, image.ErrOwnerNotFound):
+		return v1Web.NewRequestError(err, http.StatusConflict)
+	default:
+		return fmt.Errorf("creating new image, ni[%+v]: %w", ni, err)
+	}
 }
 
 // Update updates an Image in the system.
@@ -56,6 +170,13 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
+	// Clients that send back the ETag they read only update that version of
+	// the image, so they can't overwrite a change they haven't seen.
+	upd.Version, err = v1Web.ParseIfMatch(r)
+	if err != nil {
+		return err
+	}
+
 	id := web.Param(r, "id")
 
 	prd, err := h.Image.QueryByID(ctx, id)
@@ -70,17 +191,24 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	// If you are not an admin and looking to update an Image you don't own.
-	if !claims.Authorized(auth.RoleAdmin) && prd.UserID != claims.Subject {
+	// If you are not an admin and looking to update an Image you don't own,
+	// or to hand one over to someone else.
+	if !claims.Authorized(auth.RoleAdmin) && (prd.UserID != claims.Subject || upd.UserID != nil) {
 		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
 	}
 
-	if err := h.Image.Update(ctx, id, upd, v.Now); err != nil {
+	if err := h.Image.Update(ctx, id, upd, claims.Subject, v.Now); err != nil {
 		switch {
+		case validate.IsFieldErrors(err):
+			return err
 		case errors.Is(err, image.ErrInvalidID):
 			return v1Web.NewRequestError(err, http.StatusBadRequest)
 		case errors.Is(err, image.ErrNotFound):
 			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrConflict):
+			return v1Web.NewRequestError(err, http.StatusPreconditionFailed)
+		case errors.Is(err, image.ErrOwnerNotFound):
+			return v1Web.NewRequestError(err, http.StatusConflict)
 		default:
 			return fmt.Errorf("ID[%s] Product[%+v]: %w", id, &upd, err)
 		}
@@ -89,8 +217,162 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Delete removes an Image from the system.
+// Replace replaces the content of an Image with the request body, keeping
+// its editorial metadata.
+func (h Handlers) Replace(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	// Clients that send back the ETag they read only replace the content of
+	// that version of the image.
+	version, err := v1Web.ParseIfMatch(r)
+	if err != nil {
+		return err
+	}
+
+	id := web.Param(r, "id")
+
+	if err := h.authorize(ctx, claims, id); err != nil {
+		return err
+	}
+
+	img, err := h.Image.Replace(ctx, id, r.Body, version, claims.Subject, v.Now)
+	if err != nil {
+		var qe *image.QuotaError
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrConflict):
+			return v1Web.NewRequestError(err, http.StatusPreconditionFailed)
+		case errors.As(err, &qe):
+			return v1Web.NewRequestErrorFields(err, http.StatusRequestEntityTooLarge, quotaFields(qe))
+		case errors.Is(err, image.ErrUnsupportedType):
+			return v1Web.NewRequestError(err, http.StatusUnsupportedMediaType)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, img, http.StatusOK)
+}
+
+// QueryRevisions returns the revisions of an Image, latest first.
+func (h Handlers) QueryRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	revs, err := h.Image.QueryRevisions(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, revs, http.StatusOK)
+}
+
+// DiffRevisions returns the fields that differ between the two revisions of
+// an Image named by the from and to parameters.
+func (h Handlers) DiffRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	var fields validate.FieldErrors
+	revisions := make([]int, 2)
+	for i, name := range []string{"from", "to"} {
+		value := r.URL.Query().Get(name)
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: name, Error: fmt.Sprintf("invalid revision %q", value)})
+			continue
+		}
+		revisions[i] = n
+	}
+	if len(fields) > 0 {
+		return fields
+	}
+
+	diff, err := h.Image.DiffRevisions(ctx, id, revisions[0], revisions[1])
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidRevision):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound), errors.Is(err, image.ErrRevisionNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] from[%d] to[%d]: %w", id, revisions[0], revisions[1], err)
+		}
+	}
+
+	return web.Respond(ctx, w, diff, http.StatusOK)
+}
+
+// Revert returns an Image to the content and editorial metadata it had at a
+// revision, recording the revert as a new revision.
+func (h Handlers) Revert(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+
+	revision, err := strconv.Atoi(web.Param(r, "revision"))
+	if err != nil {
+		return v1Web.NewRequestError(image.ErrInvalidRevision, http.StatusBadRequest)
+	}
+
+	if err := h.authorize(ctx, claims, id); err != nil {
+		return err
+	}
+
+	img, err := h.Image.Revert(ctx, id, revision, claims.Subject, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidRevision):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound), errors.Is(err, image.ErrRevisionNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] revision[%d]: %w", id, revision, err)
+		}
+	}
+
+	return web.Respond(ctx, w, img, http.StatusOK)
+}
+
+// Delete moves an Image to the trash.
 func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
 	claims, err := auth.GetClaims(ctx)
 	if err != nil {
 		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
@@ -119,7 +401,7 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
 	}
 
-	if err := h.Image.Delete(ctx, id); err != nil {
+	if err := h.Image.Delete(ctx, id, claims.Subject, v.Now); err != nil {
 		switch {
 		case errors.Is(err, image.ErrInvalidID):
 			return v1Web.NewRequestError(err, http.StatusBadRequest)
@@ -131,41 +413,907 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Query returns a list of Images with paging.
+// Restore takes an Image out of the trash.
+func (h Handlers) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	// The body is optional and only admins may give the image to another user.
+	var ri image.RestoreImage
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &ri); err != nil {
+			return fmt.Errorf("unable to decode payload: %w", err)
+		}
+	}
+
+	id := web.Param(r, "id")
+
+	img, err := h.Image.QueryTrashByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("querying image[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking to restore an Image you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && (img.UserID != claims.Subject || ri.UserID != nil) {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	img, err = h.Image.Restore(ctx, id, ri, v.Now)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrOwnerNotFound):
+			return v1Web.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, img, http.StatusOK)
+}
+
+// QueryTrash returns a page of the Images in the trash. Users see the images
+// they uploaded, while admins see every image or those of the user named by
+// the user_id parameter.
+func (h Handlers) QueryTrash(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	var filter image.TrashFilter
+	switch {
+	case !claims.Authorized(auth.RoleAdmin):
+		filter.UserID = &claims.Subject
+	case r.URL.Query().Get("user_id") != "":
+		userID := r.URL.Query().Get("user_id")
+		filter.UserID = &userID
+	}
+
+	images, next, err := h.Image.QueryTrash(ctx, filter, page)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query the trash: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, images, next), http.StatusOK)
+}
+
+// Query returns a page of the Images that match the query string filters.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	page, err := v1Web.ParsePage(r)
 	if err != nil {
-		return v1Web.NewRequestError(fmt.Errorf("invalid page format, page[%s]", page), http.StatusBadRequest)
+		return err
 	}
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+
+	filter, err := parseFilter(r)
 	if err != nil {
-		return v1Web.NewRequestError(fmt.Errorf("invalid rows format, rows[%s]", rows), http.StatusBadRequest)
+		return err
 	}
 
-	products, err := h.Image.Query(ctx, pageNumber, rowsPerPage)
+	if filter.ViewerID, filter.ViewerOrganizationID, err = viewer(ctx); err != nil {
+		return err
+	}
+
+	images, next, err := h.Image.QueryPage(ctx, filter, page)
 	if err != nil {
-		return fmt.Errorf("unable to query for products: %w", err)
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err), errors.Is(err, image.ErrInvalidTag):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for images: %w", err)
+		}
 	}
 
-	return web.Respond(ctx, w, products, http.StatusOK)
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, images, next), http.StatusOK)
 }
 
-// QueryByID returns an Image by its ID.
-func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+// Search returns a page of the images whose editorial text matches the
+// search in the q parameter, most relevant first.
+func (h Handlers) Search(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
+	}
+
+	if filter.ViewerID, filter.ViewerOrganizationID, err = viewer(ctx); err != nil {
+		return err
+	}
+
+	sq := image.SearchQuery{
+		Text:     r.URL.Query().Get("q"),
+		Language: r.URL.Query().Get("lang"),
+	}
+
+	results, next, err := h.Image.Search(ctx, sq, filter, page)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err), errors.Is(err, image.ErrInvalidTag), errors.Is(err, image.ErrInvalidQuery):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to search for images: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, results, next), http.StatusOK)
+}
+
+// Similar returns the images that look like an image, nearest first.
+func (h Handlers) Similar(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sq := image.SimilarQuery{
+		Hash: r.URL.Query().Get("hash"),
+	}
+
+	if v := r.URL.Query().Get("distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return validate.FieldErrors{{Field: "distance", Error: fmt.Sprintf("invalid number %q", v)}}
+		}
+		sq.Distance = &n
+	}
+
+	var err error
+	if sq.ViewerID, sq.ViewerOrganizationID, err = viewer(ctx); err != nil {
+		return err
+	}
+
 	id := web.Param(r, "id")
-	prod, err := h.Image.QueryByID(ctx, id)
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	similar, err := h.Image.Similar(ctx, id, sq)
 	if err != nil {
 		switch {
-		case errors.Is(err, image.ErrInvalidID):
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidDistance):
 			return v1Web.NewRequestError(err, http.StatusBadRequest)
 		case errors.Is(err, image.ErrNotFound):
 			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrNotHashed):
+			return v1Web.NewRequestError(err, http.StatusUnprocessableEntity)
 		default:
 			return fmt.Errorf("ID[%s]: %w", id, err)
 		}
 	}
 
-	return web.Respond(ctx, w, prod, http.StatusOK)
+	return web.Respond(ctx, w, similar, http.StatusOK)
+}
+
+// QueryByID returns an Image the authenticated user can view.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	img, err := h.queryVisible(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", v1Web.ETag(img.Version))
+	return web.Respond(ctx, w, img, http.StatusOK)
+}
+
+// QueryDuplicatePolicy returns how a user's duplicate uploads are handled.
+func (h Handlers) QueryDuplicatePolicy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	userID := web.Param(r, "user_id")
+
+	// If you are not an admin and looking to retrieve someone other than yourself.
+	if !claims.Authorized(auth.RoleAdmin) && claims.Subject != userID {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	dp, err := h.Image.QueryDuplicatePolicy(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("userID[%s]: %w", userID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, dp, http.StatusOK)
+}
+
+// UpdateDuplicatePolicy sets how a user's duplicate uploads are handled.
+func (h Handlers) UpdateDuplicatePolicy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var dp image.DuplicatePolicy
+	if err := web.Decode(r, &dp); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+	dp.UserID = web.Param(r, "user_id")
+
+	if err := h.Image.UpdateDuplicatePolicy(ctx, dp); err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("policy[%+v]: %w", dp, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// QueryUsage returns what a user stores against their quota, along with what
+// their organization stores.
+func (h Handlers) QueryUsage(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+
+	// If you are not an admin and looking to retrieve someone other than yourself.
+	if !claims.Authorized(auth.RoleAdmin) && claims.Subject != id {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	usage, err := h.Image.QueryUsage(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrOwnerNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("userID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, usage, http.StatusOK)
+}
+
+// UpdateQuota sets the limits of a user or organization.
+func (h Handlers) UpdateQuota(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var uq image.UpdateQuota
+	if err := web.Decode(r, &uq); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	scope := web.Param(r, "scope")
+	id := web.Param(r, "id")
+
+	usage, err := h.Image.UpdateQuota(ctx, scope, id, uq, v.Now)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, image.ErrInvalidScope):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("%s[%s]: %w", scope, id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, usage, http.StatusOK)
+}
+
+// Download returns the content of an Image. The response carries a strong
+// ETag and a Last-Modified date so clients and caches can make conditional
+// and range requests.
+func (h Handlers) Download(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	content, err := h.Image.OpenContent(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+	defer content.Close()
+
+	w.Header().Set("ETag", `"`+content.Checksum+`"`)
+
+	return web.RespondContent(ctx, w, r, content, content.MimeType, content.ModTime)
+}
+
+// QueryRenditions returns the renditions that have been made of an Image.
+func (h Handlers) QueryRenditions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	rnds, err := h.Image.QueryRenditions(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, rnds, http.StatusOK)
+}
+
+// Rendition returns the content of a named rendition of an Image, making
+// the rendition first if it doesn't exist yet.
+func (h Handlers) Rendition(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	name := web.Param(r, "name")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	rnd, content, err := h.Image.OpenRendition(ctx, id, name, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound), errors.Is(err, image.ErrUnknownPreset):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrUnsupportedType):
+			return v1Web.NewRequestError(err, http.StatusUnprocessableEntity)
+		default:
+			return fmt.Errorf("ID[%s] name[%s]: %w", id, name, err)
+		}
+	}
+	defer content.Close()
+
+	return web.RespondStream(ctx, w, content, rnd.MimeType, http.StatusOK)
+}
+
+// Transform returns the content of an Image transformed on the fly as
+// described by the w, h, fit, format and q query parameters.
+func (h Handlers) Transform(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	t := image.Transform{
+		Fit:    query.Get("fit"),
+		Format: query.Get("format"),
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *int
+	}{
+		{"w", &t.Width},
+		{"h", &t.Height},
+		{"q", &t.Quality},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return v1Web.NewRequestError(fmt.Errorf("invalid %s format, %s[%s]", param.name, param.name, value), http.StatusBadRequest)
+		}
+		*param.dest = n
+	}
+
+	t, content, err := h.Image.OpenTransform(ctx, id, t)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidTransform), errors.Is(err, image.ErrTransformNotAllowed):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrUnsupportedType):
+			return v1Web.NewRequestError(err, http.StatusUnprocessableEntity)
+		default:
+			return fmt.Errorf("ID[%s] transform[%s]: %w", id, t, err)
+		}
+	}
+	defer content.Close()
+
+	return web.RespondStream(ctx, w, content, imaging.MimeType(t.Format), http.StatusOK)
+}
+
+// CreateShare makes a signed link through which an Image, or one of its
+// renditions, can be downloaded without signing in.
+func (h Handlers) CreateShare(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	// The body is optional since every field has a default.
+	var ns image.NewShare
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &ns); err != nil {
+			return fmt.Errorf("unable to decode payload: %w", err)
+		}
+	}
+
+	id := web.Param(r, "id")
+
+	if err := h.authorize(ctx, claims, id); err != nil {
+		return err
+	}
+
+	share, err := h.Image.CreateShare(ctx, id, ns, claims.Subject, v.Now)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidExpiry), errors.Is(err, image.ErrUnknownPreset):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrShareDisabled):
+			return v1Web.NewRequestError(err, http.StatusNotImplemented)
+		default:
+			return fmt.Errorf("ID[%s] share[%+v]: %w", id, ns, err)
+		}
+	}
+
+	w.Header().Set("Location", "/v1/shares/"+share.Token)
+	return web.Respond(ctx, w, share, http.StatusCreated)
+}
+
+// QueryShares returns the shares of an Image, latest first.
+func (h Handlers) QueryShares(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+
+	if err := h.authorize(ctx, claims, id); err != nil {
+		return err
+	}
+
+	shares, err := h.Image.QueryShares(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, shares, http.StatusOK)
+}
+
+// RevokeShare stops a share of an Image from working.
+func (h Handlers) RevokeShare(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+	shareID := web.Param(r, "share_id")
+
+	if err := h.authorize(ctx, claims, id); err != nil {
+		return err
+	}
+
+	if err := h.Image.RevokeShare(ctx, id, shareID, v.Now); err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrShareNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] shareID[%s]: %w", id, shareID, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Shared returns the content a share token grants access to, which is the
+// original content of one Image or one of its renditions. It's served without
+// authentication since the token is signed, and every request counts as a
+// download.
+func (h Handlers) Shared(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	share, err := h.Image.OpenShare(ctx, web.Param(r, "token"), v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidToken), errors.Is(err, image.ErrShareNotFound):
+			return v1Web.NewRequestError(image.ErrShareNotFound, http.StatusNotFound)
+		case errors.Is(err, image.ErrShareExpired), errors.Is(err, image.ErrShareRevoked), errors.Is(err, image.ErrShareExhausted):
+			return v1Web.NewRequestError(err, http.StatusGone)
+		case errors.Is(err, image.ErrShareDisabled):
+			return v1Web.NewRequestError(err, http.StatusNotImplemented)
+		default:
+			return fmt.Errorf("opening share: %w", err)
+		}
+	}
+
+	// Downloads are counted here, so caches must not answer for us.
+	w.Header().Set("Cache-Control", "no-store")
+
+	if share.Rendition != "" {
+		rnd, content, err := h.Image.OpenRendition(ctx, share.ImageID, share.Rendition, v.Now)
+		if err != nil {
+			switch {
+			case errors.Is(err, image.ErrNotFound), errors.Is(err, image.ErrUnknownPreset):
+				return v1Web.NewRequestError(err, http.StatusNotFound)
+			case errors.Is(err, image.ErrUnsupportedType):
+				return v1Web.NewRequestError(err, http.StatusUnprocessableEntity)
+			default:
+				return fmt.Errorf("ID[%s] name[%s]: %w", share.ImageID, share.Rendition, err)
+			}
+		}
+		defer content.Close()
+
+		return web.RespondStream(ctx, w, content, rnd.MimeType, http.StatusOK)
+	}
+
+	content, err := h.Image.OpenContent(ctx, share.ImageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", share.ImageID, err)
+		}
+	}
+	defer content.Close()
+
+	return web.RespondContent(ctx, w, r, content, content.MimeType, content.ModTime)
+}
+
+// QueryTags returns the tags attached to an Image.
+func (h Handlers) QueryTags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryVisible(ctx, id); err != nil {
+		return err
+	}
+
+	tags, err := h.Image.QueryTags(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, tags, http.StatusOK)
+}
+
+// AddTags attaches tags to an Image, creating the tags that don't exist yet.
+func (h Handlers) AddTags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var nt image.NewTags
+	if err := web.Decode(r, &nt); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+
+	img, err := h.Image.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("querying image[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking to tag an Image you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && img.UserID != claims.Subject {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	tags, err := h.Image.AddTags(ctx, id, nt, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidTag):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s] tags[%+v]: %w", id, nt, err)
+		}
+	}
+
+	return web.Respond(ctx, w, tags, http.StatusOK)
+}
+
+// RemoveTag detaches a tag from an Image.
+func (h Handlers) RemoveTag(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	id := web.Param(r, "id")
+	tag := web.Param(r, "tag")
+
+	img, err := h.Image.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("querying image[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking to untag an Image you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && img.UserID != claims.Subject {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	if err := h.Image.RemoveTag(ctx, id, tag); err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID), errors.Is(err, image.ErrInvalidTag):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s] tag[%s]: %w", id, tag, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// SuggestTags returns the tags in use that start with the prefix query
+// parameter, most used first, for autocomplete.
+func (h Handlers) SuggestTags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	var limit int
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return v1Web.NewRequestError(fmt.Errorf("invalid limit format, limit[%s]", v), http.StatusBadRequest)
+		}
+		limit = n
+	}
+
+	tags, err := h.Image.SuggestTags(ctx, prefix, limit)
+	if err != nil {
+		switch {
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("prefix[%s]: %w", prefix, err)
+		}
+	}
+
+	return web.Respond(ctx, w, tags, http.StatusOK)
+}
+
+// RenameTag changes the name of a tag on every Image it's attached to.
+func (h Handlers) RenameTag(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var rt image.RenameTag
+	if err := web.Decode(r, &rt); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	name := web.Param(r, "name")
+
+	tag, err := h.Image.RenameTag(ctx, name, rt)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidTag):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrTagNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, image.ErrTagExists):
+			return v1Web.NewRequestError(err, http.StatusConflict)
+		default:
+			return fmt.Errorf("name[%s] rename[%+v]: %w", name, rt, err)
+		}
+	}
+
+	return web.Respond(ctx, w, tag, http.StatusOK)
+}
+
+// MergeTags moves the Images of a set of tags onto a single tag and removes
+// the merged tags.
+func (h Handlers) MergeTags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var mt image.MergeTags
+	if err := web.Decode(r, &mt); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	tag, err := h.Image.MergeTags(ctx, mt, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidTag):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrTagNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("merge[%+v]: %w", mt, err)
+		}
+	}
+
+	return web.Respond(ctx, w, tag, http.StatusOK)
+}
+
+// authorize checks the authenticated user may change the Image identified by
+// a given ID, which requires they own it or are an admin.
+func (h Handlers) authorize(ctx context.Context, claims auth.Claims, id string) error {
+	img, err := h.Image.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("querying image[%s]: %w", id, err)
+		}
+	}
+
+	if !claims.Authorized(auth.RoleAdmin) && img.UserID != claims.Subject {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return nil
+}
+
+// queryVisible finds an Image, failing unless the authenticated user can
+// view it.
+func (h Handlers) queryVisible(ctx context.Context, id string) (image.Image, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return image.Image{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	img, err := h.Image.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, image.ErrInvalidID):
+			return image.Image{}, v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, image.ErrNotFound):
+			return image.Image{}, v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return image.Image{}, fmt.Errorf("querying image[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking at an Image you can't view.
+	if !claims.Authorized(auth.RoleAdmin) && !img.VisibleTo(claims.Subject, claims.OrganizationID) {
+		return image.Image{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return img, nil
+}
+
+// viewer returns the user and organization the images found for the
+// authenticated user are restricted to. Admins see every image, so their
+// queries aren't restricted.
+func viewer(ctx context.Context) (*string, *string, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return nil, nil, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	if claims.Authorized(auth.RoleAdmin) {
+		return nil, nil, nil
+	}
+
+	var organizationID *string
+	if claims.OrganizationID != "" {
+		organizationID = &claims.OrganizationID
+	}
+
+	return &claims.Subject, organizationID, nil
+}
+
+// quotaFields describes the quota an upload didn't fit in, for the client to
+// act on.
+func quotaFields(qe *image.QuotaError) map[string]string {
+	return map[string]string{
+		"scope":      qe.Scope,
+		"subject_id": qe.SubjectID,
+		"resource":   qe.Resource,
+		"limit":      strconv.FormatInt(qe.Limit, 10),
+		"used":       strconv.FormatInt(qe.Used, 10),
+	}
 }
@@ -0,0 +1,368 @@
+// Package uploadgrp maintains the group of handlers for resumable uploads,
+// speaking the tus 1.0 protocol with the creation, termination and checksum
+// extensions.
+package uploadgrp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/upload"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/business/web/auth"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Set of values describing the tus protocol spoken.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+)
+
+// StatusChecksumMismatch is the status tus uses for a chunk that doesn't
+// match its checksum.
+const StatusChecksumMismatch = 460
+
+// Handlers manages the set of upload endpoints.
+type Handlers struct {
+	Upload upload.Core
+}
+
+// Options describes the protocol version, extensions and limits supported.
+func (h Handlers) Options(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.Upload.MaxSize(), 10))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(upload.ChecksumAlgorithms, ","))
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Create starts an upload of the length given by the Upload-Length header.
+// The visibility of the image made from it can be set in the Upload-Metadata.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := resumable(w, r); err != nil {
+		return err
+	}
+
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		return v1Web.NewRequestError(errors.New("deferring the upload length is not supported"), http.StatusBadRequest)
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return v1Web.NewRequestError(errors.New("Upload-Length header is not valid"), http.StatusBadRequest)
+	}
+
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("Upload-Metadata header is not valid: %w", err), http.StatusBadRequest)
+	}
+
+	nu := upload.NewUpload{
+		UserID:         claims.Subject,
+		OrganizationID: claims.OrganizationID,
+		Length:         length,
+		Visibility:     metadata["visibility"],
+		Metadata:       metadata,
+	}
+
+	upl, err := h.Upload.Create(ctx, nu, v.Now)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, upload.ErrTooLarge):
+			return v1Web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+		default:
+			return fmt.Errorf("creating upload, nu[%+v]: %w", nu, err)
+		}
+	}
+
+	w.Header().Set("Location", "/v1/uploads/"+upl.ID)
+	return web.Respond(ctx, w, upl, http.StatusCreated)
+}
+
+// QueryByID returns an upload, including the ID of the image made from it
+// once it's complete.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	upl, err := h.queryOwned(ctx, web.Param(r, "id"))
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, upl, http.StatusOK)
+}
+
+// Head reports how much of an upload has been received, so the client knows
+// where to resume.
+func (h Handlers) Head(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := resumable(w, r); err != nil {
+		return err
+	}
+
+	upl, err := h.queryOwned(ctx, web.Param(r, "id"))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upl.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upl.Length, 10))
+	if len(upl.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatMetadata(upl.Metadata))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	// A response to a HEAD request can't carry a body.
+	web.SetStatusCode(ctx, http.StatusOK)
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Write appends the request body to an upload at the offset given by the
+// Upload-Offset header. When the last byte arrives the content is stored as
+// an image, which the Location header points to.
+func (h Handlers) Write(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := resumable(w, r); err != nil {
+		return err
+	}
+
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return v1Web.NewRequestError(errors.New("Content-Type must be application/offset+octet-stream"), http.StatusUnsupportedMediaType)
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return v1Web.NewRequestError(errors.New("Upload-Offset header is not valid"), http.StatusBadRequest)
+	}
+
+	checksum, err := parseChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("Upload-Checksum header is not valid: %w", err), http.StatusBadRequest)
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	upl, err := h.Upload.Write(ctx, id, offset, r.Body, checksum, v.Now)
+	if upl.ID != "" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upl.Offset, 10))
+	}
+	if err != nil {
+		var de *image.DuplicateError
+		var qe *image.QuotaError
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case errors.Is(err, upload.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, upload.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, upload.ErrExpired):
+			return v1Web.NewRequestError(err, http.StatusGone)
+		case errors.Is(err, upload.ErrOffsetMismatch):
+			return v1Web.NewRequestError(err, http.StatusConflict)
+		case errors.Is(err, upload.ErrLocked):
+			return v1Web.NewRequestError(err, http.StatusLocked)
+		case errors.Is(err, upload.ErrTooLarge):
+			return v1Web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+		case errors.Is(err, upload.ErrUnsupportedChecksum):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, upload.ErrChecksumMismatch):
+			return v1Web.NewRequestError(err, StatusChecksumMismatch)
+		case errors.Is(err, upload.ErrInterrupted):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.As(err, &de):
+			w.Header().Set("Location", "/v1/images/"+de.ImageID)
+			return v1Web.NewRequestErrorFields(err, http.StatusConflict, map[string]string{"image_id": de.ImageID})
+		case errors.As(err, &qe):
+			return v1Web.NewRequestErrorFields(err, http.StatusRequestEntityTooLarge, quotaFields(qe))
+		case errors.Is(err, image.ErrUnsupportedType):
+			return v1Web.NewRequestError(err, http.StatusUnsupportedMediaType)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	if upl.ImageID != nil {
+		w.Header().Set("Location", "/v1/images/"+*upl.ImageID)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete stops an upload and removes the content received for it.
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := resumable(w, r); err != nil {
+		return err
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	if err := h.Upload.Delete(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, upload.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, upload.ErrLocked):
+			return v1Web.NewRequestError(err, http.StatusLocked)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// =============================================================================
+
+// queryOwned finds the upload identified by a given ID, provided it's the
+// authenticated user's or they're an admin.
+func (h Handlers) queryOwned(ctx context.Context, id string) (upload.Upload, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return upload.Upload{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	upl, err := h.Upload.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, upload.ErrInvalidID):
+			return upload.Upload{}, v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, upload.ErrNotFound):
+			return upload.Upload{}, v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return upload.Upload{}, fmt.Errorf("querying upload[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking at an upload you didn't start.
+	if !claims.Authorized(auth.RoleAdmin) && upl.UserID != claims.Subject {
+		return upload.Upload{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return upl, nil
+}
+
+// resumable marks the response as speaking tus and turns away requests for a
+// version of the protocol other than the one spoken.
+func resumable(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		return v1Web.NewRequestError(fmt.Errorf("Tus-Resumable must be %s", tusVersion), http.StatusPreconditionFailed)
+	}
+
+	return nil
+}
+
+// parseMetadata decodes the Upload-Metadata header, a comma separated list of
+// keys each followed by a space and its base64 encoded value, if it has one.
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty key")
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// formatMetadata encodes metadata for the Upload-Metadata header, with the
+// keys in order.
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key
+		if metadata[key] != "" {
+			pairs[i] += " " + base64.StdEncoding.EncodeToString([]byte(metadata[key]))
+		}
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// parseChecksum decodes the Upload-Checksum header, the name of the algorithm
+// followed by a space and the base64 encoded checksum. It returns nil when
+// the header is empty.
+func parseChecksum(header string) (*upload.Checksum, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	algorithm, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, errors.New("missing checksum")
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding checksum: %w", err)
+	}
+
+	return &upload.Checksum{Algorithm: algorithm, Sum: sum}, nil
+}
+
+// quotaFields describes the quota the completed upload didn't fit in.
+func quotaFields(qe *image.QuotaError) map[string]string {
+	return map[string]string{
+		"scope":      qe.Scope,
+		"subject_id": qe.SubjectID,
+		"resource":   qe.Resource,
+		"limit":      strconv.FormatInt(qe.Limit, 10),
+		"used":       strconv.FormatInt(qe.Used, 10),
+	}
+}
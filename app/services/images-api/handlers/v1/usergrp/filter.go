@@ -0,0 +1,49 @@
+package usergrp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/user"
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// parseFilter reads the user query filter from the query string.
+func parseFilter(r *http.Request) (user.QueryFilter, error) {
+	values := r.URL.Query()
+
+	var filter user.QueryFilter
+	var fields validate.FieldErrors
+
+	if v := values.Get("role"); v != "" {
+		filter.Role = &v
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+	} {
+		v := values.Get(param.name)
+		if v == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			fields = append(fields, validate.FieldError{Field: param.name, Error: fmt.Sprintf("invalid RFC 3339 time %q", v)})
+			continue
+		}
+		t = t.UTC()
+		*param.dest = &t
+	}
+
+	if len(fields) > 0 {
+		return user.QueryFilter{}, fields
+	}
+
+	return filter, nil
+}
@@ -6,9 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/fadhilijuma/images/business/core/user"
+	"github.com/fadhilijuma/images/business/sys/validate"
 	"github.com/fadhilijuma/images/business/web/auth"
 	v1Web "github.com/fadhilijuma/images/business/web/v1"
 	"github.com/fadhilijuma/images/foundation/web"
@@ -60,6 +60,13 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
+	// Clients that send back the ETag they read only update that version of
+	// the user, so they can't overwrite a change they haven't seen.
+	upd.Version, err = v1Web.ParseIfMatch(r)
+	if err != nil {
+		return err
+	}
+
 	userID := web.Param(r, "id")
 
 	// If you are not an admin and looking to retrieve someone other than yourself.
@@ -73,6 +80,8 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 			return v1Web.NewRequestError(err, http.StatusBadRequest)
 		case errors.Is(err, user.ErrNotFound):
 			return v1Web.NewRequestError(err, http.StatusNotFound)
+		case errors.Is(err, user.ErrConflict):
+			return v1Web.NewRequestError(err, http.StatusPreconditionFailed)
 		default:
 			return fmt.Errorf("ID[%s] User[%+v]: %w", userID, &upd, err)
 		}
@@ -83,6 +92,11 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 // Delete removes a user from the system.
 func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
 	claims, err := auth.GetClaims(ctx)
 	if err != nil {
 		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
@@ -95,7 +109,7 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
 	}
 
-	if err := h.User.Delete(ctx, userID); err != nil {
+	if err := h.User.Delete(ctx, userID, claims.Subject, v.Now); err != nil {
 		switch {
 		case errors.Is(err, user.ErrInvalidID):
 			return v1Web.NewRequestError(err, http.StatusBadRequest)
@@ -107,25 +121,31 @@ func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Req
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// Query returns a list of users with paging.
+// Query returns a page of the users that match the query string filters.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	page, err := v1Web.ParsePage(r)
 	if err != nil {
-		return v1Web.NewRequestError(fmt.Errorf("invalid page format [%s]", page), http.StatusBadRequest)
+		return err
 	}
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+
+	filter, err := parseFilter(r)
 	if err != nil {
-		return v1Web.NewRequestError(fmt.Errorf("invalid rows format [%s]", rows), http.StatusBadRequest)
+		return err
 	}
 
-	users, err := h.User.Query(ctx, pageNumber, rowsPerPage)
+	users, next, err := h.User.QueryPage(ctx, filter, page)
 	if err != nil {
-		return fmt.Errorf("unable to query for users: %w", err)
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for users: %w", err)
+		}
 	}
 
-	return web.Respond(ctx, w, users, http.StatusOK)
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, users, next), http.StatusOK)
 }
 
 // QueryByID returns a user by its ID.
@@ -154,6 +174,7 @@ func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.
 		}
 	}
 
+	w.Header().Set("ETag", v1Web.ETag(usr.Version))
 	return web.Respond(ctx, w, usr, http.StatusOK)
 }
 
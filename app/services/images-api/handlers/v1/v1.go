@@ -4,11 +4,23 @@ package v1
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/auditgrp"
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/collectiongrp"
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/eventgrp"
 	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/imagegrp"
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/uploadgrp"
 	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/usergrp"
+	"github.com/fadhilijuma/images/app/services/images-api/handlers/v1/webhookgrp"
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/core/collection"
 	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/upload"
 	"github.com/fadhilijuma/images/business/core/user"
+	"github.com/fadhilijuma/images/business/core/webhook"
+	"github.com/fadhilijuma/images/business/sys/idempotency"
+	"github.com/fadhilijuma/images/business/sys/outbox"
 	"github.com/fadhilijuma/images/business/web/auth"
 	"github.com/fadhilijuma/images/business/web/v1/mid"
 	"github.com/fadhilijuma/images/foundation/web"
@@ -18,9 +30,28 @@ import (
 
 // Config contains all the mandatory systems required by handlers.
 type Config struct {
-	Log  *zap.SugaredLogger
-	Auth *auth.Auth
-	DB   *sqlx.DB
+	Log              *zap.SugaredLogger
+	Auth             *auth.Auth
+	DB               *sqlx.DB
+	Blobs            image.BlobStore
+	Presets          []image.Preset
+	TransformCache   image.TransformCache
+	TransformLimits  image.TransformLimits
+	EmbedEditorial   bool
+	Similarity       image.Similarity
+	Sharing          image.Sharing
+	Quotas           image.Quotas
+	Fetcher          image.Fetcher
+	Webhooks         webhook.Core
+	UploadDir        string
+	UploadMaxSize    int64
+	UploadTTL        time.Duration
+	StreamPoll       time.Duration
+	StreamHeartbeat  time.Duration
+	StreamDuration   time.Duration
+	IdempotencyTTL   time.Duration
+	IdempotencyLease time.Duration
+	IdempotencyBody  int64
 }
 
 // Routes binds all the version 1 routes.
@@ -30,25 +61,124 @@ func Routes(app *web.App, cfg Config) {
 	authen := mid.Authenticate(cfg.Auth)
 	admin := mid.Authorize(auth.RoleAdmin)
 
+	// Clients retry creates they didn't see the response to, so creates
+	// honor an Idempotency-Key.
+	idem := mid.Idempotency(cfg.Log, idempotency.NewStore(cfg.Log, cfg.DB),
+		cfg.IdempotencyTTL, cfg.IdempotencyLease, cfg.IdempotencyBody)
+
 	// Register user management and authentication endpoints.
 	ugh := usergrp.Handlers{
-		User: user.NewCore(cfg.Log, cfg.DB),
+		User: user.NewCore(cfg.Log, cfg.DB, user.WithNotifier(cfg.Webhooks)),
 		Auth: cfg.Auth,
 	}
 	app.Handle(http.MethodGet, version, "/users/token", ugh.Token)
-	app.Handle(http.MethodGet, version, "/users/:page/:rows", ugh.Query, authen, admin)
+	app.Handle(http.MethodGet, version, "/users", ugh.Query, authen, admin)
 	app.Handle(http.MethodGet, version, "/users/:id", ugh.QueryByID, authen)
-	app.Handle(http.MethodPost, version, "/users", ugh.Create, authen, admin)
+	app.Handle(http.MethodPost, version, "/users", ugh.Create, authen, admin, idem)
 	app.Handle(http.MethodPut, version, "/users/:id", ugh.Update, authen, admin)
 	app.Handle(http.MethodDelete, version, "/users/:id", ugh.Delete, authen, admin)
 
-	// Register image and sale endpoints.
-	pgh := imagegrp.Handlers{
-		Image: image.NewCore(cfg.Log, cfg.DB),
+	// Register image endpoints.
+	imageCore := image.NewCore(cfg.Log, cfg.DB, cfg.Blobs,
+		image.WithPresets(cfg.Presets),
+		image.WithTransformCache(cfg.TransformCache),
+		image.WithTransformLimits(cfg.TransformLimits),
+		image.WithEmbedEditorial(cfg.EmbedEditorial),
+		image.WithSimilarity(cfg.Similarity),
+		image.WithSharing(cfg.Sharing),
+		image.WithQuotas(cfg.Quotas),
+		image.WithFetcher(cfg.Fetcher),
+		image.WithNotifier(cfg.Webhooks),
+	)
+	igh := imagegrp.Handlers{
+		Image: imageCore,
+	}
+	app.Handle(http.MethodGet, version, "/images", igh.Query, authen)
+	app.Handle(http.MethodGet, version, "/images/search", igh.Search, authen)
+	app.Handle(http.MethodGet, version, "/images/:id", igh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/images", igh.Create, authen, idem)
+	app.Handle(http.MethodPost, version, "/images/raw", igh.CreateRaw, authen, idem)
+	app.Handle(http.MethodPost, version, "/images/url", igh.CreateFromURL, authen, idem)
+	app.Handle(http.MethodPut, version, "/images/:id", igh.Update, authen)
+	app.Handle(http.MethodDelete, version, "/images/:id", igh.Delete, authen)
+	app.Handle(http.MethodPost, version, "/images/:id/restore", igh.Restore, authen)
+	app.Handle(http.MethodPut, version, "/images/:id/content", igh.Replace, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/revisions", igh.QueryRevisions, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/revisions/diff", igh.DiffRevisions, authen)
+	app.Handle(http.MethodPost, version, "/images/:id/revisions/:revision/revert", igh.Revert, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/download", igh.Download, authen)
+	app.Handle(http.MethodPost, version, "/images/:id/share", igh.CreateShare, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/shares", igh.QueryShares, authen)
+	app.Handle(http.MethodDelete, version, "/images/:id/shares/:share_id", igh.RevokeShare, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/renditions", igh.QueryRenditions, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/renditions/:name", igh.Rendition, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/transform", igh.Transform, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/similar", igh.Similar, authen)
+	app.Handle(http.MethodGet, version, "/images/:id/tags", igh.QueryTags, authen)
+	app.Handle(http.MethodPost, version, "/images/:id/tags", igh.AddTags, authen)
+	app.Handle(http.MethodDelete, version, "/images/:id/tags/:tag", igh.RemoveTag, authen)
+	app.Handle(http.MethodGet, version, "/images/policies/:user_id", igh.QueryDuplicatePolicy, authen)
+	app.Handle(http.MethodPut, version, "/images/policies/:user_id", igh.UpdateDuplicatePolicy, authen, admin)
+	app.Handle(http.MethodGet, version, "/users/:id/usage", igh.QueryUsage, authen)
+	app.Handle(http.MethodPut, version, "/quotas/:scope/:id", igh.UpdateQuota, authen, admin)
+	app.Handle(http.MethodGet, version, "/shares/:token", igh.Shared)
+	app.Handle(http.MethodGet, version, "/trash", igh.QueryTrash, authen)
+	app.Handle(http.MethodGet, version, "/tags", igh.SuggestTags, authen)
+	app.Handle(http.MethodPost, version, "/tags/merge", igh.MergeTags, authen, admin)
+	app.Handle(http.MethodPut, version, "/tags/:name", igh.RenameTag, authen, admin)
+
+	// Register collection endpoints.
+	cgh := collectiongrp.Handlers{
+		Collection: collection.NewCore(cfg.Log, cfg.DB, imageCore),
+	}
+	app.Handle(http.MethodGet, version, "/collections", cgh.Query, authen)
+	app.Handle(http.MethodGet, version, "/collections/:id", cgh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/collections", cgh.Create, authen)
+	app.Handle(http.MethodPut, version, "/collections/:id", cgh.Update, authen)
+	app.Handle(http.MethodDelete, version, "/collections/:id", cgh.Delete, authen)
+	app.Handle(http.MethodGet, version, "/collections/:id/images", cgh.QueryItems, authen)
+	app.Handle(http.MethodPost, version, "/collections/:id/images", cgh.AddItems, authen)
+	app.Handle(http.MethodPut, version, "/collections/:id/images", cgh.Reorder, authen)
+	app.Handle(http.MethodDelete, version, "/collections/:id/images/:image_id", cgh.RemoveItem, authen)
+	app.Handle(http.MethodGet, version, "/collections/:id/download", cgh.Download, authen)
+
+	// Register resumable upload endpoints.
+	uph := uploadgrp.Handlers{
+		Upload: upload.NewCore(cfg.Log, cfg.DB, imageCore, cfg.UploadDir,
+			upload.WithMaxSize(cfg.UploadMaxSize),
+			upload.WithTTL(cfg.UploadTTL),
+		),
+	}
+	app.Handle(http.MethodOptions, version, "/uploads", uph.Options)
+	app.Handle(http.MethodPost, version, "/uploads", uph.Create, authen)
+	app.Handle(http.MethodGet, version, "/uploads/:id", uph.QueryByID, authen)
+	app.Handle(http.MethodHead, version, "/uploads/:id", uph.Head, authen)
+	app.Handle(http.MethodPatch, version, "/uploads/:id", uph.Write, authen)
+	app.Handle(http.MethodDelete, version, "/uploads/:id", uph.Delete, authen)
+
+	// Register webhook endpoints.
+	wgh := webhookgrp.Handlers{
+		Webhook: cfg.Webhooks,
+	}
+	app.Handle(http.MethodGet, version, "/webhooks", wgh.Query, authen)
+	app.Handle(http.MethodGet, version, "/webhooks/:id", wgh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/webhooks", wgh.Create, authen)
+	app.Handle(http.MethodPut, version, "/webhooks/:id", wgh.Update, authen)
+	app.Handle(http.MethodDelete, version, "/webhooks/:id", wgh.Delete, authen)
+	app.Handle(http.MethodGet, version, "/webhooks/:id/deliveries", wgh.QueryDeliveries, authen)
+
+	// Register event stream endpoints.
+	egh := eventgrp.Handlers{
+		Outbox:       outbox.NewStore(cfg.Log, cfg.DB),
+		PollInterval: cfg.StreamPoll,
+		Heartbeat:    cfg.StreamHeartbeat,
+		Duration:     cfg.StreamDuration,
+	}
+	app.Handle(http.MethodGet, version, "/events/stream", egh.Stream, authen)
+
+	// Register audit log endpoints.
+	agh := auditgrp.Handlers{
+		Audit: audit.NewCore(cfg.Log, cfg.DB),
 	}
-	app.Handle(http.MethodGet, version, "/products/:page/:rows", pgh.Query, authen)
-	app.Handle(http.MethodGet, version, "/products/:id", pgh.QueryByID, authen)
-	app.Handle(http.MethodPost, version, "/products", pgh.Create, authen)
-	app.Handle(http.MethodPut, version, "/products/:id", pgh.Update, authen)
-	app.Handle(http.MethodDelete, version, "/products/:id", pgh.Delete, authen)
+	app.Handle(http.MethodGet, version, "/audit", agh.Query, authen, admin)
 }
@@ -0,0 +1,239 @@
+// Package webhookgrp maintains the group of handlers for webhook access.
+package webhookgrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fadhilijuma/images/business/core/webhook"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/business/web/auth"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Handlers manages the set of webhook endpoints.
+type Handlers struct {
+	Webhook webhook.Core
+}
+
+// Create adds a new Webhook owned by the authenticated user. The response
+// holds the secret deliveries are signed with, which isn't shown again.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var nw webhook.NewWebhook
+	if err := web.Decode(r, &nw); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+	nw.UserID = claims.Subject
+
+	// If you are not an admin and looking to see the changes of every user.
+	if !claims.Authorized(auth.RoleAdmin) && nw.Scope == webhook.ScopeAll {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	wh, err := h.Webhook.Create(ctx, nw, v.Now)
+	if err != nil {
+		return fmt.Errorf("creating new webhook, url[%s]: %w", nw.URL, err)
+	}
+
+	return web.Respond(ctx, w, wh, http.StatusCreated)
+}
+
+// Update modifies a Webhook the authenticated user owns.
+func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	var uw webhook.UpdateWebhook
+	if err := web.Decode(r, &uw); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	// If you are not an admin and looking to see the changes of every user.
+	if !claims.Authorized(auth.RoleAdmin) && uw.Scope != nil && *uw.Scope == webhook.ScopeAll {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	wh, err := h.Webhook.Update(ctx, id, uw, v.Now)
+	if err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, webhook.ErrNotFound):
+			return v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, wh, http.StatusOK)
+}
+
+// Delete removes a Webhook the authenticated user owns, along with its
+// deliveries.
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		var re *v1Web.RequestError
+		if errors.As(err, &re) && re.Status == http.StatusNotFound {
+
+			// Deleting a webhook that doesn't exist leaves the system in
+			// the state the client asked for.
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+		return err
+	}
+
+	if err := h.Webhook.Delete(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("ID[%s]: %w", id, err)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Query returns a page of the Webhooks the authenticated user owns. Admins
+// see every webhook, or those of the user named by user_id.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	var filter webhook.QueryFilter
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		filter.UserID = &v
+	}
+
+	// If you are not an admin you only see your own webhooks.
+	if !claims.Authorized(auth.RoleAdmin) {
+		filter.UserID = &claims.Subject
+	}
+
+	whs, next, err := h.Webhook.QueryPage(ctx, filter, page)
+	if err != nil {
+		switch {
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for webhooks: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, whs, next), http.StatusOK)
+}
+
+// QueryByID returns a Webhook the authenticated user owns.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	wh, err := h.queryOwned(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, wh, http.StatusOK)
+}
+
+// QueryDeliveries returns a page of the deliveries made to a Webhook the
+// authenticated user owns, newest first, optionally only those with the
+// given status.
+func (h Handlers) QueryDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+
+	if _, err := h.queryOwned(ctx, id); err != nil {
+		return err
+	}
+
+	page, err := v1Web.ParsePage(r)
+	if err != nil {
+		return err
+	}
+
+	var filter webhook.DeliveryFilter
+	if v := r.URL.Query().Get("status"); v != "" {
+		filter.Status = &v
+	}
+
+	dlvs, next, err := h.Webhook.QueryDeliveries(ctx, id, filter, page)
+	if err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrInvalidID):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case validate.IsFieldErrors(err):
+			return err
+		case v1Web.IsPagingError(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return fmt.Errorf("unable to query for deliveries: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, v1Web.NewPageDocument(w, r, dlvs, next), http.StatusOK)
+}
+
+// =============================================================================
+
+// queryOwned finds a Webhook, failing unless the authenticated user owns it.
+func (h Handlers) queryOwned(ctx context.Context, id string) (webhook.Webhook, error) {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return webhook.Webhook{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	wh, err := h.Webhook.QueryByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrInvalidID):
+			return webhook.Webhook{}, v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, webhook.ErrNotFound):
+			return webhook.Webhook{}, v1Web.NewRequestError(err, http.StatusNotFound)
+		default:
+			return webhook.Webhook{}, fmt.Errorf("querying webhook[%s]: %w", id, err)
+		}
+	}
+
+	// If you are not an admin and looking at a Webhook you don't own.
+	if !claims.Authorized(auth.RoleAdmin) && wh.UserID != claims.Subject {
+		return webhook.Webhook{}, v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+	}
+
+	return wh, nil
+}
@@ -2,15 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"github.com/ardanlabs/conf/v3"
 	"github.com/fadhilijuma/images/app/services/images-api/handlers"
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/upload"
+	"github.com/fadhilijuma/images/business/core/webhook"
+	"github.com/fadhilijuma/images/business/sys/blob"
 	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/fetch"
+	"github.com/fadhilijuma/images/business/sys/idempotency"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/foundation/diskcache"
 	"github.com/fadhilijuma/images/foundation/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	_ "go.uber.org/automaxprocs"
 	"go.uber.org/automaxprocs/maxprocs"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -75,6 +88,77 @@ func run(log *zap.SugaredLogger) error {
 			MaxOpenConns int    `conf:"default:0"`
 			DisableTLS   bool   `conf:"default:true"`
 		}
+		Storage struct {
+			Root string `conf:"default:/tmp/images"`
+		}
+		Renditions struct {
+			Presets     []string `conf:"default:small:160x160;medium:640x640;large:1280x1280"`
+			Concurrency int      `conf:"default:2,help:images this process makes renditions for at the same time"`
+		}
+		Transform struct {
+			CacheDir      string   `conf:"default:/tmp/images-cache"`
+			CacheMaxBytes int64    `conf:"default:1073741824"`
+			MaxWidth      int      `conf:"default:2048"`
+			MaxHeight     int      `conf:"default:2048"`
+			Allowed       []string `conf:"help:allowed transforms as query strings; any transform within the maximums when empty"`
+		}
+		Metadata struct {
+			EmbedEditorial bool `conf:"default:false,help:write edited captions and credits into the served JPEGs"`
+		}
+		Similarity struct {
+			MaxDistance int           `conf:"default:10,help:largest Hamming distance between the hashes of similar images"`
+			IndexTTL    time.Duration `conf:"default:5m,help:how long the index of image hashes is used before it's rebuilt"`
+		}
+		Share struct {
+			Secret     string        `conf:"mask,help:key share links are signed with; a random key is used when empty so links stop working on restart"`
+			DefaultTTL time.Duration `conf:"default:24h,help:how long a share link lasts when the client doesn't say"`
+			MaxTTL     time.Duration `conf:"default:720h,help:longest a share link may last"`
+		}
+		Quota struct {
+			UserMaxBytes          int64 `conf:"default:0,help:bytes a user may store unless a quota is set for them; no limit when 0"`
+			UserMaxImages         int   `conf:"default:0,help:images a user may store unless a quota is set for them; no limit when 0"`
+			OrganizationMaxBytes  int64 `conf:"default:0,help:bytes an organization may store unless a quota is set for it; no limit when 0"`
+			OrganizationMaxImages int   `conf:"default:0,help:images an organization may store unless a quota is set for it; no limit when 0"`
+		}
+		Upload struct {
+			Dir            string        `conf:"default:/tmp/images-uploads,help:directory the chunks of resumable uploads are kept in until they complete"`
+			MaxSize        int64         `conf:"default:1073741824,help:largest content a resumable upload may send, in bytes"`
+			TTL            time.Duration `conf:"default:24h,help:how long a resumable upload is kept after it last received content"`
+			ExpireInterval time.Duration `conf:"default:15m,help:how often abandoned resumable uploads are checked for"`
+		}
+		Fetch struct {
+			Timeout      time.Duration `conf:"default:8s,help:how long downloading an image from a url may take; keep below the web write timeout"`
+			MaxSize      int64         `conf:"default:52428800,help:largest image that may be downloaded from a url, in bytes"`
+			MaxRedirects int           `conf:"default:5,help:how many redirects are followed when downloading an image"`
+			Allowed      []string      `conf:"help:internal networks in CIDR notation images may still be downloaded from and webhooks posted to"`
+		}
+		Jobs struct {
+			PollInterval time.Duration `conf:"default:1s,help:how long an idle job worker waits before looking for work again"`
+		}
+		Webhook struct {
+			Timeout     time.Duration `conf:"default:10s,help:how long posting a webhook delivery may take"`
+			MaxAttempts int           `conf:"default:8,help:how many times a webhook delivery is attempted before it's dead-lettered"`
+			Concurrency int           `conf:"default:4,help:webhook deliveries this process posts at the same time"`
+			BackoffBase time.Duration `conf:"default:30s,help:wait before retrying a failed webhook delivery, doubled for every retry after"`
+			BackoffMax  time.Duration `conf:"default:6h,help:longest wait between retries of a webhook delivery"`
+		}
+		Events struct {
+			PollInterval  time.Duration `conf:"default:1s,help:how often an event stream looks for new events"`
+			Heartbeat     time.Duration `conf:"default:5s,help:how often an event stream sends a comment to keep the connection open"`
+			Duration      time.Duration `conf:"default:9s,help:how long an event stream lasts before the client reconnects; keep below the web write timeout"`
+			Retention     time.Duration `conf:"default:168h,help:how long events are kept for streams to resume from before they're removed"`
+			PruneInterval time.Duration `conf:"default:1h,help:how often events past their retention are removed"`
+		}
+		Idempotency struct {
+			TTL            time.Duration `conf:"default:24h,help:how long the response to a request made with an idempotency key is replayed to retries"`
+			Lease          time.Duration `conf:"default:1m,help:how long a request may hold an idempotency key before a retry takes it over"`
+			ExpireInterval time.Duration `conf:"default:1h,help:how often expired idempotency keys are removed"`
+			MaxBody        int64         `conf:"default:104857600,help:largest body a request made with an idempotency key may send, in bytes"`
+		}
+		Trash struct {
+			Retention     time.Duration `conf:"default:720h,help:how long deleted images stay in the trash before they're purged"`
+			PurgeInterval time.Duration `conf:"default:1h,help:how often the trash is checked for images to purge"`
+		}
 		Zipkin struct {
 			ReporterURI string  `conf:"default:http://localhost:9411/api/v2/spans"`
 			ServiceName string  `conf:"default:images-api"`
@@ -136,6 +220,155 @@ func run(log *zap.SugaredLogger) error {
 		db.Close()
 	}()
 
+	// =================================================================================================================
+	// Blob Storage Support
+
+	// Create the blob store that holds the image content.
+	log.Infow("startup", "status", "initializing blob storage support", "root", cfg.Storage.Root)
+
+	blobs, err := blob.NewFS(cfg.Storage.Root)
+	if err != nil {
+		return fmt.Errorf("constructing blob store: %w", err)
+	}
+
+	presets, err := image.ParsePresets(cfg.Renditions.Presets)
+	if err != nil {
+		return fmt.Errorf("parsing rendition presets: %w", err)
+	}
+
+	// Create the disk cache that holds images transformed on the fly.
+	log.Infow("startup", "status", "initializing transform cache support", "dir", cfg.Transform.CacheDir)
+
+	transformCache, err := diskcache.New(cfg.Transform.CacheDir, cfg.Transform.CacheMaxBytes)
+	if err != nil {
+		return fmt.Errorf("constructing transform cache: %w", err)
+	}
+
+	transformLimits := image.TransformLimits{
+		MaxWidth:  cfg.Transform.MaxWidth,
+		MaxHeight: cfg.Transform.MaxHeight,
+		Allowed:   cfg.Transform.Allowed,
+	}
+
+	// Share links are signed with the configured secret. Without one a random
+	// secret is used, which only lasts as long as this process.
+	shareSecret := []byte(cfg.Share.Secret)
+	if len(shareSecret) == 0 {
+		log.Warnw("startup", "status", "no share secret configured, share links won't survive a restart")
+
+		shareSecret = make([]byte, 32)
+		if _, err := rand.Read(shareSecret); err != nil {
+			return fmt.Errorf("generating share secret: %w", err)
+		}
+	}
+
+	// =================================================================================================================
+	// Start Job Workers
+
+	log.Infow("startup", "status", "job workers started", "poll", cfg.Jobs.PollInterval,
+		"trashRetention", cfg.Trash.Retention, "uploadTTL", cfg.Upload.TTL, "idempotencyTTL", cfg.Idempotency.TTL,
+		"eventRetention", cfg.Events.Retention)
+
+	// Webhook deliveries, like images ingested from a URL, are kept away from
+	// the internal network, apart from any allowed networks.
+	allowed := make([]*net.IPNet, len(cfg.Fetch.Allowed))
+	for i, cidr := range cfg.Fetch.Allowed {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parsing allowed network[%s]: %w", cidr, err)
+		}
+		allowed[i] = network
+	}
+
+	// Redirects aren't followed, since they would turn a delivery into a GET
+	// without its body.
+	webhooks := webhook.NewCore(log, db,
+		webhook.WithClient(fetch.New(
+			fetch.WithTimeout(cfg.Webhook.Timeout),
+			fetch.WithMaxRedirects(0),
+			fetch.WithAllowedNetworks(allowed),
+		)),
+		webhook.WithMaxAttempts(cfg.Webhook.MaxAttempts),
+	)
+
+	// The renditions of new images are made against the configured presets.
+	images := image.NewCore(log, db, blobs, image.WithPresets(presets))
+
+	// Expiring never completes an upload, so the image core stands in for
+	// the ingest.
+	uploads := upload.NewCore(log, db, images, cfg.Upload.Dir, upload.WithTTL(cfg.Upload.TTL))
+
+	idempotencyKeys := idempotency.NewStore(log, db)
+	events := outbox.NewStore(log, db)
+
+	// Queues register the handlers for their jobs before the workers start.
+	// Periodic work may run for up to its interval before another worker
+	// takes it over.
+	workers := jobs.NewPool(log, db, jobs.WithPollInterval(cfg.Jobs.PollInterval))
+	workers.Register(webhook.Queue, webhooks.Deliver, jobs.QueueConfig{
+		Concurrency: cfg.Webhook.Concurrency,
+		Visibility:  2 * cfg.Webhook.Timeout,
+		BackoffBase: cfg.Webhook.BackoffBase,
+		BackoffMax:  cfg.Webhook.BackoffMax,
+	})
+	workers.Register(image.RenditionQueue, images.Render, jobs.QueueConfig{
+		Concurrency: cfg.Renditions.Concurrency,
+	})
+
+	// Permanently remove the images that have been in the trash longer than
+	// the retention period.
+	workers.RegisterPeriodic(image.PurgeQueue, func(ctx context.Context, job jobs.Job) error {
+		purged, err := images.Purge(ctx, time.Now().UTC().Add(-cfg.Trash.Retention))
+		if purged > 0 {
+			log.Infow("purge", "status", "purged trash", "images", purged)
+		}
+		return err
+	}, cfg.Trash.PurgeInterval, jobs.QueueConfig{Visibility: cfg.Trash.PurgeInterval})
+
+	// Remove the uploads that haven't received content for longer than the
+	// TTL, along with the chunks received for them.
+	workers.RegisterPeriodic(upload.ExpireQueue, func(ctx context.Context, job jobs.Job) error {
+		expired, err := uploads.Expire(ctx, time.Now().UTC())
+		if expired > 0 {
+			log.Infow("expire", "status", "expired uploads", "uploads", expired)
+		}
+		return err
+	}, cfg.Upload.ExpireInterval, jobs.QueueConfig{Visibility: cfg.Upload.ExpireInterval})
+
+	// Remove the idempotency keys whose responses are no longer replayed.
+	workers.RegisterPeriodic(idempotency.ExpireQueue, func(ctx context.Context, job jobs.Job) error {
+		expired, err := idempotencyKeys.DeleteExpired(ctx, time.Now().UTC())
+		if expired > 0 {
+			log.Infow("expire", "status", "expired idempotency keys", "keys", expired)
+		}
+		return err
+	}, cfg.Idempotency.ExpireInterval, jobs.QueueConfig{Visibility: cfg.Idempotency.ExpireInterval})
+
+	// Remove the events older than the retention period, which streams can
+	// no longer resume from.
+	workers.RegisterPeriodic(outbox.PruneQueue, func(ctx context.Context, job jobs.Job) error {
+		pruned, err := events.DeleteBefore(ctx, time.Now().UTC().Add(-cfg.Events.Retention))
+		if pruned > 0 {
+			log.Infow("prune", "status", "pruned events", "events", pruned)
+		}
+		return err
+	}, cfg.Events.PruneInterval, jobs.QueueConfig{Visibility: cfg.Events.PruneInterval})
+
+	workers.Start()
+
+	// The workers are stopped however run returns. Jobs still running when
+	// the deadline passes are put back in their queues.
+	defer func() {
+		log.Infow("shutdown", "status", "stopping job workers")
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+		defer cancel()
+
+		if err := workers.Shutdown(ctx); err != nil {
+			log.Errorw("shutdown", "status", "stopping job workers", "ERROR", err)
+		}
+	}()
+
 	// =================================================================================================================
 	// Start Debug Service
 
@@ -160,6 +393,19 @@ func run(log *zap.SugaredLogger) error {
 
 	log.Infow("startup", "status", "initializing V1 API support")
 
+	// Images ingested from a URL are downloaded by a fetcher that keeps
+	// clients away from the internal network, apart from any allowed networks.
+	fetcher := fetch.New(
+		fetch.WithTimeout(cfg.Fetch.Timeout),
+		fetch.WithMaxSize(cfg.Fetch.MaxSize),
+		fetch.WithMaxRedirects(cfg.Fetch.MaxRedirects),
+		fetch.WithAllowedNetworks(allowed),
+	)
+
+	// The W3C trace context of incoming requests is picked up, so their
+	// traces, and the webhook deliveries they cause, join the caller's trace.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
 	// Make a channel to listen for an interrupt or terminate signal from the OS.
 	// Use a buffered channel because the signal package requires it.
 	shutdown := make(chan os.Signal, 1)
@@ -170,7 +416,38 @@ func run(log *zap.SugaredLogger) error {
 		Shutdown: shutdown,
 		Log:      log,
 		//Auth:     authProvider,
-		DB: db,
+		DB:              db,
+		Blobs:           blobs,
+		Presets:         presets,
+		TransformCache:  transformCache,
+		TransformLimits: transformLimits,
+		EmbedEditorial:  cfg.Metadata.EmbedEditorial,
+		Similarity: image.Similarity{
+			MaxDistance: cfg.Similarity.MaxDistance,
+			IndexTTL:    cfg.Similarity.IndexTTL,
+		},
+		Sharing: image.Sharing{
+			Secret:     shareSecret,
+			DefaultTTL: cfg.Share.DefaultTTL,
+			MaxTTL:     cfg.Share.MaxTTL,
+		},
+		Quotas: image.Quotas{
+			UserMaxBytes:          cfg.Quota.UserMaxBytes,
+			UserMaxImages:         cfg.Quota.UserMaxImages,
+			OrganizationMaxBytes:  cfg.Quota.OrganizationMaxBytes,
+			OrganizationMaxImages: cfg.Quota.OrganizationMaxImages,
+		},
+		Fetcher:          fetcher,
+		Webhooks:         webhooks,
+		UploadDir:        cfg.Upload.Dir,
+		UploadMaxSize:    cfg.Upload.MaxSize,
+		UploadTTL:        cfg.Upload.TTL,
+		StreamPoll:       cfg.Events.PollInterval,
+		StreamHeartbeat:  cfg.Events.Heartbeat,
+		StreamDuration:   cfg.Events.Duration,
+		IdempotencyTTL:   cfg.Idempotency.TTL,
+		IdempotencyLease: cfg.Idempotency.Lease,
+		IdempotencyBody:  cfg.Idempotency.MaxBody,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// VerifyAudit checks the hash chain of the audit log, reporting the first
+// entry that was tampered with. The last hash is printed so it can be kept
+// elsewhere and compared on a later run.
+func VerifyAudit(log *zap.SugaredLogger, cfg database.Config) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	core := audit.NewCore(log, db)
+
+	ver, err := core.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying audit log after %d good entries: %w", ver.Entries, err)
+	}
+
+	fmt.Printf("audit log verified: entries[%d] last[%d] hash[%s]\n", ver.Entries, ver.LastID, ver.LastHash)
+	return nil
+}
@@ -72,6 +72,9 @@ func GenToken(log *zap.SugaredLogger, cfg database.Config, userID string, kid st
 		},
 		Roles: usr.Roles,
 	}
+	if usr.OrganizationID != nil {
+		claims.OrganizationID = *usr.OrganizationID
+	}
 
 	// This will generate a JWT with the claims embedded in them. The database
 	// with need to be configured with the information found in the public key
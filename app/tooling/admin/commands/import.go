@@ -0,0 +1,554 @@
+package commands
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/user"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// ImportConfig holds the options for the import command.
+type ImportConfig struct {
+	Workers    int
+	DryRun     bool
+	Checkpoint string
+	Visibility string
+}
+
+// Set of outcomes for a file in the import report.
+const (
+	importImported = "imported"
+	importSkipped  = "skipped"
+	importFailed   = "failed"
+)
+
+// importResult is the outcome of importing a single file.
+type importResult struct {
+	File    string `json:"file"`
+	ImageID string `json:"image_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	status  string
+}
+
+// importReport is printed once the import has finished.
+type importReport struct {
+	DryRun   bool           `json:"dry_run"`
+	Total    int            `json:"total"`
+	Imported []importResult `json:"imported"`
+	Skipped  []importResult `json:"skipped"`
+	Failed   []importResult `json:"failed"`
+}
+
+// Import walks a directory or ZIP archive and ingests every image in it for
+// the owner, identified by user id or email. Captions and the other editorial
+// fields are taken from sidecar files: a JSON file next to the image named
+// after it (photo.jpg.json or photo.json), or a CSV manifest with a filename
+// column. A JSON sidecar wins over a manifest row for the fields it sets.
+//
+// Files imported or skipped are appended to the checkpoint file, if one is
+// given, so running the same import again resumes where it stopped. Failed
+// files are left out so they are retried.
+func Import(log *zap.SugaredLogger, cfg database.Config, storageRoot string, source string, owner string, ic ImportConfig) error {
+	if source == "" || owner == "" {
+		fmt.Println("help: import <directory|zip> <owner id or email>")
+		return ErrHelp
+	}
+
+	if ic.Workers < 1 {
+		ic.Workers = 1
+	}
+
+	fsys, closeSource, err := openSource(source)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	files, sidecars, manifests, err := walkSource(fsys)
+	if err != nil {
+		return fmt.Errorf("walking source: %w", err)
+	}
+
+	rows, err := readManifests(fsys, manifests)
+	if err != nil {
+		return err
+	}
+
+	done, err := readCheckpoint(ic.Checkpoint)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	// Importing reads the whole source, so there's no timeout. An interrupt
+	// stops handing out files but lets the ones in flight finish, and the
+	// report is still written so the import can be resumed.
+	ctx := context.Background()
+	interrupt, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	usr, err := queryOwner(ctx, log, db, owner)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := blob.NewFS(storageRoot)
+	if err != nil {
+		return fmt.Errorf("constructing blob store: %w", err)
+	}
+
+	imp := importer{
+		fsys:     fsys,
+		images:   image.NewCore(log, db, blobs, image.WithPresets(nil)),
+		sidecars: sidecars,
+		rows:     rows,
+		owner:    usr,
+		config:   ic,
+	}
+
+	checkpoint := io.Discard
+	if ic.Checkpoint != "" && !ic.DryRun {
+		f, err := os.OpenFile(ic.Checkpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening checkpoint: %w", err)
+		}
+		defer f.Close()
+		checkpoint = f
+	}
+
+	log.Infow("import", "status", "started", "source", source, "files", len(files), "owner", usr.ID, "dryrun", ic.DryRun)
+
+	// Files are handed to a fixed number of workers and the results are
+	// collected here, so only this goroutine writes the checkpoint.
+	jobs := make(chan string)
+	results := make(chan importResult)
+
+	var wg sync.WaitGroup
+	wg.Add(ic.Workers)
+	for i := 0; i < ic.Workers; i++ {
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				results <- imp.importFile(ctx, name)
+			}
+		}()
+	}
+
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(results)
+		}()
+
+		for _, name := range files {
+			if done[name] {
+				results <- importResult{File: name, Reason: "already in checkpoint", status: importSkipped}
+				continue
+			}
+
+			select {
+			case jobs <- name:
+			case <-interrupt.Done():
+				return
+			}
+		}
+	}()
+
+	report := importReport{
+		DryRun:   ic.DryRun,
+		Total:    len(files),
+		Imported: []importResult{},
+		Skipped:  []importResult{},
+		Failed:   []importResult{},
+	}
+
+	var processed int
+	for res := range results {
+		switch res.status {
+		case importImported:
+			report.Imported = append(report.Imported, res)
+		case importSkipped:
+			report.Skipped = append(report.Skipped, res)
+		default:
+			report.Failed = append(report.Failed, res)
+		}
+
+		if res.status != importFailed && !done[res.File] {
+			if _, err := fmt.Fprintln(checkpoint, res.File); err != nil {
+				return fmt.Errorf("writing checkpoint: %w", err)
+			}
+		}
+
+		if processed++; processed%100 == 0 {
+			log.Infow("import", "status", "progress", "processed", processed, "total", len(files))
+		}
+	}
+
+	log.Infow("import", "status", "finished", "imported", len(report.Imported), "skipped", len(report.Skipped), "failed", len(report.Failed))
+	if interrupt.Err() != nil {
+		log.Infow("import", "status", "interrupted", "remaining", len(files)-processed)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// =============================================================================
+
+// importer ingests single files from the source for the owner.
+type importer struct {
+	fsys     fs.FS
+	images   image.Core
+	sidecars map[string]bool
+	rows     map[string]image.UpdateEditorialMetadata
+	owner    user.User
+	config   ImportConfig
+}
+
+// importFile ingests the named file, reporting why when it doesn't.
+func (imp importer) importFile(ctx context.Context, name string) importResult {
+	failed := func(err error) importResult {
+		return importResult{File: name, Reason: err.Error(), status: importFailed}
+	}
+
+	editorial, err := imp.editorial(name)
+	if err != nil {
+		return failed(err)
+	}
+
+	// The sidecar is checked up front so a bad sidecar doesn't leave an
+	// image behind without its caption.
+	if editorial != nil {
+		if err := validate.Check(editorial); err != nil {
+			return failed(fmt.Errorf("sidecar: %w", err))
+		}
+	}
+
+	f, err := imp.fsys.Open(name)
+	if err != nil {
+		return failed(err)
+	}
+	defer f.Close()
+
+	if imp.config.DryRun {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return failed(err)
+		}
+		if !strings.HasPrefix(http.DetectContentType(head[:n]), "image/") {
+			return importResult{File: name, Reason: image.ErrUnsupportedType.Error(), status: importSkipped}
+		}
+		return importResult{File: name, status: importImported}
+	}
+
+	ni := image.NewImage{
+		UserID:     imp.owner.ID,
+		Visibility: imp.config.Visibility,
+	}
+	if imp.owner.OrganizationID != nil {
+		ni.OrganizationID = *imp.owner.OrganizationID
+	}
+
+	now := time.Now().UTC()
+
+	img, err := imp.images.Create(ctx, ni, f, now)
+	if err != nil {
+		var de *image.DuplicateError
+		switch {
+		case errors.As(err, &de):
+			return importResult{File: name, ImageID: de.ImageID, Reason: image.ErrDuplicate.Error(), status: importSkipped}
+		case errors.Is(err, image.ErrUnsupportedType):
+			return importResult{File: name, Reason: err.Error(), status: importSkipped}
+		}
+		return failed(err)
+	}
+
+	if editorial != nil {
+		up := image.UpdateImage{Editorial: editorial}
+		if err := imp.images.Update(ctx, img.ID, up, imp.owner.ID, now); err != nil {
+			return importResult{File: name, ImageID: img.ID, Reason: fmt.Sprintf("applying sidecar: %s", err), status: importFailed}
+		}
+	}
+
+	return importResult{File: name, ImageID: img.ID, status: importImported}
+}
+
+// editorial merges the manifest row and JSON sidecar for the named file. It
+// returns nil when there is neither.
+func (imp importer) editorial(name string) (*image.UpdateEditorialMetadata, error) {
+	em, found := imp.rows[name]
+
+	ext := path.Ext(name)
+	for _, sidecar := range []string{name + ".json", strings.TrimSuffix(name, ext) + ".json"} {
+		if !imp.sidecars[sidecar] {
+			continue
+		}
+
+		data, err := fs.ReadFile(imp.fsys, sidecar)
+		if err != nil {
+			return nil, fmt.Errorf("reading sidecar: %w", err)
+		}
+
+		// Decoding over the manifest row replaces only the fields the
+		// sidecar sets.
+		if err := json.Unmarshal(data, &em); err != nil {
+			return nil, fmt.Errorf("decoding sidecar %s: %w", sidecar, err)
+		}
+		found = true
+		break
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &em, nil
+}
+
+// =============================================================================
+
+// openSource opens a directory, or a ZIP archive when the path names a file.
+func openSource(source string) (fs.FS, func(), error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening source: %w", err)
+	}
+
+	if info.IsDir() {
+		return os.DirFS(source), func() {}, nil
+	}
+
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening archive: %w", err)
+	}
+
+	return zr, func() { zr.Close() }, nil
+}
+
+// walkSource lists the files to import along with the JSON sidecars and CSV
+// manifests describing them. Hidden files and directories are left out, as
+// is the metadata folder macOS adds to archives.
+func walkSource(fsys fs.FS) ([]string, map[string]bool, []string, error) {
+	var files []string
+	var manifests []string
+	sidecars := make(map[string]bool)
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		base := d.Name()
+		if name != "." && (strings.HasPrefix(base, ".") || base == "__MACOSX") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		switch strings.ToLower(path.Ext(base)) {
+		case ".json":
+			sidecars[name] = true
+		case ".csv":
+			manifests = append(manifests, name)
+		default:
+			files = append(files, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, sidecars, manifests, nil
+}
+
+// readManifests reads the CSV manifests into editorial fields keyed by the
+// path of the file each row describes. Filenames are relative to the
+// manifest and keywords are separated by semicolons. Empty cells are left
+// unset.
+func readManifests(fsys fs.FS, manifests []string) (map[string]image.UpdateEditorialMetadata, error) {
+	rows := make(map[string]image.UpdateEditorialMetadata)
+
+	for _, manifest := range manifests {
+		if err := readManifest(fsys, manifest, rows); err != nil {
+			return nil, fmt.Errorf("reading manifest %s: %w", manifest, err)
+		}
+	}
+
+	return rows, nil
+}
+
+// readManifest adds the rows of a single CSV manifest.
+func readManifest(fsys fs.FS, manifest string, rows map[string]image.UpdateEditorialMetadata) error {
+	f, err := fsys.Open(manifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+
+	filename := -1
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if header[i] == "filename" {
+			filename = i
+		}
+	}
+	if filename == -1 {
+		return errors.New("no filename column")
+	}
+
+	dir := path.Dir(manifest)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if filename >= len(record) || record[filename] == "" {
+			continue
+		}
+
+		var em image.UpdateEditorialMetadata
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			setEditorial(&em, header[i], value)
+		}
+
+		rows[path.Join(dir, record[filename])] = em
+	}
+}
+
+// setEditorial sets the editorial field named by a manifest column. Columns
+// that aren't editorial fields are ignored.
+func setEditorial(em *image.UpdateEditorialMetadata, column string, value string) {
+	v := value
+
+	switch column {
+	case "caption":
+		em.Caption = &v
+	case "headline":
+		em.Headline = &v
+	case "byline":
+		em.Byline = &v
+	case "credit":
+		em.Credit = &v
+	case "copyright":
+		em.Copyright = &v
+	case "keywords":
+		var keywords []string
+		for _, keyword := range strings.Split(v, ";") {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				keywords = append(keywords, keyword)
+			}
+		}
+		em.Keywords = &keywords
+	case "city":
+		em.City = &v
+	case "country":
+		em.Country = &v
+	case "instructions":
+		em.Instructions = &v
+	case "usage_terms":
+		em.UsageTerms = &v
+	case "language":
+		em.Language = &v
+	}
+}
+
+// readCheckpoint reads the files a previous run already finished with.
+func readCheckpoint(checkpoint string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if checkpoint == "" {
+		return done, nil
+	}
+
+	f, err := os.Open(checkpoint)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return done, nil
+		}
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+
+	return done, nil
+}
+
+// queryOwner finds the user the images are imported for.
+func queryOwner(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, owner string) (user.User, error) {
+	core := user.NewCore(log, db)
+
+	var usr user.User
+	var err error
+	if validate.CheckID(owner) == nil {
+		usr, err = core.QueryByID(ctx, owner)
+	} else {
+		usr, err = core.QueryByEmail(ctx, owner)
+	}
+	if err != nil {
+		return user.User{}, fmt.Errorf("querying owner %s: %w", owner, err)
+	}
+
+	return usr, nil
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"go.uber.org/zap"
+)
+
+// Jobs lists background jobs, optionally by queue and status, or retries or
+// cancels the job with the specified id. The target is the queue to list, or
+// the id of the job to retry or cancel.
+func Jobs(log *zap.SugaredLogger, cfg database.Config, action string, target string, status string) error {
+	if action == "" || (action != "list" && target == "") {
+		fmt.Println("help: jobs list [queue] [status] | jobs retry <id> | jobs cancel <id>")
+		return ErrHelp
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store := jobs.NewStore(log, db)
+
+	var result any
+	switch action {
+	case "list":
+		var filter jobs.QueryFilter
+		if target != "" && target != "all" {
+			filter.Queue = &target
+		}
+		if status != "" {
+			filter.Status = &status
+		}
+
+		found, err := store.Query(ctx, filter, 100)
+		if err != nil {
+			return fmt.Errorf("listing jobs: %w", err)
+		}
+		result = found
+
+	case "retry":
+		job, err := store.Retry(ctx, target, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("retrying job: %w", err)
+		}
+		result = job
+
+	case "cancel":
+		job, err := store.Cancel(ctx, target, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("cancelling job: %w", err)
+		}
+		result = job
+
+	default:
+		fmt.Println("help: jobs list [queue] [status] | jobs retry <id> | jobs cancel <id>")
+		return ErrHelp
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// cluster is a set of near-duplicate images in the report.
+type cluster struct {
+	Size   int           `json:"size"`
+	Images []image.Image `json:"images"`
+}
+
+// Similar reports the clusters of near-duplicate images across the whole
+// catalog. Images without perceptual hashes are hashed first, so the report
+// also covers images uploaded before hashes were kept.
+func Similar(log *zap.SugaredLogger, cfg database.Config, storageRoot string, distance string, hash string) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	maxDistance := image.DefaultSimilarity.MaxDistance
+	if distance != "" {
+		if maxDistance, err = strconv.Atoi(distance); err != nil {
+			return fmt.Errorf("converting distance: %w", err)
+		}
+	}
+
+	if hash == "" {
+		hash = image.HashDCT
+	}
+
+	blobs, err := blob.NewFS(storageRoot)
+	if err != nil {
+		return fmt.Errorf("constructing blob store: %w", err)
+	}
+
+	// Hashing and clustering read the whole catalog, so there's no timeout.
+	ctx := context.Background()
+
+	core := image.NewCore(log, db, blobs, image.WithPresets(nil))
+
+	hashed, err := core.HashMissing(ctx)
+	if err != nil {
+		return fmt.Errorf("hashing images: %w", err)
+	}
+	log.Infow("similar", "status", "hashed images", "count", hashed)
+
+	clusters, err := core.QueryClusters(ctx, hash, maxDistance)
+	if err != nil {
+		return fmt.Errorf("clustering images: %w", err)
+	}
+
+	report := make([]cluster, len(clusters))
+	for i, images := range clusters {
+		report[i] = cluster{Size: len(images), Images: images}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// RecomputeUsage counts what every user and organization stores from
+// scratch, repairing the totals quotas are enforced against.
+func RecomputeUsage(log *zap.SugaredLogger, cfg database.Config) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("connect database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// Counting usage only reads the database, so no blob store is needed.
+	core := image.NewCore(log, db, nil, image.WithPresets(nil))
+
+	if err := core.RecomputeUsage(ctx, time.Now().UTC()); err != nil {
+		return fmt.Errorf("recomputing usage: %w", err)
+	}
+
+	fmt.Println("usage recomputed")
+	return nil
+}
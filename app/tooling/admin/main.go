@@ -50,6 +50,15 @@ func run(log *zap.SugaredLogger) error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:true"`
 		}
+		Storage struct {
+			Root string `conf:"default:/tmp/images"`
+		}
+		Import struct {
+			Workers    int  `conf:"default:4"`
+			DryRun     bool `conf:"default:false"`
+			Checkpoint string
+			Visibility string
+		}
 	}{
 		Version: conf.Version{
 			Build: build,
@@ -84,12 +93,19 @@ func run(log *zap.SugaredLogger) error {
 		DisableTLS: cfg.DB.DisableTLS,
 	}
 
-	return processCommands(cfg.Args, log, dbConfig)
+	importConfig := commands.ImportConfig{
+		Workers:    cfg.Import.Workers,
+		DryRun:     cfg.Import.DryRun,
+		Checkpoint: cfg.Import.Checkpoint,
+		Visibility: cfg.Import.Visibility,
+	}
+
+	return processCommands(cfg.Args, log, dbConfig, cfg.Storage.Root, importConfig)
 }
 
 // processCommands handles the execution of the commands specified on
 // the command line.
-func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.Config) error {
+func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.Config, storageRoot string, importConfig commands.ImportConfig) error {
 	switch args.Num(0) {
 	case "migrate":
 		if err := commands.Migrate(dbConfig); err != nil {
@@ -128,6 +144,35 @@ func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.C
 			return fmt.Errorf("generating token: %w", err)
 		}
 
+	case "similar":
+		distance := args.Num(1)
+		hash := args.Num(2)
+		if err := commands.Similar(log, dbConfig, storageRoot, distance, hash); err != nil {
+			return fmt.Errorf("reporting similar images: %w", err)
+		}
+
+	case "usage":
+		if err := commands.RecomputeUsage(log, dbConfig); err != nil {
+			return fmt.Errorf("recomputing usage: %w", err)
+		}
+
+	case "import":
+		source := args.Num(1)
+		owner := args.Num(2)
+		if err := commands.Import(log, dbConfig, storageRoot, source, owner, importConfig); err != nil {
+			return fmt.Errorf("importing images: %w", err)
+		}
+
+	case "jobs":
+		if err := commands.Jobs(log, dbConfig, args.Num(1), args.Num(2), args.Num(3)); err != nil {
+			return fmt.Errorf("managing jobs: %w", err)
+		}
+
+	case "verifyaudit":
+		if err := commands.VerifyAudit(log, dbConfig); err != nil {
+			return fmt.Errorf("verifying audit log: %w", err)
+		}
+
 	default:
 		fmt.Println("migrate: create the schema in the database")
 		fmt.Println("seed: add data to the database")
@@ -135,6 +180,11 @@ func processCommands(args conf.Args, log *zap.SugaredLogger, dbConfig database.C
 		fmt.Println("users: get a list of users from the database")
 		fmt.Println("genkey: generate a set of private/public key files")
 		fmt.Println("gentoken: generate a JWT for a user with claims")
+		fmt.Println("similar: report clusters of near-duplicate images [distance] [dhash|phash]")
+		fmt.Println("usage: recompute the storage usage counted against quotas")
+		fmt.Println("import: ingest the images in a directory or zip for an owner [path] [owner]")
+		fmt.Println("jobs: list, retry or cancel background jobs [list|retry|cancel] [queue|id] [status]")
+		fmt.Println("verifyaudit: check the hash chain of the audit log hasn't been tampered with")
 		fmt.Println("provide a command to get more help.")
 		return commands.ErrHelp
 	}
@@ -0,0 +1,323 @@
+// Package audit provides the core business API for the audit log, which
+// records every change made to users and images along with who made it, from
+// where and as part of which request. The log is append-only and each entry
+// holds a hash covering its content and the hash of the entry before it, so
+// changing or removing an entry breaks the chain from there on.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/business/web/auth"
+	"github.com/fadhilijuma/images/foundation/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// ErrChainBroken is returned when the audit log was tampered with.
+var ErrChainBroken = errors.New("audit log hash chain is broken")
+
+// Set of actions recorded.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionPurge  = "purge"
+)
+
+// Set of entities changes are recorded for.
+const (
+	EntityUser  = "user"
+	EntityImage = "image"
+)
+
+// genesisHash is the hash the first entry in the log follows.
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// verifyBatch is the number of entries read at a time when verifying the log.
+const verifyBatch = 1000
+
+// ChainError is returned when an entry in the audit log doesn't match its
+// hash or doesn't follow the entry before it.
+type ChainError struct {
+	ID     int64
+	Reason string
+}
+
+// Error implements the error interface.
+func (ce *ChainError) Error() string {
+	return fmt.Sprintf("%s at entry[%d]: %s", ErrChainBroken, ce.ID, ce.Reason)
+}
+
+// Is reports the error as an ErrChainBroken.
+func (ce *ChainError) Is(target error) bool {
+	return target == ErrChainBroken
+}
+
+// Verification is the result of checking the audit log. The last hash
+// covers every entry, so keeping it elsewhere lets a later check tell the
+// log wasn't rewritten as a whole either.
+type Verification struct {
+	Entries  int
+	LastID   int64
+	LastHash string
+}
+
+// Core manages the set of APIs for audit log access.
+type Core struct {
+	log   *zap.SugaredLogger
+	store db.Store
+}
+
+// NewCore constructs a core for audit log api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+	return Core{
+		log:   log,
+		store: db.NewStore(log, sqlxDB),
+	}
+}
+
+// Record appends an entry for a change to the log, as part of the transaction
+// making the change, so the entry exists exactly when the change was
+// committed. The actor and their roles are taken from the claims in the
+// context and the trace ID and client IP from the request values; changes
+// made outside of a request, such as by the admin tooling, have none.
+// Entries are appended under a lock held until the transaction ends, so
+// recording should be among the last things the transaction does.
+func (c Core) Record(ctx context.Context, tx sqlx.ExtContext, ne NewEntry, now time.Time) error {
+	if err := validate.Check(ne); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	diff, err := Diff(ne.Before, ne.After)
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+
+	// The entry is hashed as it will be read back, so the time is cut to
+	// the precision the database keeps.
+	dbEntry := db.Entry{
+		ActorRoles:  pq.StringArray{},
+		Action:      ne.Action,
+		Entity:      ne.Entity,
+		EntityID:    strings.ToLower(ne.EntityID),
+		Diff:        diff,
+		DateCreated: now.UTC().Truncate(time.Microsecond),
+	}
+
+	if claims, err := auth.GetClaims(ctx); err == nil {
+		actorID := strings.ToLower(claims.Subject)
+		dbEntry.ActorID = &actorID
+		dbEntry.ActorRoles = append(dbEntry.ActorRoles, claims.Roles...)
+	}
+
+	if v, err := web.GetValues(ctx); err == nil {
+		dbEntry.TraceID = v.TraceID
+		dbEntry.ClientIP = v.ClientIP
+	}
+
+	store := c.store.Tran(tx)
+
+	if err := store.Lock(ctx); err != nil {
+		return err
+	}
+
+	dbEntry.PrevHash = genesisHash
+	last, err := store.QueryLast(ctx)
+	switch {
+	case err == nil:
+		dbEntry.PrevHash = last.Hash
+	case !errors.Is(err, database.ErrDBNotFound):
+		return fmt.Errorf("query last: %w", err)
+	}
+	dbEntry.Hash = hash(dbEntry)
+
+	if err := store.Create(ctx, dbEntry); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	return nil
+}
+
+// QueryPage gets a page of the entries that match the filter, newest first
+// unless sorted by id ascending. It returns the cursor for the next page,
+// which is empty when there are no more entries.
+func (c Core) QueryPage(ctx context.Context, filter QueryFilter, page paging.Page) ([]Entry, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-id", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   "audit_id",
+		IDColumn: "audit_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if _, err := strconv.ParseInt(cursor.ID, 10, 64); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterID = cursor.ID
+	}
+
+	dbEntries, err := c.store.QueryPage(ctx, toDBQueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	var next string
+	if len(dbEntries) > limit {
+		dbEntries = dbEntries[:limit]
+		id := strconv.FormatInt(dbEntries[limit-1].ID, 10)
+		next = paging.Next(order, id, id)
+	}
+
+	return toEntrySlice(dbEntries), next, nil
+}
+
+// Verify walks the log from the start, checking every entry matches its
+// hash and follows the entry before it. The first entry that doesn't is
+// reported as a *ChainError.
+func (c Core) Verify(ctx context.Context) (Verification, error) {
+	ver := Verification{
+		LastHash: genesisHash,
+	}
+
+	for {
+		dbEntries, err := c.store.QueryAfter(ctx, ver.LastID, verifyBatch)
+		if err != nil {
+			return Verification{}, fmt.Errorf("query: %w", err)
+		}
+
+		for _, dbEntry := range dbEntries {
+			if dbEntry.PrevHash != ver.LastHash {
+				return ver, &ChainError{ID: dbEntry.ID, Reason: "doesn't follow the entry before it"}
+			}
+
+			if dbEntry.Hash != hash(dbEntry) {
+				return ver, &ChainError{ID: dbEntry.ID, Reason: "doesn't match its hash"}
+			}
+
+			ver.Entries++
+			ver.LastID = dbEntry.ID
+			ver.LastHash = dbEntry.Hash
+		}
+
+		if len(dbEntries) < verifyBatch {
+			return ver, nil
+		}
+	}
+}
+
+// Diff compares the JSON encodings of two values field by field, returning
+// the fields that differ as a JSON object of Changes. Either value may be
+// nil, in which case every field of the other is reported.
+func Diff(before any, after any) ([]byte, error) {
+	bFields, err := fields(before)
+	if err != nil {
+		return nil, fmt.Errorf("before: %w", err)
+	}
+
+	aFields, err := fields(after)
+	if err != nil {
+		return nil, fmt.Errorf("after: %w", err)
+	}
+
+	changes := make(map[string]Change)
+	for name, bv := range bFields {
+		av, ok := aFields[name]
+		if ok && bytes.Equal(bv, av) {
+			continue
+		}
+		changes[name] = Change{Before: bv, After: av}
+	}
+	for name, av := range aFields {
+		if _, ok := bFields[name]; !ok {
+			changes[name] = Change{After: av}
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+// =============================================================================
+
+// fields returns the JSON encoding of each field of a value.
+func fields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// hash returns the hex encoded SHA-256 of an entry's content and the hash of
+// the entry before it. The entry's position isn't covered, since the hash
+// before it already fixes where it is in the chain.
+func hash(dbEntry db.Entry) string {
+	roles := []string(dbEntry.ActorRoles)
+	if roles == nil {
+		roles = []string{}
+	}
+
+	content := struct {
+		PrevHash    string   `json:"prev_hash"`
+		ActorID     *string  `json:"actor_id"`
+		ActorRoles  []string `json:"actor_roles"`
+		TraceID     string   `json:"trace_id"`
+		ClientIP    string   `json:"client_ip"`
+		Action      string   `json:"action"`
+		Entity      string   `json:"entity"`
+		EntityID    string   `json:"entity_id"`
+		Diff        string   `json:"diff"`
+		DateCreated string   `json:"date_created"`
+	}{
+		PrevHash:    dbEntry.PrevHash,
+		ActorID:     dbEntry.ActorID,
+		ActorRoles:  roles,
+		TraceID:     dbEntry.TraceID,
+		ClientIP:    dbEntry.ClientIP,
+		Action:      dbEntry.Action,
+		Entity:      dbEntry.Entity,
+		EntityID:    dbEntry.EntityID,
+		Diff:        string(dbEntry.Diff),
+		DateCreated: dbEntry.DateCreated.UTC().Format(time.RFC3339Nano),
+	}
+
+	// The content only holds strings, so encoding it can't fail.
+	data, _ := json.Marshal(content)
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/user"
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/web/auth"
+	"github.com/fadhilijuma/images/foundation/docker"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+// adminID is the admin from the seed data.
+const adminID = "5cf37266-3473-4006-984f-9325122678b7"
+
+func Test_Audit(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testaudit")
+	t.Cleanup(teardown)
+
+	core := audit.NewCore(log, db)
+	usrCore := user.NewCore(log, db)
+
+	t.Log("Given the need to audit the changes made to users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen changing a user on behalf of an admin.", testID)
+		{
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: adminID},
+				Roles:            []string{auth.RoleAdmin},
+			}
+			ctx := auth.SetClaims(context.Background(), claims)
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			nu := user.NewUser{
+				Name:            "Bill Kennedy",
+				Email:           "bill@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers",
+				PasswordConfirm: "gophers",
+			}
+			usr, err := usrCore.Create(ctx, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create user : %s.", dbtest.Failed, testID, err)
+			}
+
+			name := "Jacob Walker"
+			if err := usrCore.Update(ctx, usr.ID, user.UpdateUser{Name: &name}, now.Add(time.Hour)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update user : %s.", dbtest.Failed, testID, err)
+			}
+
+			var content bytes.Buffer
+			if err := png.Encode(&content, stdimage.NewRGBA(stdimage.Rect(0, 0, 1, 1))); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to encode an image : %s.", dbtest.Failed, testID, err)
+			}
+			imgCore := image.NewCore(log, db, blob.NewMemory(), image.WithPresets(nil))
+			img, err := imgCore.Create(ctx, image.NewImage{UserID: usr.ID}, &content, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an image : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := usrCore.Delete(ctx, usr.ID, adminID, now.Add(2*time.Hour)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create, update and delete a user.", dbtest.Success, testID)
+
+			imgEntity := audit.EntityImage
+			imgFilter := audit.QueryFilter{
+				Entity:   &imgEntity,
+				EntityID: &img.ID,
+			}
+			imgEntries, _, err := core.QueryPage(ctx, imgFilter, paging.Page{Sort: "id"})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the audit log : %s.", dbtest.Failed, testID, err)
+			}
+			if len(imgEntries) != 2 || imgEntries[1].Action != audit.ActionDelete {
+				t.Fatalf("\t%s\tTest %d:\tShould get an entry for the image trashed with the user : %+v.", dbtest.Failed, testID, imgEntries)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get an entry for the image trashed with the user.", dbtest.Success, testID)
+
+			evts, err := outbox.NewStore(log, db).QueryAfter(ctx, outbox.QueryFilter{UserID: &usr.ID}, 0, 100)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the events : %s.", dbtest.Failed, testID, err)
+			}
+			var deleted bool
+			for _, evt := range evts {
+				if evt.Event == image.EventDeleted && bytes.Contains(evt.Data, []byte(img.ID)) {
+					deleted = true
+				}
+			}
+			if !deleted {
+				t.Fatalf("\t%s\tTest %d:\tShould get an event for the image trashed with the user.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get an event for the image trashed with the user.", dbtest.Success, testID)
+
+			entity := audit.EntityUser
+			filter := audit.QueryFilter{
+				Entity:   &entity,
+				EntityID: &usr.ID,
+			}
+			entries, _, err := core.QueryPage(ctx, filter, paging.Page{Sort: "id"})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the audit log : %s.", dbtest.Failed, testID, err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould get an entry for each change : got %d.", dbtest.Failed, testID, len(entries))
+			}
+			t.Logf("\t%s\tTest %d:\tShould get an entry for each change.", dbtest.Success, testID)
+
+			for i, exp := range []string{audit.ActionCreate, audit.ActionUpdate, audit.ActionDelete} {
+				entry := entries[i]
+				if entry.Action != exp {
+					t.Fatalf("\t%s\tTest %d:\tShould get the action %q : got %q.", dbtest.Failed, testID, exp, entry.Action)
+				}
+				if entry.ActorID == nil || *entry.ActorID != adminID {
+					t.Fatalf("\t%s\tTest %d:\tShould get the actor of the change : got %v.", dbtest.Failed, testID, entry.ActorID)
+				}
+				if len(entry.ActorRoles) != 1 || entry.ActorRoles[0] != auth.RoleAdmin {
+					t.Fatalf("\t%s\tTest %d:\tShould get the roles of the actor : got %v.", dbtest.Failed, testID, entry.ActorRoles)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the action and actor of each change.", dbtest.Success, testID)
+
+			var diff map[string]audit.Change
+			if err := json.Unmarshal(entries[1].Diff, &diff); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the diff : %s.", dbtest.Failed, testID, err)
+			}
+			if string(diff["name"].Before) != `"Bill Kennedy"` || string(diff["name"].After) != `"Jacob Walker"` {
+				t.Fatalf("\t%s\tTest %d:\tShould get the name before and after : %+v.", dbtest.Failed, testID, diff["name"])
+			}
+			if _, exists := diff["email"]; exists {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT get fields that didn't change.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only get the fields that changed.", dbtest.Success, testID)
+
+			ver, err := core.Verify(ctx)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to verify the audit log : %s.", dbtest.Failed, testID, err)
+			}
+			if ver.Entries < 3 || ver.LastHash != entries[2].Hash {
+				t.Fatalf("\t%s\tTest %d:\tShould verify every entry : %+v.", dbtest.Failed, testID, ver)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to verify the audit log.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen tampering with the audit log.", testID)
+		{
+			ctx := context.Background()
+
+			if _, err := db.ExecContext(ctx, `UPDATE audit SET client_ip = '10.0.0.1'`); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to modify an entry.", dbtest.Failed, testID)
+			}
+			if _, err := db.ExecContext(ctx, `DELETE FROM audit`); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to remove an entry.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to modify or remove an entry.", dbtest.Success, testID)
+
+			// Someone with control of the database can get around the
+			// trigger, which the hash chain still catches.
+			const q = `
+			ALTER TABLE audit DISABLE TRIGGER audit_append_only;
+			UPDATE audit SET client_ip = '10.0.0.1' WHERE audit_id = (SELECT MIN(audit_id) FROM audit);
+			ALTER TABLE audit ENABLE TRIGGER audit_append_only;`
+
+			if _, err := db.ExecContext(ctx, q); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to tamper with an entry : %s.", dbtest.Failed, testID, err)
+			}
+
+			_, err := core.Verify(ctx)
+			if !errors.Is(err, audit.ErrChainBroken) {
+				t.Fatalf("\t%s\tTest %d:\tShould find the entry tampered with : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the entry tampered with.", dbtest.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package db contains audit log related CRUD functionality. The log is
+// append-only, so there is no way to modify or remove an entry.
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for audit log access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Lock takes the lock entries are appended under, so each one sees the
+// entry before it. The lock is held until the transaction ends.
+func (s Store) Lock(ctx context.Context) error {
+	const q = `
+	SELECT
+		pg_advisory_xact_lock(hashtext('audit'))`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, struct{}{}); err != nil {
+		return fmt.Errorf("locking audit: %w", err)
+	}
+
+	return nil
+}
+
+// Create adds an Entry to the end of the log.
+func (s Store) Create(ctx context.Context, e Entry) error {
+	const q = `
+	INSERT INTO audit
+		(actor_id, actor_roles, trace_id, client_ip, action, entity, entity_id, diff, date_created, prev_hash, hash)
+	VALUES
+		(:actor_id, :actor_roles, :trace_id, :client_ip, :action, :entity, :entity_id, :diff, :date_created, :prev_hash, :hash)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, e); err != nil {
+		return fmt.Errorf("inserting entry: %w", err)
+	}
+
+	return nil
+}
+
+// QueryLast gets the last Entry in the log.
+func (s Store) QueryLast(ctx context.Context) (Entry, error) {
+	const q = `
+	SELECT
+		*
+	FROM
+		audit
+	ORDER BY
+		audit_id DESC
+	LIMIT 1`
+
+	var e Entry
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, struct{}{}, &e); err != nil {
+		return Entry{}, fmt.Errorf("selecting last entry: %w", err)
+	}
+
+	return e, nil
+}
+
+// QueryPage retrieves a page of the entries that match the filter.
+func (s Store) QueryPage(ctx context.Context, filter QueryFilter, keyset database.Keyset) ([]Entry, error) {
+	data := make(map[string]any)
+
+	const q = `
+	SELECT
+		*
+	FROM
+		audit`
+
+	var wc []string
+
+	if filter.ActorID != nil {
+		data["actor_id"] = *filter.ActorID
+		wc = append(wc, "actor_id = :actor_id")
+	}
+
+	if filter.Entity != nil {
+		data["entity"] = *filter.Entity
+		wc = append(wc, "entity = :entity")
+	}
+
+	if filter.EntityID != nil {
+		data["entity_id"] = *filter.EntityID
+		wc = append(wc, "entity_id = :entity_id")
+	}
+
+	if filter.CreatedAfter != nil {
+		data["created_after"] = *filter.CreatedAfter
+		wc = append(wc, "date_created >= :created_after")
+	}
+
+	if filter.CreatedBefore != nil {
+		data["created_before"] = *filter.CreatedBefore
+		wc = append(wc, "date_created < :created_before")
+	}
+
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(q)
+	if len(wc) > 0 {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	}
+	buf.WriteString(keyset.OrderBy(data))
+
+	var entries []Entry
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &entries); err != nil {
+		return nil, fmt.Errorf("selecting entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// QueryAfter retrieves up to limit entries that come after the specified
+// entry in the log, in the order they were appended.
+func (s Store) QueryAfter(ctx context.Context, after int64, limit int) ([]Entry, error) {
+	data := struct {
+		After int64 `db:"after"`
+		Limit int   `db:"limit"`
+	}{
+		After: after,
+		Limit: limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		audit
+	WHERE
+		audit_id > :after
+	ORDER BY
+		audit_id
+	LIMIT :limit`
+
+	var entries []Entry
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &entries); err != nil {
+		return nil, fmt.Errorf("selecting entries after[%d]: %w", after, err)
+	}
+
+	return entries, nil
+}
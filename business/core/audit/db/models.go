@@ -0,0 +1,34 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+// Entry represents a change recorded in the audit log.
+type Entry struct {
+	ID          int64          `db:"audit_id"`     // Position of the entry in the log.
+	ActorID     *string        `db:"actor_id"`     // ID of the user who made the change, if made on behalf of one.
+	ActorRoles  pq.StringArray `db:"actor_roles"`  // Roles the user held when making the change.
+	TraceID     string         `db:"trace_id"`     // Trace of the request that made the change.
+	ClientIP    string         `db:"client_ip"`    // Address of the client that made the change.
+	Action      string         `db:"action"`       // What was done, such as update.
+	Entity      string         `db:"entity"`       // Kind of thing changed, such as image.
+	EntityID    string         `db:"entity_id"`    // ID of the thing changed.
+	Diff        types.JSONText `db:"diff"`         // Fields changed, with their values before and after.
+	DateCreated time.Time      `db:"date_created"` // When the change was made.
+	PrevHash    string         `db:"prev_hash"`    // Hash of the entry before in the log.
+	Hash        string         `db:"hash"`         // Hash of this entry, covering the hash before it.
+}
+
+// QueryFilter holds the available fields a query of the audit log can be
+// filtered on. Nil fields are not filtered on.
+type QueryFilter struct {
+	ActorID       *string
+	Entity        *string
+	EntityID      *string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit/db"
+)
+
+// Entry represents a change recorded in the audit log.
+type Entry struct {
+	ID          int64           `json:"id"`           // Position of the entry in the log.
+	ActorID     *string         `json:"actor_id"`     // User who made the change; empty for changes made by the system.
+	ActorRoles  []string        `json:"actor_roles"`  // Roles the user held when making the change.
+	TraceID     string          `json:"trace_id"`     // Trace of the request that made the change.
+	ClientIP    string          `json:"client_ip"`    // Address of the client that made the change.
+	Action      string          `json:"action"`       // What was done, such as update.
+	Entity      string          `json:"entity"`       // Kind of thing changed, such as image.
+	EntityID    string          `json:"entity_id"`    // ID of the thing changed.
+	Diff        json.RawMessage `json:"diff"`         // Fields changed, with their values before and after.
+	DateCreated time.Time       `json:"date_created"` // When the change was made.
+	PrevHash    string          `json:"prev_hash"`    // Hash of the entry before in the log.
+	Hash        string          `json:"hash"`         // Hash of this entry, covering the hash before it.
+}
+
+// NewEntry is what we require to record a change. Before is nil for
+// something created and After is nil for something deleted. Both are
+// compared as JSON, so only their exported fields are recorded.
+type NewEntry struct {
+	Action   string `json:"action" validate:"required,oneof=create update delete purge"`
+	Entity   string `json:"entity" validate:"required,oneof=user image"`
+	EntityID string `json:"entity_id" validate:"required,uuid"`
+	Before   any    `json:"before"`
+	After    any    `json:"after"`
+}
+
+// QueryFilter holds the available fields a query of the audit log can be
+// filtered on. Nil fields are not filtered on.
+type QueryFilter struct {
+	ActorID       *string    `json:"actor_id" validate:"omitempty,uuid"`
+	Entity        *string    `json:"entity" validate:"omitempty,oneof=user image"`
+	EntityID      *string    `json:"entity_id" validate:"omitempty,uuid"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+}
+
+// Change holds the values of a field before and after a change, as JSON. A
+// field that didn't exist on one side is null there.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// =============================================================================
+
+func toEntry(dbEntry db.Entry) Entry {
+	roles := []string(dbEntry.ActorRoles)
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return Entry{
+		ID:          dbEntry.ID,
+		ActorID:     dbEntry.ActorID,
+		ActorRoles:  roles,
+		TraceID:     dbEntry.TraceID,
+		ClientIP:    dbEntry.ClientIP,
+		Action:      dbEntry.Action,
+		Entity:      dbEntry.Entity,
+		EntityID:    dbEntry.EntityID,
+		Diff:        json.RawMessage(dbEntry.Diff),
+		DateCreated: dbEntry.DateCreated,
+		PrevHash:    dbEntry.PrevHash,
+		Hash:        dbEntry.Hash,
+	}
+}
+
+func toEntrySlice(dbEntries []db.Entry) []Entry {
+	entries := make([]Entry, len(dbEntries))
+	for i, dbEntry := range dbEntries {
+		entries[i] = toEntry(dbEntry)
+	}
+	return entries
+}
+
+func toDBQueryFilter(filter QueryFilter) db.QueryFilter {
+	return db.QueryFilter{
+		ActorID:       filter.ActorID,
+		Entity:        filter.Entity,
+		EntityID:      filter.EntityID,
+		CreatedAfter:  filter.CreatedAfter,
+		CreatedBefore: filter.CreatedBefore,
+	}
+}
@@ -0,0 +1,603 @@
+// Package collection provides the core business API for collections of
+// images, such as the albums and lightboxes editors build for a story.
+package collection
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/collection/db"
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for CRUD operations.
+var (
+	ErrNotFound      = errors.New("collection not found")
+	ErrInvalidID     = errors.New("ID is not in its proper form")
+	ErrImageNotFound = errors.New("image not found")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidOrder  = errors.New("order must list every image in the collection once")
+)
+
+// Set of access levels for a collection.
+const (
+	AccessPrivate = "private"
+	AccessShared  = "shared"
+	AccessPublic  = "public"
+)
+
+// ContentOpener declares the behavior required to read the original content
+// of the images in a collection.
+type ContentOpener interface {
+	OpenContent(ctx context.Context, imageID string) (image.Content, error)
+}
+
+// Core manages the set of APIs for collection access.
+type Core struct {
+	store  db.Store
+	images ContentOpener
+}
+
+// NewCore constructs a core for collection api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, images ContentOpener) Core {
+	return Core{
+		store:  db.NewStore(log, sqlxDB),
+		images: images,
+	}
+}
+
+// Create adds a Collection to the database. Collections are private unless
+// an access level is given.
+func (c Core) Create(ctx context.Context, nc NewCollection, now time.Time) (Collection, error) {
+	if err := validate.Check(nc); err != nil {
+		return Collection{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	access := nc.Access
+	if access == "" {
+		access = AccessPrivate
+	}
+
+	dbCol := db.Collection{
+		ID:          validate.GenerateID(),
+		UserID:      nc.UserID,
+		Name:        strings.TrimSpace(nc.Name),
+		Description: strings.TrimSpace(nc.Description),
+		Access:      access,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := store.Create(ctx, dbCol); err != nil {
+			return fmt.Errorf("create: %w", err)
+		}
+
+		var err error
+		dbCol, err = c.replaceShares(ctx, store, dbCol.ID, nc.SharedWith, now)
+		return err
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return Collection{}, ErrUserNotFound
+		}
+		return Collection{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return toCollection(dbCol), nil
+}
+
+// Update modifies a Collection. It will error if the specified ID is invalid
+// or does not reference an existing Collection.
+func (c Core) Update(ctx context.Context, collectionID string, uc UpdateCollection, now time.Time) (Collection, error) {
+	if err := validate.CheckID(collectionID); err != nil {
+		return Collection{}, ErrInvalidID
+	}
+
+	if err := validate.Check(uc); err != nil {
+		return Collection{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	var dbCol db.Collection
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		var err error
+		dbCol, err = store.QueryByID(ctx, collectionID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if uc.Name != nil {
+			dbCol.Name = strings.TrimSpace(*uc.Name)
+		}
+		if uc.Description != nil {
+			dbCol.Description = strings.TrimSpace(*uc.Description)
+		}
+		if uc.Access != nil {
+			dbCol.Access = *uc.Access
+		}
+		dbCol.DateUpdated = now
+
+		if err := store.Update(ctx, dbCol); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+
+		if uc.SharedWith != nil {
+			shared, err := c.replaceShares(ctx, store, collectionID, *uc.SharedWith, now)
+			if err != nil {
+				return err
+			}
+			dbCol.SharedWith = shared.SharedWith
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return Collection{}, ErrNotFound
+		case errors.Is(err, ErrUserNotFound):
+			return Collection{}, ErrUserNotFound
+		}
+		return Collection{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return toCollection(dbCol), nil
+}
+
+// Delete removes the Collection identified by a given ID. The images in it
+// are left alone.
+func (c Core) Delete(ctx context.Context, collectionID string) error {
+	if err := validate.CheckID(collectionID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := c.store.Delete(ctx, collectionID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	return nil
+}
+
+// QueryPage gets a page of the collections that match the filter.
+// Collections can be sorted by date_created, id or name. It returns the
+// cursor for the next page, which is empty when there are no more
+// collections.
+func (c Core) QueryPage(ctx context.Context, filter QueryFilter, page paging.Page) ([]Collection, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-date_created", "date_created", "id", "name")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   sortColumns[order.Field],
+		IDColumn: "collection_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbCols, err := c.store.QueryPage(ctx, db.QueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	// One more row than the limit was asked for to learn if there's a
+	// next page without a count.
+	var next string
+	if len(dbCols) > limit {
+		dbCols = dbCols[:limit]
+		last := dbCols[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last), last.ID)
+	}
+
+	return toCollectionSlice(dbCols), next, nil
+}
+
+// QueryByID finds the Collection identified by a given ID.
+func (c Core) QueryByID(ctx context.Context, collectionID string) (Collection, error) {
+	if err := validate.CheckID(collectionID); err != nil {
+		return Collection{}, ErrInvalidID
+	}
+
+	dbCol, err := c.store.QueryByID(ctx, collectionID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Collection{}, ErrNotFound
+		}
+		return Collection{}, fmt.Errorf("query: %w", err)
+	}
+
+	return toCollection(dbCol), nil
+}
+
+// =============================================================================
+
+// QueryItems finds the images in the Collection identified by a given ID, in
+// order. When a viewer is given, the images they can't see are left out.
+func (c Core) QueryItems(ctx context.Context, collectionID string, viewerID *string, viewerOrganizationID *string) ([]Item, error) {
+	if err := validate.CheckID(collectionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbItems, err := c.store.QueryItems(ctx, collectionID, viewerID, viewerOrganizationID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toItemSlice(dbItems), nil
+}
+
+// AddItems appends images to the Collection identified by a given ID in the
+// order given. Images already in the collection keep their position. It
+// returns every item in the collection.
+func (c Core) AddItems(ctx context.Context, collectionID string, ni NewItems, now time.Time) ([]Item, error) {
+	if err := validate.CheckID(collectionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := validate.Check(ni); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	var dbItems []db.Item
+	var missing []string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := c.lock(ctx, store, collectionID); err != nil {
+			return err
+		}
+
+		found, err := store.QueryImageIDs(ctx, ni.ImageIDs, ni.ViewerID, ni.ViewerOrganizationID)
+		if err != nil {
+			return fmt.Errorf("query images: %w", err)
+		}
+		if missing = difference(ni.ImageIDs, found); len(missing) > 0 {
+			return ErrImageNotFound
+		}
+
+		for _, imageID := range ni.ImageIDs {
+			dbItem := db.Item{
+				CollectionID: collectionID,
+				ImageID:      imageID,
+				DateAdded:    now,
+			}
+			if err := store.AddItem(ctx, dbItem); err != nil {
+				return fmt.Errorf("add item: %w", err)
+			}
+		}
+
+		dbItems, err = store.QueryItems(ctx, collectionID, nil, nil)
+		if err != nil {
+			return fmt.Errorf("query items: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil, ErrNotFound
+		case errors.Is(err, ErrImageNotFound):
+			return nil, fmt.Errorf("%w: %s", ErrImageNotFound, strings.Join(missing, ", "))
+		}
+		return nil, fmt.Errorf("tran: %w", err)
+	}
+
+	return toItemSlice(dbItems), nil
+}
+
+// RemoveItem removes an image from the Collection identified by a given ID.
+// The images after it move up a position. Removing an image the collection
+// doesn't hold is not an error.
+func (c Core) RemoveItem(ctx context.Context, collectionID string, imageID string) error {
+	if err := validate.CheckID(collectionID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := validate.CheckID(imageID); err != nil {
+		return ErrInvalidID
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := c.lock(ctx, store, collectionID); err != nil {
+			return err
+		}
+
+		if err := store.RemoveItem(ctx, collectionID, imageID); err != nil {
+			return fmt.Errorf("remove item: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// Reorder puts the images of the Collection identified by a given ID in the
+// specified order, which must list every image in the collection once. It
+// returns the reordered items.
+func (c Core) Reorder(ctx context.Context, collectionID string, order Order) ([]Item, error) {
+	if err := validate.CheckID(collectionID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := validate.Check(order); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	var dbItems []db.Item
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := c.lock(ctx, store, collectionID); err != nil {
+			return err
+		}
+
+		current, err := store.QueryItems(ctx, collectionID, nil, nil)
+		if err != nil {
+			return fmt.Errorf("query items: %w", err)
+		}
+
+		ids := make([]string, len(current))
+		for i, item := range current {
+			ids[i] = item.ImageID
+		}
+		if !samePermutation(ids, order.ImageIDs) {
+			return ErrInvalidOrder
+		}
+
+		for i, imageID := range order.ImageIDs {
+			dbItem := db.Item{
+				CollectionID: collectionID,
+				ImageID:      imageID,
+				Position:     i,
+			}
+			if err := store.UpdatePosition(ctx, dbItem); err != nil {
+				return fmt.Errorf("update position: %w", err)
+			}
+		}
+
+		dbItems, err = store.QueryItems(ctx, collectionID, nil, nil)
+		if err != nil {
+			return fmt.Errorf("query items: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return nil, ErrNotFound
+		case errors.Is(err, ErrInvalidOrder):
+			return nil, ErrInvalidOrder
+		}
+		return nil, fmt.Errorf("tran: %w", err)
+	}
+
+	return toItemSlice(dbItems), nil
+}
+
+// WriteZip streams a ZIP archive of the original content of the images in
+// the Collection identified by a given ID to the writer. Entries are named
+// after their position and image ID so the archive keeps the collection's
+// order. Images are stored rather than compressed since their content is
+// compressed already. Images removed while the archive is being written are
+// left out, as are the images a given viewer can't see.
+func (c Core) WriteZip(ctx context.Context, w io.Writer, collectionID string, viewerID *string, viewerOrganizationID *string) error {
+	if err := validate.CheckID(collectionID); err != nil {
+		return ErrInvalidID
+	}
+
+	dbItems, err := c.store.QueryItems(ctx, collectionID, viewerID, viewerOrganizationID)
+	if err != nil {
+		return fmt.Errorf("query items: %w", err)
+	}
+
+	width := len(fmt.Sprint(len(dbItems)))
+
+	zw := zip.NewWriter(w)
+	for i, dbItem := range dbItems {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		content, err := c.images.OpenContent(ctx, dbItem.ImageID)
+		if err != nil {
+			if errors.Is(err, image.ErrNotFound) {
+				continue
+			}
+			return fmt.Errorf("opening image[%s]: %w", dbItem.ImageID, err)
+		}
+
+		hdr := zip.FileHeader{
+			Name:     fmt.Sprintf("%0*d-%s.%s", width, i+1, dbItem.ImageID, strings.TrimPrefix(content.MimeType, "image/")),
+			Method:   zip.Store,
+			Modified: content.ModTime,
+		}
+
+		err = writeEntry(zw, &hdr, content)
+		content.Close()
+		if err != nil {
+			return fmt.Errorf("writing image[%s]: %w", dbItem.ImageID, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("closing archive: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// sortColumns maps the fields collections can be sorted on to their columns.
+var sortColumns = map[string]string{
+	"date_created": "date_created",
+	"id":           "collection_id",
+	"name":         "name",
+}
+
+// sortKey returns the value of the sort field of a collection as held in a
+// cursor.
+func sortKey(field string, dbCol db.Collection) string {
+	switch field {
+	case "date_created":
+		return dbCol.DateCreated.UTC().Format(time.RFC3339Nano)
+	case "name":
+		return dbCol.Name
+	}
+	return ""
+}
+
+// parseSortKey converts the sort key held in a cursor back to the value of
+// the sort field.
+func parseSortKey(field string, key string) (any, error) {
+	switch field {
+	case "date_created":
+		return time.Parse(time.RFC3339Nano, key)
+	case "name":
+		return key, nil
+	}
+	return nil, nil
+}
+
+// lock locks the collection for the rest of the transaction, reporting
+// ErrNotFound if it doesn't exist.
+func (c Core) lock(ctx context.Context, store db.Store, collectionID string) error {
+	if err := store.Lock(ctx, collectionID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("lock: %w", err)
+	}
+	return nil
+}
+
+// replaceShares sets the users the collection is shared with and returns the
+// collection as stored. It reports ErrUserNotFound if any of the users
+// doesn't exist.
+func (c Core) replaceShares(ctx context.Context, store db.Store, collectionID string, userIDs []string, now time.Time) (db.Collection, error) {
+	userIDs = unique(userIDs)
+
+	if err := store.ReplaceShares(ctx, collectionID, userIDs, now); err != nil {
+		return db.Collection{}, fmt.Errorf("replace shares: %w", err)
+	}
+
+	dbCol, err := store.QueryByID(ctx, collectionID)
+	if err != nil {
+		return db.Collection{}, fmt.Errorf("query: %w", err)
+	}
+
+	if len(dbCol.SharedWith) != len(userIDs) {
+		return db.Collection{}, ErrUserNotFound
+	}
+
+	return dbCol, nil
+}
+
+// writeEntry copies content into a new entry of the archive.
+func writeEntry(zw *zip.Writer, hdr *zip.FileHeader, content io.Reader) error {
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, content)
+	return err
+}
+
+// unique returns the values without duplicates, keeping their order.
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// difference returns the values in a that aren't in b.
+func difference(a []string, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[v] = true
+	}
+
+	var out []string
+	for _, v := range a {
+		if !in[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// samePermutation reports whether b holds exactly the values of a, each once.
+func samePermutation(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[string]int, len(a))
+	for _, v := range a {
+		count[v]++
+	}
+	for _, v := range b {
+		count[v]--
+		if count[v] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package collection_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/collection"
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/foundation/docker"
+	"github.com/google/go-cmp/cmp"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+// Set of users from the seed data.
+const (
+	ownerID = "5cf37266-3473-4006-984f-9325122678b7"
+	otherID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+)
+
+func Test_Collection(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testcollection")
+	t.Cleanup(teardown)
+
+	images := image.NewCore(log, db, blob.NewMemory(), image.WithPresets(nil))
+	core := collection.NewCore(log, db, images)
+
+	t.Log("Given the need to work with Collection records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single Collection.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+			nc := collection.NewCollection{
+				UserID: ownerID,
+				Name:   "Election night",
+			}
+
+			col, err := core.Create(ctx, nc, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a collection : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a collection.", dbtest.Success, testID)
+
+			if col.Access != collection.AccessPrivate || col.VisibleTo(otherID) {
+				t.Fatalf("\t%s\tTest %d:\tShould be private by default : got %s.", dbtest.Failed, testID, col.Access)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be private by default.", dbtest.Success, testID)
+
+			access := collection.AccessShared
+			uc := collection.UpdateCollection{
+				Access:     &access,
+				SharedWith: &[]string{otherID},
+			}
+
+			col, err = core.Update(ctx, col.ID, uc, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to share a collection : %s.", dbtest.Failed, testID, err)
+			}
+			saved, err := core.QueryByID(ctx, col.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve collection by ID : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff(col, saved); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same collection. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			if !saved.VisibleTo(otherID) {
+				t.Fatalf("\t%s\tTest %d:\tShould be visible to the user it's shared with.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to share a collection.", dbtest.Success, testID)
+
+			uc = collection.UpdateCollection{
+				SharedWith: &[]string{"c0a5a5a4-51e4-4c1a-8c4b-1b4b3b1d9b51"},
+			}
+			if _, err := core.Update(ctx, col.ID, uc, now); !errors.Is(err, collection.ErrUserNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to share with an unknown user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to share with an unknown user.", dbtest.Success, testID)
+
+			member := otherID
+			filter := collection.QueryFilter{MemberID: &member}
+			cols, _, err := core.QueryPage(ctx, filter, paging.Page{})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query collections : %s.", dbtest.Failed, testID, err)
+			}
+			if len(cols) != 1 || cols[0].ID != col.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould list the collections shared with a user : got %d.", dbtest.Failed, testID, len(cols))
+			}
+			t.Logf("\t%s\tTest %d:\tShould list the collections shared with a user.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, col.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete collection : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryByID(ctx, col.ID); !errors.Is(err, collection.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve collection : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete collection.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen handling the images of a Collection.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.April, 2, 0, 0, 0, 0, time.UTC)
+
+			var ids []string
+			for i := 1; i <= 3; i++ {
+				img, err := images.Create(ctx, image.NewImage{UserID: ownerID}, bytes.NewReader(dbtest.PNGContent(t, i*10, i*10)), now)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+				}
+				ids = append(ids, img.ID)
+			}
+
+			col, err := core.Create(ctx, collection.NewCollection{UserID: ownerID, Name: "Lightbox"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a collection : %s.", dbtest.Failed, testID, err)
+			}
+
+			items, err := core.AddItems(ctx, col.ID, collection.NewItems{ImageIDs: ids}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to add images : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff(ids, itemIDs(items)); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the images in the order added. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to add images.", dbtest.Success, testID)
+
+			unknown := collection.NewItems{ImageIDs: []string{"c0a5a5a4-51e4-4c1a-8c4b-1b4b3b1d9b51"}}
+			if _, err := core.AddItems(ctx, col.ID, unknown, now); !errors.Is(err, collection.ErrImageNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to add an unknown image : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to add an unknown image.", dbtest.Success, testID)
+
+			order := []string{ids[2], ids[0], ids[1]}
+			items, err = core.Reorder(ctx, col.ID, collection.Order{ImageIDs: order})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reorder images : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff(order, itemIDs(items)); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the images in the new order. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reorder images.", dbtest.Success, testID)
+
+			if _, err := core.Reorder(ctx, col.ID, collection.Order{ImageIDs: order[:2]}); !errors.Is(err, collection.ErrInvalidOrder) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to leave images out of an order : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to leave images out of an order.", dbtest.Success, testID)
+
+			if err := core.RemoveItem(ctx, col.ID, ids[0]); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to remove an image : %s.", dbtest.Failed, testID, err)
+			}
+			items, err = core.QueryItems(ctx, col.ID, nil, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query images : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff([]string{ids[2], ids[1]}, itemIDs(items)); diff != "" || items[1].Position != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould close the gap left by a removed image. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to remove an image.", dbtest.Success, testID)
+
+			var buf bytes.Buffer
+			if err := core.WriteZip(ctx, &buf, col.ID, nil, nil); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write a zip : %s.", dbtest.Failed, testID, err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to read the zip : %s.", dbtest.Failed, testID, err)
+			}
+
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			want := []string{"1-" + ids[2] + ".png", "2-" + ids[1] + ".png"}
+			if diff := cmp.Diff(want, names); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould hold the originals in order. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to write a zip.", dbtest.Success, testID)
+
+			public := image.VisibilityPublic
+			if err := images.Update(ctx, ids[1], image.UpdateImage{Visibility: &public}, ownerID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to make an image public : %s.", dbtest.Failed, testID, err)
+			}
+
+			viewerID := otherID
+			items, err = core.QueryItems(ctx, col.ID, &viewerID, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query images : %s.", dbtest.Failed, testID, err)
+			}
+			if diff := cmp.Diff([]string{ids[1]}, itemIDs(items)); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould only list the images a viewer can see. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only list the images a viewer can see.", dbtest.Success, testID)
+
+			buf.Reset()
+			if err := core.WriteZip(ctx, &buf, col.ID, &viewerID, nil); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write a zip : %s.", dbtest.Failed, testID, err)
+			}
+			if zr, err = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil || len(zr.File) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould only zip the images a viewer can see : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only zip the images a viewer can see.", dbtest.Success, testID)
+		}
+	}
+}
+
+// itemIDs returns the image IDs of the items in order.
+func itemIDs(items []collection.Item) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ImageID
+	}
+	return ids
+}
@@ -0,0 +1,395 @@
+// Package db contains collection related CRUD functionality.
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for collection access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// selectCollections selects collections along with the users each one is
+// shared with.
+const selectCollections = `
+	SELECT
+		c.collection_id, c.user_id, c.name, c.description, c.access, c.date_created, c.date_updated,
+		ARRAY(SELECT s.user_id FROM collection_shares AS s WHERE s.collection_id = c.collection_id ORDER BY s.user_id) AS shared_with
+	FROM
+		collections AS c`
+
+// Create adds a Collection to the database. The users it's shared with are
+// set separately with ReplaceShares.
+func (s Store) Create(ctx context.Context, col Collection) error {
+	const q = `
+	INSERT INTO collections
+		(collection_id, user_id, name, description, access, date_created, date_updated)
+	VALUES
+		(:collection_id, :user_id, :name, :description, :access, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, col); err != nil {
+		return fmt.Errorf("inserting collection: %w", err)
+	}
+
+	return nil
+}
+
+// Update modifies the details of a Collection.
+func (s Store) Update(ctx context.Context, col Collection) error {
+	const q = `
+	UPDATE
+		collections
+	SET
+		"name" = :name,
+		"description" = :description,
+		"access" = :access,
+		"date_updated" = :date_updated
+	WHERE
+		collection_id = :collection_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, col); err != nil {
+		return fmt.Errorf("updating collection collectionID[%s]: %w", col.ID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the Collection identified by a given ID.
+func (s Store) Delete(ctx context.Context, collectionID string) error {
+	data := struct {
+		CollectionID string `db:"collection_id"`
+	}{
+		CollectionID: collectionID,
+	}
+
+	const q = `
+	DELETE FROM
+		collections
+	WHERE
+		collection_id = :collection_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting collection collectionID[%s]: %w", collectionID, err)
+	}
+
+	return nil
+}
+
+// ReplaceShares sets the users a Collection is shared with. User IDs that
+// don't identify a user are left out.
+func (s Store) ReplaceShares(ctx context.Context, collectionID string, userIDs []string, now time.Time) error {
+	data := struct {
+		CollectionID string         `db:"collection_id"`
+		UserIDs      pq.StringArray `db:"user_ids"`
+		DateCreated  time.Time      `db:"date_created"`
+	}{
+		CollectionID: collectionID,
+		UserIDs:      userIDs,
+		DateCreated:  now,
+	}
+
+	const del = `
+	DELETE FROM
+		collection_shares
+	WHERE
+		collection_id = :collection_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, del, data); err != nil {
+		return fmt.Errorf("deleting shares collectionID[%s]: %w", collectionID, err)
+	}
+
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	const q = `
+	INSERT INTO collection_shares
+		(collection_id, user_id, date_created)
+	SELECT
+		:collection_id, user_id, :date_created
+	FROM
+		users
+	WHERE
+		user_id = ANY(:user_ids)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("inserting shares collectionID[%s]: %w", collectionID, err)
+	}
+
+	return nil
+}
+
+// QueryPage gets a page of the Collections that match the filter.
+func (s Store) QueryPage(ctx context.Context, filter QueryFilter, keyset database.Keyset) ([]Collection, error) {
+	data := make(map[string]any)
+
+	var wc []string
+
+	if filter.MemberID != nil {
+		data["member_id"] = *filter.MemberID
+		wc = append(wc, "(c.user_id = :member_id OR EXISTS (SELECT 1 FROM collection_shares AS s WHERE s.collection_id = c.collection_id AND s.user_id = :member_id))")
+	}
+
+	if filter.Access != nil {
+		data["access"] = *filter.Access
+		wc = append(wc, "c.access = :access")
+	}
+
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(selectCollections)
+	if len(wc) > 0 {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	}
+	buf.WriteString(keyset.OrderBy(data))
+
+	var cols []Collection
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &cols); err != nil {
+		return nil, fmt.Errorf("selecting collections: %w", err)
+	}
+
+	return cols, nil
+}
+
+// QueryByID finds the Collection identified by a given ID.
+func (s Store) QueryByID(ctx context.Context, collectionID string) (Collection, error) {
+	data := struct {
+		CollectionID string `db:"collection_id"`
+	}{
+		CollectionID: collectionID,
+	}
+
+	const q = selectCollections + `
+	WHERE
+		c.collection_id = :collection_id`
+
+	var col Collection
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &col); err != nil {
+		return Collection{}, fmt.Errorf("selecting collection collectionID[%q]: %w", collectionID, err)
+	}
+
+	return col, nil
+}
+
+// Lock locks the Collection identified by a given ID until the surrounding
+// transaction ends, so changes to its items are made one at a time.
+func (s Store) Lock(ctx context.Context, collectionID string) error {
+	data := struct {
+		CollectionID string `db:"collection_id"`
+	}{
+		CollectionID: collectionID,
+	}
+
+	const q = `
+	SELECT
+		collection_id
+	FROM
+		collections
+	WHERE
+		collection_id = :collection_id
+	FOR UPDATE`
+
+	var col struct {
+		ID string `db:"collection_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &col); err != nil {
+		return fmt.Errorf("locking collection collectionID[%q]: %w", collectionID, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// QueryItems finds the Items of a Collection in order. When a viewer is
+// given, only the items holding an image they may see are returned.
+func (s Store) QueryItems(ctx context.Context, collectionID string, viewerID *string, viewerOrganizationID *string) ([]Item, error) {
+	data := map[string]any{
+		"collection_id": collectionID,
+	}
+
+	buf := bytes.NewBufferString(`
+	SELECT
+		*
+	FROM
+		collection_items
+	WHERE
+		collection_id = :collection_id`)
+
+	if viewerID != nil {
+		buf.WriteString(" AND image_id IN (SELECT image_id FROM images WHERE " + visibleTo(data, *viewerID, viewerOrganizationID) + ")")
+	}
+
+	buf.WriteString(" ORDER BY position")
+
+	var items []Item
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &items); err != nil {
+		return nil, fmt.Errorf("selecting items collectionID[%s]: %w", collectionID, err)
+	}
+
+	return items, nil
+}
+
+// AddItem adds an image to the end of a Collection. Adding an image the
+// collection already holds does nothing.
+func (s Store) AddItem(ctx context.Context, item Item) error {
+	const q = `
+	INSERT INTO collection_items
+		(collection_id, image_id, position, date_added)
+	VALUES
+		(:collection_id, :image_id,
+		(SELECT COALESCE(MAX(position) + 1, 0) FROM collection_items WHERE collection_id = :collection_id),
+		:date_added)
+	ON CONFLICT (collection_id, image_id) DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, item); err != nil {
+		return fmt.Errorf("adding item collectionID[%s] imageID[%s]: %w", item.CollectionID, item.ImageID, err)
+	}
+
+	return nil
+}
+
+// RemoveItem removes an image from a Collection, moving the images after it
+// up a position.
+func (s Store) RemoveItem(ctx context.Context, collectionID string, imageID string) error {
+	data := struct {
+		CollectionID string `db:"collection_id"`
+		ImageID      string `db:"image_id"`
+	}{
+		CollectionID: collectionID,
+		ImageID:      imageID,
+	}
+
+	const q = `
+	WITH removed AS (
+		DELETE FROM
+			collection_items
+		WHERE
+			collection_id = :collection_id AND image_id = :image_id
+		RETURNING
+			position
+	)
+	UPDATE
+		collection_items
+	SET
+		position = position - 1
+	WHERE
+		collection_id = :collection_id AND position > (SELECT position FROM removed)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("removing item collectionID[%s] imageID[%s]: %w", collectionID, imageID, err)
+	}
+
+	return nil
+}
+
+// UpdatePosition moves an image of a Collection to the specified position.
+func (s Store) UpdatePosition(ctx context.Context, item Item) error {
+	const q = `
+	UPDATE
+		collection_items
+	SET
+		position = :position
+	WHERE
+		collection_id = :collection_id AND image_id = :image_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, item); err != nil {
+		return fmt.Errorf("updating position collectionID[%s] imageID[%s]: %w", item.CollectionID, item.ImageID, err)
+	}
+
+	return nil
+}
+
+// QueryImageIDs returns the IDs from the list that identify an image that
+// isn't in the trash. When a viewer is given, only the images they may see
+// are returned.
+func (s Store) QueryImageIDs(ctx context.Context, imageIDs []string, viewerID *string, viewerOrganizationID *string) ([]string, error) {
+	data := map[string]any{
+		"image_ids": pq.StringArray(imageIDs),
+	}
+
+	buf := bytes.NewBufferString(`
+	SELECT
+		image_id
+	FROM
+		images
+	WHERE
+		image_id = ANY(:image_ids) AND deleted_at IS NULL`)
+
+	if viewerID != nil {
+		buf.WriteString(" AND (" + visibleTo(data, *viewerID, viewerOrganizationID) + ")")
+	}
+
+	var rows []struct {
+		ImageID string `db:"image_id"`
+	}
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &rows); err != nil {
+		return nil, fmt.Errorf("selecting images: %w", err)
+	}
+
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ImageID
+	}
+
+	return ids, nil
+}
+
+// =============================================================================
+
+// visibleTo returns the condition matching the images a viewer may see, adding
+// its parameters to the data. Viewers see their own images, public images and
+// the organization images of their organization.
+func visibleTo(data map[string]any, viewerID string, viewerOrganizationID *string) string {
+	data["viewer_id"] = viewerID
+	visible := "visibility = 'public' OR user_id = :viewer_id"
+	if viewerOrganizationID != nil {
+		data["viewer_organization_id"] = *viewerOrganizationID
+		visible += " OR (visibility = 'organization' AND organization_id = :viewer_organization_id)"
+	}
+
+	return visible
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Collection represents an ordered set of images such as an album or a
+// lightbox.
+type Collection struct {
+	ID          string         `db:"collection_id"` // Unique identifier.
+	UserID      string         `db:"user_id"`       // ID of the user who owns the collection.
+	Name        string         `db:"name"`          // Display name of the collection.
+	Description string         `db:"description"`   // Notes about the collection.
+	Access      string         `db:"access"`        // Who may view the collection.
+	SharedWith  pq.StringArray `db:"shared_with"`   // IDs of the users the collection is shared with.
+	DateCreated time.Time      `db:"date_created"`  // When the collection was created.
+	DateUpdated time.Time      `db:"date_updated"`  // When the collection was last modified.
+}
+
+// QueryFilter holds the available fields a query of collections can be
+// filtered on. Nil fields are not filtered on.
+type QueryFilter struct {
+	MemberID *string
+	Access   *string
+}
+
+// Item represents an image held in a collection.
+type Item struct {
+	CollectionID string    `db:"collection_id"` // ID of the collection.
+	ImageID      string    `db:"image_id"`      // ID of the image.
+	Position     int       `db:"position"`      // Zero based position of the image in the collection.
+	DateAdded    time.Time `db:"date_added"`    // When the image was added.
+}
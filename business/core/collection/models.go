@@ -0,0 +1,132 @@
+package collection
+
+import (
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/collection/db"
+)
+
+// Collection represents an ordered set of images such as an album or a
+// lightbox.
+type Collection struct {
+	ID          string    `json:"id"`           // Unique identifier.
+	UserID      string    `json:"user_id"`      // User who owns the collection.
+	Name        string    `json:"name"`         // Display name of the collection.
+	Description string    `json:"description"`  // Notes about the collection.
+	Access      string    `json:"access"`       // Who may view the collection.
+	SharedWith  []string  `json:"shared_with"`  // Users the collection is shared with.
+	DateCreated time.Time `json:"date_created"` // When the collection was created.
+	DateUpdated time.Time `json:"date_updated"` // When the collection was last modified.
+}
+
+// VisibleTo reports whether the specified user may view the collection. The
+// owner can always view it, the users it's shared with can once its access
+// is shared, and everyone can once it's public.
+func (c Collection) VisibleTo(userID string) bool {
+	switch {
+	case c.UserID == userID, c.Access == AccessPublic:
+		return true
+	case c.Access == AccessShared:
+		for _, id := range c.SharedWith {
+			if id == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewCollection is what we require from clients when adding a Collection.
+type NewCollection struct {
+	UserID      string   `json:"user_id" validate:"required"`
+	Name        string   `json:"name" validate:"required,max=256"`
+	Description string   `json:"description" validate:"max=2000"`
+	Access      string   `json:"access" validate:"omitempty,oneof=private shared public"`
+	SharedWith  []string `json:"shared_with" validate:"omitempty,max=100,dive,uuid4"`
+}
+
+// UpdateCollection defines what information may be provided to modify an
+// existing Collection. All fields are optional so clients can send just the
+// fields they want changed. SharedWith replaces the users the collection is
+// shared with.
+type UpdateCollection struct {
+	Name        *string   `json:"name" validate:"omitempty,min=1,max=256"`
+	Description *string   `json:"description" validate:"omitempty,max=2000"`
+	Access      *string   `json:"access" validate:"omitempty,oneof=private shared public"`
+	SharedWith  *[]string `json:"shared_with" validate:"omitempty,max=100,dive,uuid4"`
+}
+
+// QueryFilter holds the available fields a query of collections can be
+// filtered on. Nil fields are not filtered on. MemberID matches the
+// collections a user owns or has been shared.
+type QueryFilter struct {
+	MemberID *string `json:"member_id" validate:"omitempty,uuid4"`
+	Access   *string `json:"access" validate:"omitempty,oneof=private shared public"`
+}
+
+// Item represents an image held in a Collection.
+type Item struct {
+	ImageID   string    `json:"image_id"`   // ID of the image.
+	Position  int       `json:"position"`   // Zero based position of the image in the collection.
+	DateAdded time.Time `json:"date_added"` // When the image was added.
+}
+
+// NewItems is what we require from clients when adding images to a
+// Collection.
+type NewItems struct {
+	ImageIDs []string `json:"image_ids" validate:"required,min=1,max=500,dive,uuid4"`
+
+	// Images are reported missing unless the viewer may see them.
+	ViewerID             *string `json:"-" validate:"omitempty,uuid4"`
+	ViewerOrganizationID *string `json:"-" validate:"omitempty,uuid4"`
+}
+
+// Order is what we require from clients when reordering a Collection. It
+// must list every image in the collection once, in the new order.
+type Order struct {
+	ImageIDs []string `json:"image_ids" validate:"required,dive,uuid4"`
+}
+
+// =============================================================================
+
+func toCollection(dbCol db.Collection) Collection {
+	sharedWith := []string(dbCol.SharedWith)
+	if sharedWith == nil {
+		sharedWith = []string{}
+	}
+
+	return Collection{
+		ID:          dbCol.ID,
+		UserID:      dbCol.UserID,
+		Name:        dbCol.Name,
+		Description: dbCol.Description,
+		Access:      dbCol.Access,
+		SharedWith:  sharedWith,
+		DateCreated: dbCol.DateCreated,
+		DateUpdated: dbCol.DateUpdated,
+	}
+}
+
+func toCollectionSlice(dbCols []db.Collection) []Collection {
+	cols := make([]Collection, len(dbCols))
+	for i, dbCol := range dbCols {
+		cols[i] = toCollection(dbCol)
+	}
+	return cols
+}
+
+func toItem(dbItem db.Item) Item {
+	return Item{
+		ImageID:   dbItem.ImageID,
+		Position:  dbItem.Position,
+		DateAdded: dbItem.DateAdded,
+	}
+}
+
+func toItemSlice(dbItems []db.Item) []Item {
+	items := make([]Item, len(dbItems))
+	for i, dbItem := range dbItems {
+		items[i] = toItem(dbItem)
+	}
+	return items
+}
@@ -2,11 +2,15 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/fadhilijuma/images/business/sys/database"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -30,7 +34,7 @@ func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
 // WithinTran runs passed function and do commit/rollback at the end.
 func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
 	if s.isWithinTran {
-		fn(s.db)
+		return fn(s.db)
 	}
 	return database.WithinTran(ctx, s.log, s.tr, fn)
 }
@@ -45,14 +49,31 @@ func (s Store) Tran(tx sqlx.ExtContext) Store {
 	}
 }
 
+// imageColumns lists the columns that make up an Image. The search vector is
+// left out since it's only ever used inside queries.
+const imageColumns = `
+		image_id, user_id, storage_key, checksum, size, mime_type, date_uploaded,
+		width, height, format, color_model, orientation, date_captured, camera_make, camera_model,
+		exposure_time, f_number, iso, focal_length, gps_latitude, gps_longitude, gps_altitude, exif,
+		caption, headline, byline, credit, copyright, keywords, city, country, instructions, usage_terms, language,
+		dhash, phash, deleted_at, deleted_by, organization_id, visibility, version`
+
 // Create adds an Image to the database. It returns the created Image with
 // fields like ID and DateUploaded populated.
 func (s Store) Create(ctx context.Context, image Image) error {
 	const q = `
 	INSERT INTO images
-		(image_id, image_url, user_id, date_uploaded)
+		(image_id, user_id, storage_key, checksum, size, mime_type, date_uploaded,
+		width, height, format, color_model, orientation, date_captured, camera_make, camera_model,
+		exposure_time, f_number, iso, focal_length, gps_latitude, gps_longitude, gps_altitude, exif,
+		caption, headline, byline, credit, copyright, keywords, city, country, instructions, usage_terms, language,
+		dhash, phash, organization_id, visibility, version)
 	VALUES
-		(:image_id, :image_url, :user_id, :date_uploaded)`
+		(:image_id, :user_id, :storage_key, :checksum, :size, :mime_type, :date_uploaded,
+		:width, :height, :format, :color_model, :orientation, :date_captured, :camera_make, :camera_model,
+		:exposure_time, :f_number, :iso, :focal_length, :gps_latitude, :gps_longitude, :gps_altitude, :exif,
+		:caption, :headline, :byline, :credit, :copyright, :keywords, :city, :country, :instructions, :usage_terms, :language,
+		:dhash, :phash, :organization_id, :visibility, :version)`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, image); err != nil {
 		return fmt.Errorf("inserting image: %w", err)
@@ -68,10 +89,22 @@ func (s Store) Update(ctx context.Context, image Image) error {
 	UPDATE
 		images
 	SET
-		"image_url" = :image_url,
-		"user_id" = :user_id
+		"user_id" = :user_id,
+		"caption" = :caption,
+		"headline" = :headline,
+		"byline" = :byline,
+		"credit" = :credit,
+		"copyright" = :copyright,
+		"keywords" = :keywords,
+		"city" = :city,
+		"country" = :country,
+		"instructions" = :instructions,
+		"usage_terms" = :usage_terms,
+		"language" = :language,
+		"visibility" = :visibility,
+		"version" = :version
 	WHERE
-		image_id = :image_id`
+		image_id = :image_id AND deleted_at IS NULL`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, image); err != nil {
 		return fmt.Errorf("updating image imageID[%s]: %w", image.ID, err)
@@ -80,8 +113,10 @@ func (s Store) Update(ctx context.Context, image Image) error {
 	return nil
 }
 
-// Delete removes the Image identified by a given ID.
-func (s Store) Delete(ctx context.Context, imageID string) error {
+// Purge permanently removes the Image in the trash identified by a given ID
+// and returns it. It returns database.ErrDBNotFound when the image isn't in
+// the trash, such as when it was restored since it was found.
+func (s Store) Purge(ctx context.Context, imageID string) (Image, error) {
 	data := struct {
 		ImageID string `db:"image_id"`
 	}{
@@ -92,42 +127,90 @@ func (s Store) Delete(ctx context.Context, imageID string) error {
 	DELETE FROM
 		images
 	WHERE
-		image_id = :image_id`
+		image_id = :image_id AND deleted_at IS NOT NULL
+	RETURNING` + imageColumns
 
-	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
-		return fmt.Errorf("deleting image imageID[%s]: %w", imageID, err)
+	var img Image
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &img); err != nil {
+		return Image{}, fmt.Errorf("purging image imageID[%s]: %w", imageID, err)
 	}
 
-	return nil
+	return img, nil
 }
 
-// Query gets all Images from the database.
-func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Image, error) {
-	data := struct {
-		Offset      int `db:"offset"`
-		RowsPerPage int `db:"rows_per_page"`
-	}{
-		Offset:      (pageNumber - 1) * rowsPerPage,
-		RowsPerPage: rowsPerPage,
-	}
+// QueryPage gets a page of the Images that match the filter.
+func (s Store) QueryPage(ctx context.Context, filter QueryFilter, keyset database.Keyset) ([]Image, error) {
+	data := make(map[string]any)
 
 	const q = `
-	SELECT
-		*
+	SELECT` + imageColumns + `
 	FROM
-		Images
-	ORDER BY
-		user_id
-	OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+		images`
+
+	wc := applyFilter(filter, data)
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(q)
+	if len(wc) > 0 {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	}
+	buf.WriteString(keyset.OrderBy(data))
 
 	var images []Image
-	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &images); err != nil {
-		return nil, fmt.Errorf("selecting products: %w", err)
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &images); err != nil {
+		return nil, fmt.Errorf("selecting images: %w", err)
 	}
 
 	return images, nil
 }
 
+// Search gets a page of the Images that match the text search query and the
+// filter. The query is in the syntax of to_tsquery and is normalized with the
+// specified text search configuration. Rows can be ordered by their "rank".
+func (s Store) Search(ctx context.Context, query string, language string, filter QueryFilter, keyset database.Keyset) ([]SearchResult, error) {
+	data := map[string]any{
+		"query":            query,
+		"language":         language,
+		"headline_options": "StartSel=<mark>, StopSel=</mark>, HighlightAll=true",
+		"caption_options":  "StartSel=<mark>, StopSel=</mark>, MaxFragments=3, MinWords=5, MaxWords=20, FragmentDelimiter=\" … \"",
+	}
+
+	wc := append([]string{"search_vector @@ query"}, applyFilter(filter, data)...)
+
+	buf := bytes.NewBufferString(`
+	SELECT` + imageColumns + `,
+		rank,
+		ts_headline(language, headline, query, :headline_options) AS headline_snippet,
+		ts_headline(language, caption, query, :caption_options) AS caption_snippet
+	FROM (
+		SELECT
+			images.*, ts_rank_cd(search_vector, query, 32) AS rank, query
+		FROM
+			images, to_tsquery(CAST(:language AS regconfig), :query) AS query
+		WHERE
+			`)
+	buf.WriteString(strings.Join(wc, " AND\n\t\t\t"))
+	buf.WriteString("\n\t) AS results")
+
+	// The rows are ranked in the inner query so the page can be ordered and
+	// positioned by rank like any other column.
+	if where := keyset.Where(data); where != "" {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(where)
+	}
+	buf.WriteString(keyset.OrderBy(data))
+
+	var results []SearchResult
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &results); err != nil {
+		return nil, fmt.Errorf("searching images: %w", err)
+	}
+
+	return results, nil
+}
+
 // QueryByID finds the Image identified by a given ID.
 func (s Store) QueryByID(ctx context.Context, imageID string) (Image, error) {
 	data := struct {
@@ -137,12 +220,11 @@ func (s Store) QueryByID(ctx context.Context, imageID string) (Image, error) {
 	}
 
 	const q = `
-	SELECT
-		*
+	SELECT` + imageColumns + `
 	FROM
-		images 
-	GROUP BY
-		image_id`
+		images
+	WHERE
+		image_id = :image_id AND deleted_at IS NULL`
 
 	var prd Image
 	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &prd); err != nil {
@@ -161,12 +243,11 @@ func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Image, error
 	}
 
 	const q = `
-	SELECT
-		*
+	SELECT` + imageColumns + `
 	FROM
 		images
-	GROUP BY
-		user_id`
+	WHERE
+		user_id = :user_id AND deleted_at IS NULL`
 
 	var prds []Image
 	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &prds); err != nil {
@@ -175,3 +256,1384 @@ func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Image, error
 
 	return prds, nil
 }
+
+// QueryByChecksum finds an Image owned by the specified user with the
+// specified content checksum.
+func (s Store) QueryByChecksum(ctx context.Context, userID string, checksum string) (Image, error) {
+	data := struct {
+		UserID   string `db:"user_id"`
+		Checksum string `db:"checksum"`
+	}{
+		UserID:   userID,
+		Checksum: checksum,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		user_id = :user_id AND checksum = :checksum AND deleted_at IS NULL
+	ORDER BY
+		date_uploaded
+	LIMIT 1`
+
+	var img Image
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &img); err != nil {
+		return Image{}, fmt.Errorf("selecting image userID[%s] checksum[%s]: %w", userID, checksum, err)
+	}
+
+	return img, nil
+}
+
+// QueryByIDs finds the Images identified by the given IDs. IDs that don't
+// identify an image are left out.
+func (s Store) QueryByIDs(ctx context.Context, imageIDs []string) ([]Image, error) {
+	data := struct {
+		ImageIDs pq.StringArray `db:"image_ids"`
+	}{
+		ImageIDs: imageIDs,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		image_id = ANY(:image_ids) AND deleted_at IS NULL`
+
+	var images []Image
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &images); err != nil {
+		return nil, fmt.Errorf("selecting images: %w", err)
+	}
+
+	return images, nil
+}
+
+// =============================================================================
+
+// QueryHashes gets the perceptual hashes of every image that has them.
+func (s Store) QueryHashes(ctx context.Context) ([]ImageHash, error) {
+	const q = `
+	SELECT
+		image_id, dhash, phash
+	FROM
+		images
+	WHERE
+		dhash IS NOT NULL AND phash IS NOT NULL AND deleted_at IS NULL`
+
+	var hashes []ImageHash
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, struct{}{}, &hashes); err != nil {
+		return nil, fmt.Errorf("selecting hashes: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// QueryUnhashed gets up to limit Images without perceptual hashes whose IDs
+// come after the specified ID, in ID order.
+func (s Store) QueryUnhashed(ctx context.Context, afterID string, limit int) ([]Image, error) {
+	data := struct {
+		AfterID string `db:"after_id"`
+		Limit   int    `db:"limit"`
+	}{
+		AfterID: afterID,
+		Limit:   limit,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		(dhash IS NULL OR phash IS NULL) AND deleted_at IS NULL AND CAST(image_id AS TEXT) > :after_id
+	ORDER BY
+		CAST(image_id AS TEXT)
+	LIMIT :limit`
+
+	var images []Image
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &images); err != nil {
+		return nil, fmt.Errorf("selecting unhashed images: %w", err)
+	}
+
+	return images, nil
+}
+
+// UpdateHashes sets the perceptual hashes of an image.
+func (s Store) UpdateHashes(ctx context.Context, hash ImageHash) error {
+	const q = `
+	UPDATE
+		images
+	SET
+		"dhash" = :dhash,
+		"phash" = :phash
+	WHERE
+		image_id = :image_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, hash); err != nil {
+		return fmt.Errorf("updating hashes imageID[%s]: %w", hash.ImageID, err)
+	}
+
+	return nil
+}
+
+// UpdateContent replaces the content of an Image along with the metadata
+// read from it.
+func (s Store) UpdateContent(ctx context.Context, image Image) error {
+	const q = `
+	UPDATE
+		images
+	SET
+		"storage_key" = :storage_key,
+		"checksum" = :checksum,
+		"size" = :size,
+		"mime_type" = :mime_type,
+		"width" = :width,
+		"height" = :height,
+		"format" = :format,
+		"color_model" = :color_model,
+		"orientation" = :orientation,
+		"date_captured" = :date_captured,
+		"camera_make" = :camera_make,
+		"camera_model" = :camera_model,
+		"exposure_time" = :exposure_time,
+		"f_number" = :f_number,
+		"iso" = :iso,
+		"focal_length" = :focal_length,
+		"gps_latitude" = :gps_latitude,
+		"gps_longitude" = :gps_longitude,
+		"gps_altitude" = :gps_altitude,
+		"exif" = :exif,
+		"dhash" = :dhash,
+		"phash" = :phash,
+		"version" = :version
+	WHERE
+		image_id = :image_id AND deleted_at IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, image); err != nil {
+		return fmt.Errorf("updating content imageID[%s]: %w", image.ID, err)
+	}
+
+	return nil
+}
+
+// Lock locks the row of the Image identified by a given ID until the
+// surrounding transaction ends, so changes to the image are made one at a
+// time.
+func (s Store) Lock(ctx context.Context, imageID string) error {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		image_id
+	FROM
+		images
+	WHERE
+		image_id = :image_id AND deleted_at IS NULL
+	FOR UPDATE`
+
+	var row struct {
+		ImageID string `db:"image_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return fmt.Errorf("locking image imageID[%s]: %w", imageID, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// Trash moves the Image identified by a given ID to the trash, recording who
+// moved it and when. Images already in the trash are left as they are.
+func (s Store) Trash(ctx context.Context, imageID string, deletedBy string, deletedAt time.Time) error {
+	data := struct {
+		ImageID   string    `db:"image_id"`
+		DeletedBy string    `db:"deleted_by"`
+		DeletedAt time.Time `db:"deleted_at"`
+	}{
+		ImageID:   imageID,
+		DeletedBy: deletedBy,
+		DeletedAt: deletedAt,
+	}
+
+	const q = `
+	UPDATE
+		images
+	SET
+		"deleted_at" = :deleted_at,
+		"deleted_by" = :deleted_by
+	WHERE
+		image_id = :image_id AND deleted_at IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("trashing image imageID[%s]: %w", imageID, err)
+	}
+
+	return nil
+}
+
+// LockByUser locks the rows of the Images of the user identified by a given
+// ID until the surrounding transaction ends, and returns the images.
+func (s Store) LockByUser(ctx context.Context, userID string) ([]Image, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		user_id = :user_id AND deleted_at IS NULL
+	ORDER BY
+		image_id
+	FOR UPDATE`
+
+	var images []Image
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &images); err != nil {
+		return nil, fmt.Errorf("locking images userID[%s]: %w", userID, err)
+	}
+
+	return images, nil
+}
+
+// TrashByUser moves the Images of the user identified by a given ID to the
+// trash, recording who moved them and when.
+func (s Store) TrashByUser(ctx context.Context, userID string, deletedBy string, deletedAt time.Time) error {
+	data := struct {
+		UserID    string    `db:"user_id"`
+		DeletedBy string    `db:"deleted_by"`
+		DeletedAt time.Time `db:"deleted_at"`
+	}{
+		UserID:    userID,
+		DeletedBy: deletedBy,
+		DeletedAt: deletedAt,
+	}
+
+	const q = `
+	UPDATE
+		images
+	SET
+		"deleted_at" = :deleted_at,
+		"deleted_by" = :deleted_by
+	WHERE
+		user_id = :user_id AND deleted_at IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("trashing images userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// Restore takes an Image out of the trash, giving it to the user it names.
+func (s Store) Restore(ctx context.Context, image Image) error {
+	const q = `
+	UPDATE
+		images
+	SET
+		"user_id" = :user_id,
+		"deleted_at" = NULL,
+		"deleted_by" = NULL,
+		"version" = :version
+	WHERE
+		image_id = :image_id AND deleted_at IS NOT NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, image); err != nil {
+		return fmt.Errorf("restoring image imageID[%s]: %w", image.ID, err)
+	}
+
+	return nil
+}
+
+// LockTrash locks the row of the Image in the trash identified by a given ID
+// until the surrounding transaction ends, so it's restored or purged once.
+func (s Store) LockTrash(ctx context.Context, imageID string) error {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		image_id
+	FROM
+		images
+	WHERE
+		image_id = :image_id AND deleted_at IS NOT NULL
+	FOR UPDATE`
+
+	var row struct {
+		ImageID string `db:"image_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return fmt.Errorf("locking trashed image imageID[%s]: %w", imageID, err)
+	}
+
+	return nil
+}
+
+// QueryTrashByID finds the Image in the trash identified by a given ID.
+func (s Store) QueryTrashByID(ctx context.Context, imageID string) (Image, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		image_id = :image_id AND deleted_at IS NOT NULL`
+
+	var img Image
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &img); err != nil {
+		return Image{}, fmt.Errorf("selecting trashed image imageID[%q]: %w", imageID, err)
+	}
+
+	return img, nil
+}
+
+// QueryTrash gets a page of the Images in the trash that match the filter.
+func (s Store) QueryTrash(ctx context.Context, filter TrashFilter, keyset database.Keyset) ([]Image, error) {
+	data := make(map[string]any)
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images`
+
+	wc := []string{"deleted_at IS NOT NULL"}
+	if filter.UserID != nil {
+		data["user_id"] = *filter.UserID
+		wc = append(wc, "user_id = :user_id")
+	}
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(q)
+	buf.WriteString("\n\tWHERE\n\t\t")
+	buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	buf.WriteString(keyset.OrderBy(data))
+
+	var images []Image
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &images); err != nil {
+		return nil, fmt.Errorf("selecting trashed images: %w", err)
+	}
+
+	return images, nil
+}
+
+// QueryPurgeable gets up to limit Images that were moved to the trash before
+// the specified time, longest in the trash first.
+func (s Store) QueryPurgeable(ctx context.Context, before time.Time, limit int) ([]Image, error) {
+	data := struct {
+		Before time.Time `db:"before"`
+		Limit  int       `db:"limit"`
+	}{
+		Before: before,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT` + imageColumns + `
+	FROM
+		images
+	WHERE
+		deleted_at < :before
+	ORDER BY
+		deleted_at, image_id
+	LIMIT :limit`
+
+	var images []Image
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &images); err != nil {
+		return nil, fmt.Errorf("selecting purgeable images: %w", err)
+	}
+
+	return images, nil
+}
+
+// QueryOwnerExists reports whether the user identified by a given ID exists
+// to own images, and keeps them from being deleted until the surrounding
+// transaction ends. Images in the trash can outlive the user who uploaded
+// them, so the database doesn't check an image's owner itself.
+func (s Store) QueryOwnerExists(ctx context.Context, userID string) (bool, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		EXISTS (SELECT 1 FROM users WHERE user_id = :user_id FOR SHARE) AS exists`
+
+	var row struct {
+		Exists bool `db:"exists"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return false, fmt.Errorf("selecting owner userID[%s]: %w", userID, err)
+	}
+
+	return row.Exists, nil
+}
+
+// =============================================================================
+
+// CreateRevision adds the next Revision of an image to the database and
+// returns its number.
+func (s Store) CreateRevision(ctx context.Context, rev Revision) (int, error) {
+	const q = `
+	INSERT INTO image_revisions
+		(image_id, revision, user_id, storage_key, checksum, size, mime_type, editorial, actor_id, reverted_from, date_created)
+	VALUES
+		(:image_id, (SELECT COALESCE(MAX(revision), 0) + 1 FROM image_revisions WHERE image_id = :image_id),
+		:user_id, :storage_key, :checksum, :size, :mime_type, :editorial, :actor_id, :reverted_from, :date_created)
+	RETURNING
+		revision`
+
+	var row struct {
+		Revision int `db:"revision"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, rev, &row); err != nil {
+		return 0, fmt.Errorf("inserting revision imageID[%s]: %w", rev.ImageID, err)
+	}
+
+	return row.Revision, nil
+}
+
+// QueryRevisions finds all the Revisions of an image, latest first.
+func (s Store) QueryRevisions(ctx context.Context, imageID string) ([]Revision, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_revisions
+	WHERE
+		image_id = :image_id
+	ORDER BY
+		revision DESC`
+
+	var revs []Revision
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &revs); err != nil {
+		return nil, fmt.Errorf("selecting revisions imageID[%s]: %w", imageID, err)
+	}
+
+	return revs, nil
+}
+
+// QueryRevision finds the numbered Revision of an image.
+func (s Store) QueryRevision(ctx context.Context, imageID string, revision int) (Revision, error) {
+	data := struct {
+		ImageID  string `db:"image_id"`
+		Revision int    `db:"revision"`
+	}{
+		ImageID:  imageID,
+		Revision: revision,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_revisions
+	WHERE
+		image_id = :image_id AND revision = :revision`
+
+	var rev Revision
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rev); err != nil {
+		return Revision{}, fmt.Errorf("selecting revision imageID[%s] revision[%d]: %w", imageID, revision, err)
+	}
+
+	return rev, nil
+}
+
+// =============================================================================
+
+// AddBlobRef records another image referencing the blob identified by the
+// storage key and returns the new reference count. The blob row stays locked
+// until the surrounding transaction ends.
+func (s Store) AddBlobRef(ctx context.Context, storageKey string) (int, error) {
+	data := struct {
+		StorageKey string `db:"storage_key"`
+	}{
+		StorageKey: storageKey,
+	}
+
+	const q = `
+	INSERT INTO blobs
+		(storage_key, ref_count)
+	VALUES
+		(:storage_key, 1)
+	ON CONFLICT (storage_key) DO UPDATE SET
+		ref_count = blobs.ref_count + 1
+	RETURNING
+		ref_count`
+
+	var ref struct {
+		RefCount int `db:"ref_count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ref); err != nil {
+		return 0, fmt.Errorf("adding blob ref storageKey[%s]: %w", storageKey, err)
+	}
+
+	return ref.RefCount, nil
+}
+
+// RemoveBlobRef records one less image referencing the blob identified by the
+// storage key and returns the remaining reference count. The blob row is
+// removed once nothing references it.
+func (s Store) RemoveBlobRef(ctx context.Context, storageKey string) (int, error) {
+	data := struct {
+		StorageKey string `db:"storage_key"`
+	}{
+		StorageKey: storageKey,
+	}
+
+	const q = `
+	UPDATE
+		blobs
+	SET
+		ref_count = ref_count - 1
+	WHERE
+		storage_key = :storage_key
+	RETURNING
+		ref_count`
+
+	var ref struct {
+		RefCount int `db:"ref_count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ref); err != nil {
+		return 0, fmt.Errorf("removing blob ref storageKey[%s]: %w", storageKey, err)
+	}
+
+	if ref.RefCount > 0 {
+		return ref.RefCount, nil
+	}
+
+	const del = `
+	DELETE FROM
+		blobs
+	WHERE
+		storage_key = :storage_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, del, data); err != nil {
+		return 0, fmt.Errorf("deleting blob storageKey[%s]: %w", storageKey, err)
+	}
+
+	return 0, nil
+}
+
+// =============================================================================
+
+// QueryDuplicatePolicy gets the duplicate policy configured for a user.
+func (s Store) QueryDuplicatePolicy(ctx context.Context, userID string) (DuplicatePolicy, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		duplicate_policies
+	WHERE
+		user_id = :user_id`
+
+	var dp DuplicatePolicy
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &dp); err != nil {
+		return DuplicatePolicy{}, fmt.Errorf("selecting duplicate policy userID[%s]: %w", userID, err)
+	}
+
+	return dp, nil
+}
+
+// UpsertDuplicatePolicy sets the duplicate policy for a user.
+func (s Store) UpsertDuplicatePolicy(ctx context.Context, dp DuplicatePolicy) error {
+	const q = `
+	INSERT INTO duplicate_policies
+		(user_id, policy)
+	VALUES
+		(:user_id, :policy)
+	ON CONFLICT (user_id) DO UPDATE SET
+		policy = EXCLUDED.policy`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, dp); err != nil {
+		return fmt.Errorf("upserting duplicate policy userID[%s]: %w", dp.UserID, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// UpsertRendition adds a Rendition to the database, replacing any previous
+// rendition of the same image and name.
+func (s Store) UpsertRendition(ctx context.Context, rnd Rendition) error {
+	const q = `
+	INSERT INTO image_renditions
+		(image_id, name, width, height, storage_key, size, mime_type, source_checksum, date_created)
+	VALUES
+		(:image_id, :name, :width, :height, :storage_key, :size, :mime_type, :source_checksum, :date_created)
+	ON CONFLICT (image_id, name) DO UPDATE SET
+		width = EXCLUDED.width,
+		height = EXCLUDED.height,
+		storage_key = EXCLUDED.storage_key,
+		size = EXCLUDED.size,
+		mime_type = EXCLUDED.mime_type,
+		source_checksum = EXCLUDED.source_checksum,
+		date_created = EXCLUDED.date_created`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, rnd); err != nil {
+		return fmt.Errorf("upserting rendition imageID[%s] name[%s]: %w", rnd.ImageID, rnd.Name, err)
+	}
+
+	return nil
+}
+
+// QueryRendition finds the named Rendition of an image.
+func (s Store) QueryRendition(ctx context.Context, imageID string, name string) (Rendition, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+		Name    string `db:"name"`
+	}{
+		ImageID: imageID,
+		Name:    name,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_renditions
+	WHERE
+		image_id = :image_id AND name = :name`
+
+	var rnd Rendition
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rnd); err != nil {
+		return Rendition{}, fmt.Errorf("selecting rendition imageID[%s] name[%s]: %w", imageID, name, err)
+	}
+
+	return rnd, nil
+}
+
+// QueryRenditions finds all the Renditions of an image.
+func (s Store) QueryRenditions(ctx context.Context, imageID string) ([]Rendition, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_renditions
+	WHERE
+		image_id = :image_id
+	ORDER BY
+		width`
+
+	var rnds []Rendition
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &rnds); err != nil {
+		return nil, fmt.Errorf("selecting renditions imageID[%s]: %w", imageID, err)
+	}
+
+	return rnds, nil
+}
+
+// =============================================================================
+
+// UpsertTag adds a Tag to the database unless one with the same name already
+// exists. It returns the stored Tag either way.
+func (s Store) UpsertTag(ctx context.Context, tag Tag) (Tag, error) {
+	const q = `
+	INSERT INTO tags
+		(tag_id, name, date_created)
+	VALUES
+		(:tag_id, :name, :date_created)
+	ON CONFLICT (name) DO UPDATE SET
+		name = EXCLUDED.name
+	RETURNING
+		tag_id, name, date_created`
+
+	var saved Tag
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, tag, &saved); err != nil {
+		return Tag{}, fmt.Errorf("upserting tag name[%s]: %w", tag.Name, err)
+	}
+
+	return saved, nil
+}
+
+// QueryTagByName finds the Tag with the specified name.
+func (s Store) QueryTagByName(ctx context.Context, name string) (Tag, error) {
+	data := struct {
+		Name string `db:"name"`
+	}{
+		Name: name,
+	}
+
+	const q = `
+	SELECT
+		t.tag_id, t.name, t.date_created,
+		(SELECT count(*) FROM image_tags AS it JOIN images AS i ON i.image_id = it.image_id WHERE it.tag_id = t.tag_id AND i.deleted_at IS NULL) AS count
+	FROM
+		tags AS t
+	WHERE
+		t.name = :name`
+
+	var tag Tag
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &tag); err != nil {
+		return Tag{}, fmt.Errorf("selecting tag name[%s]: %w", name, err)
+	}
+
+	return tag, nil
+}
+
+// QueryTagsByPrefix finds the Tags in use whose names start with the prefix,
+// most used first.
+func (s Store) QueryTagsByPrefix(ctx context.Context, prefix string, limit int) ([]Tag, error) {
+	data := struct {
+		Prefix string `db:"prefix"`
+		Limit  int    `db:"limit"`
+	}{
+		Prefix: likeEscaper.Replace(prefix) + "%",
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		t.tag_id, t.name, t.date_created, count(*) AS count
+	FROM
+		tags AS t
+	JOIN
+		image_tags AS it ON it.tag_id = t.tag_id
+	JOIN
+		images AS i ON i.image_id = it.image_id
+	WHERE
+		t.name LIKE :prefix AND i.deleted_at IS NULL
+	GROUP BY
+		t.tag_id
+	ORDER BY
+		count DESC, t.name
+	LIMIT :limit`
+
+	var tags []Tag
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tags); err != nil {
+		return nil, fmt.Errorf("selecting tags prefix[%s]: %w", prefix, err)
+	}
+
+	return tags, nil
+}
+
+// QueryImageTags finds the Tags attached to an image.
+func (s Store) QueryImageTags(ctx context.Context, imageID string) ([]Tag, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		t.tag_id, t.name, t.date_created,
+		(SELECT count(*) FROM image_tags AS c JOIN images AS i ON i.image_id = c.image_id WHERE c.tag_id = t.tag_id AND i.deleted_at IS NULL) AS count
+	FROM
+		tags AS t
+	JOIN
+		image_tags AS it ON it.tag_id = t.tag_id
+	WHERE
+		it.image_id = :image_id
+	ORDER BY
+		t.name`
+
+	var tags []Tag
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &tags); err != nil {
+		return nil, fmt.Errorf("selecting tags imageID[%s]: %w", imageID, err)
+	}
+
+	return tags, nil
+}
+
+// AddImageTag attaches a tag to an image. Attaching a tag the image already
+// has does nothing.
+func (s Store) AddImageTag(ctx context.Context, it ImageTag) error {
+	const q = `
+	INSERT INTO image_tags
+		(image_id, tag_id, date_created)
+	VALUES
+		(:image_id, :tag_id, :date_created)
+	ON CONFLICT (image_id, tag_id) DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, it); err != nil {
+		return fmt.Errorf("adding tag imageID[%s] tagID[%s]: %w", it.ImageID, it.TagID, err)
+	}
+
+	return nil
+}
+
+// RemoveImageTag detaches the named tag from an image.
+func (s Store) RemoveImageTag(ctx context.Context, imageID string, name string) error {
+	data := struct {
+		ImageID string `db:"image_id"`
+		Name    string `db:"name"`
+	}{
+		ImageID: imageID,
+		Name:    name,
+	}
+
+	const q = `
+	DELETE FROM
+		image_tags AS it
+	USING
+		tags AS t
+	WHERE
+		it.tag_id = t.tag_id AND it.image_id = :image_id AND t.name = :name`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("removing tag imageID[%s] name[%s]: %w", imageID, name, err)
+	}
+
+	return nil
+}
+
+// RenameTag changes the name of the Tag identified by a given ID.
+func (s Store) RenameTag(ctx context.Context, tagID string, name string) error {
+	data := struct {
+		TagID string `db:"tag_id"`
+		Name  string `db:"name"`
+	}{
+		TagID: tagID,
+		Name:  name,
+	}
+
+	const q = `
+	UPDATE
+		tags
+	SET
+		"name" = :name
+	WHERE
+		tag_id = :tag_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("renaming tag tagID[%s]: %w", tagID, err)
+	}
+
+	return nil
+}
+
+// MergeTag moves the images of one tag onto another and removes the first
+// tag. Images that already have both tags keep a single one.
+func (s Store) MergeTag(ctx context.Context, fromID string, intoID string) error {
+	data := struct {
+		FromID string `db:"from_id"`
+		IntoID string `db:"into_id"`
+	}{
+		FromID: fromID,
+		IntoID: intoID,
+	}
+
+	const q = `
+	INSERT INTO image_tags
+		(image_id, tag_id, date_created)
+	SELECT
+		image_id, :into_id, date_created
+	FROM
+		image_tags
+	WHERE
+		tag_id = :from_id
+	ON CONFLICT (image_id, tag_id) DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("moving tag fromID[%s] intoID[%s]: %w", fromID, intoID, err)
+	}
+
+	const del = `
+	DELETE FROM
+		tags
+	WHERE
+		tag_id = :from_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, del, data); err != nil {
+		return fmt.Errorf("deleting tag tagID[%s]: %w", fromID, err)
+	}
+
+	return nil
+}
+
+// likeEscaper escapes the characters LIKE treats as wildcards.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// =============================================================================
+
+// applyFilter returns the conditions for the fields of the filter that are
+// set, adding their values to the named query data. Images in the trash are
+// always left out.
+func applyFilter(filter QueryFilter, data map[string]any) []string {
+	wc := []string{"deleted_at IS NULL"}
+
+	if filter.UserID != nil {
+		data["user_id"] = *filter.UserID
+		wc = append(wc, "user_id = :user_id")
+	}
+
+	if filter.MimeType != nil {
+		data["mime_type"] = *filter.MimeType
+		wc = append(wc, "mime_type = :mime_type")
+	}
+
+	if filter.UploadedAfter != nil {
+		data["uploaded_after"] = *filter.UploadedAfter
+		wc = append(wc, "date_uploaded >= :uploaded_after")
+	}
+
+	if filter.UploadedBefore != nil {
+		data["uploaded_before"] = *filter.UploadedBefore
+		wc = append(wc, "date_uploaded < :uploaded_before")
+	}
+
+	if filter.Format != nil {
+		data["format"] = *filter.Format
+		wc = append(wc, "format = :format")
+	}
+
+	if filter.CameraMake != nil {
+		data["camera_make"] = *filter.CameraMake
+		wc = append(wc, "lower(camera_make) = lower(:camera_make)")
+	}
+
+	if filter.CameraModel != nil {
+		data["camera_model"] = *filter.CameraModel
+		wc = append(wc, "lower(camera_model) = lower(:camera_model)")
+	}
+
+	if filter.MinWidth != nil {
+		data["min_width"] = *filter.MinWidth
+		wc = append(wc, "width >= :min_width")
+	}
+
+	if filter.MinHeight != nil {
+		data["min_height"] = *filter.MinHeight
+		wc = append(wc, "height >= :min_height")
+	}
+
+	if filter.CapturedAfter != nil {
+		data["captured_after"] = *filter.CapturedAfter
+		wc = append(wc, "date_captured >= :captured_after")
+	}
+
+	if filter.CapturedBefore != nil {
+		data["captured_before"] = *filter.CapturedBefore
+		wc = append(wc, "date_captured < :captured_before")
+	}
+
+	if len(filter.Tags) > 0 {
+		data["tags"] = pq.StringArray(filter.Tags)
+
+		// Images must have every tag unless any one of them will do.
+		if filter.TagMatch != nil && *filter.TagMatch == "any" {
+			wc = append(wc, "image_id IN (SELECT it.image_id FROM image_tags AS it JOIN tags AS t ON t.tag_id = it.tag_id WHERE t.name = ANY(:tags))")
+		} else {
+			data["tag_count"] = len(filter.Tags)
+			wc = append(wc, "image_id IN (SELECT it.image_id FROM image_tags AS it JOIN tags AS t ON t.tag_id = it.tag_id WHERE t.name = ANY(:tags) GROUP BY it.image_id HAVING count(*) = :tag_count)")
+		}
+	}
+
+	// Viewers see their own images, public images and the organization
+	// images of their organization.
+	if filter.ViewerID != nil {
+		data["viewer_id"] = *filter.ViewerID
+		visible := "visibility = 'public' OR user_id = :viewer_id"
+		if filter.ViewerOrganizationID != nil {
+			data["viewer_organization_id"] = *filter.ViewerOrganizationID
+			visible += " OR (visibility = 'organization' AND organization_id = :viewer_organization_id)"
+		}
+		wc = append(wc, "("+visible+")")
+	}
+
+	if filter.HasGPS != nil {
+		if *filter.HasGPS {
+			wc = append(wc, "gps_latitude IS NOT NULL")
+		} else {
+			wc = append(wc, "gps_latitude IS NULL")
+		}
+	}
+
+	return wc
+}
+
+// =============================================================================
+
+// CreateShare adds a Share to the database.
+func (s Store) CreateShare(ctx context.Context, share Share) error {
+	const q = `
+	INSERT INTO image_shares
+		(share_id, image_id, user_id, rendition, max_downloads, downloads, expires_at, revoked_at, date_created)
+	VALUES
+		(:share_id, :image_id, :user_id, :rendition, :max_downloads, :downloads, :expires_at, :revoked_at, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, share); err != nil {
+		return fmt.Errorf("inserting share: %w", err)
+	}
+
+	return nil
+}
+
+// QueryShareByID finds the Share identified by a given ID.
+func (s Store) QueryShareByID(ctx context.Context, shareID string) (Share, error) {
+	data := struct {
+		ShareID string `db:"share_id"`
+	}{
+		ShareID: shareID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_shares
+	WHERE
+		share_id = :share_id`
+
+	var share Share
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &share); err != nil {
+		return Share{}, fmt.Errorf("selecting shareID[%q]: %w", shareID, err)
+	}
+
+	return share, nil
+}
+
+// QueryShares finds the Shares of the Image identified by a given ID, latest
+// first.
+func (s Store) QueryShares(ctx context.Context, imageID string) ([]Share, error) {
+	data := struct {
+		ImageID string `db:"image_id"`
+	}{
+		ImageID: imageID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		image_shares
+	WHERE
+		image_id = :image_id
+	ORDER BY
+		date_created DESC, share_id`
+
+	var shares []Share
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &shares); err != nil {
+		return nil, fmt.Errorf("selecting shares imageID[%s]: %w", imageID, err)
+	}
+
+	return shares, nil
+}
+
+// RevokeShare stops the Share identified by a given ID from working. It
+// returns database.ErrDBNotFound when the share doesn't belong to the image
+// or was already revoked.
+func (s Store) RevokeShare(ctx context.Context, imageID string, shareID string, revokedAt time.Time) error {
+	data := struct {
+		ImageID   string    `db:"image_id"`
+		ShareID   string    `db:"share_id"`
+		RevokedAt time.Time `db:"revoked_at"`
+	}{
+		ImageID:   imageID,
+		ShareID:   shareID,
+		RevokedAt: revokedAt,
+	}
+
+	const q = `
+	UPDATE
+		image_shares
+	SET
+		revoked_at = :revoked_at
+	WHERE
+		share_id = :share_id AND image_id = :image_id AND revoked_at IS NULL
+	RETURNING
+		share_id`
+
+	var share struct {
+		ID string `db:"share_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &share); err != nil {
+		return fmt.Errorf("revoking shareID[%s]: %w", shareID, err)
+	}
+
+	return nil
+}
+
+// AddShareDownload records a download through the Share identified by a
+// given ID and returns the share. It returns database.ErrDBNotFound when the
+// share has been revoked, has expired or has no downloads left, so two
+// clients can't both take the last download.
+func (s Store) AddShareDownload(ctx context.Context, shareID string, now time.Time) (Share, error) {
+	data := struct {
+		ShareID string    `db:"share_id"`
+		Now     time.Time `db:"now"`
+	}{
+		ShareID: shareID,
+		Now:     now,
+	}
+
+	const q = `
+	UPDATE
+		image_shares
+	SET
+		downloads = downloads + 1
+	WHERE
+		share_id = :share_id AND revoked_at IS NULL AND expires_at > :now AND
+		(max_downloads IS NULL OR downloads < max_downloads)
+	RETURNING
+		*`
+
+	var share Share
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &share); err != nil {
+		return Share{}, fmt.Errorf("adding download shareID[%s]: %w", shareID, err)
+	}
+
+	return share, nil
+}
+
+// =============================================================================
+
+// QueryOwnerOrganization gets the ID of the organization the user identified
+// by a given ID belongs to, which is nil when they don't belong to one.
+func (s Store) QueryOwnerOrganization(ctx context.Context, userID string) (*string, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		organization_id
+	FROM
+		users
+	WHERE
+		user_id = :user_id`
+
+	var row struct {
+		OrganizationID *string `db:"organization_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return nil, fmt.Errorf("selecting organization userID[%s]: %w", userID, err)
+	}
+
+	return row.OrganizationID, nil
+}
+
+// AddUsage adds the bytes and images of a Usage, which may be negative, to
+// what its user or organization stores and returns the new totals. The usage
+// row stays locked until the transaction ends, so concurrent uploads against
+// the same quota are counted one after the other.
+func (s Store) AddUsage(ctx context.Context, usage Usage) (Usage, error) {
+	const q = `
+	INSERT INTO quota_usage
+		(scope, subject_id, bytes, images, date_updated)
+	VALUES
+		(:scope, :subject_id, :bytes, :images, :date_updated)
+	ON CONFLICT (scope, subject_id) DO UPDATE SET
+		bytes = quota_usage.bytes + EXCLUDED.bytes,
+		images = quota_usage.images + EXCLUDED.images,
+		date_updated = EXCLUDED.date_updated
+	RETURNING
+		*`
+
+	var total Usage
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, usage, &total); err != nil {
+		return Usage{}, fmt.Errorf("adding usage %s[%s]: %w", usage.Scope, usage.SubjectID, err)
+	}
+
+	return total, nil
+}
+
+// QueryUsage gets what the user or organization identified by a given ID
+// stores.
+func (s Store) QueryUsage(ctx context.Context, scope string, subjectID string) (Usage, error) {
+	data := struct {
+		Scope     string `db:"scope"`
+		SubjectID string `db:"subject_id"`
+	}{
+		Scope:     scope,
+		SubjectID: subjectID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		quota_usage
+	WHERE
+		scope = :scope AND subject_id = :subject_id`
+
+	var usage Usage
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usage); err != nil {
+		return Usage{}, fmt.Errorf("selecting usage %s[%s]: %w", scope, subjectID, err)
+	}
+
+	return usage, nil
+}
+
+// RecomputeUsage throws away the usage counted so far and counts it again
+// from the images that aren't in the trash.
+func (s Store) RecomputeUsage(ctx context.Context, now time.Time) error {
+	data := struct {
+		Now time.Time `db:"now"`
+	}{
+		Now: now,
+	}
+
+	const qDelete = `
+	DELETE FROM
+		quota_usage`
+
+	const qUsers = `
+	INSERT INTO quota_usage
+		(scope, subject_id, bytes, images, date_updated)
+	SELECT
+		'user', user_id, SUM(COALESCE(size, 0)), COUNT(*), :now
+	FROM
+		images
+	WHERE
+		deleted_at IS NULL
+	GROUP BY
+		user_id`
+
+	const qOrganizations = `
+	INSERT INTO quota_usage
+		(scope, subject_id, bytes, images, date_updated)
+	SELECT
+		'organization', organization_id, SUM(COALESCE(size, 0)), COUNT(*), :now
+	FROM
+		images
+	WHERE
+		deleted_at IS NULL AND organization_id IS NOT NULL
+	GROUP BY
+		organization_id`
+
+	for _, q := range []string{qDelete, qUsers, qOrganizations} {
+		if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+			return fmt.Errorf("recomputing usage: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReleaseUsage stops the images of the user identified by a given ID from
+// counting against quotas, taking them off the usage of the organizations
+// they were uploaded under and dropping the user's own usage and quota.
+func (s Store) ReleaseUsage(ctx context.Context, userID string, now time.Time) error {
+	data := struct {
+		UserID string    `db:"user_id"`
+		Now    time.Time `db:"now"`
+	}{
+		UserID: userID,
+		Now:    now,
+	}
+
+	const qOrganizations = `
+	UPDATE
+		quota_usage
+	SET
+		bytes = quota_usage.bytes - released.bytes,
+		images = quota_usage.images - released.images,
+		date_updated = :now
+	FROM
+		(SELECT organization_id, SUM(COALESCE(size, 0)) AS bytes, COUNT(*) AS images
+		FROM images
+		WHERE user_id = :user_id AND deleted_at IS NULL AND organization_id IS NOT NULL
+		GROUP BY organization_id) AS released
+	WHERE
+		quota_usage.scope = 'organization' AND quota_usage.subject_id = released.organization_id`
+
+	const qUsage = `
+	DELETE FROM
+		quota_usage
+	WHERE
+		scope = 'user' AND subject_id = :user_id`
+
+	const qQuota = `
+	DELETE FROM
+		quotas
+	WHERE
+		scope = 'user' AND subject_id = :user_id`
+
+	for _, q := range []string{qOrganizations, qUsage, qQuota} {
+		if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+			return fmt.Errorf("releasing usage userID[%s]: %w", userID, err)
+		}
+	}
+
+	return nil
+}
+
+// QueryQuota gets the limits set for the user or organization identified by
+// a given ID.
+func (s Store) QueryQuota(ctx context.Context, scope string, subjectID string) (Quota, error) {
+	data := struct {
+		Scope     string `db:"scope"`
+		SubjectID string `db:"subject_id"`
+	}{
+		Scope:     scope,
+		SubjectID: subjectID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		quotas
+	WHERE
+		scope = :scope AND subject_id = :subject_id`
+
+	var quota Quota
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &quota); err != nil {
+		return Quota{}, fmt.Errorf("selecting quota %s[%s]: %w", scope, subjectID, err)
+	}
+
+	return quota, nil
+}
+
+// UpsertQuota sets the limits for a user or organization, replacing those
+// set before.
+func (s Store) UpsertQuota(ctx context.Context, quota Quota) error {
+	const q = `
+	INSERT INTO quotas
+		(scope, subject_id, max_bytes, max_images, date_updated)
+	VALUES
+		(:scope, :subject_id, :max_bytes, :max_images, :date_updated)
+	ON CONFLICT (scope, subject_id) DO UPDATE SET
+		max_bytes = EXCLUDED.max_bytes,
+		max_images = EXCLUDED.max_images,
+		date_updated = EXCLUDED.date_updated`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, quota); err != nil {
+		return fmt.Errorf("upserting quota %s[%s]: %w", quota.Scope, quota.SubjectID, err)
+	}
+
+	return nil
+}
@@ -1,11 +1,180 @@
 package db
 
-import "time"
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
 
 // Image represents an individual image.
 type Image struct {
-	ID           string    `db:"image_id"`      // Unique identifier.
-	ImageURL     string    `db:"image_url"`     // Display image URL to the path on file system containing image.
-	UserID       string    `db:"user_id"`       // ID of the user who created the image.
-	DateUploaded time.Time `db:"date_uploaded"` // When the image was uploaded.
+	ID           string         `db:"image_id"`      // Unique identifier.
+	UserID       string         `db:"user_id"`       // ID of the user who created the image.
+	StorageKey   string         `db:"storage_key"`   // Key of the image content in the blob store.
+	Checksum     string         `db:"checksum"`      // Hex encoded SHA-256 of the image content.
+	Size         int64          `db:"size"`          // Size of the image content in bytes.
+	MimeType     string         `db:"mime_type"`     // Sniffed MIME type of the image content.
+	DateUploaded time.Time      `db:"date_uploaded"` // When the image was uploaded.
+	Width        int            `db:"width"`         // Width of the image in pixels.
+	Height       int            `db:"height"`        // Height of the image in pixels.
+	Format       string         `db:"format"`        // Decoded format of the image.
+	ColorModel   string         `db:"color_model"`   // Color model of the decoded image.
+	Orientation  int            `db:"orientation"`   // EXIF orientation, 1 when upright.
+	DateCaptured *time.Time     `db:"date_captured"` // When the image was captured.
+	CameraMake   string         `db:"camera_make"`   // Make of the camera.
+	CameraModel  string         `db:"camera_model"`  // Model of the camera.
+	ExposureTime string         `db:"exposure_time"` // Exposure time in seconds, such as 1/250.
+	FNumber      *float64       `db:"f_number"`      // Aperture as an f-number.
+	ISO          *int           `db:"iso"`           // ISO speed.
+	FocalLength  *float64       `db:"focal_length"`  // Focal length in millimeters.
+	GPSLatitude  *float64       `db:"gps_latitude"`  // Latitude in decimal degrees.
+	GPSLongitude *float64       `db:"gps_longitude"` // Longitude in decimal degrees.
+	GPSAltitude  *float64       `db:"gps_altitude"`  // Altitude in meters.
+	EXIF         types.JSONText `db:"exif"`          // EXIF tags by name.
+	Caption      string         `db:"caption"`       // Description of who, what and where.
+	Headline     string         `db:"headline"`      // Short synopsis of the caption.
+	Byline       string         `db:"byline"`        // Name of the photographer.
+	Credit       string         `db:"credit"`        // Credit line required when publishing.
+	Copyright    string         `db:"copyright"`     // Copyright notice.
+	Keywords     pq.StringArray `db:"keywords"`      // Keywords describing the image.
+	City         string         `db:"city"`          // City where the image was taken.
+	Country      string         `db:"country"`       // Country where the image was taken.
+	Instructions string         `db:"instructions"`  // Special instructions such as embargoes.
+	UsageTerms   string         `db:"usage_terms"`   // Terms the image may be used under.
+	Language     string         `db:"language"`      // Text search configuration the editorial text is stemmed with.
+	DHash        *int64         `db:"dhash"`         // Difference hash of the content, as the bits of a uint64.
+	PHash        *int64         `db:"phash"`         // DCT hash of the content, as the bits of a uint64.
+	DeletedAt    *time.Time     `db:"deleted_at"`    // When the image was moved to the trash.
+	DeletedBy    *string        `db:"deleted_by"`    // ID of the user who moved the image to the trash.
+
+	OrganizationID *string `db:"organization_id"` // ID of the organization the image was uploaded in.
+	Visibility     string  `db:"visibility"`      // Who may view the image.
+	Version        int     `db:"version"`         // Incremented on every change to the image.
+}
+
+// ImageHash holds the perceptual hashes of an image.
+type ImageHash struct {
+	ImageID string `db:"image_id"`
+	DHash   int64  `db:"dhash"`
+	PHash   int64  `db:"phash"`
+}
+
+// SearchResult represents an image matching a text search.
+type SearchResult struct {
+	Image
+	Rank            float64 `db:"rank"`             // Relevance of the image to the search.
+	HeadlineSnippet string  `db:"headline_snippet"` // Headline with the matching words highlighted.
+	CaptionSnippet  string  `db:"caption_snippet"`  // Fragments of the caption with the matching words highlighted.
+}
+
+// QueryFilter holds the available fields a query of images can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	UserID         *string
+	MimeType       *string
+	UploadedAfter  *time.Time
+	UploadedBefore *time.Time
+	Format         *string
+	CameraMake     *string
+	CameraModel    *string
+	MinWidth       *int
+	MinHeight      *int
+	CapturedAfter  *time.Time
+	CapturedBefore *time.Time
+	HasGPS         *bool
+	Tags           []string
+	TagMatch       *string
+
+	ViewerID             *string
+	ViewerOrganizationID *string
+}
+
+// Revision represents the state of an image after one of its changes.
+type Revision struct {
+	ImageID      string         `db:"image_id"`      // ID of the image the revision is of.
+	Revision     int            `db:"revision"`      // Number of the revision, counting from 1.
+	UserID       string         `db:"user_id"`       // ID of the user who owned the image.
+	StorageKey   string         `db:"storage_key"`   // Key of the image content in the blob store.
+	Checksum     string         `db:"checksum"`      // Hex encoded SHA-256 of the image content.
+	Size         int64          `db:"size"`          // Size of the image content in bytes.
+	MimeType     string         `db:"mime_type"`     // Sniffed MIME type of the image content.
+	Editorial    types.JSONText `db:"editorial"`     // Editorial metadata of the image.
+	ActorID      string         `db:"actor_id"`      // ID of the user who made the change.
+	RevertedFrom *int           `db:"reverted_from"` // Revision the change reverted to, if any.
+	DateCreated  time.Time      `db:"date_created"`  // When the change was made.
+}
+
+// TrashFilter holds the available fields a query of the trash can be
+// filtered on. Nil fields are not filtered on.
+type TrashFilter struct {
+	UserID *string
+}
+
+// DuplicatePolicy represents how uploads of content a user already has are handled.
+type DuplicatePolicy struct {
+	UserID string `db:"user_id"`
+	Policy string `db:"policy"`
+}
+
+// Rendition represents a resized copy of an image.
+type Rendition struct {
+	ImageID        string    `db:"image_id"`        // ID of the image the rendition was made from.
+	Name           string    `db:"name"`            // Name of the preset used to make the rendition.
+	Width          int       `db:"width"`           // Width of the rendition in pixels.
+	Height         int       `db:"height"`          // Height of the rendition in pixels.
+	StorageKey     string    `db:"storage_key"`     // Key of the rendition content in the blob store.
+	Size           int64     `db:"size"`            // Size of the rendition content in bytes.
+	MimeType       string    `db:"mime_type"`       // MIME type of the rendition content.
+	SourceChecksum string    `db:"source_checksum"` // Checksum of the image content the rendition was made from.
+	DateCreated    time.Time `db:"date_created"`    // When the rendition was made.
+}
+
+// Tag represents a normalized tag that can be attached to images.
+type Tag struct {
+	ID          string    `db:"tag_id"`       // Unique identifier.
+	Name        string    `db:"name"`         // Normalized name of the tag.
+	Count       int       `db:"count"`        // Number of images the tag is attached to.
+	DateCreated time.Time `db:"date_created"` // When the tag was first used.
+}
+
+// ImageTag represents a tag attached to an image.
+type ImageTag struct {
+	ImageID     string    `db:"image_id"`     // ID of the tagged image.
+	TagID       string    `db:"tag_id"`       // ID of the tag.
+	DateCreated time.Time `db:"date_created"` // When the tag was attached.
+}
+
+// Share represents a link through which an image can be downloaded without
+// signing in.
+type Share struct {
+	ID           string     `db:"share_id"`      // Unique identifier.
+	ImageID      string     `db:"image_id"`      // ID of the image that's shared.
+	UserID       string     `db:"user_id"`       // ID of the user who shared the image.
+	Rendition    string     `db:"rendition"`     // Name of the rendition shared, or empty for the original.
+	MaxDownloads *int       `db:"max_downloads"` // Number of downloads allowed, or nil for no limit.
+	Downloads    int        `db:"downloads"`     // Number of downloads made.
+	ExpiresAt    time.Time  `db:"expires_at"`    // When the share stops working.
+	RevokedAt    *time.Time `db:"revoked_at"`    // When the share was revoked.
+	DateCreated  time.Time  `db:"date_created"`  // When the share was created.
+}
+
+// Quota represents the limits on what a user or organization may store. A
+// limit that's nil falls back to the configured default.
+type Quota struct {
+	Scope       string    `db:"scope"`        // Whether the quota is for a user or an organization.
+	SubjectID   string    `db:"subject_id"`   // ID of the user or organization.
+	MaxBytes    *int64    `db:"max_bytes"`    // Bytes that may be stored, 0 for no limit.
+	MaxImages   *int      `db:"max_images"`   // Images that may be stored, 0 for no limit.
+	DateUpdated time.Time `db:"date_updated"` // When the quota was last set.
+}
+
+// Usage represents what a user or organization stores, counted against its
+// quota. Images in the trash aren't counted.
+type Usage struct {
+	Scope       string    `db:"scope"`        // Whether the usage is of a user or an organization.
+	SubjectID   string    `db:"subject_id"`   // ID of the user or organization.
+	Bytes       int64     `db:"bytes"`        // Bytes of image content stored.
+	Images      int       `db:"images"`       // Number of images stored.
+	DateUpdated time.Time `db:"date_updated"` // When the usage last changed.
 }
@@ -1,17 +1,31 @@
-// Package image provides an example of a core business API. Right now these
-// calls are just wrapping the data/store layer. But at some point you will
-// want auditing or something that isn't specific to the data/store layer.
+// Package image provides the core business API for images. Beyond the
+// data/store layer, every change to an image is recorded in the audit log and
+// the events outbox as part of the transaction making it.
 package image
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fadhilijuma/images/business/core/audit"
 	"github.com/fadhilijuma/images/business/core/image/db"
 	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/business/sys/paging"
 	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/imaging"
+	"github.com/fadhilijuma/images/foundation/web"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -20,96 +34,584 @@ import (
 var (
 	ErrNotFound  = errors.New("image not found")
 	ErrInvalidID = errors.New("ID is not in its proper form")
+	ErrConflict  = errors.New("image has changed since the version specified")
+
+	ErrUnsupportedType = errors.New("content is not a supported image type")
+	ErrDuplicate       = errors.New("image content has already been uploaded")
+)
+
+// Set of policies for handling content a user has already uploaded.
+const (
+	DuplicateReject    = "reject"
+	DuplicateReference = "reference"
+)
+
+// Set of visibilities deciding who may view an image.
+const (
+	VisibilityPrivate      = "private"
+	VisibilityOrganization = "organization"
+	VisibilityPublic       = "public"
 )
 
+// DuplicateError is returned when a user uploads content they have already
+// uploaded and their policy rejects duplicates.
+type DuplicateError struct {
+	ImageID string
+}
+
+// Error implements the error interface.
+func (de *DuplicateError) Error() string {
+	return fmt.Sprintf("%s as image[%s]", ErrDuplicate, de.ImageID)
+}
+
+// Is reports the error as an ErrDuplicate.
+func (de *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// sniffLen is the number of bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// BlobStore declares the behavior required to store image content.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Rename(ctx context.Context, oldKey string, newKey string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Options represent optional parameters.
+type Options struct {
+	presets    []Preset
+	cache      TransformCache
+	limits     TransformLimits
+	embed      bool
+	similarity Similarity
+	sharing    Sharing
+	quotas     Quotas
+	fetcher    Fetcher
+	notifier   Notifier
+}
+
+// WithPresets sets the rendition presets made for every image.
+func WithPresets(presets []Preset) func(opts *Options) {
+	return func(opts *Options) {
+		opts.presets = presets
+	}
+}
+
+// WithTransformCache sets the cache for images transformed on the fly.
+func WithTransformCache(cache TransformCache) func(opts *Options) {
+	return func(opts *Options) {
+		opts.cache = cache
+	}
+}
+
+// WithTransformLimits sets the limits on transforms clients may request.
+func WithTransformLimits(limits TransformLimits) func(opts *Options) {
+	return func(opts *Options) {
+		opts.limits = limits
+	}
+}
+
+// WithEmbedEditorial sets whether the editorial metadata of an image is
+// written into the JPEG content served for it, so edits made since the upload
+// reach downstream consumers.
+func WithEmbedEditorial(embed bool) func(opts *Options) {
+	return func(opts *Options) {
+		opts.embed = embed
+	}
+}
+
 // Core manages the set of APIs for image access.
 type Core struct {
-	store db.Store
+	log        *zap.SugaredLogger
+	store      db.Store
+	audit      audit.Core
+	outbox     outbox.Store
+	jobs       jobs.Store
+	blobs      BlobStore
+	presets    []Preset
+	cache      TransformCache
+	limits     TransformLimits
+	embed      bool
+	similarity Similarity
+	sharing    Sharing
+	quotas     Quotas
+	fetcher    Fetcher
+	notifier   Notifier
+	index      *hashIndex
 }
 
 // NewCore constructs a core for image api access.
-func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, blobs BlobStore,
+	options ...func(opts *Options)) Core {
+	opts := Options{
+		presets:    DefaultPresets,
+		limits:     DefaultTransformLimits,
+		similarity: DefaultSimilarity,
+		sharing:    DefaultSharing,
+		quotas:     DefaultQuotas,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
 	return Core{
-		store: db.NewStore(log, sqlxDB),
+		log:        log,
+		store:      db.NewStore(log, sqlxDB),
+		audit:      audit.NewCore(log, sqlxDB),
+		outbox:     outbox.NewStore(log, sqlxDB),
+		jobs:       jobs.NewStore(log, sqlxDB),
+		blobs:      blobs,
+		presets:    opts.presets,
+		cache:      opts.cache,
+		limits:     opts.limits,
+		embed:      opts.embed,
+		similarity: opts.similarity,
+		sharing:    opts.sharing,
+		quotas:     opts.quotas,
+		fetcher:    opts.fetcher,
+		notifier:   opts.notifier,
+		index:      &hashIndex{},
 	}
 }
 
-// Create adds an Image to the database. It returns the created Image with
-// fields like ID and DateCreated populated.
-func (c Core) Create(ctx context.Context, np NewImage, now time.Time) (Image, error) {
-	if err := validate.Check(np); err != nil {
+// Create streams the image content into the blob store and adds an Image to
+// the database. It returns the created Image with fields like ID and
+// DateUploaded populated.
+//
+// Content is stored under a key derived from its SHA-256 checksum so the same
+// bytes are only stored once. If the user's duplicate policy rejects content
+// they have already uploaded, a *DuplicateError naming the existing image is
+// returned. If the image would take the user or their organization past a
+// quota, a *QuotaError is returned. If the database insert fails, content
+// that was new to the blob store is removed again. Renditions for the
+// configured presets are made by a job in the renditions queue once the
+// image is stored.
+func (c Core) Create(ctx context.Context, ni NewImage, content io.Reader,
+	now time.Time) (Image, error) {
+	if err := validate.Check(ni); err != nil {
 		return Image{}, fmt.Errorf("validating data: %w", err)
 	}
 
+	policy, err := c.QueryDuplicatePolicy(ctx, ni.UserID)
+	if err != nil {
+		return Image{}, fmt.Errorf("query policy: %w", err)
+	}
+
+	staged, err := c.stageContent(ctx, content)
+	if err != nil {
+		return Image{}, err
+	}
+	defer c.removeBlob(ctx, staged.key)
+
 	dbImg := db.Image{
 		ID:           validate.GenerateID(),
-		ImageURL:     np.ImageURL,
-		UserID:       np.UserID,
+		UserID:       ni.UserID,
+		StorageKey:   contentKey(staged.checksum),
+		Checksum:     staged.checksum,
+		Size:         staged.size,
+		MimeType:     staged.mimeType,
 		DateUploaded: now,
+		Language:     DefaultLanguage,
+		Visibility:   VisibilityPrivate,
+		Version:      1,
+	}
+	if ni.OrganizationID != "" {
+		dbImg.OrganizationID = &ni.OrganizationID
+	}
+	if ni.Visibility != "" {
+		dbImg.Visibility = ni.Visibility
+	}
+	c.readMetadata(ctx, staged.key, &dbImg)
+	c.readHashes(ctx, staged.key, &dbImg)
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := c.checkOwner(ctx, store, dbImg.UserID); err != nil {
+			return err
+		}
+
+		if policy.Policy == DuplicateReject {
+			existing, err := store.QueryByChecksum(ctx, ni.UserID, staged.checksum)
+			switch {
+			case err == nil:
+				return &DuplicateError{ImageID: existing.ID}
+			case !errors.Is(err, database.ErrDBNotFound):
+				return fmt.Errorf("query checksum: %w", err)
+			}
+		}
+
+		err := c.charge(ctx, store, dbImg.UserID, dbImg.OrganizationID,
+			dbImg.Size, 1, true, now)
+		if err != nil {
+			return err
+		}
+
+		// Adding the reference locks the blob row, so a concurrent delete of
+		// the last image using this content can't remove the bytes between
+		// here and the commit.
+		refs, err := store.AddBlobRef(ctx, dbImg.StorageKey)
+		if err != nil {
+			return fmt.Errorf("add blob ref: %w", err)
+		}
+
+		if refs == 1 {
+			if err := c.blobs.Rename(ctx, staged.key, dbImg.StorageKey); err != nil {
+				return fmt.Errorf("storing content: %w", err)
+			}
+		}
+
+		if err := store.Create(ctx, dbImg); err != nil {
+			if refs == 1 {
+				c.removeBlob(ctx, dbImg.StorageKey)
+			}
+			return fmt.Errorf("create: %w", err)
+		}
+
+		if _, err := c.createRevision(ctx, store, dbImg, ni.UserID, nil, now); err != nil {
+			if refs == 1 {
+				c.removeBlob(ctx, dbImg.StorageKey)
+			}
+			return err
+		}
+
+		if len(c.presets) > 0 {
+			nj := jobs.NewJob{
+				Queue:   RenditionQueue,
+				Payload: renditionJob{ImageID: dbImg.ID},
+			}
+			if _, err := c.jobs.Tran(tx).Enqueue(ctx, nj, now); err != nil {
+				return fmt.Errorf("enqueue renditions: %w", err)
+			}
+		}
+
+		return c.record(ctx, tx, EventCreated, nil, dbImg, now)
 	}
 
-	if err := c.store.Create(ctx, dbImg); err != nil {
-		return Image{}, fmt.Errorf("create: %w", err)
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		var de *DuplicateError
+		if errors.As(err, &de) {
+			return Image{}, de
+		}
+		var qe *QuotaError
+		if errors.As(err, &qe) {
+			return Image{}, qe
+		}
+		if errors.Is(err, ErrOwnerNotFound) {
+			return Image{}, ErrOwnerNotFound
+		}
+		return Image{}, fmt.Errorf("tran: %w", err)
 	}
+	c.index.add(dbImg)
+	c.notify(ctx, EventCreated, dbImg, false, now)
 
 	return toImage(dbImg), nil
 }
 
 // Update modifies data about a Product. It will error if the specified ID is
-// invalid or does not reference an existing Product.
-func (c Core) Update(ctx context.Context, productID string, up UpdateImage, now time.Time) error {
+// invalid or does not reference an existing Product, and with ErrConflict if
+// a version is specified and the Product has changed since. A revision
+// recording the change and the specified user who made it is kept.
+func (c Core) Update(ctx context.Context, productID string, up UpdateImage,
+	actorID string, now time.Time) error {
 	if err := validate.CheckID(productID); err != nil {
 		return ErrInvalidID
 	}
 
+	if err := validate.CheckID(actorID); err != nil {
+		return ErrInvalidID
+	}
+
 	if err := validate.Check(up); err != nil {
 		return fmt.Errorf("validating data: %w", err)
 	}
 
-	dbImg, err := c.store.QueryByID(ctx, productID)
-	if err != nil {
-		if errors.Is(err, database.ErrDBNotFound) {
+	var dbImg db.Image
+	var wasPublic bool
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		var err error
+		dbImg, err = c.lock(ctx, store, productID)
+		if err != nil {
+			return err
+		}
+		wasPublic = dbImg.Visibility == VisibilityPublic
+		before := dbImg
+
+		// The image is locked, so no one else can change it between this
+		// check and the commit.
+		if up.Version != nil && *up.Version != dbImg.Version {
+			return ErrConflict
+		}
+		dbImg.Version++
+
+		// The image counts against the quota of its new owner from now on.
+		// Handing images over is for admins, so the new owner's limits
+		// aren't enforced.
+		if up.UserID != nil && *up.UserID != dbImg.UserID {
+			if err := c.checkOwner(ctx, store, *up.UserID); err != nil {
+				return err
+			}
+			if err := c.charge(ctx, store, dbImg.UserID, nil, -dbImg.Size, -1, false, now); err != nil {
+				return err
+			}
+			if err := c.charge(ctx, store, *up.UserID, nil, dbImg.Size, 1, false, now); err != nil {
+				return err
+			}
+			dbImg.UserID = *up.UserID
+		}
+		if up.Visibility != nil {
+			dbImg.Visibility = *up.Visibility
+		}
+		if up.Editorial != nil {
+			applyEditorialUpdate(*up.Editorial, &dbImg)
+		}
+
+		if err := store.Update(ctx, dbImg); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+
+		if _, err := c.createRevision(ctx, store, dbImg, actorID, nil, now); err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, EventUpdated, &before, dbImg, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
 			return ErrNotFound
+		case errors.Is(err, ErrConflict):
+			return ErrConflict
+		case errors.Is(err, ErrOwnerNotFound):
+			return ErrOwnerNotFound
 		}
 		return fmt.Errorf("updating image productID[%s]: %w", productID, err)
 	}
+	c.notify(ctx, EventUpdated, dbImg, wasPublic, now)
+
+	return nil
+}
+
+// Replace swaps the content of the image identified by a given ID for new
+// content, such as a re-cropped or color corrected file, on behalf of the
+// specified user. The technical metadata and hashes are read from the new
+// content while the editorial metadata is kept. The previous content stays
+// stored for the image's earlier revisions. Content larger than before is
+// counted against the quotas of the owner and their organization, returning a
+// *QuotaError when it doesn't fit, and with ErrConflict if a version is
+// specified and the image has changed since. Replacing content with the same
+// content leaves the image as it is and reports no change.
+func (c Core) Replace(ctx context.Context, imageID string, content io.Reader,
+	version *int, actorID string, now time.Time) (Image, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Image{}, ErrInvalidID
+	}
 
-	if up.ImageURL != nil {
-		dbImg.ImageURL = *up.ImageURL
+	if err := validate.CheckID(actorID); err != nil {
+		return Image{}, ErrInvalidID
 	}
-	if up.UserID != nil {
-		dbImg.UserID = *up.UserID
+
+	staged, err := c.stageContent(ctx, content)
+	if err != nil {
+		return Image{}, err
 	}
-	dbImg.DateUploaded = now
+	defer c.removeBlob(ctx, staged.key)
 
-	if err := c.store.Update(ctx, dbImg); err != nil {
-		return fmt.Errorf("update: %w", err)
+	var dbImg db.Image
+	var changed bool
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbImg, err = c.lock(ctx, store, imageID)
+		if err != nil {
+			return err
+		}
+
+		// The image is locked, so no one else can change it between this
+		// check and the commit.
+		if version != nil && *version != dbImg.Version {
+			return ErrConflict
+		}
+
+		// The same content again changes nothing.
+		if dbImg.Checksum == staged.checksum {
+			return nil
+		}
+		changed = true
+		before := dbImg
+		dbImg.Version++
+
+		err := c.charge(ctx, store, dbImg.UserID, dbImg.OrganizationID,
+			staged.size-dbImg.Size, 0, true, now)
+		if err != nil {
+			return err
+		}
+
+		oldKey := dbImg.StorageKey
+		dbImg.StorageKey = contentKey(staged.checksum)
+		dbImg.Checksum = staged.checksum
+		dbImg.Size = staged.size
+		dbImg.MimeType = staged.mimeType
+		c.readContent(ctx, staged.key, &dbImg)
+
+		refs, err := store.AddBlobRef(ctx, dbImg.StorageKey)
+		if err != nil {
+			return fmt.Errorf("add blob ref: %w", err)
+		}
+
+		if refs == 1 {
+			if err := c.blobs.Rename(ctx, staged.key, dbImg.StorageKey); err != nil {
+				return fmt.Errorf("storing content: %w", err)
+			}
+		}
+
+		if err := c.swapContent(ctx, store, oldKey, dbImg); err != nil {
+			if refs == 1 {
+				c.removeBlob(ctx, dbImg.StorageKey)
+			}
+			return err
+		}
+
+		if _, err := c.createRevision(ctx, store, dbImg, actorID, nil, now); err != nil {
+			if refs == 1 {
+				c.removeBlob(ctx, dbImg.StorageKey)
+			}
+			return err
+		}
+
+		return c.record(ctx, tx, EventUpdated, &before, dbImg, now)
 	}
 
-	return nil
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Image{}, ErrNotFound
+		}
+		if errors.Is(err, ErrConflict) {
+			return Image{}, ErrConflict
+		}
+		var qe *QuotaError
+		if errors.As(err, &qe) {
+			return Image{}, qe
+		}
+		return Image{}, fmt.Errorf("tran: %w", err)
+	}
+	if changed {
+		c.index.add(dbImg)
+		c.notify(ctx, EventUpdated, dbImg, true, now)
+	}
+
+	return toImage(dbImg), nil
 }
 
-// Delete removes the image identified by a given ID.
-func (c Core) Delete(ctx context.Context, productID string) error {
-	if err := validate.CheckID(productID); err != nil {
+// Delete moves the image identified by a given ID to the trash on behalf of
+// the specified user. Images in the trash are left out of every query but
+// keep their content and renditions until they're restored or purged, but no
+// longer count against any quota.
+func (c Core) Delete(ctx context.Context, imageID string, actorID string, now time.Time) error {
+	if err := validate.CheckID(imageID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := validate.CheckID(actorID); err != nil {
 		return ErrInvalidID
 	}
 
-	if err := c.store.Delete(ctx, productID); err != nil {
-		return fmt.Errorf("delete: %w", err)
+	var dbImg db.Image
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// An image already gone leaves nothing to do.
+		var err error
+		dbImg, err = c.lock(ctx, store, imageID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := store.Trash(ctx, imageID, actorID, now); err != nil {
+			return fmt.Errorf("trash: %w", err)
+		}
+
+		err = c.charge(ctx, store, dbImg.UserID, dbImg.OrganizationID,
+			-dbImg.Size, -1, false, now)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, EventDeleted, &dbImg, dbImg, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	if dbImg.ID != "" {
+		c.notify(ctx, EventDeleted, dbImg, false, now)
 	}
 
 	return nil
 }
 
-// Query gets all Products from the database.
-func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Image, error) {
-	dbImg, err := c.store.Query(ctx, pageNumber, rowsPerPage)
+// QueryPage gets a page of the images that match the filter. Images can be
+// sorted by date_uploaded, id or size. Images filtered on tags must have all
+// of them, or any one of them when the filter's TagMatch is TagMatchAny. It
+// returns the cursor for the next page, which is empty when there are no
+// more images.
+func (c Core) QueryPage(ctx context.Context, filter QueryFilter,
+	page paging.Page) ([]Image, string, error) {
+	filter, err := checkFilter(filter)
 	if err != nil {
-		return nil, fmt.Errorf("query: %w", err)
+		return nil, "", err
 	}
 
-	return toImageSlice(dbImg), nil
+	order, limit, cursor, err := paging.Parse(page, "-date_uploaded", "date_uploaded", "id", "size")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   sortColumns[order.Field],
+		IDColumn: "image_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbImgs, err := c.store.QueryPage(ctx, db.QueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	// One more row than the limit was asked for to learn if there's a
+	// next page without a count.
+	var next string
+	if len(dbImgs) > limit {
+		dbImgs = dbImgs[:limit]
+		last := dbImgs[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last), last.ID)
+	}
+
+	return toImageSlice(dbImgs), next, nil
 }
 
 // QueryByID finds the image identified by a given ID.
@@ -129,6 +631,49 @@ func (c Core) QueryByID(ctx context.Context, productID string) (Image, error) {
 	return toImage(dbImg), nil
 }
 
+// OpenContent returns the content of the image identified by a given ID. The
+// caller must close the content.
+func (c Core) OpenContent(ctx context.Context, imageID string) (Content, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Content{}, ErrInvalidID
+	}
+
+	dbImg, err := c.store.QueryByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Content{}, ErrNotFound
+		}
+		return Content{}, fmt.Errorf("query: %w", err)
+	}
+
+	obj, err := c.blobs.Open(ctx, dbImg.StorageKey)
+	if err != nil {
+		return Content{}, fmt.Errorf("opening content: %w", err)
+	}
+
+	content := Content{
+		ReadSeekCloser: obj,
+		MimeType:       dbImg.MimeType,
+		Checksum:       dbImg.Checksum,
+		ModTime:        dbImg.DateUploaded,
+	}
+
+	// Embedding the editorial metadata changes the bytes, so the checksum
+	// must describe what's served rather than what's stored.
+	if c.embed && dbImg.MimeType == imaging.MimeType(imaging.FormatJPEG) {
+		embedded := c.embedEditorial(ctx, dbImg, dbImg.MimeType, obj)
+		checksum, err := checksumOf(embedded)
+		if err != nil {
+			embedded.Close()
+			return Content{}, fmt.Errorf("checksum: %w", err)
+		}
+		content.ReadSeekCloser = embedded
+		content.Checksum = checksum
+	}
+
+	return content, nil
+}
+
 // QueryByUserID finds the products identified by a given User ID.
 func (c Core) QueryByUserID(ctx context.Context, userID string) ([]Image, error) {
 	if err := validate.CheckID(userID); err != nil {
@@ -142,3 +687,173 @@ func (c Core) QueryByUserID(ctx context.Context, userID string) ([]Image, error)
 
 	return toImageSlice(dbImg), nil
 }
+
+// QueryDuplicatePolicy gets the duplicate policy for the specified user. Users
+// without a configured policy have duplicates rejected.
+func (c Core) QueryDuplicatePolicy(ctx context.Context, userID string) (DuplicatePolicy, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return DuplicatePolicy{}, ErrInvalidID
+	}
+
+	dbDP, err := c.store.QueryDuplicatePolicy(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return DuplicatePolicy{UserID: userID, Policy: DuplicateReject}, nil
+		}
+		return DuplicatePolicy{}, fmt.Errorf("query: %w", err)
+	}
+
+	return toDuplicatePolicy(dbDP), nil
+}
+
+// UpdateDuplicatePolicy sets the duplicate policy for the specified user.
+func (c Core) UpdateDuplicatePolicy(ctx context.Context, dp DuplicatePolicy) error {
+	if err := validate.CheckID(dp.UserID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := validate.Check(dp); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	dbDP := db.DuplicatePolicy{
+		UserID: dp.UserID,
+		Policy: dp.Policy,
+	}
+
+	if err := c.store.UpsertDuplicatePolicy(ctx, dbDP); err != nil {
+		return fmt.Errorf("upsert: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// checkFilter validates the filter and normalizes the tags it names.
+func checkFilter(filter QueryFilter) (QueryFilter, error) {
+	if err := validate.Check(filter); err != nil {
+		return QueryFilter{}, fmt.Errorf("validating filter: %w", err)
+	}
+
+	if len(filter.Tags) > 0 {
+		tags, err := normalizeTags(filter.Tags)
+		if err != nil {
+			return QueryFilter{}, err
+		}
+		filter.Tags = tags
+	}
+
+	return filter, nil
+}
+
+// sortColumns maps the fields images can be sorted on to their columns.
+var sortColumns = map[string]string{
+	"date_uploaded": "date_uploaded",
+	"id":            "image_id",
+	"size":          "size",
+}
+
+// sortKey returns the value of the sort field of an image as held in a
+// cursor.
+func sortKey(field string, dbImg db.Image) string {
+	switch field {
+	case "date_uploaded":
+		return dbImg.DateUploaded.UTC().Format(time.RFC3339Nano)
+	case "deleted_at":
+		if dbImg.DeletedAt != nil {
+			return dbImg.DeletedAt.UTC().Format(time.RFC3339Nano)
+		}
+	case "size":
+		return strconv.FormatInt(dbImg.Size, 10)
+	}
+	return ""
+}
+
+// parseSortKey converts the sort key held in a cursor back to the value of
+// the sort field.
+func parseSortKey(field string, key string) (any, error) {
+	switch field {
+	case "date_uploaded", "deleted_at":
+		return time.Parse(time.RFC3339Nano, key)
+	case "size":
+		return strconv.ParseInt(key, 10, 64)
+	case "rank":
+		return strconv.ParseFloat(key, 64)
+	}
+	return nil, nil
+}
+
+// stagedContent is content held under a temporary key in the blob store.
+type stagedContent struct {
+	key      string
+	checksum string
+	size     int64
+	mimeType string
+}
+
+// stageContent streams content into the blob store under a temporary key,
+// sniffing its MIME type and hashing it on the way. The checksum isn't known
+// until all the content has been read, so the content can't be stored under
+// its final key yet. The caller must remove the temporary key.
+func (c Core) stageContent(ctx context.Context, content io.Reader) (stagedContent, error) {
+	// Peek at the start of the content to sniff the MIME type without
+	// losing those bytes for the blob store.
+	br := bufio.NewReaderSize(content, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return stagedContent{}, fmt.Errorf("reading content: %w", err)
+	}
+
+	mimeType := http.DetectContentType(head)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return stagedContent{}, ErrUnsupportedType
+	}
+
+	key := "uploads/" + validate.GenerateID()
+
+	hash := sha256.New()
+	size, err := c.blobs.Put(ctx, key, io.TeeReader(br, hash))
+	if err != nil {
+		return stagedContent{}, fmt.Errorf("storing content: %w", err)
+	}
+
+	staged := stagedContent{
+		key:      key,
+		checksum: hex.EncodeToString(hash.Sum(nil)),
+		size:     size,
+		mimeType: mimeType,
+	}
+
+	return staged, nil
+}
+
+// checksumOf returns the hex encoded SHA-256 of the content, leaving the
+// content positioned at its start.
+func checksumOf(content io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contentKey returns the blob store key for content with the specified hex
+// encoded SHA-256 checksum. The leading bytes fan the content out across
+// directories so none of them grow too large.
+func contentKey(checksum string) string {
+	return path.Join("sha256", checksum[0:2], checksum[2:4], checksum)
+}
+
+// removeBlob deletes content from the blob store. A failure leaves an orphaned
+// blob behind but must not fail the calling operation, so it's only logged.
+func (c Core) removeBlob(ctx context.Context, key string) {
+	if err := c.blobs.Delete(ctx, key); err != nil {
+		c.log.Errorw("remove blob", "traceid", web.GetTraceID(ctx), "key", key, "ERROR", err)
+	}
+}
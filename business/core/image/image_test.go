@@ -1,13 +1,27 @@
 package image_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	stdimage "image"
+	_ "image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fadhilijuma/images/business/core/image"
 	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/fetch"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/foundation/diskcache"
 	"github.com/fadhilijuma/images/foundation/docker"
 	"github.com/google/go-cmp/cmp"
 )
@@ -30,7 +44,17 @@ func Test_Image(t *testing.T) {
 	log, db, teardown := dbtest.NewUnit(t, c, "testprod")
 	t.Cleanup(teardown)
 
-	core := image.NewCore(log, db)
+	blobs := blob.NewMemory()
+	cache, err := diskcache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("constructing transform cache: %s", err)
+	}
+	sharing := image.Sharing{
+		Secret:     []byte("share secret"),
+		DefaultTTL: time.Hour,
+		MaxTTL:     24 * time.Hour,
+	}
+	core := image.NewCore(log, db, blobs, image.WithTransformCache(cache), image.WithSharing(sharing))
 
 	t.Log("Given the need to work with Image records.")
 	{
@@ -41,16 +65,27 @@ func Test_Image(t *testing.T) {
 			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
 
 			ni := image.NewImage{
-				ImageURL: "images/afp.jpg",
-				UserID:   "5cf37266-3473-4006-984f-9325122678b7",
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
 			}
 
-			img, err := core.Create(ctx, ni, now)
+			content := dbtest.PNGContent(t, 8, 6)
+
+			img, err := core.Create(ctx, ni, bytes.NewReader(content), now)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to create a image.", dbtest.Success, testID)
 
+			if img.MimeType != "image/png" || img.Size != int64(len(content)) {
+				t.Fatalf("\t%s\tTest %d:\tShould record the content type and size : got %s/%d.", dbtest.Failed, testID, img.MimeType, img.Size)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the content type and size.", dbtest.Success, testID)
+
+			if !exists(blobs, img.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould store the content in the blob store.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould store the content in the blob store.", dbtest.Success, testID)
+
 			saved, err := core.QueryByID(ctx, img.ID)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve image by ID: %s.", dbtest.Failed, testID, err)
@@ -63,17 +98,20 @@ func Test_Image(t *testing.T) {
 			t.Logf("\t%s\tTest %d:\tShould get back the same image.", dbtest.Success, testID)
 
 			upd := image.UpdateImage{
-				ImageURL: dbtest.StringPointer("images/pic.jpg"),
-				UserID:   dbtest.StringPointer("5cf37266-3473-4006-984f-9325122678b7"),
+				UserID: dbtest.StringPointer("45b5fbd3-755f-4379-8f07-a58d4a30fa2f"),
+				Editorial: &image.UpdateEditorialMetadata{
+					Caption:  dbtest.StringPointer("Crowds gather in the Place de la Concorde."),
+					Keywords: &[]string{"france", " protest "},
+				},
 			}
 			updatedTime := time.Date(2019, time.January, 1, 1, 1, 1, 0, time.UTC)
 
-			if err := core.Update(ctx, img.ID, upd, updatedTime); err != nil {
+			if err := core.Update(ctx, img.ID, upd, img.UserID, updatedTime); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to update image : %s.", dbtest.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to update image.", dbtest.Success, testID)
 
-			products, err := core.Query(ctx, 1, 3)
+			products, _, err := core.QueryPage(ctx, image.QueryFilter{UserID: upd.UserID}, paging.Page{Limit: 3})
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve updated image : %s.", dbtest.Failed, testID, err)
 			}
@@ -82,8 +120,10 @@ func Test_Image(t *testing.T) {
 			// Check specified fields were updated. Make a copy of the original image
 			// and change just the fields we expect then diff it with what was saved.
 			want := img
-			want.ImageURL = *upd.ImageURL
 			want.UserID = *upd.UserID
+			want.Editorial.Caption = *upd.Editorial.Caption
+			want.Editorial.Keywords = []string{"france", "protest"}
+			want.Version = img.Version + 1
 
 			var idx int
 			for i, p := range products {
@@ -97,11 +137,10 @@ func Test_Image(t *testing.T) {
 			t.Logf("\t%s\tTest %d:\tShould get back the same image.", dbtest.Success, testID)
 
 			upd = image.UpdateImage{
-				ImageURL: dbtest.StringPointer("images/image.jpg"),
-				UserID:   dbtest.StringPointer("5cf37266-3473-4006-984f-9325122678b7"),
+				UserID: dbtest.StringPointer("5cf37266-3473-4006-984f-9325122678b7"),
 			}
 
-			if err := core.Update(ctx, img.ID, upd, updatedTime); err != nil {
+			if err := core.Update(ctx, img.ID, upd, img.UserID, updatedTime); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to update just some fields of image : %s.", dbtest.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to update just some fields of image.", dbtest.Success, testID)
@@ -112,22 +151,1077 @@ func Test_Image(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to retrieve updated image.", dbtest.Success, testID)
 
-			if saved.ImageURL != *upd.ImageURL {
-				t.Fatalf("\t%s\tTest %d:\tShould be able to see updated ImageURL field : got %q want %q.", dbtest.Failed, testID, saved.ImageURL, *upd.ImageURL)
+			if saved.UserID != *upd.UserID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to see updated UserID field : got %q want %q.", dbtest.Failed, testID, saved.UserID, *upd.UserID)
 			} else {
-				t.Logf("\t%s\tTest %d:\tShould be able to see updated Name field.", dbtest.Success, testID)
+				t.Logf("\t%s\tTest %d:\tShould be able to see updated UserID field.", dbtest.Success, testID)
+			}
+
+			stale := image.UpdateImage{
+				Visibility: dbtest.StringPointer(image.VisibilityPublic),
+				Version:    &img.Version,
+			}
+			if err := core.Update(ctx, img.ID, stale, img.UserID, updatedTime); !errors.Is(err, image.ErrConflict) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to update an older version of image : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to update an older version of image.", dbtest.Success, testID)
+
+			current := image.UpdateImage{
+				Visibility: dbtest.StringPointer(image.VisibilityPublic),
+				Version:    &saved.Version,
+			}
+			if err := core.Update(ctx, img.ID, current, img.UserID, updatedTime); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the current version of image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update the current version of image.", dbtest.Success, testID)
+
+			unknown := image.UpdateImage{UserID: dbtest.StringPointer("f3d2b4a1-3c44-4b39-9a5e-4d0f4a9f6a10")}
+			if err := core.Update(ctx, img.ID, unknown, img.UserID, updatedTime); !errors.Is(err, image.ErrOwnerNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to hand an image to an unknown user : %v.", dbtest.Failed, testID, err)
 			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to hand an image to an unknown user.", dbtest.Success, testID)
 
-			if err := core.Delete(ctx, img.ID); err != nil {
+			if err := core.Delete(ctx, img.ID, ni.UserID, now); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to delete image.", dbtest.Success, testID)
-			//
-			//_, err = core.QueryByID(ctx, img.ID)
-			//if !errors.Is(err, image.ErrNotFound) {
-			//	t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted image : %s.", dbtest.Failed, testID, err)
-			//}
-			//t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted image.", dbtest.Success, testID)
+
+			_, err = core.QueryByID(ctx, img.ID)
+			if !errors.Is(err, image.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted image.", dbtest.Success, testID)
+
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to purge the trash.", dbtest.Success, testID)
+
+			if blobs.Len() != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the content of a deleted image : got %d blobs.", dbtest.Failed, testID, blobs.Len())
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove the content of a deleted image.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen a new Image is rejected.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "00000000-0000-0000-0000-000000000000",
+			}
+
+			if _, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 4, 4)), now); !errors.Is(err, image.ErrOwnerNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create an image for an unknown user.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create an image for an unknown user.", dbtest.Success, testID)
+
+			if blobs.Len() != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould roll back the stored content : got %d blobs.", dbtest.Failed, testID, blobs.Len())
+			}
+			t.Logf("\t%s\tTest %d:\tShould roll back the stored content.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen handling content that isn't an image.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			_, err := core.Create(ctx, ni, bytes.NewReader([]byte("plain text")), now)
+			if !errors.Is(err, image.ErrUnsupportedType) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject content that isn't an image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject content that isn't an image.", dbtest.Success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen handling duplicate content.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+			}
+			content := dbtest.PNGContent(t, 5, 5)
+
+			first, err := core.Create(ctx, ni, bytes.NewReader(content), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a image.", dbtest.Success, testID)
+
+			_, err = core.Create(ctx, ni, bytes.NewReader(content), now)
+			var de *image.DuplicateError
+			if !errors.As(err, &de) || de.ImageID != first.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the duplicate pointing at the existing image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the duplicate pointing at the existing image.", dbtest.Success, testID)
+
+			dp := image.DuplicatePolicy{
+				UserID: ni.UserID,
+				Policy: image.DuplicateReference,
+			}
+			if err := core.UpdateDuplicatePolicy(ctx, dp); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the duplicate policy : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update the duplicate policy.", dbtest.Success, testID)
+
+			second, err := core.Create(ctx, ni, bytes.NewReader(content), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a duplicate image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a duplicate image.", dbtest.Success, testID)
+
+			if second.StorageKey != first.StorageKey {
+				t.Fatalf("\t%s\tTest %d:\tShould share the stored content : got %s and %s.", dbtest.Failed, testID, first.StorageKey, second.StorageKey)
+			}
+			t.Logf("\t%s\tTest %d:\tShould share the stored content.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, first.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if !exists(blobs, second.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould keep content that is still referenced.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep content that is still referenced.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, second.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if blobs.Len() != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove content once nothing references it : got %d blobs.", dbtest.Failed, testID, blobs.Len())
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove content once nothing references it.", dbtest.Success, testID)
 		}
+
+		testID = 4
+		t.Logf("\tTest %d:\tWhen handling the renditions of an Image.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 400, 300)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a image.", dbtest.Success, testID)
+
+			if img.Width != 400 || img.Height != 300 || img.Format != "png" || img.ColorModel != "rgba" || img.Orientation != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould record the technical metadata : got %dx%d %s %s %d.", dbtest.Failed, testID, img.Width, img.Height, img.Format, img.ColorModel, img.Orientation)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the technical metadata.", dbtest.Success, testID)
+
+			minWidth := 400
+			filter := image.QueryFilter{
+				Format:   dbtest.StringPointer("png"),
+				MinWidth: &minWidth,
+			}
+			imgs, _, err := core.QueryPage(ctx, filter, paging.Page{Limit: 10})
+			if err != nil || len(imgs) != 1 || imgs[0].ID != img.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to filter on the technical metadata : %v %d.", dbtest.Failed, testID, err, len(imgs))
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to filter on the technical metadata.", dbtest.Success, testID)
+
+			queue := image.RenditionQueue
+			queued, err := jobs.NewStore(log, db).Query(ctx, jobs.QueryFilter{Queue: &queue}, 100)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the renditions queue : %s.", dbtest.Failed, testID, err)
+			}
+			var job *jobs.Job
+			for i := range queued {
+				if strings.Contains(string(queued[i].Payload), img.ID) {
+					job = &queued[i]
+				}
+			}
+			if job == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould enqueue a job to make the renditions.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould enqueue a job to make the renditions.", dbtest.Success, testID)
+
+			if err := core.Render(ctx, *job); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to run the renditions job : %s.", dbtest.Failed, testID, err)
+			}
+
+			rnds, err := core.QueryRenditions(ctx, img.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve renditions : %s.", dbtest.Failed, testID, err)
+			}
+			if len(rnds) != len(image.DefaultPresets) {
+				t.Fatalf("\t%s\tTest %d:\tShould have a rendition for every preset : got %d.", dbtest.Failed, testID, len(rnds))
+			}
+			t.Logf("\t%s\tTest %d:\tShould have a rendition for every preset.", dbtest.Success, testID)
+
+			if err := blobs.Delete(ctx, rnds[0].StorageKey); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to remove rendition content : %s.", dbtest.Failed, testID, err)
+			}
+
+			rnd, content, err := core.OpenRendition(ctx, img.ID, "small", now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open a missing rendition : %s.", dbtest.Failed, testID, err)
+			}
+			content.Close()
+			t.Logf("\t%s\tTest %d:\tShould be able to open a missing rendition.", dbtest.Success, testID)
+
+			if rnd.Width != 160 || rnd.Height != 120 || rnd.MimeType != "image/png" {
+				t.Fatalf("\t%s\tTest %d:\tShould get a 160x120 png : got %dx%d %s.", dbtest.Failed, testID, rnd.Width, rnd.Height, rnd.MimeType)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a 160x120 png.", dbtest.Success, testID)
+
+			if _, _, err := core.OpenRendition(ctx, img.ID, "huge", now); !errors.Is(err, image.ErrUnknownPreset) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open an unknown rendition : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to open an unknown rendition.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if blobs.Len() != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the renditions of a deleted image : got %d blobs.", dbtest.Failed, testID, blobs.Len())
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove the renditions of a deleted image.", dbtest.Success, testID)
+		}
+
+		testID = 5
+		t.Logf("\tTest %d:\tWhen transforming an Image on the fly.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 400, 300)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a image.", dbtest.Success, testID)
+
+			tr := image.Transform{Width: 100, Height: 100, Fit: image.FitCover, Format: "jpeg"}
+			got, content, err := core.OpenTransform(ctx, img.ID, tr)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to transform the image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to transform the image.", dbtest.Success, testID)
+
+			cfg, format, err := stdimage.DecodeConfig(content)
+			content.Close()
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the transformed image : %s.", dbtest.Failed, testID, err)
+			}
+			if cfg.Width != 100 || cfg.Height != 100 || format != "jpeg" || got.Quality != 80 {
+				t.Fatalf("\t%s\tTest %d:\tShould get a 100x100 jpeg at quality 80 : got %dx%d %s q%d.", dbtest.Failed, testID, cfg.Width, cfg.Height, format, got.Quality)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a 100x100 jpeg at quality 80.", dbtest.Success, testID)
+
+			if cache.Size() == 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould cache the transformed image.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould cache the transformed image.", dbtest.Success, testID)
+
+			if _, _, err := core.OpenTransform(ctx, img.ID, image.Transform{Width: 100, Fit: image.FitCover}); !errors.Is(err, image.ErrInvalidTransform) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to cover without both sides : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to cover without both sides.", dbtest.Success, testID)
+
+			if _, _, err := core.OpenTransform(ctx, img.ID, image.Transform{Width: 4096}); !errors.Is(err, image.ErrTransformNotAllowed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to transform beyond the limits : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to transform beyond the limits.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+		}
+
+		testID = 6
+		t.Logf("\tTest %d:\tWhen paging through Images.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+			}
+
+			var ids []string
+			for i := 1; i <= 3; i++ {
+				img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, i*10, i*10)), now.Add(time.Duration(i)*time.Hour))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+				}
+				ids = append(ids, img.ID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create images.", dbtest.Success, testID)
+
+			filter := image.QueryFilter{UserID: &ni.UserID}
+
+			var got []string
+			page := paging.Page{Limit: 2, Sort: "date_uploaded"}
+			for i := 0; ; i++ {
+				imgs, next, err := core.QueryPage(ctx, filter, page)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query a page : %s.", dbtest.Failed, testID, err)
+				}
+				for _, img := range imgs {
+					got = append(got, img.ID)
+				}
+				if next == "" || i > 3 {
+					break
+				}
+				page.Cursor = next
+			}
+
+			if diff := cmp.Diff(ids, got); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get every image once in order. Diff:\n%s", dbtest.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get every image once in order.", dbtest.Success, testID)
+
+			page = paging.Page{Limit: 2, Sort: "-size", Cursor: page.Cursor}
+			if _, _, err := core.QueryPage(ctx, filter, page); !errors.Is(err, paging.ErrInvalidCursor) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a cursor with another sort : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a cursor with another sort.", dbtest.Success, testID)
+
+			order := paging.Order{Field: "date_uploaded", Desc: true}
+			page = paging.Page{Limit: 2, Cursor: paging.Next(order, now.Format(time.RFC3339Nano), "not an id")}
+			if _, _, err := core.QueryPage(ctx, filter, page); !errors.Is(err, paging.ErrInvalidCursor) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a cursor with an invalid ID : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a cursor with an invalid ID.", dbtest.Success, testID)
+
+			for _, id := range ids {
+				if err := core.Delete(ctx, id, ni.UserID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+				}
+			}
+		}
+
+		testID = 7
+		t.Logf("\tTest %d:\tWhen handling image tags.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			first, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 12, 12)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+			second, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 14, 14)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+
+			tags, err := core.AddTags(ctx, first.ID, image.NewTags{Tags: []string{"  New   York ", "new york", "Caf\u0065\u0301"}}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to tag an image : %s.", dbtest.Failed, testID, err)
+			}
+			if len(tags) != 2 || tags[0].Name != "caf\u00e9" || tags[1].Name != "new york" {
+				t.Fatalf("\t%s\tTest %d:\tShould normalize the tag names : got %+v.", dbtest.Failed, testID, tags)
+			}
+			t.Logf("\t%s\tTest %d:\tShould normalize the tag names.", dbtest.Success, testID)
+
+			if _, err := core.AddTags(ctx, second.ID, image.NewTags{Tags: []string{"new york", "newsroom"}}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to tag an image : %s.", dbtest.Failed, testID, err)
+			}
+
+			for _, tt := range []struct {
+				match string
+				tags  []string
+				want  int
+			}{
+				{image.TagMatchAll, []string{"New York", "café"}, 1},
+				{image.TagMatchAny, []string{"café", "newsroom"}, 2},
+			} {
+				match := tt.match
+				filter := image.QueryFilter{UserID: &ni.UserID, Tags: tt.tags, TagMatch: &match}
+				imgs, _, err := core.QueryPage(ctx, filter, paging.Page{})
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to filter on tags : %s.", dbtest.Failed, testID, err)
+				}
+				if len(imgs) != tt.want {
+					t.Fatalf("\t%s\tTest %d:\tShould get %d images matching %s of %v : got %d.", dbtest.Failed, testID, tt.want, tt.match, tt.tags, len(imgs))
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to filter on all or any tags.", dbtest.Success, testID)
+
+			suggested, err := core.SuggestTags(ctx, "NEW", 0)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to suggest tags : %s.", dbtest.Failed, testID, err)
+			}
+			if len(suggested) != 2 || suggested[0].Name != "new york" || suggested[0].Count != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould rank suggestions by usage : got %+v.", dbtest.Failed, testID, suggested)
+			}
+			t.Logf("\t%s\tTest %d:\tShould rank suggestions by usage.", dbtest.Success, testID)
+
+			if _, err := core.RenameTag(ctx, "newsroom", image.RenameTag{Name: "New York"}); !errors.Is(err, image.ErrTagExists) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to rename onto an existing tag : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to rename onto an existing tag.", dbtest.Success, testID)
+
+			merged, err := core.MergeTags(ctx, image.MergeTags{Tags: []string{"newsroom", "café"}, Into: "new york"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to merge tags : %s.", dbtest.Failed, testID, err)
+			}
+			if merged.Count != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould keep one tag per image when merging : got %d.", dbtest.Failed, testID, merged.Count)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to merge tags.", dbtest.Success, testID)
+
+			renamed, err := core.RenameTag(ctx, "new york", image.RenameTag{Name: "NYC"})
+			if err != nil || renamed.Name != "nyc" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to rename a tag : %+v %v.", dbtest.Failed, testID, renamed, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to rename a tag.", dbtest.Success, testID)
+
+			if err := core.RemoveTag(ctx, first.ID, "NYC"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to remove a tag : %s.", dbtest.Failed, testID, err)
+			}
+			tags, err = core.QueryTags(ctx, first.ID)
+			if err != nil || len(tags) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould have no tags left : %+v %v.", dbtest.Failed, testID, tags, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to remove a tag.", dbtest.Success, testID)
+
+			if _, err := core.AddTags(ctx, first.ID, image.NewTags{Tags: []string{"   "}}, now); !errors.Is(err, image.ErrInvalidTag) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to add an empty tag : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to add an empty tag.", dbtest.Success, testID)
+
+			for _, id := range []string{first.ID, second.ID} {
+				if err := core.Delete(ctx, id, ni.UserID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+				}
+			}
+		}
+
+		testID = 8
+		t.Logf("\tTest %d:\tWhen searching images.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.March, 2, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			editorials := []image.UpdateEditorialMetadata{
+				{Headline: strPtr("Flash floods hit Nairobi"), Caption: strPtr("Residents wade through flood waters in Nairobi."), Keywords: &[]string{"weather"}},
+				{Headline: strPtr("Aerial view of the floods"), Caption: strPtr("Farms under water near Kisumu."), Keywords: &[]string{"aerial", "weather"}},
+				{Headline: strPtr("Nairobi marathon"), Caption: strPtr("Runners cross the finish line."), Credit: strPtr("Flood Photo Agency")},
+			}
+
+			var ids []string
+			for i, ue := range editorials {
+				img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 20+i, 20+i)), now)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+				}
+
+				ue := ue
+				if err := core.Update(ctx, img.ID, image.UpdateImage{Editorial: &ue}, img.UserID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to update image : %s.", dbtest.Failed, testID, err)
+				}
+				ids = append(ids, img.ID)
+			}
+
+			for _, tt := range []struct {
+				q    string
+				want []string
+			}{
+				{"flooding nairobi", []string{ids[0], ids[2]}},
+				{`"flood waters"`, []string{ids[0]}},
+				{"flood -aerial", []string{ids[0], ids[2]}},
+				{"caption:flooded", []string{ids[0]}},
+				{`credit:"photo agency"`, []string{ids[2]}},
+			} {
+				results, _, err := core.Search(ctx, image.SearchQuery{Text: tt.q}, image.QueryFilter{UserID: &ni.UserID}, paging.Page{Sort: "date_uploaded"})
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to search for %q : %s.", dbtest.Failed, testID, tt.q, err)
+				}
+
+				var got []string
+				for _, res := range results {
+					got = append(got, res.Image.ID)
+				}
+				sort.Strings(got)
+				want := append([]string(nil), tt.want...)
+				sort.Strings(want)
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould get the images matching %q. Diff:\n%s", dbtest.Failed, testID, tt.q, diff)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the images matching words, phrases, exclusions and fields.", dbtest.Success, testID)
+
+			results, _, err := core.Search(ctx, image.SearchQuery{Text: "flood"}, image.QueryFilter{UserID: &ni.UserID}, paging.Page{})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to search : %s.", dbtest.Failed, testID, err)
+			}
+			if len(results) != 3 || results[0].Image.ID != ids[0] {
+				t.Fatalf("\t%s\tTest %d:\tShould rank the headline and caption match first : got %d results.", dbtest.Failed, testID, len(results))
+			}
+			if !strings.Contains(results[0].Highlights.Headline, "<mark>floods</mark>") {
+				t.Fatalf("\t%s\tTest %d:\tShould highlight the matching words : got %q.", dbtest.Failed, testID, results[0].Highlights.Headline)
+			}
+			t.Logf("\t%s\tTest %d:\tShould rank and highlight the results.", dbtest.Success, testID)
+
+			if _, _, err := core.Search(ctx, image.SearchQuery{Text: "-flood"}, image.QueryFilter{}, paging.Page{}); !errors.Is(err, image.ErrInvalidQuery) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to search only for exclusions : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to search only for exclusions.", dbtest.Success, testID)
+
+			for _, id := range ids {
+				if err := core.Delete(ctx, id, ni.UserID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+				}
+			}
+		}
+
+		testID = 9
+		t.Logf("\tTest %d:\tWhen finding similar images.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.April, 3, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+			}
+
+			// The same solid image at two sizes looks the same once reduced.
+			var ids []string
+			for _, size := range []int{16, 48} {
+				img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, size, size)), now)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+				}
+				if img.DHash == "" || img.PHash == "" {
+					t.Fatalf("\t%s\tTest %d:\tShould hash the image content : %+v.", dbtest.Failed, testID, img)
+				}
+				ids = append(ids, img.ID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould hash the image content.", dbtest.Success, testID)
+
+			distance := 0
+			similar, err := core.Similar(ctx, ids[0], image.SimilarQuery{Hash: image.HashDifference, Distance: &distance})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to find similar images : %s.", dbtest.Failed, testID, err)
+			}
+
+			var found bool
+			for _, si := range similar {
+				if si.Image.ID == ids[0] {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT find the image itself.", dbtest.Failed, testID)
+				}
+				found = found || si.Image.ID == ids[1]
+			}
+			if !found {
+				t.Fatalf("\t%s\tTest %d:\tShould find the resized image : got %d images.", dbtest.Failed, testID, len(similar))
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to find similar images.", dbtest.Success, testID)
+
+			distance = 65
+			if _, err := core.Similar(ctx, ids[0], image.SimilarQuery{Distance: &distance}); !errors.Is(err, image.ErrInvalidDistance) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to search beyond the maximum distance : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to search beyond the maximum distance.", dbtest.Success, testID)
+
+			for _, id := range ids {
+				if err := core.Delete(ctx, id, ni.UserID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+				}
+			}
+		}
+
+		testID = 10
+		t.Logf("\tTest %d:\tWhen moving Images to the trash.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.May, 4, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+			}
+			adminID := "5cf37266-3473-4006-984f-9325122678b7"
+
+			img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 12, 10)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+
+			if err := core.Delete(ctx, img.ID, adminID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryByID(ctx, img.ID); !errors.Is(err, image.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve trashed image : %v.", dbtest.Failed, testID, err)
+			}
+			if !exists(blobs, img.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the content of a trashed image.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould move the image to the trash.", dbtest.Success, testID)
+
+			trash, _, err := core.QueryTrash(ctx, image.TrashFilter{UserID: &ni.UserID}, paging.Page{})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if len(trash) == 0 || trash[0].ID != img.ID || trash[0].DeletedBy != adminID || trash[0].DeletedAt == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould find the most recently trashed image first : %+v.", dbtest.Failed, testID, trash)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the most recently trashed image first.", dbtest.Success, testID)
+
+			missing := "a2b0639f-2cc6-44b8-b97b-15d69dbb511e"
+			if _, err := core.Restore(ctx, img.ID, image.RestoreImage{UserID: &missing}, now); !errors.Is(err, image.ErrOwnerNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to restore to an unknown user : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to restore to an unknown user.", dbtest.Success, testID)
+
+			restored, err := core.Restore(ctx, img.ID, image.RestoreImage{}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to restore the image : %s.", dbtest.Failed, testID, err)
+			}
+			if restored.UserID != ni.UserID || restored.DeletedAt != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould give the image back to its owner : %+v.", dbtest.Failed, testID, restored)
+			}
+			if _, err := core.QueryByID(ctx, img.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve restored image : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to restore the image.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, adminID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, err := core.Purge(ctx, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryTrashByID(ctx, img.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep images trashed within the retention : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep images trashed within the retention.", dbtest.Success, testID)
+
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryTrashByID(ctx, img.ID); !errors.Is(err, image.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould purge images trashed before the retention : %v.", dbtest.Failed, testID, err)
+			}
+			if exists(blobs, img.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the content of a purged image.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould purge images trashed before the retention.", dbtest.Success, testID)
+		}
+
+		testID = 11
+		t.Logf("\tTest %d:\tWhen keeping the revisions of an Image.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.May, 5, 0, 0, 0, 0, time.UTC)
+
+			ni := image.NewImage{
+				UserID: "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+			}
+			adminID := "5cf37266-3473-4006-984f-9325122678b7"
+
+			img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 14, 10)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+			}
+
+			upd := image.UpdateImage{Editorial: &image.UpdateEditorialMetadata{Headline: strPtr("Sunrise over Lamu")}}
+			if err := core.Update(ctx, img.ID, upd, adminID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update image : %s.", dbtest.Failed, testID, err)
+			}
+
+			revs, err := core.QueryRevisions(ctx, img.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the revisions : %s.", dbtest.Failed, testID, err)
+			}
+			if len(revs) != 2 || revs[0].Revision != 2 || revs[0].ActorID != adminID || revs[0].Editorial.Headline != "Sunrise over Lamu" {
+				t.Fatalf("\t%s\tTest %d:\tShould record a revision for each change, latest first : %+v.", dbtest.Failed, testID, revs)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record a revision for each change, latest first.", dbtest.Success, testID)
+
+			diff, err := core.DiffRevisions(ctx, img.ID, 1, 2)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to diff the revisions : %s.", dbtest.Failed, testID, err)
+			}
+			want := []image.Change{{Field: "editorial.headline", From: "", To: "Sunrise over Lamu"}}
+			if d := cmp.Diff(want, diff.Changes); d != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the fields that changed. Diff:\n%s", dbtest.Failed, testID, d)
+			}
+			if _, err := core.DiffRevisions(ctx, img.ID, 1, 9); !errors.Is(err, image.ErrRevisionNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to diff an unknown revision : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to diff the revisions.", dbtest.Success, testID)
+
+			stale := img.Version
+			if _, err := core.Replace(ctx, img.ID, bytes.NewReader(dbtest.PNGContent(t, 16, 12)), &stale, ni.UserID, now); !errors.Is(err, image.ErrConflict) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to replace the content of a changed version : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to replace the content of a changed version.", dbtest.Success, testID)
+
+			replaced, err := core.Replace(ctx, img.ID, bytes.NewReader(dbtest.PNGContent(t, 16, 12)), nil, ni.UserID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to replace the content : %s.", dbtest.Failed, testID, err)
+			}
+			if replaced.Checksum == img.Checksum || replaced.Width != 16 || replaced.Editorial.Headline != "Sunrise over Lamu" {
+				t.Fatalf("\t%s\tTest %d:\tShould replace the content and keep the editorial metadata : %+v.", dbtest.Failed, testID, replaced)
+			}
+			if !exists(blobs, img.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the content of earlier revisions.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to replace the content.", dbtest.Success, testID)
+
+			same, err := core.Replace(ctx, img.ID, bytes.NewReader(dbtest.PNGContent(t, 16, 12)), nil, ni.UserID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to replace the content with the same content : %s.", dbtest.Failed, testID, err)
+			}
+			if same.Version != replaced.Version {
+				t.Fatalf("\t%s\tTest %d:\tShould leave the image unchanged when the content is the same : version %d.", dbtest.Failed, testID, same.Version)
+			}
+			t.Logf("\t%s\tTest %d:\tShould leave the image unchanged when the content is the same.", dbtest.Success, testID)
+
+			reverted, err := core.Revert(ctx, img.ID, 1, ni.UserID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revert the image : %s.", dbtest.Failed, testID, err)
+			}
+			if reverted.Checksum != img.Checksum || reverted.Editorial.Headline != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the content and editorial metadata of the revision : %+v.", dbtest.Failed, testID, reverted)
+			}
+
+			revs, err = core.QueryRevisions(ctx, img.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the revisions : %s.", dbtest.Failed, testID, err)
+			}
+			if len(revs) != 4 || revs[0].RevertedFrom == nil || *revs[0].RevertedFrom != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould record the revert as a new revision : %+v.", dbtest.Failed, testID, revs)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to revert the image.", dbtest.Success, testID)
+
+			if _, err := core.Revert(ctx, img.ID, 9, ni.UserID, now); !errors.Is(err, image.ErrRevisionNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to revert to an unknown revision : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to revert to an unknown revision.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Purge(ctx, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge the trash : %s.", dbtest.Failed, testID, err)
+			}
+			if exists(blobs, img.StorageKey) || exists(blobs, replaced.StorageKey) {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the content of every revision of a purged image.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove the content of every revision of a purged image.", dbtest.Success, testID)
+		}
+
+		testID = 12
+		t.Logf("\tTest %d:\tWhen sharing Images.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.May, 6, 0, 0, 0, 0, time.UTC)
+
+			ownerID := "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			viewerID := "5cf37266-3473-4006-984f-9325122678b7"
+			orgID := "a8c0e9d5-3a2b-4c7e-9b1f-2d6e4f8a1c3b"
+
+			ids := make(map[string]string)
+			for i, visibility := range []string{image.VisibilityPrivate, image.VisibilityOrganization, image.VisibilityPublic} {
+				ni := image.NewImage{
+					UserID:         ownerID,
+					OrganizationID: orgID,
+					Visibility:     visibility,
+				}
+				img, err := core.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 18+i, 10)), now)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create a image : %s.", dbtest.Failed, testID, err)
+				}
+				ids[visibility] = img.ID
+			}
+
+			for _, tt := range []struct {
+				orgID *string
+				want  []string
+			}{
+				{nil, []string{ids[image.VisibilityPublic]}},
+				{&orgID, []string{ids[image.VisibilityOrganization], ids[image.VisibilityPublic]}},
+			} {
+				filter := image.QueryFilter{
+					UserID:               &ownerID,
+					UploadedAfter:        &now,
+					ViewerID:             &viewerID,
+					ViewerOrganizationID: tt.orgID,
+				}
+				imgs, _, err := core.QueryPage(ctx, filter, paging.Page{})
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query images : %s.", dbtest.Failed, testID, err)
+				}
+
+				var got []string
+				for _, img := range imgs {
+					got = append(got, img.ID)
+				}
+				sort.Strings(got)
+				want := append([]string(nil), tt.want...)
+				sort.Strings(want)
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould only get the images the viewer can see. Diff:\n%s", dbtest.Failed, testID, diff)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould only get the images the viewer can see.", dbtest.Success, testID)
+
+			private, err := core.QueryByID(ctx, ids[image.VisibilityPrivate])
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve image by ID : %s.", dbtest.Failed, testID, err)
+			}
+			if private.VisibleTo(viewerID, orgID) || !private.VisibleTo(ownerID, "") {
+				t.Fatalf("\t%s\tTest %d:\tShould only let the owner view a private image.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only let the owner view a private image.", dbtest.Success, testID)
+
+			maxDownloads := 1
+			share, err := core.CreateShare(ctx, private.ID, image.NewShare{MaxDownloads: &maxDownloads}, ownerID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to share the image : %s.", dbtest.Failed, testID, err)
+			}
+
+			opened, err := core.OpenShare(ctx, share.Token, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to open the share : %s.", dbtest.Failed, testID, err)
+			}
+			if opened.ImageID != private.ID || opened.Downloads != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould count the download of the shared image : %+v.", dbtest.Failed, testID, opened)
+			}
+			if _, err := core.OpenShare(ctx, share.Token, now); !errors.Is(err, image.ErrShareExhausted) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to download past the maximum : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to download a shared image up to the maximum.", dbtest.Success, testID)
+
+			share, err = core.CreateShare(ctx, private.ID, image.NewShare{Rendition: "small"}, ownerID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to share the rendition : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.OpenShare(ctx, share.Token+"x", now); !errors.Is(err, image.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open a tampered share : %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.OpenShare(ctx, share.Token, share.ExpiresAt); !errors.Is(err, image.ErrShareExpired) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open an expired share : %v.", dbtest.Failed, testID, err)
+			}
+			if err := core.RevokeShare(ctx, private.ID, share.ID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke the share : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.OpenShare(ctx, share.Token, now); !errors.Is(err, image.ErrShareRevoked) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open a revoked share : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to open tampered, expired or revoked shares.", dbtest.Success, testID)
+
+			expiresIn := int((48 * time.Hour) / time.Second)
+			if _, err := core.CreateShare(ctx, private.ID, image.NewShare{ExpiresIn: &expiresIn}, ownerID, now); !errors.Is(err, image.ErrInvalidExpiry) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to share beyond the maximum TTL : %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.CreateShare(ctx, private.ID, image.NewShare{Rendition: "huge"}, ownerID, now); !errors.Is(err, image.ErrUnknownPreset) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to share an unknown rendition : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to share beyond the limits.", dbtest.Success, testID)
+
+			for _, id := range ids {
+				if err := core.Delete(ctx, id, ownerID, now); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+				}
+			}
+		}
+
+		testID = 13
+		t.Logf("\tTest %d:\tWhen storing Images against quotas.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.May, 7, 0, 0, 0, 0, time.UTC)
+
+			ownerID := "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+			before, err := core.QueryUsage(ctx, ownerID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query usage : %s.", dbtest.Failed, testID, err)
+			}
+			if before.User.MaxBytes != 0 || before.User.MaxImages != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould have no limits by default : %+v.", dbtest.Failed, testID, before.User)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to query usage.", dbtest.Success, testID)
+
+			maxImages := before.User.Images + 1
+			if _, err := core.UpdateQuota(ctx, image.ScopeUser, ownerID, image.UpdateQuota{MaxImages: &maxImages}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set a quota : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.UpdateQuota(ctx, "team", ownerID, image.UpdateQuota{}, now); !errors.Is(err, image.ErrInvalidScope) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to set a quota for an unknown scope : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to set a quota.", dbtest.Success, testID)
+
+			img, err := core.Create(ctx, image.NewImage{UserID: ownerID}, bytes.NewReader(dbtest.PNGContent(t, 21, 10)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a image within the quota : %s.", dbtest.Failed, testID, err)
+			}
+
+			usage, err := core.QueryUsage(ctx, ownerID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query usage : %s.", dbtest.Failed, testID, err)
+			}
+			if usage.User.Images != maxImages || usage.User.Bytes != before.User.Bytes+img.Size || usage.User.MaxImages != maxImages {
+				t.Fatalf("\t%s\tTest %d:\tShould count the image against the quota : %+v.", dbtest.Failed, testID, usage.User)
+			}
+			t.Logf("\t%s\tTest %d:\tShould count the image against the quota.", dbtest.Success, testID)
+
+			_, err = core.Create(ctx, image.NewImage{UserID: ownerID}, bytes.NewReader(dbtest.PNGContent(t, 22, 10)), now)
+			var qe *image.QuotaError
+			if !errors.As(err, &qe) || !errors.Is(err, image.ErrOverQuota) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a image over the quota : %v.", dbtest.Failed, testID, err)
+			}
+			if qe.Scope != image.ScopeUser || qe.Resource != image.ResourceImages || qe.Limit != int64(maxImages) {
+				t.Fatalf("\t%s\tTest %d:\tShould describe the quota exceeded : %+v.", dbtest.Failed, testID, qe)
+			}
+			if after, err := core.QueryUsage(ctx, ownerID); err != nil || after.User != usage.User {
+				t.Fatalf("\t%s\tTest %d:\tShould leave the usage as it was : %+v : %v.", dbtest.Failed, testID, after.User, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a image over the quota.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, ownerID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+			if after, err := core.QueryUsage(ctx, ownerID); err != nil || after.User.Images != before.User.Images || after.User.Bytes != before.User.Bytes {
+				t.Fatalf("\t%s\tTest %d:\tShould release the usage of a deleted image : %+v : %v.", dbtest.Failed, testID, after.User, err)
+			}
+			if _, err := core.Restore(ctx, img.ID, image.RestoreImage{}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to restore the image : %s.", dbtest.Failed, testID, err)
+			}
+			if after, err := core.QueryUsage(ctx, ownerID); err != nil || after.User != usage.User {
+				t.Fatalf("\t%s\tTest %d:\tShould count a restored image again : %+v : %v.", dbtest.Failed, testID, after.User, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould release and count usage as images are deleted and restored.", dbtest.Success, testID)
+
+			noLimit := 0
+			if _, err := core.UpdateQuota(ctx, image.ScopeUser, ownerID, image.UpdateQuota{MaxBytes: &usage.User.Bytes, MaxImages: &noLimit}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to set a quota : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.Replace(ctx, img.ID, bytes.NewReader(dbtest.PNGContent(t, 80, 80)), nil, ownerID, now); !errors.As(err, &qe) || qe.Resource != image.ResourceBytes {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to replace content with larger content over the quota : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to replace content with larger content over the quota.", dbtest.Success, testID)
+
+			if err := core.RecomputeUsage(ctx, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to recompute usage : %s.", dbtest.Failed, testID, err)
+			}
+			if after, err := core.QueryUsage(ctx, ownerID); err != nil || after.User.Images != usage.User.Images || after.User.Bytes != usage.User.Bytes {
+				t.Fatalf("\t%s\tTest %d:\tShould recompute the same usage : %+v : %v.", dbtest.Failed, testID, after.User, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould recompute the same usage.", dbtest.Success, testID)
+
+			if _, err := core.UpdateQuota(ctx, image.ScopeUser, ownerID, image.UpdateQuota{}, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reset the quota : %s.", dbtest.Failed, testID, err)
+			}
+			if err := core.Delete(ctx, img.ID, ownerID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+		}
+
+		testID = 14
+		t.Logf("\tTest %d:\tWhen ingesting an Image from a URL.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.May, 8, 0, 0, 0, 0, time.UTC)
+
+			content := dbtest.PNGContent(t, 26, 14)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/harbour.png":
+					w.Header().Set("Content-Type", "image/png")
+					w.Write(content)
+				default:
+					w.Header().Set("Content-Type", "text/plain")
+					w.Write([]byte("not an image"))
+				}
+			}))
+			defer srv.Close()
+
+			ni := image.NewImage{
+				UserID:   "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+				ImageURL: srv.URL + "/harbour.png",
+			}
+
+			if _, err := core.CreateFromURL(ctx, ni, now); !errors.Is(err, image.ErrFetchDisabled) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to ingest from a URL without a fetcher : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to ingest from a URL without a fetcher.", dbtest.Success, testID)
+
+			public := image.NewCore(log, db, blobs, image.WithPresets(nil), image.WithFetcher(fetch.New()))
+			if _, err := public.CreateFromURL(ctx, ni, now); !errors.Is(err, fetch.ErrBlockedAddress) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to ingest from a loopback address : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to ingest from a loopback address.", dbtest.Success, testID)
+
+			_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+			remote := image.NewCore(log, db, blobs, image.WithPresets(nil), image.WithFetcher(fetch.New(fetch.WithAllowedNetworks([]*net.IPNet{loopback}))))
+
+			img, err := remote.CreateFromURL(ctx, ni, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to ingest from a URL : %s.", dbtest.Failed, testID, err)
+			}
+			if img.Size != int64(len(content)) || img.MimeType != "image/png" {
+				t.Fatalf("\t%s\tTest %d:\tShould store the downloaded content : %+v.", dbtest.Failed, testID, img)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to ingest from a URL.", dbtest.Success, testID)
+
+			ni.ImageURL = srv.URL + "/notes.txt"
+			if _, err := remote.CreateFromURL(ctx, ni, now); !errors.Is(err, fetch.ErrUnsupportedType) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to ingest content that isn't an image : %v.", dbtest.Failed, testID, err)
+			}
+			ni.ImageURL = ""
+			if _, err := remote.CreateFromURL(ctx, ni, now); !errors.Is(err, image.ErrMissingURL) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to ingest without a URL : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to ingest content that isn't an image.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, img.ID, ni.UserID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete image : %s.", dbtest.Failed, testID, err)
+			}
+		}
+	}
+}
+
+// strPtr returns a pointer to the string.
+func strPtr(s string) *string {
+	return &s
+}
+
+// exists reports whether the blob store holds content for the key.
+func exists(blobs *blob.Memory, key string) bool {
+	obj, err := blobs.Open(context.Background(), key)
+	if err != nil {
+		return false
 	}
+	obj.Close()
+	return true
 }
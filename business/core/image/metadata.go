@@ -0,0 +1,227 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/foundation/exif"
+	"github.com/fadhilijuma/images/foundation/imaging"
+	"github.com/fadhilijuma/images/foundation/iptc"
+	"github.com/fadhilijuma/images/foundation/web"
+	"github.com/lib/pq"
+)
+
+// readMetadata fills in the technical metadata of an image from its content.
+// Metadata is best effort, so content that can't be read is logged and the
+// image is stored without it.
+func (c Core) readMetadata(ctx context.Context, key string, dbImg *db.Image) {
+	dbImg.Orientation = 1
+	dbImg.EXIF = []byte("{}")
+	dbImg.Keywords = pq.StringArray{}
+
+	obj, err := c.blobs.Open(ctx, key)
+	if err != nil {
+		c.log.Errorw("read metadata", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		return
+	}
+	defer obj.Close()
+
+	cfg, err := imaging.DecodeConfig(obj)
+	switch {
+	case err == nil:
+		dbImg.Width = cfg.Width
+		dbImg.Height = cfg.Height
+		dbImg.Format = cfg.Format
+		dbImg.ColorModel = cfg.ColorModel
+	case !errors.Is(err, imaging.ErrUnsupportedFormat):
+		c.log.Errorw("read metadata", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+	}
+
+	// Only JPEGs carry EXIF, IPTC and XMP in a form the readers understand.
+	if dbImg.Format != imaging.FormatJPEG {
+		return
+	}
+
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		c.log.Errorw("read metadata", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		return
+	}
+
+	x, err := exif.Decode(obj)
+	switch {
+	case err == nil:
+		applyExif(x, dbImg)
+	case !errors.Is(err, exif.ErrNotFound):
+		c.log.Errorw("read exif", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+	}
+
+	if _, err := obj.Seek(0, io.SeekStart); err != nil {
+		c.log.Errorw("read metadata", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		return
+	}
+
+	md, err := iptc.Decode(obj)
+	if err != nil {
+		c.log.Errorw("read editorial", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		return
+	}
+
+	applyEditorial(md, dbImg)
+}
+
+// applyExif copies the EXIF fields the images table has columns for.
+func applyExif(x exif.Exif, dbImg *db.Image) {
+	if data, err := json.Marshal(x); err == nil {
+		dbImg.EXIF = data
+	}
+
+	dbImg.Orientation = x.Orientation()
+	dbImg.CameraMake, _ = x.String("Make")
+	dbImg.CameraModel, _ = x.String("Model")
+
+	if t, ok := x.DateTimeOriginal(); ok {
+		t = t.UTC()
+		dbImg.DateCaptured = &t
+	}
+
+	if r, ok := x.Rational("ExposureTime"); ok {
+		dbImg.ExposureTime = r.String()
+	}
+
+	if f, ok := x.Float("FNumber"); ok {
+		dbImg.FNumber = &f
+	}
+
+	if n, ok := x.Int("ISOSpeedRatings"); ok {
+		iso := int(n)
+		dbImg.ISO = &iso
+	}
+
+	if f, ok := x.Float("FocalLength"); ok {
+		dbImg.FocalLength = &f
+	}
+
+	if lat, long, ok := x.LatLong(); ok {
+		dbImg.GPSLatitude = &lat
+		dbImg.GPSLongitude = &long
+
+		if alt, ok := x.Altitude(); ok {
+			dbImg.GPSAltitude = &alt
+		}
+	}
+}
+
+// applyEditorial copies the editorial metadata read from the content.
+func applyEditorial(md iptc.Metadata, dbImg *db.Image) {
+	dbImg.Caption = md.Caption
+	dbImg.Headline = md.Headline
+	dbImg.Byline = md.Byline
+	dbImg.Credit = md.Credit
+	dbImg.Copyright = md.Copyright
+	dbImg.City = md.City
+	dbImg.Country = md.Country
+	dbImg.Instructions = md.Instructions
+	dbImg.UsageTerms = md.UsageTerms
+
+	dbImg.Keywords = append(pq.StringArray{}, md.Keywords...)
+}
+
+// applyEditorialUpdate copies the editorial fields that were provided.
+func applyEditorialUpdate(ue UpdateEditorialMetadata, dbImg *db.Image) {
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&dbImg.Caption, ue.Caption},
+		{&dbImg.Headline, ue.Headline},
+		{&dbImg.Byline, ue.Byline},
+		{&dbImg.Credit, ue.Credit},
+		{&dbImg.Copyright, ue.Copyright},
+		{&dbImg.City, ue.City},
+		{&dbImg.Country, ue.Country},
+		{&dbImg.Instructions, ue.Instructions},
+		{&dbImg.UsageTerms, ue.UsageTerms},
+	} {
+		if f.src != nil {
+			*f.dst = strings.TrimSpace(*f.src)
+		}
+	}
+
+	if ue.Language != nil {
+		dbImg.Language = *ue.Language
+	}
+
+	if ue.Keywords != nil {
+		dbImg.Keywords = pq.StringArray{}
+		for _, kw := range *ue.Keywords {
+			if kw = strings.TrimSpace(kw); kw != "" {
+				dbImg.Keywords = append(dbImg.Keywords, kw)
+			}
+		}
+	}
+}
+
+// setEditorial replaces every editorial field with those of the metadata.
+func setEditorial(em EditorialMetadata, dbImg *db.Image) {
+	keywords := em.Keywords
+	ue := UpdateEditorialMetadata{
+		Caption:      &em.Caption,
+		Headline:     &em.Headline,
+		Byline:       &em.Byline,
+		Credit:       &em.Credit,
+		Copyright:    &em.Copyright,
+		Keywords:     &keywords,
+		City:         &em.City,
+		Country:      &em.Country,
+		Instructions: &em.Instructions,
+		UsageTerms:   &em.UsageTerms,
+	}
+	if em.Language != "" {
+		ue.Language = &em.Language
+	}
+
+	applyEditorialUpdate(ue, dbImg)
+}
+
+// embedEditorial returns the content with the editorial metadata of the
+// image written into it. Only JPEG content is changed, and only when the core
+// was configured to embed the metadata. Content that can't be rewritten is
+// logged and served as it is.
+func (c Core) embedEditorial(ctx context.Context, dbImg db.Image, mimeType string, content io.ReadSeekCloser) io.ReadSeekCloser {
+	if !c.embed || mimeType != imaging.MimeType(imaging.FormatJPEG) {
+		return content
+	}
+
+	md := iptc.Metadata{
+		Caption:      dbImg.Caption,
+		Headline:     dbImg.Headline,
+		Byline:       dbImg.Byline,
+		Credit:       dbImg.Credit,
+		Copyright:    dbImg.Copyright,
+		Keywords:     dbImg.Keywords,
+		City:         dbImg.City,
+		Country:      dbImg.Country,
+		Instructions: dbImg.Instructions,
+		UsageTerms:   dbImg.UsageTerms,
+	}
+
+	var buf bytes.Buffer
+	err := iptc.Embed(&buf, content, md)
+	if err == nil {
+		content.Close()
+		return readSeekNopCloser{bytes.NewReader(buf.Bytes())}
+	}
+
+	c.log.Errorw("embed editorial", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		c.log.Errorw("embed editorial", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+	}
+
+	return content
+}
@@ -1,22 +1,101 @@
 package image
 
 import (
-	"github.com/fadhilijuma/images/business/core/image/db"
+	"encoding/json"
+	"fmt"
+	"io"
 	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
 )
 
 // Image represents an individual image.
 type Image struct {
 	ID           string    `json:"id"`            // Unique identifier.
-	ImageURL     string    `json:"image_url"`     // Display image url of the image.
 	UserID       string    `json:"user_id"`       // User who uploaded the image.
+	StorageKey   string    `json:"-"`             // Key of the image content in the blob store.
+	Checksum     string    `json:"checksum"`      // Hex encoded SHA-256 of the image content.
+	Size         int64     `json:"size"`          // Size of the image content in bytes.
+	MimeType     string    `json:"mime_type"`     // Sniffed MIME type of the image content.
 	DateUploaded time.Time `json:"date_uploaded"` // When the image was added.
+
+	// Technical metadata read from the content when it was uploaded or replaced.
+	Width        int             `json:"width"`                   // Width of the image in pixels.
+	Height       int             `json:"height"`                  // Height of the image in pixels.
+	Format       string          `json:"format"`                  // Decoded format of the image.
+	ColorModel   string          `json:"color_model"`             // Color model of the decoded image.
+	Orientation  int             `json:"orientation"`             // EXIF orientation, 1 when upright.
+	DateCaptured *time.Time      `json:"date_captured,omitempty"` // When the image was captured.
+	CameraMake   string          `json:"camera_make,omitempty"`   // Make of the camera.
+	CameraModel  string          `json:"camera_model,omitempty"`  // Model of the camera.
+	ExposureTime string          `json:"exposure_time,omitempty"` // Exposure time in seconds, such as 1/250.
+	FNumber      *float64        `json:"f_number,omitempty"`      // Aperture as an f-number.
+	ISO          *int            `json:"iso,omitempty"`           // ISO speed.
+	FocalLength  *float64        `json:"focal_length,omitempty"`  // Focal length in millimeters.
+	GPSLatitude  *float64        `json:"gps_latitude,omitempty"`  // Latitude in decimal degrees.
+	GPSLongitude *float64        `json:"gps_longitude,omitempty"` // Longitude in decimal degrees.
+	GPSAltitude  *float64        `json:"gps_altitude,omitempty"`  // Altitude in meters.
+	EXIF         json.RawMessage `json:"exif"`                    // EXIF tags by name.
+	DHash        string          `json:"dhash,omitempty"`         // Hex encoded difference hash of the content.
+	PHash        string          `json:"phash,omitempty"`         // Hex encoded DCT hash of the content.
+	DeletedAt    *time.Time      `json:"deleted_at,omitempty"`    // When the image was moved to the trash.
+	DeletedBy    string          `json:"deleted_by,omitempty"`    // User who moved the image to the trash.
+
+	OrganizationID string `json:"organization_id,omitempty"` // Organization the image was uploaded in.
+	Visibility     string `json:"visibility"`                // Who may view the image.
+	Version        int    `json:"version"`                   // Incremented on every change to the image.
+
+	// Editorial metadata read from the IPTC and XMP in the content and
+	// edited since.
+	Editorial EditorialMetadata `json:"editorial"`
+}
+
+// VisibleTo reports whether the specified user, a member of the specified
+// organization, may view the image. The owner can always view it, members
+// of the organization it was uploaded in can once its visibility is
+// organization, and everyone can once it's public.
+func (i Image) VisibleTo(userID string, organizationID string) bool {
+	switch {
+	case i.UserID == userID, i.Visibility == VisibilityPublic:
+		return true
+	case i.Visibility == VisibilityOrganization:
+		return i.OrganizationID != "" && i.OrganizationID == organizationID
+	}
+	return false
+}
+
+// EditorialMetadata holds the IPTC fields editors work with.
+type EditorialMetadata struct {
+	Caption      string   `json:"caption"`      // Description of who, what and where.
+	Headline     string   `json:"headline"`     // Short synopsis of the caption.
+	Byline       string   `json:"byline"`       // Name of the photographer.
+	Credit       string   `json:"credit"`       // Credit line required when publishing.
+	Copyright    string   `json:"copyright"`    // Copyright notice.
+	Keywords     []string `json:"keywords"`     // Keywords describing the image.
+	City         string   `json:"city"`         // City where the image was taken.
+	Country      string   `json:"country"`      // Country where the image was taken.
+	Instructions string   `json:"instructions"` // Special instructions such as embargoes.
+	UsageTerms   string   `json:"usage_terms"`  // Terms the image may be used under.
+	Language     string   `json:"language"`     // Language the text is in, used to stem it for search.
+}
+
+// Content is the content of an image along with what's needed to serve it
+// with HTTP caching.
+type Content struct {
+	io.ReadSeekCloser
+	MimeType string    // MIME type of the content.
+	Checksum string    // Hex encoded SHA-256 of the content as served.
+	ModTime  time.Time // When the content was last modified.
 }
 
-// NewImage is what we require from clients when adding an image.
+// NewImage is what we require from clients when adding an image. The image
+// content itself is streamed separately, or downloaded from ImageURL when
+// the image is ingested from a URL.
 type NewImage struct {
-	ImageURL string `json:"image_url" validate:"required"`
-	UserID   string `json:"user_id" validate:"required"`
+	UserID         string `json:"user_id" validate:"required"`
+	OrganizationID string `json:"organization_id" validate:"omitempty,uuid4"`
+	Visibility     string `json:"visibility" validate:"omitempty,oneof=private organization public"`
+	ImageURL       string `json:"image_url" validate:"omitempty,url,max=2048"`
 }
 
 // UpdateImage defines what information may be provided to modify an
@@ -26,15 +105,270 @@ type NewImage struct {
 // explicitly blank. Normally we do not want to use pointers to basic types but
 // we make exceptions around marshalling/unmarshalling.
 type UpdateImage struct {
-	ImageURL *string `json:"image_url"`
-	UserID   *string `json:"user_id"`
+	UserID     *string                  `json:"user_id"`
+	Visibility *string                  `json:"visibility" validate:"omitempty,oneof=private organization public"`
+	Editorial  *UpdateEditorialMetadata `json:"editorial"`
+
+	// Version, when set, is the version of the image the change was made
+	// against. The change is refused if the image has changed since.
+	Version *int `json:"-"`
+}
+
+// UpdateEditorialMetadata defines the editorial fields that may be modified.
+// Like UpdateImage only the fields provided are changed.
+type UpdateEditorialMetadata struct {
+	Caption      *string   `json:"caption" validate:"omitempty,max=2000"`
+	Headline     *string   `json:"headline" validate:"omitempty,max=256"`
+	Byline       *string   `json:"byline" validate:"omitempty,max=256"`
+	Credit       *string   `json:"credit" validate:"omitempty,max=256"`
+	Copyright    *string   `json:"copyright" validate:"omitempty,max=256"`
+	Keywords     *[]string `json:"keywords" validate:"omitempty,max=64,dive,required,max=64"`
+	City         *string   `json:"city" validate:"omitempty,max=64"`
+	Country      *string   `json:"country" validate:"omitempty,max=64"`
+	Instructions *string   `json:"instructions" validate:"omitempty,max=256"`
+	UsageTerms   *string   `json:"usage_terms" validate:"omitempty,max=2000"`
+	Language     *string   `json:"language" validate:"omitempty,oneof=simple danish dutch english finnish french german hungarian italian norwegian portuguese romanian russian spanish swedish turkish"`
+}
+
+// QueryFilter holds the available fields a query of images can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	UserID         *string    `json:"user_id" validate:"omitempty,uuid4"`
+	MimeType       *string    `json:"mime_type" validate:"omitempty,min=1"`
+	UploadedAfter  *time.Time `json:"uploaded_after"`
+	UploadedBefore *time.Time `json:"uploaded_before"`
+	Format         *string    `json:"format" validate:"omitempty,oneof=jpeg png gif"`
+	CameraMake     *string    `json:"camera_make" validate:"omitempty,min=1"`
+	CameraModel    *string    `json:"camera_model" validate:"omitempty,min=1"`
+	MinWidth       *int       `json:"min_width" validate:"omitempty,gte=0"`
+	MinHeight      *int       `json:"min_height" validate:"omitempty,gte=0"`
+	CapturedAfter  *time.Time `json:"captured_after"`
+	CapturedBefore *time.Time `json:"captured_before"`
+	HasGPS         *bool      `json:"has_gps"`
+	Tags           []string   `json:"tags" validate:"omitempty,max=20"`
+	TagMatch       *string    `json:"tag_match" validate:"omitempty,oneof=all any"`
+
+	// Images are left out unless the viewer may see them.
+	ViewerID             *string `json:"-" validate:"omitempty,uuid4"`
+	ViewerOrganizationID *string `json:"-" validate:"omitempty,uuid4"`
+}
+
+// SearchQuery is what we require from clients when searching images. The
+// text is in the search syntax described by Search and the language picks
+// how its words are stemmed, defaulting to DefaultLanguage.
+type SearchQuery struct {
+	Text     string `json:"q" validate:"required,max=512"`
+	Language string `json:"lang" validate:"omitempty,oneof=simple danish dutch english finnish french german hungarian italian norwegian portuguese romanian russian spanish swedish turkish"`
+}
+
+// SearchResult represents an image matching a search along with how well it
+// matched.
+type SearchResult struct {
+	Image      Image      `json:"image"`
+	Rank       float64    `json:"rank"`       // Relevance between 0 and 1, higher is better.
+	Highlights Highlights `json:"highlights"` // Matching words wrapped in <mark> tags.
+}
+
+// Highlights holds the editorial text of a search result with the words that
+// matched the search highlighted.
+type Highlights struct {
+	Headline string `json:"headline"`
+	Caption  string `json:"caption"`
+}
+
+// SimilarQuery defines how images similar to another are found. Distance is
+// the largest Hamming distance between the hashes of similar images and
+// defaults to the configured maximum.
+type SimilarQuery struct {
+	Hash     string `json:"hash" validate:"omitempty,oneof=dhash phash"`
+	Distance *int   `json:"distance" validate:"omitempty,gte=0"`
+
+	// Images are left out unless the viewer may see them.
+	ViewerID             *string `json:"-" validate:"omitempty,uuid4"`
+	ViewerOrganizationID *string `json:"-" validate:"omitempty,uuid4"`
+}
+
+// SimilarImage represents an image that looks like another.
+type SimilarImage struct {
+	Image    Image `json:"image"`
+	Distance int   `json:"distance"` // Number of bits the hashes differ in.
+}
+
+// Revision represents the state of an image after one of its changes. The
+// first revision is the image as it was uploaded.
+type Revision struct {
+	ImageID      string            `json:"image_id"`                // ID of the image the revision is of.
+	Revision     int               `json:"revision"`                // Number of the revision, counting from 1.
+	UserID       string            `json:"user_id"`                 // User who owned the image.
+	StorageKey   string            `json:"-"`                       // Key of the image content in the blob store.
+	Checksum     string            `json:"checksum"`                // Hex encoded SHA-256 of the image content.
+	Size         int64             `json:"size"`                    // Size of the image content in bytes.
+	MimeType     string            `json:"mime_type"`               // Sniffed MIME type of the image content.
+	Editorial    EditorialMetadata `json:"editorial"`               // Editorial metadata of the image.
+	ActorID      string            `json:"actor_id"`                // User who made the change.
+	RevertedFrom *int              `json:"reverted_from,omitempty"` // Revision the change reverted to, if any.
+	DateCreated  time.Time         `json:"date_created"`            // When the change was made.
+}
+
+// RevisionDiff lists the fields that differ between two revisions of an
+// image.
+type RevisionDiff struct {
+	ImageID string   `json:"image_id"`
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Changes []Change `json:"changes"`
+}
+
+// Change is a field that differs between two revisions, with its values.
+type Change struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// TrashFilter holds the available fields a query of the trash can be
+// filtered on. Nil fields are not filtered on.
+type TrashFilter struct {
+	UserID *string `json:"user_id" validate:"omitempty,uuid4"`
+}
+
+// RestoreImage defines how an image is taken out of the trash. The image goes
+// back to the user who uploaded it unless another user is given, which is
+// needed once that user has been deleted.
+type RestoreImage struct {
+	UserID *string `json:"user_id" validate:"omitempty,uuid4"`
+}
+
+// DuplicatePolicy defines how a user's uploads of content they have already
+// uploaded are handled.
+type DuplicatePolicy struct {
+	UserID string `json:"user_id"`
+	Policy string `json:"policy" validate:"required,oneof=reject reference"`
+}
+
+// Preset describes a named rendition size. Images are scaled to fit inside
+// the width and height, keeping their aspect ratio.
+type Preset struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// Rendition represents a resized copy of an image.
+type Rendition struct {
+	ImageID        string    `json:"image_id"`     // ID of the image the rendition was made from.
+	Name           string    `json:"name"`         // Name of the preset used to make the rendition.
+	Width          int       `json:"width"`        // Width of the rendition in pixels.
+	Height         int       `json:"height"`       // Height of the rendition in pixels.
+	StorageKey     string    `json:"-"`            // Key of the rendition content in the blob store.
+	Size           int64     `json:"size"`         // Size of the rendition content in bytes.
+	MimeType       string    `json:"mime_type"`    // MIME type of the rendition content.
+	SourceChecksum string    `json:"-"`            // Checksum of the image content the rendition was made from.
+	DateCreated    time.Time `json:"date_created"` // When the rendition was made.
+}
+
+// Transform describes how to transform an image on the fly. A zero width or
+// height leaves that side free to follow the aspect ratio.
+type Transform struct {
+	Width   int    `json:"w" validate:"gte=0"`
+	Height  int    `json:"h" validate:"gte=0"`
+	Fit     string `json:"fit" validate:"omitempty,oneof=contain cover"`
+	Format  string `json:"format" validate:"omitempty,oneof=jpeg png gif"`
+	Quality int    `json:"q" validate:"gte=0,lte=100"`
+}
+
+// Tag represents a normalized tag that can be attached to images.
+type Tag struct {
+	ID          string    `json:"id"`           // Unique identifier.
+	Name        string    `json:"name"`         // Normalized name of the tag.
+	Count       int       `json:"count"`        // Number of images the tag is attached to.
+	DateCreated time.Time `json:"date_created"` // When the tag was first used.
+}
+
+// NewTags is what we require from clients when tagging an image.
+type NewTags struct {
+	Tags []string `json:"tags" validate:"required,min=1,max=64"`
+}
+
+// RenameTag is what we require from clients when renaming a tag.
+type RenameTag struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// MergeTags is what we require from clients when merging tags. The images of
+// every tag are moved to the tag named by Into, which is created if needed.
+type MergeTags struct {
+	Tags []string `json:"tags" validate:"required,min=1"`
+	Into string   `json:"into" validate:"required"`
 }
 
 // =============================================================================
 
-func toImage(dbPrd db.Image) Image {
-	pu := (*Image)(&dbPrd)
-	return *pu
+func toImage(dbImg db.Image) Image {
+	return Image{
+		ID:           dbImg.ID,
+		UserID:       dbImg.UserID,
+		StorageKey:   dbImg.StorageKey,
+		Checksum:     dbImg.Checksum,
+		Size:         dbImg.Size,
+		MimeType:     dbImg.MimeType,
+		DateUploaded: dbImg.DateUploaded,
+		Width:        dbImg.Width,
+		Height:       dbImg.Height,
+		Format:       dbImg.Format,
+		ColorModel:   dbImg.ColorModel,
+		Orientation:  dbImg.Orientation,
+		DateCaptured: dbImg.DateCaptured,
+		CameraMake:   dbImg.CameraMake,
+		CameraModel:  dbImg.CameraModel,
+		ExposureTime: dbImg.ExposureTime,
+		FNumber:      dbImg.FNumber,
+		ISO:          dbImg.ISO,
+		FocalLength:  dbImg.FocalLength,
+		GPSLatitude:  dbImg.GPSLatitude,
+		GPSLongitude: dbImg.GPSLongitude,
+		GPSAltitude:  dbImg.GPSAltitude,
+		EXIF:         json.RawMessage(dbImg.EXIF),
+		DHash:        hashString(dbImg.DHash),
+		PHash:        hashString(dbImg.PHash),
+		DeletedAt:    dbImg.DeletedAt,
+		DeletedBy:    stringValue(dbImg.DeletedBy),
+
+		OrganizationID: stringValue(dbImg.OrganizationID),
+		Visibility:     dbImg.Visibility,
+		Version:        dbImg.Version,
+
+		Editorial: EditorialMetadata{
+			Caption:      dbImg.Caption,
+			Headline:     dbImg.Headline,
+			Byline:       dbImg.Byline,
+			Credit:       dbImg.Credit,
+			Copyright:    dbImg.Copyright,
+			Keywords:     []string(dbImg.Keywords),
+			City:         dbImg.City,
+			Country:      dbImg.Country,
+			Instructions: dbImg.Instructions,
+			UsageTerms:   dbImg.UsageTerms,
+			Language:     dbImg.Language,
+		},
+	}
+}
+
+// hashString returns the hash as 16 hex digits, or an empty string when the
+// image has no hash.
+func hashString(hash *int64) string {
+	if hash == nil {
+		return ""
+	}
+	return fmt.Sprintf("%016x", uint64(*hash))
+}
+
+// stringValue returns the string, or an empty string when there's none.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 func toImageSlice(dbImages []db.Image) []Image {
@@ -44,3 +378,130 @@ func toImageSlice(dbImages []db.Image) []Image {
 	}
 	return images
 }
+
+func toRevision(dbRev db.Revision) Revision {
+	rev := Revision{
+		ImageID:      dbRev.ImageID,
+		Revision:     dbRev.Revision,
+		UserID:       dbRev.UserID,
+		StorageKey:   dbRev.StorageKey,
+		Checksum:     dbRev.Checksum,
+		Size:         dbRev.Size,
+		MimeType:     dbRev.MimeType,
+		ActorID:      dbRev.ActorID,
+		RevertedFrom: dbRev.RevertedFrom,
+		DateCreated:  dbRev.DateCreated,
+	}
+
+	// The editorial is written by this package from EditorialMetadata.
+	_ = json.Unmarshal(dbRev.Editorial, &rev.Editorial)
+
+	return rev
+}
+
+func toRevisionSlice(dbRevs []db.Revision) []Revision {
+	revs := make([]Revision, len(dbRevs))
+	for i, dbRev := range dbRevs {
+		revs[i] = toRevision(dbRev)
+	}
+	return revs
+}
+
+func toSearchResult(dbResult db.SearchResult) SearchResult {
+	return SearchResult{
+		Image: toImage(dbResult.Image),
+		Rank:  dbResult.Rank,
+		Highlights: Highlights{
+			Headline: dbResult.HeadlineSnippet,
+			Caption:  dbResult.CaptionSnippet,
+		},
+	}
+}
+
+func toSearchResultSlice(dbResults []db.SearchResult) []SearchResult {
+	results := make([]SearchResult, len(dbResults))
+	for i, dbResult := range dbResults {
+		results[i] = toSearchResult(dbResult)
+	}
+	return results
+}
+
+func toDuplicatePolicy(dbDP db.DuplicatePolicy) DuplicatePolicy {
+	pdp := (*DuplicatePolicy)(&dbDP)
+	return *pdp
+}
+
+func toRendition(dbRnd db.Rendition) Rendition {
+	prnd := (*Rendition)(&dbRnd)
+	return *prnd
+}
+
+func toRenditionSlice(dbRnds []db.Rendition) []Rendition {
+	rnds := make([]Rendition, len(dbRnds))
+	for i, dbRnd := range dbRnds {
+		rnds[i] = toRendition(dbRnd)
+	}
+	return rnds
+}
+
+func toTag(dbTag db.Tag) Tag {
+	ptag := (*Tag)(&dbTag)
+	return *ptag
+}
+
+func toTagSlice(dbTags []db.Tag) []Tag {
+	tags := make([]Tag, len(dbTags))
+	for i, dbTag := range dbTags {
+		tags[i] = toTag(dbTag)
+	}
+	return tags
+}
+
+// Share represents a link through which an image, or one of its renditions,
+// can be downloaded without signing in.
+type Share struct {
+	ID           string     `json:"id"`                      // Unique identifier.
+	ImageID      string     `json:"image_id"`                // ID of the image that's shared.
+	UserID       string     `json:"user_id"`                 // User who shared the image.
+	Rendition    string     `json:"rendition,omitempty"`     // Name of the rendition shared, or empty for the original.
+	Token        string     `json:"token"`                   // Signed token the share is used through.
+	MaxDownloads *int       `json:"max_downloads,omitempty"` // Number of downloads allowed, or nil for no limit.
+	Downloads    int        `json:"downloads"`               // Number of downloads made.
+	ExpiresAt    time.Time  `json:"expires_at"`              // When the share stops working.
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`    // When the share was revoked.
+	DateCreated  time.Time  `json:"date_created"`            // When the share was created.
+}
+
+// NewShare is what we require from clients when sharing an image. ExpiresIn
+// is the number of seconds the share lasts, defaulting to the configured TTL.
+// Rendition names the preset shared instead of the original content.
+type NewShare struct {
+	Rendition    string `json:"rendition" validate:"omitempty,max=64"`
+	ExpiresIn    *int   `json:"expires_in" validate:"omitempty,gt=0"`
+	MaxDownloads *int   `json:"max_downloads" validate:"omitempty,gt=0"`
+}
+
+// Usage represents what a user or organization stores against its quota. A
+// limit of 0 means there's none.
+type Usage struct {
+	Scope     string `json:"scope"`
+	SubjectID string `json:"subject_id"`
+	Bytes     int64  `json:"bytes"`
+	Images    int    `json:"images"`
+	MaxBytes  int64  `json:"max_bytes"`
+	MaxImages int    `json:"max_images"`
+}
+
+// UserUsage represents what a user stores, along with what the organization
+// they belong to stores.
+type UserUsage struct {
+	User         Usage  `json:"user"`
+	Organization *Usage `json:"organization,omitempty"`
+}
+
+// UpdateQuota is what we require from admins when setting a quota. A limit
+// left out falls back to the configured default, while 0 lifts it.
+type UpdateQuota struct {
+	MaxBytes  *int64 `json:"max_bytes" validate:"omitempty,gte=0"`
+	MaxImages *int   `json:"max_images" validate:"omitempty,gte=0"`
+}
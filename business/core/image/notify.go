@@ -0,0 +1,104 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/foundation/web"
+	"github.com/jmoiron/sqlx"
+)
+
+// Set of events reported about images.
+const (
+	EventCreated   = "image.created"
+	EventUpdated   = "image.updated"
+	EventDeleted   = "image.deleted"
+	EventPublished = "image.published"
+)
+
+// Notifier declares the behavior required to tell others about changes to
+// images, such as the webhooks subscribed to them. The user is the owner of
+// the image.
+type Notifier interface {
+	Notify(ctx context.Context, event string, userID string, data any, now time.Time) error
+}
+
+// WithNotifier sets who is told about changes to images.
+func WithNotifier(notifier Notifier) func(opts *Options) {
+	return func(opts *Options) {
+		opts.notifier = notifier
+	}
+}
+
+// record appends a change to an image to the audit log and its events to the
+// outbox, as part of the transaction making the change. The image before the
+// change is nil when it was created. It's the last thing the transaction
+// does, since the audit log and the outbox hold a lock until the commit.
+func (c Core) record(ctx context.Context, tx sqlx.ExtContext, event string, before *db.Image, dbImg db.Image, now time.Time) error {
+	img := toImage(dbImg)
+
+	entry := audit.NewEntry{
+		Action:   audit.ActionUpdate,
+		Entity:   audit.EntityImage,
+		EntityID: dbImg.ID,
+		After:    img,
+	}
+	switch event {
+	case EventCreated:
+		entry.Action = audit.ActionCreate
+	case EventDeleted:
+		entry.Action = audit.ActionDelete
+		entry.After = nil
+	}
+	if before != nil {
+		entry.Before = toImage(*before)
+	}
+
+	if err := c.audit.Record(ctx, tx, entry, now); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	wasPublic := before != nil && before.Visibility == VisibilityPublic
+
+	store := c.outbox.Tran(tx)
+	for _, event := range events(event, dbImg, wasPublic) {
+		ne := outbox.NewEvent{
+			Event:  event,
+			UserID: dbImg.UserID,
+			Data:   img,
+		}
+		if err := store.Append(ctx, ne, now); err != nil {
+			return fmt.Errorf("append event[%s]: %w", event, err)
+		}
+	}
+
+	return nil
+}
+
+// notify tells the notifier about a change to an image once it's committed.
+// The change has already been made, so failing to report it is only logged.
+func (c Core) notify(ctx context.Context, event string, dbImg db.Image, wasPublic bool, now time.Time) {
+	if c.notifier == nil {
+		return
+	}
+
+	img := toImage(dbImg)
+	for _, event := range events(event, dbImg, wasPublic) {
+		if err := c.notifier.Notify(ctx, event, dbImg.UserID, img, now); err != nil {
+			c.log.Errorw("notify", "traceid", web.GetTraceID(ctx), "event", event, "imageID", dbImg.ID, "ERROR", err)
+		}
+	}
+}
+
+// events lists the events a change to an image is reported as. An image
+// that became public is reported as published as well.
+func events(event string, dbImg db.Image, wasPublic bool) []string {
+	if event != EventDeleted && !wasPublic && dbImg.Visibility == VisibilityPublic {
+		return []string{event, EventPublished}
+	}
+	return []string{event}
+}
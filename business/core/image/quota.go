@@ -0,0 +1,262 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+)
+
+// Set of error variables for quotas.
+var (
+	ErrOverQuota    = errors.New("storage quota exceeded")
+	ErrInvalidScope = errors.New("quota scope is not valid")
+)
+
+// Set of scopes quotas are kept for.
+const (
+	ScopeUser         = "user"
+	ScopeOrganization = "organization"
+)
+
+// Set of resources quotas limit.
+const (
+	ResourceBytes  = "bytes"
+	ResourceImages = "images"
+)
+
+// QuotaError is returned when storing an image would take a user or their
+// organization past one of its limits.
+type QuotaError struct {
+	Scope     string
+	SubjectID string
+	Resource  string
+	Limit     int64
+	Used      int64 // What would be stored with the image.
+}
+
+// Error implements the error interface.
+func (qe *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s[%s] would store %d of %d %s", ErrOverQuota, qe.Scope, qe.SubjectID, qe.Used, qe.Limit, qe.Resource)
+}
+
+// Is reports the error as an ErrOverQuota.
+func (qe *QuotaError) Is(target error) bool {
+	return target == ErrOverQuota
+}
+
+// Quotas configures the limits users and organizations get when no quota has
+// been set for them. A limit of 0 means there's none.
+type Quotas struct {
+	UserMaxBytes          int64
+	UserMaxImages         int
+	OrganizationMaxBytes  int64
+	OrganizationMaxImages int
+}
+
+// DefaultQuotas is used when the core isn't configured otherwise. It sets no
+// limits.
+var DefaultQuotas = Quotas{}
+
+// WithQuotas sets the limits used when no quota has been set.
+func WithQuotas(quotas Quotas) func(opts *Options) {
+	return func(opts *Options) {
+		opts.quotas = quotas
+	}
+}
+
+// QueryUsage gets what the user identified by a given ID stores, and what
+// the organization they belong to stores, along with their limits.
+func (c Core) QueryUsage(ctx context.Context, userID string) (UserUsage, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return UserUsage{}, ErrInvalidID
+	}
+
+	orgID, err := c.store.QueryOwnerOrganization(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return UserUsage{}, ErrOwnerNotFound
+		}
+		return UserUsage{}, fmt.Errorf("query organization: %w", err)
+	}
+
+	var uu UserUsage
+	if uu.User, err = c.usage(ctx, c.store, ScopeUser, userID); err != nil {
+		return UserUsage{}, err
+	}
+
+	if orgID != nil {
+		usage, err := c.usage(ctx, c.store, ScopeOrganization, *orgID)
+		if err != nil {
+			return UserUsage{}, err
+		}
+		uu.Organization = &usage
+	}
+
+	return uu, nil
+}
+
+// UpdateQuota sets the limits of the user or organization identified by a
+// given ID, replacing any set before, and returns its usage against them.
+func (c Core) UpdateQuota(ctx context.Context, scope string, subjectID string, uq UpdateQuota, now time.Time) (Usage, error) {
+	if scope != ScopeUser && scope != ScopeOrganization {
+		return Usage{}, ErrInvalidScope
+	}
+
+	if err := validate.CheckID(subjectID); err != nil {
+		return Usage{}, ErrInvalidID
+	}
+
+	if err := validate.Check(uq); err != nil {
+		return Usage{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	dbQuota := db.Quota{
+		Scope:       scope,
+		SubjectID:   subjectID,
+		MaxBytes:    uq.MaxBytes,
+		MaxImages:   uq.MaxImages,
+		DateUpdated: now,
+	}
+
+	if err := c.store.UpsertQuota(ctx, dbQuota); err != nil {
+		return Usage{}, fmt.Errorf("upsert quota: %w", err)
+	}
+
+	return c.usage(ctx, c.store, scope, subjectID)
+}
+
+// RecomputeUsage counts what every user and organization stores from scratch,
+// replacing the running totals. It's for repairing the totals after images
+// were changed outside of the core.
+func (c Core) RecomputeUsage(ctx context.Context, now time.Time) error {
+	tran := func(tx sqlx.ExtContext) error {
+		return c.store.Tran(tx).RecomputeUsage(ctx, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseUsage stops the images of the user identified by a given ID from
+// counting against quotas as part of the transaction deleting the user. It
+// takes them off the usage of the organizations they were uploaded under and
+// drops the user's own usage and quota. It's called before the images are
+// moved to the trash with TrashOwnedBy, which leaves quotas alone.
+func (c Core) ReleaseUsage(ctx context.Context, tx sqlx.ExtContext, userID string, now time.Time) error {
+	if err := c.store.Tran(tx).ReleaseUsage(ctx, userID, now); err != nil {
+		return fmt.Errorf("release: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// charge adds bytes and images, which may be negative, to what the user and
+// the organization, if any, store. When enforce is set, a *QuotaError is
+// returned for any limit the new totals pass, and the caller rolls back.
+func (c Core) charge(ctx context.Context, store db.Store, userID string, orgID *string, bytes int64, images int, enforce bool, now time.Time) error {
+	type subject struct {
+		scope string
+		id    string
+	}
+
+	subjects := []subject{{ScopeUser, userID}}
+	if orgID != nil {
+		subjects = append(subjects, subject{ScopeOrganization, *orgID})
+	}
+
+	for _, sub := range subjects {
+		dbUsage := db.Usage{
+			Scope:       sub.scope,
+			SubjectID:   sub.id,
+			Bytes:       bytes,
+			Images:      images,
+			DateUpdated: now,
+		}
+
+		total, err := store.AddUsage(ctx, dbUsage)
+		if err != nil {
+			return fmt.Errorf("add usage: %w", err)
+		}
+
+		// Releasing space is always allowed, even for a subject that's over
+		// a limit lowered since the images were stored.
+		if !enforce || (bytes <= 0 && images <= 0) {
+			continue
+		}
+
+		maxBytes, maxImages, err := c.quotaLimits(ctx, store, sub.scope, sub.id)
+		if err != nil {
+			return err
+		}
+
+		if maxBytes > 0 && bytes > 0 && total.Bytes > maxBytes {
+			return &QuotaError{Scope: sub.scope, SubjectID: sub.id, Resource: ResourceBytes, Limit: maxBytes, Used: total.Bytes}
+		}
+		if maxImages > 0 && images > 0 && total.Images > maxImages {
+			return &QuotaError{Scope: sub.scope, SubjectID: sub.id, Resource: ResourceImages, Limit: int64(maxImages), Used: int64(total.Images)}
+		}
+	}
+
+	return nil
+}
+
+// quotaLimits returns the limits of the user or organization identified by a
+// given ID, falling back to the configured defaults.
+func (c Core) quotaLimits(ctx context.Context, store db.Store, scope string, subjectID string) (int64, int, error) {
+	maxBytes, maxImages := c.quotas.UserMaxBytes, c.quotas.UserMaxImages
+	if scope == ScopeOrganization {
+		maxBytes, maxImages = c.quotas.OrganizationMaxBytes, c.quotas.OrganizationMaxImages
+	}
+
+	dbQuota, err := store.QueryQuota(ctx, scope, subjectID)
+	switch {
+	case errors.Is(err, database.ErrDBNotFound):
+		return maxBytes, maxImages, nil
+	case err != nil:
+		return 0, 0, fmt.Errorf("query quota: %w", err)
+	}
+
+	if dbQuota.MaxBytes != nil {
+		maxBytes = *dbQuota.MaxBytes
+	}
+	if dbQuota.MaxImages != nil {
+		maxImages = *dbQuota.MaxImages
+	}
+
+	return maxBytes, maxImages, nil
+}
+
+// usage returns what the user or organization identified by a given ID
+// stores along with its limits.
+func (c Core) usage(ctx context.Context, store db.Store, scope string, subjectID string) (Usage, error) {
+	usage := Usage{
+		Scope:     scope,
+		SubjectID: subjectID,
+	}
+
+	dbUsage, err := store.QueryUsage(ctx, scope, subjectID)
+	switch {
+	case err == nil:
+		usage.Bytes = dbUsage.Bytes
+		usage.Images = dbUsage.Images
+	case !errors.Is(err, database.ErrDBNotFound):
+		return Usage{}, fmt.Errorf("query usage: %w", err)
+	}
+
+	if usage.MaxBytes, usage.MaxImages, err = c.quotaLimits(ctx, store, scope, subjectID); err != nil {
+		return Usage{}, err
+	}
+
+	return usage, nil
+}
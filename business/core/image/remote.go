@@ -0,0 +1,58 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// Set of error variables for ingesting images from a URL.
+var (
+	ErrMissingURL    = errors.New("image url is required")
+	ErrFetchDisabled = errors.New("ingesting images from a url is not configured")
+)
+
+// Fetcher declares the behavior required to download image content from a
+// remote URL. Implementations are responsible for refusing internal
+// addresses and for bounding the time and size of a download.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// WithFetcher sets how images are downloaded when they are ingested from a
+// URL.
+func WithFetcher(fetcher Fetcher) func(opts *Options) {
+	return func(opts *Options) {
+		opts.fetcher = fetcher
+	}
+}
+
+// CreateFromURL downloads the image at the URL in the NewImage and adds it
+// like Create does, streaming the content into the blob store as it arrives.
+// Errors from the fetcher are wrapped, so the caller can tell a remote that
+// couldn't be reached or wasn't allowed from content that was rejected.
+func (c Core) CreateFromURL(ctx context.Context, ni NewImage, now time.Time) (Image, error) {
+	if c.fetcher == nil {
+		return Image{}, ErrFetchDisabled
+	}
+
+	if err := validate.Check(ni); err != nil {
+		return Image{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if ni.ImageURL == "" {
+		return Image{}, ErrMissingURL
+	}
+
+	content, err := c.fetcher.Fetch(ctx, ni.ImageURL)
+	if err != nil {
+		return Image{}, fmt.Errorf("fetching url[%s]: %w", ni.ImageURL, err)
+	}
+	defer content.Close()
+
+	return c.Create(ctx, ni, content, now)
+}
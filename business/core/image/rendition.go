@@ -0,0 +1,255 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/imaging"
+)
+
+// Set of error variables for rendition operations.
+var (
+	ErrUnknownPreset = errors.New("rendition preset is not known")
+)
+
+// renditionQuality is the JPEG quality used when encoding renditions.
+const renditionQuality = 85
+
+// RenditionQueue is the job queue the renditions of new images are made from.
+const RenditionQueue = "renditions"
+
+// DefaultPresets are the rendition sizes used when none are configured.
+var DefaultPresets = []Preset{
+	{Name: "small", Width: 160, Height: 160},
+	{Name: "medium", Width: 640, Height: 640},
+	{Name: "large", Width: 1280, Height: 1280},
+}
+
+// ParsePresets parses a set of presets in the form name:WIDTHxHEIGHT, for
+// example "small:160x160".
+func ParsePresets(fields []string) ([]Preset, error) {
+	var presets []Preset
+	for _, field := range fields {
+		name, size, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("preset[%s] is not in the form name:WIDTHxHEIGHT", field)
+		}
+
+		w, h, ok := strings.Cut(size, "x")
+		if !ok {
+			return nil, fmt.Errorf("preset[%s] is not in the form name:WIDTHxHEIGHT", field)
+		}
+
+		width, err := strconv.Atoi(w)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("preset[%s] has an invalid width", field)
+		}
+
+		height, err := strconv.Atoi(h)
+		if err != nil || height <= 0 {
+			return nil, fmt.Errorf("preset[%s] has an invalid height", field)
+		}
+
+		presets = append(presets, Preset{Name: name, Width: width, Height: height})
+	}
+
+	return presets, nil
+}
+
+// QueryRenditions returns the renditions that have been made of an image.
+func (c Core) QueryRenditions(ctx context.Context, imageID string) ([]Rendition, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbRnds, err := c.store.QueryRenditions(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toRenditionSlice(dbRnds), nil
+}
+
+// OpenRendition returns the named rendition of an image along with a reader
+// for its content. A rendition that is missing, or was made from content the
+// image no longer has, is generated first. The caller must close the reader.
+func (c Core) OpenRendition(ctx context.Context, imageID string, name string, now time.Time) (Rendition, io.ReadSeekCloser, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Rendition{}, nil, ErrInvalidID
+	}
+
+	preset, exists := c.preset(name)
+	if !exists {
+		return Rendition{}, nil, ErrUnknownPreset
+	}
+
+	dbImg, err := c.store.QueryByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Rendition{}, nil, ErrNotFound
+		}
+		return Rendition{}, nil, fmt.Errorf("query: %w", err)
+	}
+
+	dbRnd, err := c.store.QueryRendition(ctx, imageID, name)
+	switch {
+	case err == nil:
+		if dbRnd.SourceChecksum == dbImg.Checksum {
+			obj, err := c.blobs.Open(ctx, dbRnd.StorageKey)
+			if err == nil {
+				return toRendition(dbRnd), c.embedEditorial(ctx, dbImg, dbRnd.MimeType, obj), nil
+			}
+			if !errors.Is(err, blob.ErrNotFound) {
+				return Rendition{}, nil, fmt.Errorf("opening rendition: %w", err)
+			}
+		}
+	case !errors.Is(err, database.ErrDBNotFound):
+		return Rendition{}, nil, fmt.Errorf("query rendition: %w", err)
+	}
+
+	rnds, err := c.generateRenditions(ctx, dbImg, []Preset{preset}, now)
+	if err != nil {
+		return Rendition{}, nil, fmt.Errorf("generate: %w", err)
+	}
+
+	obj, err := c.blobs.Open(ctx, rnds[0].StorageKey)
+	if err != nil {
+		return Rendition{}, nil, fmt.Errorf("opening rendition: %w", err)
+	}
+
+	return rnds[0], c.embedEditorial(ctx, dbImg, rnds[0].MimeType, obj), nil
+}
+
+// GenerateRenditions makes a rendition of an image for every configured
+// preset, replacing any renditions made before.
+func (c Core) GenerateRenditions(ctx context.Context, imageID string, now time.Time) ([]Rendition, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbImg, err := c.store.QueryByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	rnds, err := c.generateRenditions(ctx, dbImg, c.presets, now)
+	if err != nil {
+		return nil, fmt.Errorf("generate: %w", err)
+	}
+
+	return rnds, nil
+}
+
+// renditionJob is the payload of a job in the renditions queue.
+type renditionJob struct {
+	ImageID string `json:"image_id"`
+}
+
+// Render makes the renditions of the image a job in the renditions queue is
+// for, and is registered as the queue's handler. Images deleted since the job
+// was enqueued are skipped, and content that can't be decoded isn't retried.
+// Renditions can always be made later on demand, so a job that gives up
+// doesn't lose anything.
+func (c Core) Render(ctx context.Context, job jobs.Job) error {
+	var rj renditionJob
+	if err := json.Unmarshal(job.Payload, &rj); err != nil {
+		return jobs.Permanent(fmt.Errorf("decoding payload: %w", err))
+	}
+
+	_, err := c.GenerateRenditions(ctx, rj.ImageID, time.Now().UTC())
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil
+	case errors.Is(err, ErrInvalidID), errors.Is(err, ErrUnsupportedType):
+		return jobs.Permanent(err)
+	}
+
+	return err
+}
+
+// =============================================================================
+
+// preset finds a configured preset by name.
+func (c Core) preset(name string) (Preset, bool) {
+	for _, p := range c.presets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
+
+// generateRenditions decodes the image content once and stores a rendition
+// for each of the specified presets.
+func (c Core) generateRenditions(ctx context.Context, dbImg db.Image, presets []Preset, now time.Time) ([]Rendition, error) {
+	obj, err := c.blobs.Open(ctx, dbImg.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("opening content: %w", err)
+	}
+	defer obj.Close()
+
+	src, format, err := imaging.Decode(obj)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedFormat) {
+			return nil, ErrUnsupportedType
+		}
+		return nil, fmt.Errorf("decoding content: %w", err)
+	}
+
+	// Photos stay JPEG, everything else is made a PNG to keep transparency.
+	if format != imaging.FormatJPEG {
+		format = imaging.FormatPNG
+	}
+
+	rnds := make([]Rendition, len(presets))
+	for i, preset := range presets {
+		b := src.Bounds()
+		width, height := imaging.Fit(b.Dx(), b.Dy(), preset.Width, preset.Height)
+
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, imaging.Resize(src, width, height), format, renditionQuality); err != nil {
+			return nil, fmt.Errorf("encoding preset[%s]: %w", preset.Name, err)
+		}
+
+		key := "renditions/" + dbImg.ID + "/" + preset.Name
+		size, err := c.blobs.Put(ctx, key, &buf)
+		if err != nil {
+			return nil, fmt.Errorf("storing preset[%s]: %w", preset.Name, err)
+		}
+
+		dbRnd := db.Rendition{
+			ImageID:        dbImg.ID,
+			Name:           preset.Name,
+			Width:          width,
+			Height:         height,
+			StorageKey:     key,
+			Size:           size,
+			MimeType:       imaging.MimeType(format),
+			SourceChecksum: dbImg.Checksum,
+			DateCreated:    now,
+		}
+
+		if err := c.store.UpsertRendition(ctx, dbRnd); err != nil {
+			return nil, fmt.Errorf("upsert preset[%s]: %w", preset.Name, err)
+		}
+
+		rnds[i] = toRendition(dbRnd)
+	}
+
+	return rnds, nil
+}
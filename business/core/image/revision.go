@@ -0,0 +1,304 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+)
+
+// Set of error variables for image revisions.
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrInvalidRevision  = errors.New("revision is not valid")
+)
+
+// QueryRevisions finds the revisions of the image identified by a given ID,
+// latest first.
+func (c Core) QueryRevisions(ctx context.Context, imageID string) ([]Revision, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if _, err := c.store.QueryByID(ctx, imageID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	dbRevs, err := c.store.QueryRevisions(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("query revisions: %w", err)
+	}
+
+	return toRevisionSlice(dbRevs), nil
+}
+
+// DiffRevisions compares two revisions of the image identified by a given ID
+// and returns the fields that differ between them.
+func (c Core) DiffRevisions(ctx context.Context, imageID string, from int, to int) (RevisionDiff, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return RevisionDiff{}, ErrInvalidID
+	}
+
+	if from < 1 || to < 1 {
+		return RevisionDiff{}, ErrInvalidRevision
+	}
+
+	if _, err := c.store.QueryByID(ctx, imageID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return RevisionDiff{}, ErrNotFound
+		}
+		return RevisionDiff{}, fmt.Errorf("query: %w", err)
+	}
+
+	revs := make([]Revision, 2)
+	for i, revision := range []int{from, to} {
+		dbRev, err := c.store.QueryRevision(ctx, imageID, revision)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return RevisionDiff{}, fmt.Errorf("%w: %d", ErrRevisionNotFound, revision)
+			}
+			return RevisionDiff{}, fmt.Errorf("query revision: %w", err)
+		}
+		revs[i] = toRevision(dbRev)
+	}
+
+	diff := RevisionDiff{
+		ImageID: imageID,
+		From:    from,
+		To:      to,
+		Changes: []Change{},
+	}
+
+	for _, f := range revisionFields {
+		a, b := f.value(revs[0]), f.value(revs[1])
+		if !reflect.DeepEqual(a, b) {
+			diff.Changes = append(diff.Changes, Change{Field: f.name, From: a, To: b})
+		}
+	}
+
+	return diff, nil
+}
+
+// Revert returns the image identified by a given ID to the content and
+// editorial metadata it had at the specified revision, on behalf of the
+// specified user. The image keeps its owner. Reverting adds a new revision
+// rather than removing the ones after it, so a revert can be reverted too.
+// The content of earlier revisions is already stored, so reverting to larger
+// content is counted against the quota without being refused.
+func (c Core) Revert(ctx context.Context, imageID string, revision int, actorID string, now time.Time) (Image, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Image{}, ErrInvalidID
+	}
+
+	if err := validate.CheckID(actorID); err != nil {
+		return Image{}, ErrInvalidID
+	}
+
+	if revision < 1 {
+		return Image{}, ErrInvalidRevision
+	}
+
+	var dbImg db.Image
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		var err error
+		dbImg, err = c.lock(ctx, store, imageID)
+		if err != nil {
+			return err
+		}
+		before := dbImg
+		dbImg.Version++
+
+		dbRev, err := store.QueryRevision(ctx, imageID, revision)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrRevisionNotFound
+			}
+			return fmt.Errorf("query revision: %w", err)
+		}
+
+		// The revision holds a reference to its content, so the content is
+		// still stored even when no image uses it now.
+		if dbRev.StorageKey != dbImg.StorageKey {
+			if err := c.charge(ctx, store, dbImg.UserID, dbImg.OrganizationID, dbRev.Size-dbImg.Size, 0, false, now); err != nil {
+				return err
+			}
+
+			oldKey := dbImg.StorageKey
+			dbImg.StorageKey = dbRev.StorageKey
+			dbImg.Checksum = dbRev.Checksum
+			dbImg.Size = dbRev.Size
+			dbImg.MimeType = dbRev.MimeType
+			c.readContent(ctx, dbRev.StorageKey, &dbImg)
+
+			if _, err := store.AddBlobRef(ctx, dbImg.StorageKey); err != nil {
+				return fmt.Errorf("add blob ref: %w", err)
+			}
+
+			if err := c.swapContent(ctx, store, oldKey, dbImg); err != nil {
+				return err
+			}
+		}
+
+		setEditorial(toRevision(dbRev).Editorial, &dbImg)
+		if err := store.Update(ctx, dbImg); err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+
+		if _, err := c.createRevision(ctx, store, dbImg, actorID, &revision, now); err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, EventUpdated, &before, dbImg, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return Image{}, ErrNotFound
+		case errors.Is(err, ErrRevisionNotFound):
+			return Image{}, ErrRevisionNotFound
+		}
+		return Image{}, fmt.Errorf("tran: %w", err)
+	}
+	c.index.add(dbImg)
+	c.notify(ctx, EventUpdated, dbImg, true, now)
+
+	return toImage(dbImg), nil
+}
+
+// =============================================================================
+
+// revisionFields lists the fields of a revision that are compared, by the
+// name they're reported under.
+var revisionFields = []struct {
+	name  string
+	value func(rev Revision) any
+}{
+	{"user_id", func(rev Revision) any { return rev.UserID }},
+	{"checksum", func(rev Revision) any { return rev.Checksum }},
+	{"size", func(rev Revision) any { return rev.Size }},
+	{"mime_type", func(rev Revision) any { return rev.MimeType }},
+	{"editorial.caption", func(rev Revision) any { return rev.Editorial.Caption }},
+	{"editorial.headline", func(rev Revision) any { return rev.Editorial.Headline }},
+	{"editorial.byline", func(rev Revision) any { return rev.Editorial.Byline }},
+	{"editorial.credit", func(rev Revision) any { return rev.Editorial.Credit }},
+	{"editorial.copyright", func(rev Revision) any { return rev.Editorial.Copyright }},
+	{"editorial.keywords", func(rev Revision) any { return append([]string{}, rev.Editorial.Keywords...) }},
+	{"editorial.city", func(rev Revision) any { return rev.Editorial.City }},
+	{"editorial.country", func(rev Revision) any { return rev.Editorial.Country }},
+	{"editorial.instructions", func(rev Revision) any { return rev.Editorial.Instructions }},
+	{"editorial.usage_terms", func(rev Revision) any { return rev.Editorial.UsageTerms }},
+	{"editorial.language", func(rev Revision) any { return rev.Editorial.Language }},
+}
+
+// lock locks the image identified by a given ID for the rest of the
+// transaction and returns it, reporting ErrNotFound if it doesn't exist.
+func (c Core) lock(ctx context.Context, store db.Store, imageID string) (db.Image, error) {
+	if err := store.Lock(ctx, imageID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return db.Image{}, ErrNotFound
+		}
+		return db.Image{}, fmt.Errorf("lock: %w", err)
+	}
+
+	dbImg, err := store.QueryByID(ctx, imageID)
+	if err != nil {
+		return db.Image{}, fmt.Errorf("query: %w", err)
+	}
+
+	return dbImg, nil
+}
+
+// swapContent stores the new content of an image, whose blob reference the
+// caller has added, and releases the image's reference to its old content.
+func (c Core) swapContent(ctx context.Context, store db.Store, oldKey string, dbImg db.Image) error {
+	if err := store.UpdateContent(ctx, dbImg); err != nil {
+		return fmt.Errorf("update content: %w", err)
+	}
+
+	refs, err := store.RemoveBlobRef(ctx, oldKey)
+	if err != nil {
+		return fmt.Errorf("remove blob ref: %w", err)
+	}
+
+	// Revisions hold references too, so this only happens to content
+	// stored before they were kept.
+	if refs == 0 {
+		c.removeBlob(ctx, oldKey)
+	}
+
+	return nil
+}
+
+// readContent replaces the technical metadata and hashes of an image with
+// those read from new content, keeping its editorial metadata.
+func (c Core) readContent(ctx context.Context, key string, dbImg *db.Image) {
+	em := toImage(*dbImg).Editorial
+
+	*dbImg = db.Image{
+		ID:           dbImg.ID,
+		UserID:       dbImg.UserID,
+		StorageKey:   dbImg.StorageKey,
+		Checksum:     dbImg.Checksum,
+		Size:         dbImg.Size,
+		MimeType:     dbImg.MimeType,
+		DateUploaded: dbImg.DateUploaded,
+		Language:     dbImg.Language,
+		DeletedAt:    dbImg.DeletedAt,
+		DeletedBy:    dbImg.DeletedBy,
+
+		OrganizationID: dbImg.OrganizationID,
+		Visibility:     dbImg.Visibility,
+		Version:        dbImg.Version,
+	}
+	c.readMetadata(ctx, key, dbImg)
+	c.readHashes(ctx, key, dbImg)
+
+	setEditorial(em, dbImg)
+}
+
+// createRevision records the current state of an image as its next revision,
+// which holds a reference to the image content. It returns the number of the
+// revision.
+func (c Core) createRevision(ctx context.Context, store db.Store, dbImg db.Image, actorID string, revertedFrom *int, now time.Time) (int, error) {
+	editorial, err := json.Marshal(toImage(dbImg).Editorial)
+	if err != nil {
+		return 0, fmt.Errorf("encoding editorial: %w", err)
+	}
+
+	if _, err := store.AddBlobRef(ctx, dbImg.StorageKey); err != nil {
+		return 0, fmt.Errorf("add blob ref: %w", err)
+	}
+
+	dbRev := db.Revision{
+		ImageID:      dbImg.ID,
+		UserID:       dbImg.UserID,
+		StorageKey:   dbImg.StorageKey,
+		Checksum:     dbImg.Checksum,
+		Size:         dbImg.Size,
+		MimeType:     dbImg.MimeType,
+		Editorial:    editorial,
+		ActorID:      actorID,
+		RevertedFrom: revertedFrom,
+		DateCreated:  now,
+	}
+
+	revision, err := store.CreateRevision(ctx, dbRev)
+	if err != nil {
+		return 0, fmt.Errorf("create revision: %w", err)
+	}
+
+	return revision, nil
+}
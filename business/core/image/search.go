@@ -0,0 +1,211 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// ErrInvalidQuery is returned when the text of a search can't be parsed.
+var ErrInvalidQuery = errors.New("search query is not valid")
+
+// DefaultLanguage is the language editorial text is assumed to be in, and
+// searches are made in, when no other is given.
+const DefaultLanguage = "english"
+
+// searchFields maps the fields a search term can be qualified with to the
+// weight their text is given in the search vector.
+var searchFields = map[string]string{
+	"headline": "A",
+	"keywords": "B",
+	"keyword":  "B",
+	"caption":  "C",
+	"credit":   "D",
+}
+
+// searchTerm is a single word or phrase of a search.
+type searchTerm struct {
+	text    string
+	weight  string
+	exclude bool
+}
+
+// Search finds the images whose editorial text matches the search, most
+// relevant first, along with the cursor of the next page. The search text is
+// a list of terms that must all match:
+//
+//	flood nairobi          both words, in any field
+//	"flood waters"         the words next to each other and in order
+//	-aerial                images without the word
+//	caption:flood          the word in the caption
+//	headline:"flash flood" the phrase in the headline
+//
+// Fields are headline, caption, keywords and credit. Words are stemmed in the
+// language of the search, so "floods" also finds "flooded". Images that match
+// are also filtered on the same fields as QueryPage. Results can be sorted by
+// rank, the default, or by date_uploaded.
+func (c Core) Search(ctx context.Context, sq SearchQuery, filter QueryFilter, page paging.Page) ([]SearchResult, string, error) {
+	if err := validate.Check(sq); err != nil {
+		return nil, "", fmt.Errorf("validating data: %w", err)
+	}
+
+	filter, err := checkFilter(filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	terms, err := parseSearch(sq.Text)
+	if err != nil {
+		return nil, "", err
+	}
+
+	language := sq.Language
+	if language == "" {
+		language = DefaultLanguage
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-rank", "rank", "date_uploaded")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   order.Field,
+		IDColumn: "image_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbResults, err := c.store.Search(ctx, tsquery(terms), language, db.QueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("search: %w", err)
+	}
+
+	var next string
+	if len(dbResults) > limit {
+		dbResults = dbResults[:limit]
+		last := dbResults[limit-1]
+
+		key := sortKey(order.Field, last.Image)
+		if order.Field == "rank" {
+			key = strconv.FormatFloat(last.Rank, 'g', -1, 64)
+		}
+		next = paging.Next(order, key, last.ID)
+	}
+
+	return toSearchResultSlice(dbResults), next, nil
+}
+
+// =============================================================================
+
+// parseSearch splits the text of a search into its terms. A term is a word or
+// a quoted phrase, optionally qualified by a field and a colon, and excluded
+// when it starts with a minus. A qualifier that doesn't name a field is taken
+// as part of the word.
+func parseSearch(text string) ([]searchTerm, error) {
+	var terms []searchTerm
+	var matching bool
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+
+		var term searchTerm
+		if text[i] == '-' {
+			term.exclude = true
+			i++
+		}
+
+		if j := strings.IndexByte(text[i:], ':'); j > 0 {
+			if weight, ok := searchFields[strings.ToLower(text[i:i+j])]; ok {
+				term.weight = weight
+				i += j + 1
+			}
+		}
+
+		switch {
+		case i < len(text) && text[i] == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: phrase at %d isn't closed", ErrInvalidQuery, i)
+			}
+			term.text = text[i+1 : i+1+end]
+			i += end + 2
+
+		default:
+			end := strings.IndexFunc(text[i:], unicode.IsSpace)
+			if end < 0 {
+				end = len(text) - i
+			}
+			term.text = text[i : i+end]
+			i += end
+		}
+
+		// A lone minus, qualifier or pair of quotes holds nothing to match.
+		if strings.TrimSpace(term.text) == "" {
+			continue
+		}
+
+		terms = append(terms, term)
+		matching = matching || !term.exclude
+	}
+
+	// Every image without the excluded words would match otherwise.
+	if !matching {
+		return nil, fmt.Errorf("%w: must have a term that isn't excluded", ErrInvalidQuery)
+	}
+
+	return terms, nil
+}
+
+// tsqueryEscaper escapes text for use inside a quoted tsquery operand.
+var tsqueryEscaper = strings.NewReplacer(`\`, `\\`, `'`, `''`)
+
+// tsquery returns the terms in the syntax of to_tsquery. Every term is quoted
+// so its words are normalized by the text search configuration, which joins
+// the words of a phrase so they must follow each other. The weight limits a
+// term to the text of its field.
+func tsquery(terms []searchTerm) string {
+	operands := make([]string, len(terms))
+	for i, term := range terms {
+		var b strings.Builder
+		if term.exclude {
+			b.WriteString("!")
+		}
+		b.WriteString("'")
+		b.WriteString(tsqueryEscaper.Replace(term.text))
+		b.WriteString("'")
+		if term.weight != "" {
+			b.WriteString(":")
+			b.WriteString(term.weight)
+		}
+		operands[i] = b.String()
+	}
+
+	return strings.Join(operands, " & ")
+}
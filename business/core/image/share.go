@@ -0,0 +1,259 @@
+package image
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+)
+
+// Set of error variables for sharing images.
+var (
+	ErrShareNotFound  = errors.New("share not found")
+	ErrInvalidToken   = errors.New("share token is not valid")
+	ErrInvalidExpiry  = errors.New("expiry is not valid")
+	ErrShareExpired   = errors.New("share has expired")
+	ErrShareRevoked   = errors.New("share has been revoked")
+	ErrShareExhausted = errors.New("share has no downloads left")
+	ErrShareDisabled  = errors.New("sharing is not configured")
+)
+
+// Sharing configures the links images are shared through.
+type Sharing struct {
+	Secret     []byte        // Key the share tokens are signed with.
+	DefaultTTL time.Duration // How long a share lasts when the client doesn't say.
+	MaxTTL     time.Duration // Longest a share may last.
+}
+
+// DefaultSharing is used when the core isn't configured otherwise. It has no
+// secret, so images can't be shared until one is set.
+var DefaultSharing = Sharing{
+	DefaultTTL: 24 * time.Hour,
+	MaxTTL:     30 * 24 * time.Hour,
+}
+
+// WithSharing sets how the links images are shared through are made.
+func WithSharing(sharing Sharing) func(opts *Options) {
+	return func(opts *Options) {
+		opts.sharing = sharing
+	}
+}
+
+// CreateShare makes a link through which the image identified by a given ID,
+// or one of its renditions, can be downloaded without signing in, on behalf
+// of the specified user. The link carries a token signed with the configured
+// secret that names the share and when it expires.
+func (c Core) CreateShare(ctx context.Context, imageID string, ns NewShare, userID string, now time.Time) (Share, error) {
+	if len(c.sharing.Secret) == 0 {
+		return Share{}, ErrShareDisabled
+	}
+
+	if err := validate.CheckID(imageID); err != nil {
+		return Share{}, ErrInvalidID
+	}
+
+	if err := validate.Check(ns); err != nil {
+		return Share{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	ttl := c.sharing.DefaultTTL
+	if ns.ExpiresIn != nil {
+		ttl = time.Duration(*ns.ExpiresIn) * time.Second
+	}
+	if ttl > c.sharing.MaxTTL {
+		return Share{}, fmt.Errorf("%w: must be at most %d seconds", ErrInvalidExpiry, int(c.sharing.MaxTTL/time.Second))
+	}
+
+	if ns.Rendition != "" {
+		if _, ok := c.preset(ns.Rendition); !ok {
+			return Share{}, ErrUnknownPreset
+		}
+	}
+
+	if _, err := c.store.QueryByID(ctx, imageID); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Share{}, ErrNotFound
+		}
+		return Share{}, fmt.Errorf("query: imageID[%s]: %w", imageID, err)
+	}
+
+	// Tokens hold the expiry in seconds, so it's kept to the second here too.
+	dbShare := db.Share{
+		ID:           validate.GenerateID(),
+		ImageID:      imageID,
+		UserID:       userID,
+		Rendition:    ns.Rendition,
+		MaxDownloads: ns.MaxDownloads,
+		ExpiresAt:    now.Add(ttl).Truncate(time.Second),
+		DateCreated:  now,
+	}
+
+	if err := c.store.CreateShare(ctx, dbShare); err != nil {
+		return Share{}, fmt.Errorf("create share: %w", err)
+	}
+
+	return c.toShare(dbShare), nil
+}
+
+// QueryShares finds the shares of the image identified by a given ID, latest
+// first, including those that have expired or been revoked.
+func (c Core) QueryShares(ctx context.Context, imageID string) ([]Share, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbShares, err := c.store.QueryShares(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("query shares: %w", err)
+	}
+
+	shares := make([]Share, len(dbShares))
+	for i, dbShare := range dbShares {
+		shares[i] = c.toShare(dbShare)
+	}
+
+	return shares, nil
+}
+
+// RevokeShare stops the share identified by a given ID from working. The
+// share must belong to the image identified by a given ID.
+func (c Core) RevokeShare(ctx context.Context, imageID string, shareID string, now time.Time) error {
+	if err := validate.CheckID(imageID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := validate.CheckID(shareID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := c.store.RevokeShare(ctx, imageID, shareID, now); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return ErrShareNotFound
+		}
+		return fmt.Errorf("revoke share: %w", err)
+	}
+
+	return nil
+}
+
+// OpenShare checks the share token and records a download through the share
+// it names, returning the share. The caller serves the image or rendition
+// the share is for and nothing else.
+func (c Core) OpenShare(ctx context.Context, token string, now time.Time) (Share, error) {
+	if len(c.sharing.Secret) == 0 {
+		return Share{}, ErrShareDisabled
+	}
+
+	shareID, expiresAt, err := c.parseToken(token)
+	if err != nil {
+		return Share{}, ErrInvalidToken
+	}
+
+	// The expiry is signed, so an expired share is turned away without
+	// going to the database.
+	if !now.Before(expiresAt) {
+		return Share{}, ErrShareExpired
+	}
+
+	dbShare, err := c.store.AddShareDownload(ctx, shareID, now)
+	if err != nil {
+		if !errors.Is(err, database.ErrDBNotFound) {
+			return Share{}, fmt.Errorf("add download: %w", err)
+		}
+
+		// Find out why the share can't be used so the client is told.
+		dbShare, err := c.store.QueryShareByID(ctx, shareID)
+		switch {
+		case errors.Is(err, database.ErrDBNotFound):
+			return Share{}, ErrShareNotFound
+		case err != nil:
+			return Share{}, fmt.Errorf("query share: %w", err)
+		case dbShare.RevokedAt != nil:
+			return Share{}, ErrShareRevoked
+		case !now.Before(dbShare.ExpiresAt):
+			return Share{}, ErrShareExpired
+		}
+		return Share{}, ErrShareExhausted
+	}
+
+	return c.toShare(dbShare), nil
+}
+
+// =============================================================================
+
+// signToken returns the token for a share, which names the share and when it
+// expires along with their HMAC-SHA256 signature.
+func (c Core) signToken(shareID string, expiresAt time.Time) string {
+	payload := shareID + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+
+	mac := hmac.New(sha256.New, c.sharing.Secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseToken checks the signature of a share token and returns the ID and
+// expiry of the share it names.
+func (c Core) parseToken(token string) (string, time.Time, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", time.Time{}, errors.New("missing signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding payload: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, c.sharing.Secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", time.Time{}, errors.New("signature mismatch")
+	}
+
+	shareID, expires, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return "", time.Time{}, errors.New("malformed payload")
+	}
+
+	if err := validate.CheckID(shareID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("parsing expiry: %w", err)
+	}
+
+	return shareID, time.Unix(unix, 0).UTC(), nil
+}
+
+// toShare converts a share from the database, signing its token.
+func (c Core) toShare(dbShare db.Share) Share {
+	return Share{
+		ID:           dbShare.ID,
+		ImageID:      dbShare.ImageID,
+		UserID:       dbShare.UserID,
+		Rendition:    dbShare.Rendition,
+		Token:        c.signToken(dbShare.ID, dbShare.ExpiresAt),
+		MaxDownloads: dbShare.MaxDownloads,
+		Downloads:    dbShare.Downloads,
+		ExpiresAt:    dbShare.ExpiresAt,
+		RevokedAt:    dbShare.RevokedAt,
+		DateCreated:  dbShare.DateCreated,
+	}
+}
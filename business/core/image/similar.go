@@ -0,0 +1,315 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/imaging"
+	"github.com/fadhilijuma/images/foundation/phash"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Set of error variables for finding similar images.
+var (
+	ErrNotHashed       = errors.New("image content could not be hashed")
+	ErrInvalidDistance = errors.New("distance is not valid")
+)
+
+// Set of perceptual hashes images can be compared by.
+const (
+	HashDifference = "dhash"
+	HashDCT        = "phash"
+)
+
+// Similarity configures how similar images are found.
+type Similarity struct {
+	MaxDistance int           // Largest Hamming distance clients may ask for.
+	IndexTTL    time.Duration // How long the index of hashes is used before it's rebuilt.
+}
+
+// DefaultSimilarity is used when the core isn't configured otherwise.
+var DefaultSimilarity = Similarity{
+	MaxDistance: 10,
+	IndexTTL:    5 * time.Minute,
+}
+
+// WithSimilarity sets how similar images are found.
+func WithSimilarity(similarity Similarity) func(opts *Options) {
+	return func(opts *Options) {
+		opts.similarity = similarity
+	}
+}
+
+// Similar finds the images that look like the image identified by a given
+// ID, nearest first. Images are compared by the Hamming distance between
+// their perceptual hashes, which defaults to the configured maximum. Images
+// the viewer named by the query may not see are left out.
+//
+// The hashes are searched in an index held in memory. Images uploaded through
+// this core are added to it as they're created, and the index is rebuilt from
+// the database once it's older than the configured TTL to pick up the rest.
+func (c Core) Similar(ctx context.Context, imageID string, sq SimilarQuery) ([]SimilarImage, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := validate.Check(sq); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	kind := sq.Hash
+	if kind == "" {
+		kind = HashDCT
+	}
+
+	maxDistance := c.similarity.MaxDistance
+	if sq.Distance != nil {
+		if *sq.Distance > maxDistance {
+			return nil, fmt.Errorf("%w: must be between 0 and %d", ErrInvalidDistance, maxDistance)
+		}
+		maxDistance = *sq.Distance
+	}
+
+	dbImg, err := c.store.QueryByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query: imageID[%s]: %w", imageID, err)
+	}
+
+	if dbImg.DHash == nil || dbImg.PHash == nil {
+		return nil, ErrNotHashed
+	}
+
+	hash := uint64(*dbImg.PHash)
+	if kind == HashDifference {
+		hash = uint64(*dbImg.DHash)
+	}
+
+	matches, err := c.index.search(ctx, c.store, kind, hash, maxDistance, c.similarity.IndexTTL)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	distances := make(map[string]int, len(matches))
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+
+		// Images whose content was replaced are in the index under their
+		// earlier hashes too, and the nearest match comes first.
+		if _, exists := distances[m.ID]; exists || m.ID == imageID {
+			continue
+		}
+		distances[m.ID] = m.Distance
+		ids = append(ids, m.ID)
+	}
+
+	if len(ids) == 0 {
+		return []SimilarImage{}, nil
+	}
+
+	// Images deleted since the index was built are left out here.
+	dbImgs, err := c.store.QueryByIDs(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	similar := make([]SimilarImage, 0, len(dbImgs))
+	for _, dbImg := range dbImgs {
+		img := toImage(dbImg)
+		if sq.ViewerID != nil && !img.VisibleTo(*sq.ViewerID, stringValue(sq.ViewerOrganizationID)) {
+			continue
+		}
+		similar = append(similar, SimilarImage{
+			Image:    img,
+			Distance: distances[dbImg.ID],
+		})
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].Image.ID < similar[j].Image.ID
+	})
+
+	return similar, nil
+}
+
+// QueryClusters groups every image in the catalog with the images that look
+// like it, using the specified hash and maximum Hamming distance. Images
+// that look like no other image are left out. The largest clusters are
+// returned first.
+func (c Core) QueryClusters(ctx context.Context, kind string, maxDistance int) ([][]Image, error) {
+	if kind != HashDifference && kind != HashDCT {
+		return nil, fmt.Errorf("hash %q must be %s or %s", kind, HashDifference, HashDCT)
+	}
+
+	if maxDistance < 0 || maxDistance > 64 {
+		return nil, fmt.Errorf("%w: must be between 0 and 64", ErrInvalidDistance)
+	}
+
+	dbHashes, err := c.store.QueryHashes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query hashes: %w", err)
+	}
+
+	clusters := phash.Cluster(hashItems(dbHashes, kind), maxDistance)
+
+	images := make([][]Image, len(clusters))
+	for i, cluster := range clusters {
+		ids := make([]string, len(cluster))
+		for j, item := range cluster {
+			ids[j] = item.ID
+		}
+
+		dbImgs, err := c.store.QueryByIDs(ctx, ids)
+		if err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+
+		sort.Slice(dbImgs, func(a, b int) bool {
+			return dbImgs[a].DateUploaded.Before(dbImgs[b].DateUploaded)
+		})
+		images[i] = toImageSlice(dbImgs)
+	}
+
+	return images, nil
+}
+
+// HashMissing computes the perceptual hashes of the images uploaded before
+// hashes were kept, or whose hashes couldn't be computed at the time. It
+// returns the number of images hashed. Images whose content can't be decoded
+// are logged and skipped.
+func (c Core) HashMissing(ctx context.Context) (int, error) {
+	const batch = 100
+
+	var hashed int
+	var afterID string
+	for {
+		dbImgs, err := c.store.QueryUnhashed(ctx, afterID, batch)
+		if err != nil {
+			return hashed, fmt.Errorf("query unhashed: %w", err)
+		}
+
+		for _, dbImg := range dbImgs {
+			c.readHashes(ctx, dbImg.StorageKey, &dbImg)
+			if dbImg.DHash == nil || dbImg.PHash == nil {
+				continue
+			}
+
+			hash := db.ImageHash{
+				ImageID: dbImg.ID,
+				DHash:   *dbImg.DHash,
+				PHash:   *dbImg.PHash,
+			}
+
+			if err := c.store.UpdateHashes(ctx, hash); err != nil {
+				return hashed, fmt.Errorf("update hashes: %w", err)
+			}
+			hashed++
+		}
+
+		if len(dbImgs) < batch {
+			return hashed, nil
+		}
+		afterID = dbImgs[len(dbImgs)-1].ID
+	}
+}
+
+// =============================================================================
+
+// readHashes fills in the perceptual hashes of an image from its content.
+// Like the rest of the metadata the hashes are best effort, so content that
+// can't be decoded is logged and the image is stored without them.
+func (c Core) readHashes(ctx context.Context, key string, dbImg *db.Image) {
+	obj, err := c.blobs.Open(ctx, key)
+	if err != nil {
+		c.log.Errorw("read hashes", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		return
+	}
+	defer obj.Close()
+
+	img, _, err := imaging.Decode(obj)
+	if err != nil {
+		if !errors.Is(err, imaging.ErrUnsupportedFormat) {
+			c.log.Errorw("read hashes", "traceid", web.GetTraceID(ctx), "imageID", dbImg.ID, "ERROR", err)
+		}
+		return
+	}
+
+	dhash := int64(phash.DHash(img))
+	dbImg.DHash = &dhash
+
+	ph := int64(phash.PHash(img))
+	dbImg.PHash = &ph
+}
+
+// hashIndex holds a BK-tree of every image's hashes for each kind of hash.
+// It's shared by the copies of a core.
+type hashIndex struct {
+	mu    sync.Mutex
+	trees map[string]*phash.BKTree
+	built time.Time
+}
+
+// search returns the images within maxDistance of the hash, rebuilding the
+// index from the store first when it's older than the TTL.
+func (hi *hashIndex) search(ctx context.Context, store db.Store, kind string, hash uint64, maxDistance int, ttl time.Duration) ([]phash.Match, error) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	if hi.trees == nil || time.Since(hi.built) > ttl {
+		dbHashes, err := store.QueryHashes(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("query hashes: %w", err)
+		}
+
+		hi.trees = map[string]*phash.BKTree{
+			HashDifference: phash.NewBKTree(hashItems(dbHashes, HashDifference)...),
+			HashDCT:        phash.NewBKTree(hashItems(dbHashes, HashDCT)...),
+		}
+		hi.built = time.Now()
+	}
+
+	return hi.trees[kind].Search(hash, maxDistance), nil
+}
+
+// add puts the hashes of a new image into the index. Nothing is done until
+// the index is first built, since building it reads every image.
+func (hi *hashIndex) add(dbImg db.Image) {
+	if dbImg.DHash == nil || dbImg.PHash == nil {
+		return
+	}
+
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	if hi.trees == nil {
+		return
+	}
+
+	hi.trees[HashDifference].Add(phash.Item{ID: dbImg.ID, Hash: uint64(*dbImg.DHash)})
+	hi.trees[HashDCT].Add(phash.Item{ID: dbImg.ID, Hash: uint64(*dbImg.PHash)})
+}
+
+// hashItems returns the specified kind of hash of every image.
+func hashItems(dbHashes []db.ImageHash, kind string) []phash.Item {
+	items := make([]phash.Item, len(dbHashes))
+	for i, h := range dbHashes {
+		hash := h.PHash
+		if kind == HashDifference {
+			hash = h.DHash
+		}
+		items[i] = phash.Item{ID: h.ImageID, Hash: uint64(hash)}
+	}
+	return items
+}
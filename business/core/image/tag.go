@@ -0,0 +1,328 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Set of error variables for working with tags.
+var (
+	ErrInvalidTag  = errors.New("tag is not valid")
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("tag already exists")
+)
+
+// Set of ways the tags of a query filter are matched.
+const (
+	TagMatchAll = "all"
+	TagMatchAny = "any"
+)
+
+// Set of limits on tags.
+const (
+	maxTagLen          = 64
+	defaultTagSuggests = 10
+	maxTagSuggests     = 50
+)
+
+// NormalizeTag returns the form a tag name is stored and matched in. The name
+// is put in Unicode NFC, lower cased and has its runs of whitespace collapsed
+// to a single space, so "New  York" and "new york" are the same tag.
+func NormalizeTag(name string) (string, error) {
+	tag := strings.Join(strings.Fields(normalizeTagText(name)), " ")
+
+	if tag == "" {
+		return "", fmt.Errorf("%w: name is empty", ErrInvalidTag)
+	}
+	if utf8.RuneCountInString(tag) > maxTagLen {
+		return "", fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidTag, tag, maxTagLen)
+	}
+
+	return tag, nil
+}
+
+// normalizeTags normalizes the names, dropping any that end up the same.
+func normalizeTags(names []string) ([]string, error) {
+	tags := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		tag, err := NormalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// normalizeTagText puts text in the case and Unicode form tags are stored in.
+func normalizeTagText(text string) string {
+	return norm.NFC.String(strings.ToLower(norm.NFC.String(text)))
+}
+
+// AddTags attaches the named tags to the image identified by a given ID,
+// creating the tags that don't exist yet. It returns every tag the image has.
+func (c Core) AddTags(ctx context.Context, imageID string, nt NewTags, now time.Time) ([]Tag, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := validate.Check(nt); err != nil {
+		return nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	names, err := normalizeTags(nt.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbTags []db.Tag
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if _, err := store.QueryByID(ctx, imageID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("query: %w", err)
+		}
+
+		for _, name := range names {
+			dbTag, err := store.UpsertTag(ctx, db.Tag{ID: validate.GenerateID(), Name: name, DateCreated: now})
+			if err != nil {
+				return fmt.Errorf("upsert tag: %w", err)
+			}
+
+			dbIT := db.ImageTag{
+				ImageID:     imageID,
+				TagID:       dbTag.ID,
+				DateCreated: now,
+			}
+			if err := store.AddImageTag(ctx, dbIT); err != nil {
+				return fmt.Errorf("add tag: %w", err)
+			}
+		}
+
+		dbTags, err = store.QueryImageTags(ctx, imageID)
+		if err != nil {
+			return fmt.Errorf("query tags: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("tran: %w", err)
+	}
+
+	return toTagSlice(dbTags), nil
+}
+
+// RemoveTag detaches the named tag from the image identified by a given ID.
+// Removing a tag the image doesn't have is not an error.
+func (c Core) RemoveTag(ctx context.Context, imageID string, name string) error {
+	if err := validate.CheckID(imageID); err != nil {
+		return ErrInvalidID
+	}
+
+	tag, err := NormalizeTag(name)
+	if err != nil {
+		return err
+	}
+
+	if err := c.store.RemoveImageTag(ctx, imageID, tag); err != nil {
+		return fmt.Errorf("remove tag: %w", err)
+	}
+
+	return nil
+}
+
+// QueryTags finds the tags attached to the image identified by a given ID.
+func (c Core) QueryTags(ctx context.Context, imageID string) ([]Tag, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbTags, err := c.store.QueryImageTags(ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toTagSlice(dbTags), nil
+}
+
+// SuggestTags finds the tags in use that start with the prefix, ranked by the
+// number of images they're attached to. The prefix is normalized like a tag
+// name, except that trailing whitespace is kept as a single space so "new "
+// suggests "new york" but not "newsroom". A limit of zero uses the default.
+func (c Core) SuggestTags(ctx context.Context, prefix string, limit int) ([]Tag, error) {
+	switch {
+	case limit == 0:
+		limit = defaultTagSuggests
+	case limit < 0 || limit > maxTagSuggests:
+		return nil, fmt.Errorf("%w: must be between 1 and %d", paging.ErrInvalidLimit, maxTagSuggests)
+	}
+
+	pfx := strings.Join(strings.Fields(normalizeTagText(prefix)), " ")
+	if pfx != "" {
+		if r, _ := utf8.DecodeLastRuneInString(prefix); unicode.IsSpace(r) {
+			pfx += " "
+		}
+	}
+
+	dbTags, err := c.store.QueryTagsByPrefix(ctx, pfx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	return toTagSlice(dbTags), nil
+}
+
+// RenameTag changes the name of a tag on every image it's attached to. It
+// fails with ErrTagExists if the new name is taken, in which case the tags
+// should be merged instead.
+func (c Core) RenameTag(ctx context.Context, name string, rt RenameTag) (Tag, error) {
+	if err := validate.Check(rt); err != nil {
+		return Tag{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	from, err := NormalizeTag(name)
+	if err != nil {
+		return Tag{}, err
+	}
+
+	to, err := NormalizeTag(rt.Name)
+	if err != nil {
+		return Tag{}, err
+	}
+
+	var dbTag db.Tag
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbTag, err = store.QueryTagByName(ctx, from)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrTagNotFound
+			}
+			return fmt.Errorf("query tag: %w", err)
+		}
+
+		if to == from {
+			return nil
+		}
+
+		switch _, err := store.QueryTagByName(ctx, to); {
+		case err == nil:
+			return ErrTagExists
+		case !errors.Is(err, database.ErrDBNotFound):
+			return fmt.Errorf("query tag: %w", err)
+		}
+
+		if err := store.RenameTag(ctx, dbTag.ID, to); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		dbTag.Name = to
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrTagNotFound):
+			return Tag{}, ErrTagNotFound
+		case errors.Is(err, ErrTagExists):
+			return Tag{}, ErrTagExists
+		}
+		return Tag{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return toTag(dbTag), nil
+}
+
+// MergeTags moves the images of the named tags onto the tag they're merged
+// into and removes the merged tags. The tag merged into is created if it
+// doesn't exist, which makes a merge of a single tag a rename that's allowed
+// to land on an existing name.
+func (c Core) MergeTags(ctx context.Context, mt MergeTags, now time.Time) (Tag, error) {
+	if err := validate.Check(mt); err != nil {
+		return Tag{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	names, err := normalizeTags(mt.Tags)
+	if err != nil {
+		return Tag{}, err
+	}
+
+	into, err := NormalizeTag(mt.Into)
+	if err != nil {
+		return Tag{}, err
+	}
+
+	var dbTag db.Tag
+	var missing string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		dbInto, err := store.UpsertTag(ctx, db.Tag{ID: validate.GenerateID(), Name: into, DateCreated: now})
+		if err != nil {
+			return fmt.Errorf("upsert tag: %w", err)
+		}
+
+		for _, name := range names {
+			if name == into {
+				continue
+			}
+
+			dbFrom, err := store.QueryTagByName(ctx, name)
+			if err != nil {
+				if errors.Is(err, database.ErrDBNotFound) {
+					missing = name
+					return ErrTagNotFound
+				}
+				return fmt.Errorf("query tag: %w", err)
+			}
+
+			if err := store.MergeTag(ctx, dbFrom.ID, dbInto.ID); err != nil {
+				return fmt.Errorf("merge: %w", err)
+			}
+		}
+
+		dbTag, err = store.QueryTagByName(ctx, into)
+		if err != nil {
+			return fmt.Errorf("query tag: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		if errors.Is(err, ErrTagNotFound) {
+			return Tag{}, fmt.Errorf("%w: %q", ErrTagNotFound, missing)
+		}
+		return Tag{}, fmt.Errorf("tran: %w", err)
+	}
+
+	return toTag(dbTag), nil
+}
@@ -0,0 +1,234 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/imaging"
+	"github.com/fadhilijuma/images/foundation/web"
+)
+
+// Set of error variables for transform operations.
+var (
+	ErrInvalidTransform    = errors.New("transform parameters are not valid")
+	ErrTransformNotAllowed = errors.New("transform is not allowed")
+)
+
+// Set of ways a transform can fit an image into the requested size.
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+)
+
+// defaultTransformQuality is the JPEG quality used when none is requested.
+const defaultTransformQuality = 80
+
+// TransformCache declares the behavior required to cache transformed images.
+type TransformCache interface {
+	Get(key string) (io.ReadSeekCloser, error)
+	Put(key string, data []byte) error
+}
+
+// TransformLimits bounds the transforms clients may request. Each allowed
+// entry is a set of query parameters, such as "w=640&h=480&fit=cover", and a
+// transform is allowed when it matches every parameter of an entry. When
+// there are no entries any transform inside the maximum size is allowed.
+type TransformLimits struct {
+	MaxWidth  int
+	MaxHeight int
+	Allowed   []string
+}
+
+// DefaultTransformLimits are the limits used when none are configured.
+var DefaultTransformLimits = TransformLimits{
+	MaxWidth:  2048,
+	MaxHeight: 2048,
+}
+
+// OpenTransform returns a reader for an image transformed on the fly along
+// with the normalized transform that was applied. Results are served from the
+// transform cache when they have been made before. The caller must close the
+// reader.
+func (c Core) OpenTransform(ctx context.Context, imageID string, t Transform) (Transform, io.ReadSeekCloser, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Transform{}, nil, ErrInvalidID
+	}
+
+	if err := validate.Check(t); err != nil {
+		return Transform{}, nil, fmt.Errorf("validating data: %w", err)
+	}
+
+	dbImg, err := c.store.QueryByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Transform{}, nil, ErrNotFound
+		}
+		return Transform{}, nil, fmt.Errorf("query: %w", err)
+	}
+
+	t, err = t.normalize(dbImg.MimeType)
+	if err != nil {
+		return Transform{}, nil, err
+	}
+
+	if !c.limits.allow(t) {
+		return Transform{}, nil, ErrTransformNotAllowed
+	}
+
+	// The checksum makes sure a cached result is never served for content
+	// the image no longer has.
+	key := dbImg.Checksum + "?" + t.String()
+
+	if c.cache != nil {
+		if obj, err := c.cache.Get(key); err == nil {
+			return t, c.embedEditorial(ctx, dbImg, imaging.MimeType(t.Format), obj), nil
+		}
+	}
+
+	obj, err := c.blobs.Open(ctx, dbImg.StorageKey)
+	if err != nil {
+		return Transform{}, nil, fmt.Errorf("opening content: %w", err)
+	}
+	defer obj.Close()
+
+	src, _, err := imaging.Decode(obj)
+	if err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedFormat) {
+			return Transform{}, nil, ErrUnsupportedType
+		}
+		return Transform{}, nil, fmt.Errorf("decoding content: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, t.apply(src, c.limits), t.Format, t.Quality); err != nil {
+		return Transform{}, nil, fmt.Errorf("encoding: %w", err)
+	}
+
+	if c.cache != nil {
+		if err := c.cache.Put(key, buf.Bytes()); err != nil {
+			c.log.Errorw("cache transform", "traceid", web.GetTraceID(ctx), "key", key, "ERROR", err)
+		}
+	}
+
+	return t, c.embedEditorial(ctx, dbImg, imaging.MimeType(t.Format), readSeekNopCloser{bytes.NewReader(buf.Bytes())}), nil
+}
+
+// =============================================================================
+
+// String returns the transform as a canonical query string. Two transforms
+// that produce the same result have the same string.
+func (t Transform) String() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&format=%s&q=%d", t.Width, t.Height, t.Fit, t.Format, t.Quality)
+}
+
+// normalize fills in the defaults for a transform of content with the
+// specified MIME type.
+func (t Transform) normalize(mimeType string) (Transform, error) {
+	if t.Fit == "" {
+		t.Fit = FitContain
+	}
+
+	if t.Fit == FitCover && (t.Width == 0 || t.Height == 0) {
+		return Transform{}, fmt.Errorf("%w: cover needs both a width and a height", ErrInvalidTransform)
+	}
+
+	if t.Format == "" {
+		switch format := strings.TrimPrefix(mimeType, "image/"); format {
+		case imaging.FormatJPEG, imaging.FormatPNG, imaging.FormatGIF:
+			t.Format = format
+		default:
+			t.Format = imaging.FormatPNG
+		}
+	}
+
+	switch {
+	case t.Format != imaging.FormatJPEG:
+		t.Quality = 0
+	case t.Quality == 0:
+		t.Quality = defaultTransformQuality
+	}
+
+	return t, nil
+}
+
+// apply transforms the image. Contained images are never scaled up.
+func (t Transform) apply(src image.Image, limits TransformLimits) image.Image {
+	if t.Fit == FitCover {
+		return imaging.Cover(src, t.Width, t.Height)
+	}
+
+	maxWidth, maxHeight := t.Width, t.Height
+	if maxWidth == 0 {
+		maxWidth = limits.MaxWidth
+	}
+	if maxHeight == 0 {
+		maxHeight = limits.MaxHeight
+	}
+
+	b := src.Bounds()
+	width, height := imaging.Fit(b.Dx(), b.Dy(), maxWidth, maxHeight)
+	if width == b.Dx() && height == b.Dy() {
+		return src
+	}
+
+	return imaging.Resize(src, width, height)
+}
+
+// allow reports whether the normalized transform is inside the limits.
+func (l TransformLimits) allow(t Transform) bool {
+	if t.Width > l.MaxWidth || t.Height > l.MaxHeight {
+		return false
+	}
+
+	if len(l.Allowed) == 0 {
+		return true
+	}
+
+	params := map[string]string{
+		"w":      strconv.Itoa(t.Width),
+		"h":      strconv.Itoa(t.Height),
+		"fit":    t.Fit,
+		"format": t.Format,
+		"q":      strconv.Itoa(t.Quality),
+	}
+
+	for _, allowed := range l.Allowed {
+		values, err := url.ParseQuery(allowed)
+		if err != nil || len(values) == 0 {
+			continue
+		}
+
+		match := true
+		for k := range values {
+			if params[k] != values.Get(k) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readSeekNopCloser adds a no-op Close to an io.ReadSeeker.
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+// Close implements the io.Closer interface.
+func (readSeekNopCloser) Close() error {
+	return nil
+}
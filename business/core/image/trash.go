@@ -0,0 +1,354 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/core/image/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/web"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrOwnerNotFound is returned when an image would be created for, handed
+// over to or restored to a user that doesn't exist.
+var ErrOwnerNotFound = errors.New("owner of the image not found")
+
+// purgeBatch is the number of images purged between queries of the trash.
+const purgeBatch = 100
+
+// PurgeQueue is the job queue the trash is purged from.
+const PurgeQueue = "trash-purge"
+
+// QueryTrashByID finds the image in the trash identified by a given ID.
+func (c Core) QueryTrashByID(ctx context.Context, imageID string) (Image, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Image{}, ErrInvalidID
+	}
+
+	dbImg, err := c.store.QueryTrashByID(ctx, imageID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Image{}, ErrNotFound
+		}
+		return Image{}, fmt.Errorf("query: %w", err)
+	}
+
+	return toImage(dbImg), nil
+}
+
+// QueryTrash gets a page of the images in the trash that match the filter,
+// most recently deleted first by default. Images can be sorted by deleted_at
+// or date_uploaded. It returns the cursor for the next page, which is empty
+// when there are no more images.
+func (c Core) QueryTrash(ctx context.Context, filter TrashFilter, page paging.Page) ([]Image, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-deleted_at", "deleted_at", "date_uploaded")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   order.Field,
+		IDColumn: "image_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbImgs, err := c.store.QueryTrash(ctx, db.TrashFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	var next string
+	if len(dbImgs) > limit {
+		dbImgs = dbImgs[:limit]
+		last := dbImgs[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last), last.ID)
+	}
+
+	return toImageSlice(dbImgs), next, nil
+}
+
+// TrashOwnedBy moves the images of the user identified by a given ID to the
+// trash as part of the transaction deleting the user, recording each one in
+// the audit log and the outbox. Quotas are left alone, since deleting the
+// user releases their usage as a whole with ReleaseUsage. It returns the
+// images, for the caller to report as deleted once the transaction is
+// committed.
+func (c Core) TrashOwnedBy(ctx context.Context, tx sqlx.ExtContext, userID string,
+	actorID string, now time.Time) ([]Image, error) {
+	store := c.store.Tran(tx)
+
+	dbImgs, err := store.LockByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+
+	if err := store.TrashByUser(ctx, userID, actorID, now); err != nil {
+		return nil, fmt.Errorf("trash: %w", err)
+	}
+
+	for i := range dbImgs {
+		if err := c.record(ctx, tx, EventDeleted, &dbImgs[i], dbImgs[i], now); err != nil {
+			return nil, err
+		}
+	}
+
+	return toImageSlice(dbImgs), nil
+}
+
+// Restore takes the image identified by a given ID out of the trash. The
+// image goes back to the user who uploaded it, or to the user named by the
+// RestoreImage, who must exist. The image counts against their quota again,
+// even when that takes them past it, since it was stored all along.
+func (c Core) Restore(ctx context.Context, imageID string, ri RestoreImage, now time.Time) (Image, error) {
+	if err := validate.CheckID(imageID); err != nil {
+		return Image{}, ErrInvalidID
+	}
+
+	if err := validate.Check(ri); err != nil {
+		return Image{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	var dbImg db.Image
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// The image is locked so a concurrent restore waits for this one
+		// and then finds the image gone from the trash, rather than
+		// charging the owner for it a second time.
+		if err := store.LockTrash(ctx, imageID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		var err error
+		dbImg, err = store.QueryTrashByID(ctx, imageID)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		before := dbImg
+		dbImg.Version++
+
+		if ri.UserID != nil {
+			dbImg.UserID = *ri.UserID
+		}
+
+		if err := c.checkOwner(ctx, store, dbImg.UserID); err != nil {
+			return err
+		}
+
+		if err := store.Restore(ctx, dbImg); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+
+		if err := c.charge(ctx, store, dbImg.UserID, dbImg.OrganizationID, dbImg.Size, 1, false, now); err != nil {
+			return err
+		}
+
+		dbImg.DeletedAt = nil
+		dbImg.DeletedBy = nil
+
+		return c.record(ctx, tx, EventUpdated, &before, dbImg, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			return Image{}, ErrNotFound
+		case errors.Is(err, ErrOwnerNotFound):
+			return Image{}, ErrOwnerNotFound
+		}
+		return Image{}, fmt.Errorf("tran: %w", err)
+	}
+
+	c.index.add(dbImg)
+	c.notify(ctx, EventUpdated, dbImg, true, now)
+
+	return toImage(dbImg), nil
+}
+
+// Purge permanently removes the images that were moved to the trash before
+// the specified time, along with their renditions and revisions. Their
+// content is removed from the blob store once no other image references it.
+// It returns the number of images purged.
+func (c Core) Purge(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+	for {
+		dbImgs, err := c.store.QueryPurgeable(ctx, before, purgeBatch)
+		if err != nil {
+			return purged, fmt.Errorf("query purgeable: %w", err)
+		}
+
+		for _, dbImg := range dbImgs {
+			ok, err := c.purge(ctx, dbImg.ID)
+			if err != nil {
+				return purged, fmt.Errorf("purge imageID[%s]: %w", dbImg.ID, err)
+			}
+			if ok {
+				purged++
+			}
+		}
+
+		// Purged images leave the trash, so the next query picks up where
+		// this one stopped.
+		if len(dbImgs) < purgeBatch {
+			return purged, nil
+		}
+	}
+}
+
+// =============================================================================
+
+// purge permanently removes the image in the trash identified by a given ID.
+// It reports false when the image is no longer in the trash.
+func (c Core) purge(ctx context.Context, imageID string) (bool, error) {
+	var purged bool
+	var dbRnds []db.Rendition
+	var unused []string
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// The rendition and revision rows are removed with the image so
+		// capture their keys first.
+		var err error
+		dbRnds, err = store.QueryRenditions(ctx, imageID)
+		if err != nil {
+			return fmt.Errorf("query renditions: %w", err)
+		}
+
+		dbRevs, err := store.QueryRevisions(ctx, imageID)
+		if err != nil {
+			return fmt.Errorf("query revisions: %w", err)
+		}
+
+		dbImg, err := store.Purge(ctx, imageID)
+		if err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return nil
+			}
+			return fmt.Errorf("purge: %w", err)
+		}
+
+		// The image and each of its revisions hold a reference to their
+		// content.
+		keys := []string{dbImg.StorageKey}
+		for _, dbRev := range dbRevs {
+			keys = append(keys, dbRev.StorageKey)
+		}
+
+		for _, key := range keys {
+			refs, err := store.RemoveBlobRef(ctx, key)
+			if err != nil {
+				return fmt.Errorf("remove blob ref: %w", err)
+			}
+			if refs == 0 {
+				unused = append(unused, key)
+			}
+		}
+
+		// Purging is done by the system rather than on behalf of a user, so
+		// it's recorded as of when it happened.
+		ne := audit.NewEntry{
+			Action:   audit.ActionPurge,
+			Entity:   audit.EntityImage,
+			EntityID: dbImg.ID,
+			Before:   toImage(dbImg),
+		}
+		if err := c.audit.Record(ctx, tx, ne, time.Now().UTC()); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+
+		purged = true
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return false, fmt.Errorf("tran: %w", err)
+	}
+
+	if !purged {
+		return false, nil
+	}
+
+	for _, dbRnd := range dbRnds {
+		c.removeBlob(ctx, dbRnd.StorageKey)
+	}
+	c.removeUnusedBlobs(ctx, unused)
+
+	c.log.Infow("purge", "traceid", web.GetTraceID(ctx), "imageID", imageID)
+
+	return true, nil
+}
+
+// removeUnusedBlobs removes the content no image referenced once a purge was
+// committed. The content may have been uploaded again since, so each blob
+// row is taken again to lock it and the bytes are only removed when nothing
+// else holds a reference.
+func (c Core) removeUnusedBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		tran := func(tx sqlx.ExtContext) error {
+			store := c.store.Tran(tx)
+
+			refs, err := store.AddBlobRef(ctx, key)
+			if err != nil {
+				return fmt.Errorf("add blob ref: %w", err)
+			}
+
+			if refs == 1 {
+				if err := c.blobs.Delete(ctx, key); err != nil {
+					return fmt.Errorf("removing content: %w", err)
+				}
+			}
+
+			if _, err := store.RemoveBlobRef(ctx, key); err != nil {
+				return fmt.Errorf("remove blob ref: %w", err)
+			}
+
+			return nil
+		}
+
+		if err := c.store.WithinTran(ctx, tran); err != nil {
+			c.log.Errorw("remove blob", "traceid", web.GetTraceID(ctx), "key", key, "ERROR", err)
+		}
+	}
+}
+
+// checkOwner returns ErrOwnerNotFound unless the user identified by a given
+// ID exists to own an image. The user can't be deleted until the surrounding
+// transaction ends, so the image can't be left with an owner who's gone.
+func (c Core) checkOwner(ctx context.Context, store db.Store, userID string) error {
+	exists, err := store.QueryOwnerExists(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("query owner: %w", err)
+	}
+	if !exists {
+		return ErrOwnerNotFound
+	}
+
+	return nil
+}
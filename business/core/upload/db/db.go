@@ -0,0 +1,224 @@
+// Package db contains upload related CRUD functionality.
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for upload access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Create adds an Upload to the database.
+func (s Store) Create(ctx context.Context, upl Upload) error {
+	const q = `
+	INSERT INTO uploads
+		(upload_id, user_id, organization_id, length, upload_offset, metadata, expires_at, date_created, date_updated)
+	VALUES
+		(:upload_id, :user_id, :organization_id, :length, :upload_offset, :metadata, :expires_at, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, upl); err != nil {
+		return fmt.Errorf("inserting upload: %w", err)
+	}
+
+	return nil
+}
+
+// QueryByID gets the specified Upload from the database.
+func (s Store) QueryByID(ctx context.Context, uploadID string) (Upload, error) {
+	data := struct {
+		UploadID string `db:"upload_id"`
+	}{
+		UploadID: uploadID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		uploads
+	WHERE
+		upload_id = :upload_id`
+
+	var upl Upload
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &upl); err != nil {
+		return Upload{}, fmt.Errorf("selecting upload uploadID[%s]: %w", uploadID, err)
+	}
+
+	return upl, nil
+}
+
+// UpdateOffset records that the Upload received content up to its offset,
+// moving its expiry along. It's only done when the offset stored is still
+// the one the content was appended at, returning ErrDBNotFound otherwise.
+func (s Store) UpdateOffset(ctx context.Context, upl Upload, from int64) error {
+	data := struct {
+		Upload
+		From int64 `db:"from_offset"`
+	}{
+		Upload: upl,
+		From:   from,
+	}
+
+	const q = `
+	UPDATE
+		uploads
+	SET
+		"upload_offset" = :upload_offset,
+		"expires_at" = :expires_at,
+		"date_updated" = :date_updated
+	WHERE
+		upload_id = :upload_id AND upload_offset = :from_offset
+	RETURNING
+		upload_id`
+
+	var row struct {
+		UploadID string `db:"upload_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return fmt.Errorf("updating offset uploadID[%s]: %w", upl.ID, err)
+	}
+
+	return nil
+}
+
+// Touch moves the expiry of the Upload identified by a given ID along.
+func (s Store) Touch(ctx context.Context, uploadID string, expiresAt time.Time) error {
+	data := struct {
+		UploadID  string    `db:"upload_id"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}{
+		UploadID:  uploadID,
+		ExpiresAt: expiresAt,
+	}
+
+	const q = `
+	UPDATE
+		uploads
+	SET
+		"expires_at" = :expires_at
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("touching upload uploadID[%s]: %w", uploadID, err)
+	}
+
+	return nil
+}
+
+// Complete records the image made from the content of the Upload.
+func (s Store) Complete(ctx context.Context, uploadID string, imageID string, now time.Time) error {
+	data := struct {
+		UploadID    string    `db:"upload_id"`
+		ImageID     string    `db:"image_id"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		UploadID:    uploadID,
+		ImageID:     imageID,
+		DateUpdated: now,
+	}
+
+	const q = `
+	UPDATE
+		uploads
+	SET
+		"image_id" = :image_id,
+		"date_updated" = :date_updated
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("completing upload uploadID[%s]: %w", uploadID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the Upload identified by a given ID.
+func (s Store) Delete(ctx context.Context, uploadID string) error {
+	data := struct {
+		UploadID string `db:"upload_id"`
+	}{
+		UploadID: uploadID,
+	}
+
+	const q = `
+	DELETE FROM
+		uploads
+	WHERE
+		upload_id = :upload_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting upload uploadID[%s]: %w", uploadID, err)
+	}
+
+	return nil
+}
+
+// QueryExpired gets up to limit uploads that expired before the specified
+// time, oldest first.
+func (s Store) QueryExpired(ctx context.Context, before time.Time, limit int) ([]Upload, error) {
+	data := struct {
+		Before time.Time `db:"before"`
+		Limit  int       `db:"limit"`
+	}{
+		Before: before,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		uploads
+	WHERE
+		expires_at < :before
+	ORDER BY
+		expires_at
+	LIMIT :limit`
+
+	var upls []Upload
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &upls); err != nil {
+		return nil, fmt.Errorf("selecting expired uploads: %w", err)
+	}
+
+	return upls, nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Upload represents content being uploaded in chunks that's kept between
+// requests so the upload can be resumed.
+type Upload struct {
+	ID             string         `db:"upload_id"`       // Unique identifier.
+	UserID         string         `db:"user_id"`         // ID of the user uploading the content.
+	OrganizationID *string        `db:"organization_id"` // ID of the organization the user belonged to.
+	Length         int64          `db:"length"`          // Size of the whole content in bytes.
+	Offset         int64          `db:"upload_offset"`   // Number of bytes received so far.
+	Metadata       types.JSONText `db:"metadata"`        // Key value pairs the client sent with the upload.
+	ImageID        *string        `db:"image_id"`        // ID of the image made once the content was complete.
+	ExpiresAt      time.Time      `db:"expires_at"`      // When the upload is removed if it's left alone.
+	DateCreated    time.Time      `db:"date_created"`    // When the upload was created.
+	DateUpdated    time.Time      `db:"date_updated"`    // When content was last received.
+}
@@ -0,0 +1,62 @@
+package upload
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/upload/db"
+)
+
+// Upload represents image content being uploaded in chunks. Once every byte
+// has been received the content is stored as an image.
+type Upload struct {
+	ID             string            `json:"id"`              // Unique identifier.
+	UserID         string            `json:"user_id"`         // ID of the user uploading the content.
+	OrganizationID *string           `json:"organization_id"` // ID of the organization the image is uploaded under.
+	Length         int64             `json:"length"`          // Size of the whole content in bytes.
+	Offset         int64             `json:"offset"`          // Number of bytes received so far.
+	Metadata       map[string]string `json:"metadata"`        // Key value pairs the client sent with the upload.
+	ImageID        *string           `json:"image_id"`        // ID of the image made once the content was complete.
+	ExpiresAt      time.Time         `json:"expires_at"`      // When the upload is removed if it's left alone.
+	DateCreated    time.Time         `json:"date_created"`    // When the upload was created.
+	DateUpdated    time.Time         `json:"date_updated"`    // When content was last received.
+}
+
+// NewUpload is what we require from clients when starting an upload. The
+// visibility is given to the image made from the content.
+type NewUpload struct {
+	UserID         string            `json:"user_id" validate:"required"`
+	OrganizationID string            `json:"organization_id" validate:"omitempty,uuid4"`
+	Length         int64             `json:"length" validate:"gt=0"`
+	Visibility     string            `json:"visibility" validate:"omitempty,oneof=private organization public"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+// Checksum is the digest a client sends with a chunk so the chunk can be
+// verified before it's kept.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// =============================================================================
+
+func toUpload(dbUpl db.Upload) Upload {
+	upl := Upload{
+		ID:             dbUpl.ID,
+		UserID:         dbUpl.UserID,
+		OrganizationID: dbUpl.OrganizationID,
+		Length:         dbUpl.Length,
+		Offset:         dbUpl.Offset,
+		Metadata:       map[string]string{},
+		ImageID:        dbUpl.ImageID,
+		ExpiresAt:      dbUpl.ExpiresAt,
+		DateCreated:    dbUpl.DateCreated,
+		DateUpdated:    dbUpl.DateUpdated,
+	}
+
+	// The metadata is written by this package from a map of strings.
+	_ = json.Unmarshal(dbUpl.Metadata, &upl.Metadata)
+
+	return upl
+}
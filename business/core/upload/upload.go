@@ -0,0 +1,457 @@
+// Package upload provides the core business API for resumable uploads, where
+// the content of an image is sent in chunks so a dropped connection only
+// loses the chunk in flight.
+package upload
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/upload/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/fadhilijuma/images/foundation/web"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for CRUD operations.
+var (
+	ErrNotFound            = errors.New("upload not found")
+	ErrInvalidID           = errors.New("ID is not in its proper form")
+	ErrTooLarge            = errors.New("upload is larger than allowed")
+	ErrOffsetMismatch      = errors.New("offset doesn't match the upload")
+	ErrChecksumMismatch    = errors.New("chunk doesn't match its checksum")
+	ErrUnsupportedChecksum = errors.New("checksum algorithm is not supported")
+	ErrLocked              = errors.New("upload is receiving another chunk")
+	ErrExpired             = errors.New("upload has expired")
+	ErrInterrupted         = errors.New("chunk was interrupted")
+)
+
+// Set of algorithms chunks can be verified with.
+const (
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+)
+
+// ChecksumAlgorithms lists the algorithms chunks can be verified with.
+var ChecksumAlgorithms = []string{ChecksumSHA1, ChecksumSHA256}
+
+// Set of defaults used when the core isn't configured otherwise.
+const (
+	DefaultMaxSize = 1 << 30
+	DefaultTTL     = 24 * time.Hour
+)
+
+// expireBatch is the number of uploads expired between queries.
+const expireBatch = 100
+
+// ExpireQueue is the job queue abandoned uploads are expired from.
+const ExpireQueue = "upload-expiry"
+
+// Ingester declares the behavior required to store completed uploads as
+// images.
+type Ingester interface {
+	Create(ctx context.Context, ni image.NewImage, content io.Reader, now time.Time) (image.Image, error)
+}
+
+// Options represent optional parameters.
+type Options struct {
+	maxSize int64
+	ttl     time.Duration
+}
+
+// WithMaxSize sets the largest content that may be uploaded, in bytes.
+func WithMaxSize(maxSize int64) func(opts *Options) {
+	return func(opts *Options) {
+		opts.maxSize = maxSize
+	}
+}
+
+// WithTTL sets how long an upload is kept after it last received content.
+func WithTTL(ttl time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.ttl = ttl
+	}
+}
+
+// Core manages the set of APIs for upload access.
+type Core struct {
+	log     *zap.SugaredLogger
+	store   db.Store
+	images  Ingester
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+	locks   *lockSet
+}
+
+// NewCore constructs a core for upload api access. The chunks received are
+// kept in files under the specified directory until the upload completes.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, images Ingester, dir string, options ...func(opts *Options)) Core {
+	opts := Options{
+		maxSize: DefaultMaxSize,
+		ttl:     DefaultTTL,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	return Core{
+		log:     log,
+		store:   db.NewStore(log, sqlxDB),
+		images:  images,
+		dir:     dir,
+		maxSize: opts.maxSize,
+		ttl:     opts.ttl,
+		locks:   &lockSet{ids: make(map[string]struct{})},
+	}
+}
+
+// MaxSize returns the largest content that may be uploaded, in bytes.
+func (c Core) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Create starts an upload of content of the specified length. No content is
+// received yet; it's appended in chunks with Write.
+func (c Core) Create(ctx context.Context, nu NewUpload, now time.Time) (Upload, error) {
+	if err := validate.Check(nu); err != nil {
+		return Upload{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if nu.Length > c.maxSize {
+		return Upload{}, fmt.Errorf("%w: must be at most %d bytes", ErrTooLarge, c.maxSize)
+	}
+
+	metadata := make(map[string]string, len(nu.Metadata)+1)
+	for k, v := range nu.Metadata {
+		metadata[k] = v
+	}
+	if nu.Visibility != "" {
+		metadata["visibility"] = nu.Visibility
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return Upload{}, fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	dbUpl := db.Upload{
+		ID:          validate.GenerateID(),
+		UserID:      nu.UserID,
+		Length:      nu.Length,
+		Metadata:    encoded,
+		ExpiresAt:   now.Add(c.ttl),
+		DateCreated: now,
+		DateUpdated: now,
+	}
+	if nu.OrganizationID != "" {
+		dbUpl.OrganizationID = &nu.OrganizationID
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return Upload{}, fmt.Errorf("creating dir[%s]: %w", c.dir, err)
+	}
+
+	f, err := os.OpenFile(c.path(dbUpl.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return Upload{}, fmt.Errorf("creating chunk file: %w", err)
+	}
+	f.Close()
+
+	if err := c.store.Create(ctx, dbUpl); err != nil {
+		c.removeFile(ctx, dbUpl.ID)
+		return Upload{}, fmt.Errorf("create: %w", err)
+	}
+
+	return toUpload(dbUpl), nil
+}
+
+// QueryByID gets the upload identified by a given ID.
+func (c Core) QueryByID(ctx context.Context, uploadID string) (Upload, error) {
+	if err := validate.CheckID(uploadID); err != nil {
+		return Upload{}, ErrInvalidID
+	}
+
+	dbUpl, err := c.store.QueryByID(ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, fmt.Errorf("query: uploadID[%s]: %w", uploadID, err)
+	}
+
+	return toUpload(dbUpl), nil
+}
+
+// Write appends a chunk to the upload identified by a given ID. The offset
+// must be the number of bytes the upload has received so far. When a checksum
+// is given the chunk is only kept if it matches. Otherwise the bytes received
+// before the chunk was interrupted are kept, so the client resumes from there.
+//
+// Once every byte has been received the content is stored as an image. If
+// that fails, the error from the ingest is returned and the content is kept
+// so writing an empty chunk at the final offset tries again.
+func (c Core) Write(ctx context.Context, uploadID string, offset int64, chunk io.Reader, checksum *Checksum, now time.Time) (Upload, error) {
+	if err := validate.CheckID(uploadID); err != nil {
+		return Upload{}, ErrInvalidID
+	}
+
+	var h hash.Hash
+	if checksum != nil {
+		switch checksum.Algorithm {
+		case ChecksumSHA1:
+			h = sha1.New()
+		case ChecksumSHA256:
+			h = sha256.New()
+		default:
+			return Upload{}, ErrUnsupportedChecksum
+		}
+	}
+
+	if !c.locks.lock(uploadID) {
+		return Upload{}, ErrLocked
+	}
+	defer c.locks.unlock(uploadID)
+
+	dbUpl, err := c.store.QueryByID(ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Upload{}, ErrNotFound
+		}
+		return Upload{}, fmt.Errorf("query: uploadID[%s]: %w", uploadID, err)
+	}
+
+	if !now.Before(dbUpl.ExpiresAt) {
+		return Upload{}, ErrExpired
+	}
+
+	if offset != dbUpl.Offset {
+		return Upload{}, fmt.Errorf("%w: expected %d", ErrOffsetMismatch, dbUpl.Offset)
+	}
+
+	// The content has already been stored as an image.
+	if dbUpl.ImageID != nil {
+		return toUpload(dbUpl), nil
+	}
+
+	// Receiving a large chunk over a slow link takes a while, and the upload
+	// mustn't expire while it's in flight.
+	if err := c.store.Touch(ctx, uploadID, now.Add(c.ttl)); err != nil {
+		return Upload{}, fmt.Errorf("touch: %w", err)
+	}
+	dbUpl.ExpiresAt = now.Add(c.ttl)
+
+	f, err := os.OpenFile(c.path(uploadID), os.O_WRONLY, 0)
+	if err != nil {
+		return Upload{}, fmt.Errorf("opening chunk file: %w", err)
+	}
+	defer f.Close()
+
+	// Bytes past the offset are left over from a chunk that was never
+	// recorded, so they're dropped before appending.
+	if err := f.Truncate(offset); err != nil {
+		return Upload{}, fmt.Errorf("truncating chunk file: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return Upload{}, fmt.Errorf("seeking chunk file: %w", err)
+	}
+
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+
+	// Reading one byte more than remains tells a chunk running past the
+	// length apart from one that ends exactly on it.
+	remaining := dbUpl.Length - offset
+	n, copyErr := io.Copy(w, io.LimitReader(chunk, remaining+1))
+
+	switch {
+	case n > remaining:
+		f.Truncate(offset)
+		return Upload{}, fmt.Errorf("%w: chunk runs past the length of %d bytes", ErrTooLarge, dbUpl.Length)
+	case copyErr != nil && h != nil:
+		f.Truncate(offset)
+		return Upload{}, fmt.Errorf("%w: %v", ErrInterrupted, copyErr)
+	case copyErr == nil && h != nil && subtle.ConstantTimeCompare(h.Sum(nil), checksum.Sum) != 1:
+		f.Truncate(offset)
+		return Upload{}, ErrChecksumMismatch
+	}
+
+	if n > 0 {
+		if err := f.Sync(); err != nil {
+			f.Truncate(offset)
+			return Upload{}, fmt.Errorf("syncing chunk file: %w", err)
+		}
+
+		dbUpl.Offset = offset + n
+		dbUpl.DateUpdated = now
+
+		if err := c.store.UpdateOffset(ctx, dbUpl, offset); err != nil {
+			f.Truncate(offset)
+			if errors.Is(err, database.ErrDBNotFound) {
+				return Upload{}, ErrOffsetMismatch
+			}
+			return Upload{}, fmt.Errorf("update offset: %w", err)
+		}
+	}
+
+	if copyErr != nil {
+		return toUpload(dbUpl), fmt.Errorf("%w: %v", ErrInterrupted, copyErr)
+	}
+
+	if dbUpl.Offset == dbUpl.Length {
+		if err := c.ingest(ctx, &dbUpl, now); err != nil {
+			return toUpload(dbUpl), err
+		}
+	}
+
+	return toUpload(dbUpl), nil
+}
+
+// Delete stops the upload identified by a given ID and removes the content
+// received for it.
+func (c Core) Delete(ctx context.Context, uploadID string) error {
+	if err := validate.CheckID(uploadID); err != nil {
+		return ErrInvalidID
+	}
+
+	if !c.locks.lock(uploadID) {
+		return ErrLocked
+	}
+	defer c.locks.unlock(uploadID)
+
+	if err := c.store.Delete(ctx, uploadID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	c.removeFile(ctx, uploadID)
+
+	return nil
+}
+
+// Expire removes the uploads that expired before the specified time along
+// with the content received for them. Uploads receiving a chunk are left for
+// the next run. It returns the number of uploads removed.
+func (c Core) Expire(ctx context.Context, now time.Time) (int, error) {
+	var expired int
+	for {
+		dbUpls, err := c.store.QueryExpired(ctx, now, expireBatch)
+		if err != nil {
+			return expired, fmt.Errorf("query expired: %w", err)
+		}
+
+		var removed int
+		for _, dbUpl := range dbUpls {
+			if err := c.Delete(ctx, dbUpl.ID); err != nil {
+				if errors.Is(err, ErrLocked) {
+					continue
+				}
+				return expired, fmt.Errorf("expire uploadID[%s]: %w", dbUpl.ID, err)
+			}
+			removed++
+		}
+		expired += removed
+
+		// Removed uploads leave the query, so the next one picks up where
+		// this one stopped. Stop when only uploads in use are left.
+		if len(dbUpls) < expireBatch || removed == 0 {
+			return expired, nil
+		}
+	}
+}
+
+// =============================================================================
+
+// ingest stores the completed content of an upload as an image owned by the
+// user who uploaded it, recording the image on the upload.
+func (c Core) ingest(ctx context.Context, dbUpl *db.Upload, now time.Time) error {
+	f, err := os.Open(c.path(dbUpl.ID))
+	if err != nil {
+		return fmt.Errorf("opening chunk file: %w", err)
+	}
+	defer f.Close()
+
+	upl := toUpload(*dbUpl)
+
+	ni := image.NewImage{
+		UserID:     upl.UserID,
+		Visibility: upl.Metadata["visibility"],
+	}
+	if upl.OrganizationID != nil {
+		ni.OrganizationID = *upl.OrganizationID
+	}
+
+	img, err := c.images.Create(ctx, ni, f, now)
+	if err != nil {
+		return fmt.Errorf("ingest: %w", err)
+	}
+
+	if err := c.store.Complete(ctx, dbUpl.ID, img.ID, now); err != nil {
+		return fmt.Errorf("complete: %w", err)
+	}
+	dbUpl.ImageID = &img.ID
+	dbUpl.DateUpdated = now
+
+	// The content is in the blob store now.
+	c.removeFile(ctx, dbUpl.ID)
+
+	return nil
+}
+
+// path returns the name of the file holding the content received for the
+// upload identified by a given ID.
+func (c Core) path(uploadID string) string {
+	return filepath.Join(c.dir, uploadID)
+}
+
+// removeFile removes the content received for an upload. Failing to remove
+// it only wastes space, so the error is logged rather than returned.
+func (c Core) removeFile(ctx context.Context, uploadID string) {
+	if err := os.Remove(c.path(uploadID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.log.Errorw("remove chunk file", "traceid", web.GetTraceID(ctx), "uploadID", uploadID, "ERROR", err)
+	}
+}
+
+// lockSet holds the IDs of the uploads receiving a chunk, so two requests
+// can't append to the same upload at once.
+type lockSet struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+// lock claims the upload identified by a given ID, reporting false when it's
+// already claimed.
+func (ls *lockSet) lock(uploadID string) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if _, exists := ls.ids[uploadID]; exists {
+		return false
+	}
+	ls.ids[uploadID] = struct{}{}
+
+	return true
+}
+
+// unlock releases the upload identified by a given ID.
+func (ls *lockSet) unlock(uploadID string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	delete(ls.ids, uploadID)
+}
@@ -0,0 +1,192 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/upload"
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/foundation/docker"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+// ownerID is a user from the seed data.
+const ownerID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+
+func Test_Upload(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testupload")
+	t.Cleanup(teardown)
+
+	images := image.NewCore(log, db, blob.NewMemory(), image.WithPresets(nil))
+	core := upload.NewCore(log, db, images, t.TempDir(), upload.WithMaxSize(1<<20), upload.WithTTL(time.Hour))
+
+	t.Log("Given the need to upload images in chunks.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen resuming an upload.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+			content := dbtest.PNGContent(t, 24, 16)
+			half := int64(len(content) / 2)
+
+			nu := upload.NewUpload{
+				UserID:     ownerID,
+				Length:     int64(len(content)),
+				Visibility: image.VisibilityPublic,
+				Metadata:   map[string]string{"filename": "harbour.png"},
+			}
+			upl, err := core.Create(ctx, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an upload : %s.", dbtest.Failed, testID, err)
+			}
+			if upl.Offset != 0 || upl.Metadata["filename"] != "harbour.png" || upl.Metadata["visibility"] != image.VisibilityPublic {
+				t.Fatalf("\t%s\tTest %d:\tShould start with no content and the metadata : %+v.", dbtest.Failed, testID, upl)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create an upload.", dbtest.Success, testID)
+
+			if _, err := core.Create(ctx, upload.NewUpload{UserID: ownerID, Length: 2 << 20}, now); !errors.Is(err, upload.ErrTooLarge) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create an upload over the maximum size : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create an upload over the maximum size.", dbtest.Success, testID)
+
+			// The connection drops partway through the first half.
+			dropped := io.MultiReader(bytes.NewReader(content[:half/2]), iotest.ErrReader(errors.New("connection reset")))
+			upl, err = core.Write(ctx, upl.ID, 0, dropped, nil, now)
+			if !errors.Is(err, upload.ErrInterrupted) {
+				t.Fatalf("\t%s\tTest %d:\tShould report the interrupted chunk : %v.", dbtest.Failed, testID, err)
+			}
+			if upl.Offset != half/2 {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the bytes received before the drop : got %d, exp %d.", dbtest.Failed, testID, upl.Offset, half/2)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the bytes received before the drop.", dbtest.Success, testID)
+
+			if _, err := core.Write(ctx, upl.ID, 0, bytes.NewReader(content[:half]), nil, now); !errors.Is(err, upload.ErrOffsetMismatch) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to write at the wrong offset : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to write at the wrong offset.", dbtest.Success, testID)
+
+			bad := upload.Checksum{Algorithm: upload.ChecksumSHA256, Sum: make([]byte, sha256.Size)}
+			if _, err := core.Write(ctx, upl.ID, upl.Offset, bytes.NewReader(content[upl.Offset:half]), &bad, now); !errors.Is(err, upload.ErrChecksumMismatch) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT keep a chunk that doesn't match its checksum : %v.", dbtest.Failed, testID, err)
+			}
+			if got, err := core.QueryByID(ctx, upl.ID); err != nil || got.Offset != upl.Offset {
+				t.Fatalf("\t%s\tTest %d:\tShould leave the offset as it was : %+v : %v.", dbtest.Failed, testID, got, err)
+			}
+			unknown := upload.Checksum{Algorithm: "crc32"}
+			if _, err := core.Write(ctx, upl.ID, upl.Offset, bytes.NewReader(content[upl.Offset:half]), &unknown, now); !errors.Is(err, upload.ErrUnsupportedChecksum) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept an unknown checksum algorithm : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT keep a chunk that doesn't match its checksum.", dbtest.Success, testID)
+
+			if _, err := core.Write(ctx, upl.ID, upl.Offset, bytes.NewReader(content[upl.Offset:]), nil, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write the rest : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to resume from the offset.", dbtest.Success, testID)
+
+			upl, err = core.QueryByID(ctx, upl.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the upload : %s.", dbtest.Failed, testID, err)
+			}
+			if upl.Offset != upl.Length || upl.ImageID == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould complete the upload as an image : %+v.", dbtest.Failed, testID, upl)
+			}
+
+			img, err := images.QueryByID(ctx, *upl.ImageID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the image : %s.", dbtest.Failed, testID, err)
+			}
+			if img.UserID != ownerID || img.Size != int64(len(content)) || img.Visibility != image.VisibilityPublic {
+				t.Fatalf("\t%s\tTest %d:\tShould store the whole content for the uploader : %+v.", dbtest.Failed, testID, img)
+			}
+			t.Logf("\t%s\tTest %d:\tShould complete the upload as an image.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen verifying, stopping and expiring uploads.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.June, 2, 0, 0, 0, 0, time.UTC)
+
+			content := dbtest.PNGContent(t, 30, 12)
+			sum := sha256.Sum256(content)
+
+			upl, err := core.Create(ctx, upload.NewUpload{UserID: ownerID, Length: int64(len(content))}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an upload : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, err := core.Write(ctx, upl.ID, 0, bytes.NewReader(append(content, 0)), nil, now); !errors.Is(err, upload.ErrTooLarge) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to write past the length : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to write past the length.", dbtest.Success, testID)
+
+			checksum := upload.Checksum{Algorithm: upload.ChecksumSHA256, Sum: sum[:]}
+			upl, err = core.Write(ctx, upl.ID, 0, bytes.NewReader(content), &checksum, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write a chunk matching its checksum : %s.", dbtest.Failed, testID, err)
+			}
+			if upl.ImageID == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould complete the upload in one chunk : %+v.", dbtest.Failed, testID, upl)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to write a chunk matching its checksum.", dbtest.Success, testID)
+
+			stopped, err := core.Create(ctx, upload.NewUpload{UserID: ownerID, Length: 100}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an upload : %s.", dbtest.Failed, testID, err)
+			}
+			if err := core.Delete(ctx, stopped.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to stop an upload : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryByID(ctx, stopped.ID); !errors.Is(err, upload.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve a stopped upload : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to stop an upload.", dbtest.Success, testID)
+
+			abandoned, err := core.Create(ctx, upload.NewUpload{UserID: ownerID, Length: 100}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an upload : %s.", dbtest.Failed, testID, err)
+			}
+
+			later := now.Add(2 * time.Hour)
+			if _, err := core.Write(ctx, abandoned.ID, 0, bytes.NewReader(content[:10]), nil, later); !errors.Is(err, upload.ErrExpired) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to write to an expired upload : %v.", dbtest.Failed, testID, err)
+			}
+
+			expired, err := core.Expire(ctx, later)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to expire uploads : %s.", dbtest.Failed, testID, err)
+			}
+			if expired < 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould expire the uploads past their TTL : got %d.", dbtest.Failed, testID, expired)
+			}
+			if _, err := core.QueryByID(ctx, abandoned.ID); !errors.Is(err, upload.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve an expired upload : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould expire the uploads past their TTL.", dbtest.Success, testID)
+		}
+	}
+}
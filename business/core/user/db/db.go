@@ -2,8 +2,10 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/fadhilijuma/images/business/sys/database"
 	"github.com/jmoiron/sqlx"
@@ -49,9 +51,9 @@ func (s Store) Tran(tx sqlx.ExtContext) Store {
 func (s Store) Create(ctx context.Context, usr User) error {
 	const q = `
 	INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
+		(user_id, name, email, password_hash, roles, organization_id, date_created, date_updated, version)
 	VALUES
-		(:user_id, :name, :email, :password_hash, :roles, :date_created, :date_updated)`
+		(:user_id, :name, :email, :password_hash, :roles, :organization_id, :date_created, :date_updated, :version)`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, usr); err != nil {
 		return fmt.Errorf("inserting user: %w", err)
@@ -70,7 +72,9 @@ func (s Store) Update(ctx context.Context, usr User) error {
 		"email" = :email,
 		"roles" = :roles,
 		"password_hash" = :password_hash,
-		"date_updated" = :date_updated
+		"organization_id" = :organization_id,
+		"date_updated" = :date_updated,
+		"version" = :version
 	WHERE
 		user_id = :user_id`
 
@@ -81,6 +85,35 @@ func (s Store) Update(ctx context.Context, usr User) error {
 	return nil
 }
 
+// Lock locks the row of the User identified by a given ID until the
+// surrounding transaction ends, so changes to the user are made one at a
+// time.
+func (s Store) Lock(ctx context.Context, userID string) error {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		user_id
+	FROM
+		users
+	WHERE
+		user_id = :user_id
+	FOR UPDATE`
+
+	var row struct {
+		UserID string `db:"user_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &row); err != nil {
+		return fmt.Errorf("locking user userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
 // Delete removes a user from the database.
 func (s Store) Delete(ctx context.Context, userID string) error {
 	data := struct {
@@ -129,6 +162,52 @@ func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Us
 	return usrs, nil
 }
 
+// QueryPage retrieves a page of the users that match the filter.
+func (s Store) QueryPage(ctx context.Context, filter QueryFilter, keyset database.Keyset) ([]User, error) {
+	data := make(map[string]any)
+
+	const q = `
+	SELECT
+		*
+	FROM
+		users`
+
+	var wc []string
+
+	if filter.Role != nil {
+		data["role"] = *filter.Role
+		wc = append(wc, ":role = ANY(roles)")
+	}
+
+	if filter.CreatedAfter != nil {
+		data["created_after"] = *filter.CreatedAfter
+		wc = append(wc, "date_created >= :created_after")
+	}
+
+	if filter.CreatedBefore != nil {
+		data["created_before"] = *filter.CreatedBefore
+		wc = append(wc, "date_created < :created_before")
+	}
+
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(q)
+	if len(wc) > 0 {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	}
+	buf.WriteString(keyset.OrderBy(data))
+
+	var usrs []User
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &usrs); err != nil {
+		return nil, fmt.Errorf("selecting users: %w", err)
+	}
+
+	return usrs, nil
+}
+
 // QueryByID gets the specified user from the database.
 func (s Store) QueryByID(ctx context.Context, userID string) (User, error) {
 	data := struct {
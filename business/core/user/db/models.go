@@ -9,11 +9,21 @@ import (
 // User represent the structure we need for moving data
 // between the app and the database.
 type User struct {
-	ID           string         `db:"user_id"`
-	Name         string         `db:"name"`
-	Email        string         `db:"email"`
-	Roles        pq.StringArray `db:"roles"`
-	PasswordHash []byte         `db:"password_hash"`
-	DateCreated  time.Time      `db:"date_created"`
-	DateUpdated  time.Time      `db:"date_updated"`
+	ID             string         `db:"user_id"`
+	Name           string         `db:"name"`
+	Email          string         `db:"email"`
+	Roles          pq.StringArray `db:"roles"`
+	PasswordHash   []byte         `db:"password_hash"`
+	DateCreated    time.Time      `db:"date_created"`
+	DateUpdated    time.Time      `db:"date_updated"`
+	OrganizationID *string        `db:"organization_id"`
+	Version        int            `db:"version"`
+}
+
+// QueryFilter holds the available fields a query of users can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	Role          *string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
 }
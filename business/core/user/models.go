@@ -9,13 +9,15 @@ import (
 
 // User represents an individual user.
 type User struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Email        string    `json:"email"`
-	Roles        []string  `json:"roles"`
-	PasswordHash []byte    `json:"-"`
-	DateCreated  time.Time `json:"date_created"`
-	DateUpdated  time.Time `json:"date_updated"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Roles          []string  `json:"roles"`
+	PasswordHash   []byte    `json:"-"`
+	DateCreated    time.Time `json:"date_created"`
+	DateUpdated    time.Time `json:"date_updated"`
+	OrganizationID *string   `json:"organization_id,omitempty"`
+	Version        int       `json:"version"`
 }
 
 // NewUser contains information needed to create a new User.
@@ -25,6 +27,15 @@ type NewUser struct {
 	Roles           []string `json:"roles" validate:"required"`
 	Password        string   `json:"password" validate:"required"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
+	OrganizationID  *string  `json:"organization_id" validate:"omitempty,uuid4"`
+}
+
+// QueryFilter holds the available fields a query of users can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	Role          *string    `json:"role" validate:"omitempty,min=1"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
 }
 
 // UpdateUser defines what information may be provided to modify an existing
@@ -39,6 +50,11 @@ type UpdateUser struct {
 	Roles           []string `json:"roles"`
 	Password        *string  `json:"password"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
+	OrganizationID  *string  `json:"organization_id" validate:"omitempty,uuid4"`
+
+	// Version, when set, is the version of the user the change was made
+	// against. The change is refused if the user has changed since.
+	Version *int `json:"-"`
 }
 
 // =============================================================================
@@ -1,6 +1,6 @@
-// Package user provides an example of a core business API. Right now these
-// calls are just wrapping the data/data layer. But at some point you will
-// want auditing or something that isn't specific to the data/store layer.
+// Package user provides the core business API for users. Beyond the
+// data/store layer, every change to a user is recorded in the audit log and
+// the events outbox as part of the transaction making it.
 package user
 
 import (
@@ -9,10 +9,15 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/fadhilijuma/images/business/core/audit"
+	"github.com/fadhilijuma/images/business/core/image"
 	"github.com/fadhilijuma/images/business/core/user/db"
 	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/business/sys/paging"
 	"github.com/fadhilijuma/images/business/sys/validate"
 	"github.com/fadhilijuma/images/business/web/auth"
+	"github.com/fadhilijuma/images/foundation/web"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
@@ -25,18 +30,59 @@ var (
 	ErrInvalidID             = errors.New("ID is not in its proper form")
 	ErrInvalidEmail          = errors.New("email is not valid")
 	ErrUniqueEmail           = errors.New("email is not unique")
+	ErrConflict              = errors.New("user has changed since the version specified")
 	ErrAuthenticationFailure = errors.New("authentication failed")
 )
 
+// Set of events reported about users.
+const (
+	EventCreated = "user.created"
+	EventUpdated = "user.updated"
+	EventDeleted = "user.deleted"
+)
+
+// Notifier declares the behavior required to tell others about changes to
+// users, such as the webhooks subscribed to them.
+type Notifier interface {
+	Notify(ctx context.Context, event string, userID string, data any, now time.Time) error
+}
+
+// Options represent optional parameters.
+type Options struct {
+	notifier Notifier
+}
+
+// WithNotifier sets who is told about changes to users.
+func WithNotifier(notifier Notifier) func(opts *Options) {
+	return func(opts *Options) {
+		opts.notifier = notifier
+	}
+}
+
 // Core manages the set of APIs for user access.
 type Core struct {
-	store db.Store
+	log      *zap.SugaredLogger
+	store    db.Store
+	audit    audit.Core
+	outbox   outbox.Store
+	images   image.Core
+	notifier Notifier
 }
 
 // NewCore constructs a core for user api access.
-func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB) Core {
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, options ...func(opts *Options)) Core {
+	var opts Options
+	for _, option := range options {
+		option(&opts)
+	}
+
 	return Core{
-		store: db.NewStore(log, sqlxDB),
+		log:      log,
+		store:    db.NewStore(log, sqlxDB),
+		audit:    audit.NewCore(log, sqlxDB),
+		outbox:   outbox.NewStore(log, sqlxDB),
+		images:   image.NewCore(log, sqlxDB, nil, image.WithPresets(nil)),
+		notifier: opts.notifier,
 	}
 }
 
@@ -52,13 +98,15 @@ func (c Core) Create(ctx context.Context, nu NewUser, now time.Time) (User, erro
 	}
 
 	dbUsr := db.User{
-		ID:           validate.GenerateID(),
-		Name:         nu.Name,
-		Email:        nu.Email,
-		PasswordHash: hash,
-		Roles:        nu.Roles,
-		DateCreated:  now,
-		DateUpdated:  now,
+		ID:             validate.GenerateID(),
+		Name:           nu.Name,
+		Email:          nu.Email,
+		PasswordHash:   hash,
+		Roles:          nu.Roles,
+		DateCreated:    now,
+		DateUpdated:    now,
+		OrganizationID: nu.OrganizationID,
+		Version:        1,
 	}
 
 	// This provides an example of how to execute a transaction if required.
@@ -69,17 +117,19 @@ func (c Core) Create(ctx context.Context, nu NewUser, now time.Time) (User, erro
 			}
 			return fmt.Errorf("create: %w", err)
 		}
-		return nil
+		return c.record(ctx, tx, EventCreated, nil, dbUsr, now)
 	}
 
 	if err := c.store.WithinTran(ctx, tran); err != nil {
 		return User{}, fmt.Errorf("tran: %w", err)
 	}
+	c.notify(ctx, EventCreated, dbUsr, now)
 
 	return toUser(dbUsr), nil
 }
 
-// Update replaces a user document in the database.
+// Update replaces a user document in the database. It will error with
+// ErrConflict if a version is specified and the user has changed since.
 func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time.Time) error {
 	if err := validate.CheckID(userID); err != nil {
 		return ErrInvalidID
@@ -89,56 +139,146 @@ func (c Core) Update(ctx context.Context, userID string, uu UpdateUser, now time
 		return fmt.Errorf("validating data: %w", err)
 	}
 
-	dbUsr, err := c.store.QueryByID(ctx, userID)
-	if err != nil {
-		if errors.Is(err, database.ErrDBNotFound) {
-			return ErrNotFound
-		}
-		return fmt.Errorf("updating user userID[%s]: %w", userID, err)
-	}
-
-	if uu.Name != nil {
-		dbUsr.Name = *uu.Name
-	}
-	if uu.Email != nil {
-		dbUsr.Email = *uu.Email
-	}
-	if uu.Roles != nil {
-		dbUsr.Roles = uu.Roles
-	}
+	// Hashing is slow, so it's done before the user is locked.
+	var pw []byte
 	if uu.Password != nil {
-		pw, err := bcrypt.GenerateFromPassword([]byte(*uu.Password), bcrypt.DefaultCost)
+		var err error
+		pw, err = bcrypt.GenerateFromPassword([]byte(*uu.Password), bcrypt.DefaultCost)
 		if err != nil {
 			return fmt.Errorf("generating password hash: %w", err)
 		}
-		dbUsr.PasswordHash = pw
 	}
-	dbUsr.DateUpdated = now
 
-	if err := c.store.Update(ctx, dbUsr); err != nil {
-		if errors.Is(err, database.ErrDBDuplicatedEntry) {
-			return fmt.Errorf("updating user userID[%s]: %w", userID, ErrUniqueEmail)
+	var dbUsr db.User
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		if err := store.Lock(ctx, userID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return ErrNotFound
+			}
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		var err error
+		dbUsr, err = store.QueryByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+		before := dbUsr
+
+		// The user is locked, so no one else can change them between this
+		// check and the commit.
+		if uu.Version != nil && *uu.Version != dbUsr.Version {
+			return ErrConflict
 		}
-		return fmt.Errorf("update: %w", err)
+		dbUsr.Version++
+
+		if uu.Name != nil {
+			dbUsr.Name = *uu.Name
+		}
+		if uu.Email != nil {
+			dbUsr.Email = *uu.Email
+		}
+		if uu.Roles != nil {
+			dbUsr.Roles = uu.Roles
+		}
+		if pw != nil {
+			dbUsr.PasswordHash = pw
+		}
+		if uu.OrganizationID != nil {
+			dbUsr.OrganizationID = uu.OrganizationID
+		}
+		dbUsr.DateUpdated = now
+
+		if err := store.Update(ctx, dbUsr); err != nil {
+			if errors.Is(err, database.ErrDBDuplicatedEntry) {
+				return fmt.Errorf("update: %w", ErrUniqueEmail)
+			}
+			return fmt.Errorf("update: %w", err)
+		}
+		return c.record(ctx, tx, EventUpdated, &before, dbUsr, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("updating user userID[%s]: %w", userID, err)
 	}
+	c.notify(ctx, EventUpdated, dbUsr, now)
 
 	return nil
 }
 
-// Delete removes a user from the database.
-func (c Core) Delete(ctx context.Context, userID string) error {
+// Delete removes a user from the database on behalf of the specified user.
+// The user's images are moved to the trash rather than removed with them, and
+// stop counting against the quota of their organization. Each image is
+// reported as deleted, the same as when it's deleted on its own.
+func (c Core) Delete(ctx context.Context, userID string, actorID string, now time.Time) error {
 	if err := validate.CheckID(userID); err != nil {
 		return ErrInvalidID
 	}
 
-	if err := c.store.Delete(ctx, userID); err != nil {
-		return fmt.Errorf("delete: %w", err)
+	if err := validate.CheckID(actorID); err != nil {
+		return ErrInvalidID
+	}
+
+	var dbUsr db.User
+	var imgs []image.Image
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+
+		// The user is locked before the images are recorded, in the same
+		// order an update takes its locks. A user already gone leaves
+		// nothing to do.
+		if err := store.Lock(ctx, userID); err != nil {
+			if errors.Is(err, database.ErrDBNotFound) {
+				return nil
+			}
+			return fmt.Errorf("lock: %w", err)
+		}
+
+		var err error
+		dbUsr, err = store.QueryByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("query: %w", err)
+		}
+
+		if err := c.images.ReleaseUsage(ctx, tx, userID, now); err != nil {
+			return fmt.Errorf("release usage: %w", err)
+		}
+
+		imgs, err = c.images.TrashOwnedBy(ctx, tx, userID, actorID, now)
+		if err != nil {
+			return fmt.Errorf("trash images: %w", err)
+		}
+
+		if err := store.Delete(ctx, userID); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+
+		return c.record(ctx, tx, EventDeleted, &dbUsr, dbUsr, now)
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	if dbUsr.ID != "" {
+		c.notify(ctx, EventDeleted, dbUsr, now)
+	}
+
+	if c.notifier != nil {
+		for _, img := range imgs {
+			if err := c.notifier.Notify(ctx, image.EventDeleted, img.UserID, img, now); err != nil {
+				c.log.Errorw("notify", "traceid", web.GetTraceID(ctx), "event", image.EventDeleted, "imageID", img.ID, "ERROR", err)
+			}
+		}
 	}
 
 	return nil
 }
 
-// Query retrieves a list of existing users from the database.
+// Query retrieves a list of existing users from the database using offset
+// paging. It's kept for admin tooling; clients page with QueryPage.
 func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]User, error) {
 	dbUsers, err := c.store.Query(ctx, pageNumber, rowsPerPage)
 	if err != nil {
@@ -148,6 +288,56 @@ func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Use
 	return toUserSlice(dbUsers), nil
 }
 
+// QueryPage retrieves a page of the users that match the filter. Users can be
+// sorted by date_created, id or email. It returns the cursor for the next
+// page, which is empty when there are no more users.
+func (c Core) QueryPage(ctx context.Context, filter QueryFilter, page paging.Page) ([]User, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "date_created", "date_created", "id", "email")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   sortColumns[order.Field],
+		IDColumn: "user_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbUsers, err := c.store.QueryPage(ctx, db.QueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	// One more row than the limit was asked for to learn if there's a
+	// next page without a count.
+	var next string
+	if len(dbUsers) > limit {
+		dbUsers = dbUsers[:limit]
+		last := dbUsers[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last), last.ID)
+	}
+
+	return toUserSlice(dbUsers), next, nil
+}
+
 // QueryByID gets the specified user from the database.
 func (c Core) QueryByID(ctx context.Context, userID string) (User, error) {
 	if err := validate.CheckID(userID); err != nil {
@@ -213,6 +403,92 @@ func (c Core) Authenticate(ctx context.Context, now time.Time, email, password s
 		},
 		Roles: dbUsr.Roles,
 	}
+	if dbUsr.OrganizationID != nil {
+		claims.OrganizationID = *dbUsr.OrganizationID
+	}
 
 	return claims, nil
 }
+
+// record appends a change to a user to the audit log and its event to the
+// outbox, as part of the transaction making the change. The user before the
+// change is nil when they were created. It's the last thing the transaction
+// does, since the audit log and the outbox hold a lock until the commit.
+func (c Core) record(ctx context.Context, tx sqlx.ExtContext, event string, before *db.User, dbUsr db.User, now time.Time) error {
+	usr := toUser(dbUsr)
+
+	entry := audit.NewEntry{
+		Action:   audit.ActionUpdate,
+		Entity:   audit.EntityUser,
+		EntityID: dbUsr.ID,
+		After:    usr,
+	}
+	switch event {
+	case EventCreated:
+		entry.Action = audit.ActionCreate
+	case EventDeleted:
+		entry.Action = audit.ActionDelete
+		entry.After = nil
+	}
+	if before != nil {
+		entry.Before = toUser(*before)
+	}
+
+	if err := c.audit.Record(ctx, tx, entry, now); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
+	ne := outbox.NewEvent{
+		Event:  event,
+		UserID: dbUsr.ID,
+		Data:   usr,
+	}
+	if err := c.outbox.Tran(tx).Append(ctx, ne, now); err != nil {
+		return fmt.Errorf("append event[%s]: %w", event, err)
+	}
+	return nil
+}
+
+// notify tells the notifier about a change to a user once it's committed.
+// The change has already been made, so failing to report it is only logged.
+func (c Core) notify(ctx context.Context, event string, dbUsr db.User, now time.Time) {
+	if c.notifier == nil {
+		return
+	}
+
+	if err := c.notifier.Notify(ctx, event, dbUsr.ID, toUser(dbUsr), now); err != nil {
+		c.log.Errorw("notify", "traceid", web.GetTraceID(ctx), "event", event, "userID", dbUsr.ID, "ERROR", err)
+	}
+}
+
+// =============================================================================
+
+// sortColumns maps the fields users can be sorted on to their columns.
+var sortColumns = map[string]string{
+	"date_created": "date_created",
+	"id":           "user_id",
+	"email":        "email",
+}
+
+// sortKey returns the value of the sort field of a user as held in a cursor.
+func sortKey(field string, dbUsr db.User) string {
+	switch field {
+	case "date_created":
+		return dbUsr.DateCreated.UTC().Format(time.RFC3339Nano)
+	case "email":
+		return dbUsr.Email
+	}
+	return ""
+}
+
+// parseSortKey converts the sort key held in a cursor back to the value of
+// the sort field.
+func parseSortKey(field string, key string) (any, error) {
+	switch field {
+	case "date_created":
+		return time.Parse(time.RFC3339Nano, key)
+	case "email":
+		return key, nil
+	}
+	return nil, nil
+}
@@ -100,7 +100,30 @@ func Test_User(t *testing.T) {
 				t.Logf("\t%s\tTest %d:\tShould be able to see updates to Email.", dbtest.Success, testID)
 			}
 
-			if err := core.Delete(ctx, usr.ID); err != nil {
+			if saved.Version != usr.Version+1 {
+				t.Fatalf("\t%s\tTest %d:\tShould get the next version after an update : got %d.", dbtest.Failed, testID, saved.Version)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the next version after an update.", dbtest.Success, testID)
+
+			stale := user.UpdateUser{
+				Name:    dbtest.StringPointer("Jacob Walker"),
+				Version: &usr.Version,
+			}
+			if err := core.Update(ctx, usr.ID, stale, now); !errors.Is(err, user.ErrConflict) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to update an older version : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to update an older version.", dbtest.Success, testID)
+
+			current := user.UpdateUser{
+				Name:    dbtest.StringPointer("Jacob Walker"),
+				Version: &saved.Version,
+			}
+			if err := core.Update(ctx, usr.ID, current, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the current version : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update the current version.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, usr.ID, "5cf37266-3473-4006-984f-9325122678b7", now); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to delete user : %s.", dbtest.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to delete user.", dbtest.Success, testID)
@@ -0,0 +1,295 @@
+// Package db contains webhook related CRUD functionality.
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for webhook access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a data for api access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Create adds a Webhook to the database.
+func (s Store) Create(ctx context.Context, wh Webhook) error {
+	const q = `
+	INSERT INTO webhooks
+		(webhook_id, user_id, url, events, secret, scope, active, date_created, date_updated)
+	VALUES
+		(:webhook_id, :user_id, :url, :events, :secret, :scope, :active, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, wh); err != nil {
+		return fmt.Errorf("inserting webhook: %w", err)
+	}
+
+	return nil
+}
+
+// Update modifies the details of a Webhook.
+func (s Store) Update(ctx context.Context, wh Webhook) error {
+	const q = `
+	UPDATE
+		webhooks
+	SET
+		"url" = :url,
+		"events" = :events,
+		"secret" = :secret,
+		"scope" = :scope,
+		"active" = :active,
+		"date_updated" = :date_updated
+	WHERE
+		webhook_id = :webhook_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, wh); err != nil {
+		return fmt.Errorf("updating webhook webhookID[%s]: %w", wh.ID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the Webhook identified by a given ID along with its
+// deliveries.
+func (s Store) Delete(ctx context.Context, webhookID string) error {
+	data := struct {
+		WebhookID string `db:"webhook_id"`
+	}{
+		WebhookID: webhookID,
+	}
+
+	const q = `
+	DELETE FROM
+		webhooks
+	WHERE
+		webhook_id = :webhook_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting webhook webhookID[%s]: %w", webhookID, err)
+	}
+
+	return nil
+}
+
+// QueryPage gets the webhooks that match the filter, a page at a time.
+func (s Store) QueryPage(ctx context.Context, filter QueryFilter, keyset database.Keyset) ([]Webhook, error) {
+	data := make(map[string]any)
+
+	var wc []string
+
+	if filter.UserID != nil {
+		data["user_id"] = *filter.UserID
+		wc = append(wc, "user_id = :user_id")
+	}
+
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(`
+	SELECT
+		*
+	FROM
+		webhooks`)
+	if len(wc) > 0 {
+		buf.WriteString("\n\tWHERE\n\t\t")
+		buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	}
+	buf.WriteString(keyset.OrderBy(data))
+
+	var whs []Webhook
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &whs); err != nil {
+		return nil, fmt.Errorf("selecting webhooks: %w", err)
+	}
+
+	return whs, nil
+}
+
+// QueryByID finds the Webhook identified by a given ID.
+func (s Store) QueryByID(ctx context.Context, webhookID string) (Webhook, error) {
+	data := struct {
+		WebhookID string `db:"webhook_id"`
+	}{
+		WebhookID: webhookID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhooks
+	WHERE
+		webhook_id = :webhook_id`
+
+	var wh Webhook
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &wh); err != nil {
+		return Webhook{}, fmt.Errorf("selecting webhook webhookID[%q]: %w", webhookID, err)
+	}
+
+	return wh, nil
+}
+
+// QuerySubscribed finds the active webhooks subscribed to the event. Those
+// scoped to their owner only see events about things the specified user
+// owns.
+func (s Store) QuerySubscribed(ctx context.Context, event string, userID string) ([]Webhook, error) {
+	data := struct {
+		Event  string `db:"event"`
+		UserID string `db:"user_id"`
+	}{
+		Event:  event,
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		webhooks
+	WHERE
+		active AND
+		(cardinality(events) = 0 OR :event = ANY(events)) AND
+		(scope = 'all' OR user_id = :user_id)`
+
+	var whs []Webhook
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &whs); err != nil {
+		return nil, fmt.Errorf("selecting webhooks for event[%s]: %w", event, err)
+	}
+
+	return whs, nil
+}
+
+// =============================================================================
+
+// CreateDelivery adds a Delivery to the database.
+func (s Store) CreateDelivery(ctx context.Context, dlv Delivery) error {
+	const q = `
+	INSERT INTO webhook_deliveries
+		(delivery_id, webhook_id, event, payload, status, attempts, traceparent, date_created, date_updated)
+	VALUES
+		(:delivery_id, :webhook_id, :event, :payload, :status, :attempts, :traceparent, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, dlv); err != nil {
+		return fmt.Errorf("inserting delivery: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateDelivery records the outcome of an attempt at a Delivery.
+func (s Store) UpdateDelivery(ctx context.Context, dlv Delivery) error {
+	const q = `
+	UPDATE
+		webhook_deliveries
+	SET
+		"status" = :status,
+		"attempts" = :attempts,
+		"response_status" = :response_status,
+		"last_error" = :last_error,
+		"date_updated" = :date_updated
+	WHERE
+		delivery_id = :delivery_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, dlv); err != nil {
+		return fmt.Errorf("updating delivery deliveryID[%s]: %w", dlv.ID, err)
+	}
+
+	return nil
+}
+
+// QueryTarget finds the Delivery identified by a given ID along with the
+// webhook it's posted to.
+func (s Store) QueryTarget(ctx context.Context, deliveryID string) (Target, error) {
+	data := struct {
+		DeliveryID string `db:"delivery_id"`
+	}{
+		DeliveryID: deliveryID,
+	}
+
+	const q = `
+	SELECT
+		d.*, w.url, w.secret, w.active
+	FROM
+		webhook_deliveries AS d
+	JOIN
+		webhooks AS w ON w.webhook_id = d.webhook_id
+	WHERE
+		d.delivery_id = :delivery_id`
+
+	var tgt Target
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &tgt); err != nil {
+		return Target{}, fmt.Errorf("selecting delivery deliveryID[%q]: %w", deliveryID, err)
+	}
+
+	return tgt, nil
+}
+
+// QueryDeliveries gets the deliveries made to a webhook, a page at a time.
+func (s Store) QueryDeliveries(ctx context.Context, webhookID string, status *string, keyset database.Keyset) ([]Delivery, error) {
+	data := map[string]any{
+		"webhook_id": webhookID,
+	}
+
+	wc := []string{"webhook_id = :webhook_id"}
+
+	if status != nil {
+		data["status"] = *status
+		wc = append(wc, "status = :status")
+	}
+
+	if where := keyset.Where(data); where != "" {
+		wc = append(wc, where)
+	}
+
+	buf := bytes.NewBufferString(`
+	SELECT
+		*
+	FROM
+		webhook_deliveries
+	WHERE
+		`)
+	buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+	buf.WriteString(keyset.OrderBy(data))
+
+	var dlvs []Delivery
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &dlvs); err != nil {
+		return nil, fmt.Errorf("selecting deliveries for webhookID[%s]: %w", webhookID, err)
+	}
+
+	return dlvs, nil
+}
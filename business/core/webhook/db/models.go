@@ -0,0 +1,50 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+// Webhook represents a subscription to the events of the system.
+type Webhook struct {
+	ID          string         `db:"webhook_id"`   // Unique identifier.
+	UserID      string         `db:"user_id"`      // ID of the user who owns the webhook.
+	URL         string         `db:"url"`          // Where deliveries are posted.
+	Events      pq.StringArray `db:"events"`       // Events delivered; every event when empty.
+	Secret      string         `db:"secret"`       // Key deliveries are signed with.
+	Scope       string         `db:"scope"`        // Whose changes are delivered.
+	Active      bool           `db:"active"`       // Whether events are delivered at all.
+	DateCreated time.Time      `db:"date_created"` // When the webhook was created.
+	DateUpdated time.Time      `db:"date_updated"` // When the webhook was last modified.
+}
+
+// QueryFilter holds the available fields a query of webhooks can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	UserID *string
+}
+
+// Delivery represents an event posted, or to be posted, to a webhook.
+type Delivery struct {
+	ID             string         `db:"delivery_id"`     // Unique identifier.
+	WebhookID      string         `db:"webhook_id"`      // ID of the webhook delivered to.
+	Event          string         `db:"event"`           // Name of the event.
+	Payload        types.JSONText `db:"payload"`         // Body posted to the webhook.
+	Status         string         `db:"status"`          // Where the delivery is in its life.
+	Attempts       int            `db:"attempts"`        // Number of times the body has been posted.
+	ResponseStatus *int           `db:"response_status"` // Status code of the last response.
+	LastError      *string        `db:"last_error"`      // Error from the last failed attempt.
+	Traceparent    *string        `db:"traceparent"`     // W3C trace context of the request that caused the event.
+	DateCreated    time.Time      `db:"date_created"`    // When the event happened.
+	DateUpdated    time.Time      `db:"date_updated"`    // When the delivery last changed.
+}
+
+// Target is a delivery along with the webhook it's posted to.
+type Target struct {
+	Delivery
+	URL    string `db:"url"`    // Where the delivery is posted.
+	Secret string `db:"secret"` // Key the delivery is signed with.
+	Active bool   `db:"active"` // Whether the webhook still takes deliveries.
+}
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/webhook/db"
+)
+
+// Webhook represents a subscription to the events of the system, delivered
+// by posting them to a URL.
+type Webhook struct {
+	ID          string    `json:"id"`               // Unique identifier.
+	UserID      string    `json:"user_id"`          // User who owns the webhook.
+	URL         string    `json:"url"`              // Where deliveries are posted.
+	Events      []string  `json:"events"`           // Events delivered; every event when empty.
+	Secret      string    `json:"secret,omitempty"` // Key deliveries are signed with, only shown when it's set.
+	Scope       string    `json:"scope"`            // Whose changes are delivered.
+	Active      bool      `json:"active"`           // Whether events are delivered at all.
+	DateCreated time.Time `json:"date_created"`     // When the webhook was created.
+	DateUpdated time.Time `json:"date_updated"`     // When the webhook was last modified.
+}
+
+// NewWebhook is what we require from clients when adding a Webhook. A secret
+// is generated when none is given. Webhooks scoped to all see the changes of
+// every user, which only admins may ask for.
+type NewWebhook struct {
+	UserID string   `json:"user_id" validate:"required"`
+	URL    string   `json:"url" validate:"required,url,startswith=http,max=2048"`
+	Events []string `json:"events" validate:"omitempty,max=20,dive,oneof=image.created image.updated image.deleted image.published user.created user.updated user.deleted"`
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=256"`
+	Scope  string   `json:"scope" validate:"omitempty,oneof=own all"`
+}
+
+// UpdateWebhook defines what information may be provided to modify an
+// existing Webhook. All fields are optional so clients can send just the
+// fields they want changed. Setting Secret rotates the signing key.
+type UpdateWebhook struct {
+	URL    *string   `json:"url" validate:"omitempty,url,startswith=http,max=2048"`
+	Events *[]string `json:"events" validate:"omitempty,max=20,dive,oneof=image.created image.updated image.deleted image.published user.created user.updated user.deleted"`
+	Secret *string   `json:"secret" validate:"omitempty,min=16,max=256"`
+	Scope  *string   `json:"scope" validate:"omitempty,oneof=own all"`
+	Active *bool     `json:"active"`
+}
+
+// QueryFilter holds the available fields a query of webhooks can be filtered
+// on. Nil fields are not filtered on.
+type QueryFilter struct {
+	UserID *string `json:"user_id" validate:"omitempty,uuid4"`
+}
+
+// Delivery represents an event posted, or to be posted, to a webhook.
+type Delivery struct {
+	ID             string          `json:"id"`              // Unique identifier, sent in the X-Webhook-Delivery header.
+	WebhookID      string          `json:"webhook_id"`      // Webhook delivered to.
+	Event          string          `json:"event"`           // Name of the event.
+	Payload        json.RawMessage `json:"payload"`         // Body posted to the webhook.
+	Status         string          `json:"status"`          // Where the delivery is in its life.
+	Attempts       int             `json:"attempts"`        // Number of times the body has been posted.
+	ResponseStatus *int            `json:"response_status"` // Status code of the last response.
+	LastError      *string         `json:"last_error"`      // Error from the last failed attempt.
+	Traceparent    *string         `json:"traceparent"`     // W3C trace context of the request that caused the event.
+	DateCreated    time.Time       `json:"date_created"`    // When the event happened.
+	DateUpdated    time.Time       `json:"date_updated"`    // When the delivery last changed.
+}
+
+// DeliveryFilter holds the available fields a query of deliveries can be
+// filtered on. Nil fields are not filtered on.
+type DeliveryFilter struct {
+	Status *string `json:"status" validate:"omitempty,oneof=pending retrying succeeded dead cancelled"`
+}
+
+// Payload is the body posted to a webhook.
+type Payload struct {
+	ID        string    `json:"id"`         // ID of the delivery.
+	Event     string    `json:"event"`      // Name of the event.
+	CreatedAt time.Time `json:"created_at"` // When the event happened.
+	Data      any       `json:"data"`       // What the event is about, such as the image.
+}
+
+// =============================================================================
+
+func toWebhook(dbWh db.Webhook) Webhook {
+	events := []string(dbWh.Events)
+	if events == nil {
+		events = []string{}
+	}
+
+	return Webhook{
+		ID:          dbWh.ID,
+		UserID:      dbWh.UserID,
+		URL:         dbWh.URL,
+		Events:      events,
+		Scope:       dbWh.Scope,
+		Active:      dbWh.Active,
+		DateCreated: dbWh.DateCreated,
+		DateUpdated: dbWh.DateUpdated,
+	}
+}
+
+func toWebhookSlice(dbWhs []db.Webhook) []Webhook {
+	whs := make([]Webhook, len(dbWhs))
+	for i, dbWh := range dbWhs {
+		whs[i] = toWebhook(dbWh)
+	}
+	return whs
+}
+
+func toDelivery(dbDlv db.Delivery) Delivery {
+	return Delivery{
+		ID:             dbDlv.ID,
+		WebhookID:      dbDlv.WebhookID,
+		Event:          dbDlv.Event,
+		Payload:        json.RawMessage(dbDlv.Payload),
+		Status:         dbDlv.Status,
+		Attempts:       dbDlv.Attempts,
+		ResponseStatus: dbDlv.ResponseStatus,
+		LastError:      dbDlv.LastError,
+		Traceparent:    dbDlv.Traceparent,
+		DateCreated:    dbDlv.DateCreated,
+		DateUpdated:    dbDlv.DateUpdated,
+	}
+}
+
+func toDeliverySlice(dbDlvs []db.Delivery) []Delivery {
+	dlvs := make([]Delivery, len(dbDlvs))
+	for i, dbDlv := range dbDlvs {
+		dlvs[i] = toDelivery(dbDlv)
+	}
+	return dlvs
+}
@@ -0,0 +1,601 @@
+// Package webhook provides the core business API for webhooks, which post
+// the events of the system to the URLs users subscribe. Deliveries are made
+// from the job queue, so a webhook that's down is retried with a backoff
+// until it runs out of attempts and the delivery is dead-lettered.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/webhook/db"
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/fetch"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for CRUD operations.
+var (
+	ErrNotFound  = errors.New("webhook not found")
+	ErrInvalidID = errors.New("ID is not in its proper form")
+	ErrStatus    = errors.New("webhook responded with an error")
+)
+
+// Set of events a webhook can subscribe to.
+const (
+	EventImageCreated   = "image.created"
+	EventImageUpdated   = "image.updated"
+	EventImageDeleted   = "image.deleted"
+	EventImagePublished = "image.published"
+	EventUserCreated    = "user.created"
+	EventUserUpdated    = "user.updated"
+	EventUserDeleted    = "user.deleted"
+)
+
+// Set of scopes deciding whose changes a webhook is told about.
+const (
+	ScopeOwn = "own" // Changes to the owner and the images they own.
+	ScopeAll = "all" // Changes to every user and image.
+)
+
+// Set of statuses a delivery moves through.
+const (
+	StatusPending   = "pending"   // Waiting for its first attempt.
+	StatusRetrying  = "retrying"  // Failed, waiting for another attempt.
+	StatusSucceeded = "succeeded" // Accepted by the webhook.
+	StatusDead      = "dead"      // Out of attempts.
+	StatusCancelled = "cancelled" // Not made because the webhook was deactivated.
+)
+
+// Set of headers sent with every delivery. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook's
+// secret, so receivers can check a delivery came from us and isn't being
+// replayed.
+const (
+	HeaderDelivery    = "X-Webhook-Delivery"
+	HeaderEvent       = "X-Webhook-Event"
+	HeaderTimestamp   = "X-Webhook-Timestamp"
+	HeaderSignature   = "X-Webhook-Signature"
+	HeaderTraceparent = "traceparent"
+)
+
+// Queue is the job queue deliveries are made from.
+const Queue = "webhooks"
+
+// DefaultMaxAttempts is how many times a delivery is attempted before it's
+// dead-lettered.
+const DefaultMaxAttempts = 8
+
+// Doer declares the behavior required to post deliveries. Implementations
+// are responsible for refusing internal addresses and for bounding how long
+// a request may take.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Options represent optional parameters.
+type Options struct {
+	client      Doer
+	maxAttempts int
+}
+
+// WithClient sets the client deliveries are posted with.
+func WithClient(client Doer) func(opts *Options) {
+	return func(opts *Options) {
+		opts.client = client
+	}
+}
+
+// WithMaxAttempts sets how many times a delivery is attempted before it's
+// dead-lettered.
+func WithMaxAttempts(maxAttempts int) func(opts *Options) {
+	return func(opts *Options) {
+		opts.maxAttempts = maxAttempts
+	}
+}
+
+// Core manages the set of APIs for webhook access.
+type Core struct {
+	log         *zap.SugaredLogger
+	store       db.Store
+	jobs        jobs.Store
+	client      Doer
+	maxAttempts int
+}
+
+// NewCore constructs a core for webhook api access.
+func NewCore(log *zap.SugaredLogger, sqlxDB *sqlx.DB, options ...func(opts *Options)) Core {
+	opts := Options{
+		maxAttempts: DefaultMaxAttempts,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.client == nil {
+		opts.client = fetch.New()
+	}
+
+	return Core{
+		log:         log,
+		store:       db.NewStore(log, sqlxDB),
+		jobs:        jobs.NewStore(log, sqlxDB),
+		client:      opts.client,
+		maxAttempts: opts.maxAttempts,
+	}
+}
+
+// Create adds a Webhook to the database. The returned Webhook holds its
+// secret, which isn't shown again.
+func (c Core) Create(ctx context.Context, nw NewWebhook, now time.Time) (Webhook, error) {
+	if err := validate.Check(nw); err != nil {
+		return Webhook{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	secret := nw.Secret
+	if secret == "" {
+		var err error
+		if secret, err = generateSecret(); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	scope := nw.Scope
+	if scope == "" {
+		scope = ScopeOwn
+	}
+
+	dbWh := db.Webhook{
+		ID:          validate.GenerateID(),
+		UserID:      nw.UserID,
+		URL:         nw.URL,
+		Events:      nw.Events,
+		Secret:      secret,
+		Scope:       scope,
+		Active:      true,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	if err := c.store.Create(ctx, dbWh); err != nil {
+		return Webhook{}, fmt.Errorf("create: %w", err)
+	}
+
+	wh := toWebhook(dbWh)
+	wh.Secret = secret
+
+	return wh, nil
+}
+
+// Update modifies a Webhook. It will error if the specified ID is invalid or
+// does not reference an existing Webhook. The returned Webhook holds the
+// secret only when it was rotated.
+func (c Core) Update(ctx context.Context, webhookID string, uw UpdateWebhook, now time.Time) (Webhook, error) {
+	if err := validate.CheckID(webhookID); err != nil {
+		return Webhook{}, ErrInvalidID
+	}
+
+	if err := validate.Check(uw); err != nil {
+		return Webhook{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	dbWh, err := c.store.QueryByID(ctx, webhookID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Webhook{}, ErrNotFound
+		}
+		return Webhook{}, fmt.Errorf("updating webhook webhookID[%s]: %w", webhookID, err)
+	}
+
+	if uw.URL != nil {
+		dbWh.URL = *uw.URL
+	}
+	if uw.Events != nil {
+		dbWh.Events = *uw.Events
+	}
+	if uw.Secret != nil {
+		dbWh.Secret = *uw.Secret
+	}
+	if uw.Scope != nil {
+		dbWh.Scope = *uw.Scope
+	}
+	if uw.Active != nil {
+		dbWh.Active = *uw.Active
+	}
+	dbWh.DateUpdated = now
+
+	if err := c.store.Update(ctx, dbWh); err != nil {
+		return Webhook{}, fmt.Errorf("update: %w", err)
+	}
+
+	wh := toWebhook(dbWh)
+	if uw.Secret != nil {
+		wh.Secret = dbWh.Secret
+	}
+
+	return wh, nil
+}
+
+// Delete removes the Webhook identified by a given ID along with its
+// deliveries, including those still waiting to be made.
+func (c Core) Delete(ctx context.Context, webhookID string) error {
+	if err := validate.CheckID(webhookID); err != nil {
+		return ErrInvalidID
+	}
+
+	if err := c.store.Delete(ctx, webhookID); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	return nil
+}
+
+// QueryPage gets a page of the webhooks that match the filter. Webhooks can
+// be sorted by date_created or id. It returns the cursor for the next page,
+// which is empty when there are no more webhooks.
+func (c Core) QueryPage(ctx context.Context, filter QueryFilter, page paging.Page) ([]Webhook, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-date_created", "date_created", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   sortColumns[order.Field],
+		IDColumn: "webhook_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbWhs, err := c.store.QueryPage(ctx, db.QueryFilter(filter), keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	// One more row than the limit was asked for to learn if there's a
+	// next page without a count.
+	var next string
+	if len(dbWhs) > limit {
+		dbWhs = dbWhs[:limit]
+		last := dbWhs[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last.DateCreated), last.ID)
+	}
+
+	return toWebhookSlice(dbWhs), next, nil
+}
+
+// QueryByID finds the Webhook identified by a given ID.
+func (c Core) QueryByID(ctx context.Context, webhookID string) (Webhook, error) {
+	if err := validate.CheckID(webhookID); err != nil {
+		return Webhook{}, ErrInvalidID
+	}
+
+	dbWh, err := c.store.QueryByID(ctx, webhookID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Webhook{}, ErrNotFound
+		}
+		return Webhook{}, fmt.Errorf("query: %w", err)
+	}
+
+	return toWebhook(dbWh), nil
+}
+
+// QueryDeliveries gets a page of the deliveries made to the Webhook
+// identified by a given ID. Deliveries can be sorted by date_created or id.
+// It returns the cursor for the next page, which is empty when there are no
+// more deliveries.
+func (c Core) QueryDeliveries(ctx context.Context, webhookID string, filter DeliveryFilter, page paging.Page) ([]Delivery, string, error) {
+	if err := validate.CheckID(webhookID); err != nil {
+		return nil, "", ErrInvalidID
+	}
+
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	order, limit, cursor, err := paging.Parse(page, "-date_created", "date_created", "id")
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyset := database.Keyset{
+		Column:   deliverySortColumns[order.Field],
+		IDColumn: "delivery_id",
+		Desc:     order.Desc,
+		Limit:    limit + 1,
+	}
+
+	if cursor != nil {
+		if err := validate.CheckID(cursor.ID); err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		key, err := parseSortKey(order.Field, cursor.Key)
+		if err != nil {
+			return nil, "", paging.ErrInvalidCursor
+		}
+		keyset.After = true
+		keyset.AfterKey = key
+		keyset.AfterID = cursor.ID
+	}
+
+	dbDlvs, err := c.store.QueryDeliveries(ctx, webhookID, filter.Status, keyset)
+	if err != nil {
+		return nil, "", fmt.Errorf("query: %w", err)
+	}
+
+	var next string
+	if len(dbDlvs) > limit {
+		dbDlvs = dbDlvs[:limit]
+		last := dbDlvs[limit-1]
+		next = paging.Next(order, sortKey(order.Field, last.DateCreated), last.ID)
+	}
+
+	return toDeliverySlice(dbDlvs), next, nil
+}
+
+// =============================================================================
+
+// Notify queues a delivery of the event to every active webhook subscribed
+// to it. Webhooks scoped to their owner are only told about changes to the
+// specified user or the things they own. The W3C trace context of the
+// request in the context is kept with each delivery and sent along with it,
+// so the receiver can join the trace.
+func (c Core) Notify(ctx context.Context, event string, userID string, data any, now time.Time) error {
+	dbWhs, err := c.store.QuerySubscribed(ctx, event, userID)
+	if err != nil {
+		return fmt.Errorf("query subscribed: %w", err)
+	}
+
+	if len(dbWhs) == 0 {
+		return nil
+	}
+
+	var traceparent *string
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if tp := carrier.Get(HeaderTraceparent); tp != "" {
+		traceparent = &tp
+	}
+
+	tran := func(tx sqlx.ExtContext) error {
+		store := c.store.Tran(tx)
+		queue := c.jobs.Tran(tx)
+
+		for _, dbWh := range dbWhs {
+			p := Payload{
+				ID:        validate.GenerateID(),
+				Event:     event,
+				CreatedAt: now,
+				Data:      data,
+			}
+
+			body, err := json.Marshal(p)
+			if err != nil {
+				return fmt.Errorf("encoding payload: %w", err)
+			}
+
+			dbDlv := db.Delivery{
+				ID:          p.ID,
+				WebhookID:   dbWh.ID,
+				Event:       event,
+				Payload:     body,
+				Status:      StatusPending,
+				Traceparent: traceparent,
+				DateCreated: now,
+				DateUpdated: now,
+			}
+
+			if err := store.CreateDelivery(ctx, dbDlv); err != nil {
+				return fmt.Errorf("create delivery: %w", err)
+			}
+
+			nj := jobs.NewJob{
+				Queue:       Queue,
+				Payload:     delivery{ID: dbDlv.ID},
+				MaxAttempts: c.maxAttempts,
+			}
+
+			if _, err := queue.Enqueue(ctx, nj, now); err != nil {
+				return fmt.Errorf("enqueue: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	if err := c.store.WithinTran(ctx, tran); err != nil {
+		return fmt.Errorf("tran: %w", err)
+	}
+
+	return nil
+}
+
+// delivery is the payload of a job in the webhooks queue.
+type delivery struct {
+	ID string `json:"delivery_id"`
+}
+
+// Deliver makes an attempt at the delivery a job in the webhooks queue is
+// for, and is registered as the queue's handler. A failed attempt returns an
+// error so the job is retried with a backoff, and the delivery is marked
+// dead once the job runs out of attempts. Deliveries to webhooks that have
+// been deleted are dropped.
+func (c Core) Deliver(ctx context.Context, job jobs.Job) error {
+	var d delivery
+	if err := json.Unmarshal(job.Payload, &d); err != nil {
+		return jobs.Permanent(fmt.Errorf("decoding payload: %w", err))
+	}
+
+	tgt, err := c.store.QueryTarget(ctx, d.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return nil
+		}
+		return fmt.Errorf("query: %w", err)
+	}
+
+	dbDlv := tgt.Delivery
+
+	var sendErr error
+	switch {
+	case !tgt.Active:
+		dbDlv.Status = StatusCancelled
+		dbDlv.LastError = errorString(errors.New("webhook is not active"))
+
+	default:
+		var status int
+		status, sendErr = c.send(ctx, tgt)
+
+		dbDlv.Attempts++
+		dbDlv.ResponseStatus = nil
+		if status != 0 {
+			dbDlv.ResponseStatus = &status
+		}
+		dbDlv.LastError = errorString(sendErr)
+
+		switch {
+		case sendErr == nil:
+			dbDlv.Status = StatusSucceeded
+		case job.Attempts >= job.MaxAttempts:
+			dbDlv.Status = StatusDead
+		default:
+			dbDlv.Status = StatusRetrying
+		}
+	}
+	dbDlv.DateUpdated = time.Now().UTC()
+
+	// The outcome of the attempt matters more than recording it, so a
+	// failure to record it only gets logged.
+	if err := c.store.UpdateDelivery(ctx, dbDlv); err != nil {
+		c.log.Errorw("webhook", "status", "recording delivery", "deliveryID", dbDlv.ID, "ERROR", err)
+	}
+
+	return sendErr
+}
+
+// send posts the delivery to its webhook, returning the status code of the
+// response when there was one.
+func (c Core) send(ctx context.Context, tgt db.Target) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tgt.URL, bytes.NewReader(tgt.Payload))
+	if err != nil {
+		return 0, jobs.Permanent(fmt.Errorf("building request: %w", err))
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, tgt.ID)
+	req.Header.Set(HeaderEvent, tgt.Event)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(tgt.Secret, timestamp, tgt.Payload))
+	if tgt.Traceparent != nil {
+		req.Header.Set(HeaderTraceparent, *tgt.Traceparent)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("posting: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Reading what's left of the body lets the connection be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the value of the signature header for a delivery posted at
+// the specified Unix timestamp.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// =============================================================================
+
+// sortColumns maps the fields webhooks can be sorted on to their columns.
+var sortColumns = map[string]string{
+	"date_created": "date_created",
+	"id":           "webhook_id",
+}
+
+// deliverySortColumns maps the fields deliveries can be sorted on to their
+// columns.
+var deliverySortColumns = map[string]string{
+	"date_created": "date_created",
+	"id":           "delivery_id",
+}
+
+// sortKey returns the value of the sort field to keep in a cursor.
+func sortKey(field string, dateCreated time.Time) string {
+	if field == "date_created" {
+		return dateCreated.UTC().Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// parseSortKey converts the sort key held in a cursor back to the value of
+// the sort field.
+func parseSortKey(field string, key string) (any, error) {
+	if field == "date_created" {
+		return time.Parse(time.RFC3339Nano, key)
+	}
+	return nil, nil
+}
+
+// generateSecret returns a random secret for signing deliveries.
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// errorString returns the message of an error for the last_error column.
+func errorString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	s := err.Error()
+	return &s
+}
@@ -0,0 +1,279 @@
+package webhook_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/core/image"
+	"github.com/fadhilijuma/images/business/core/webhook"
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/blob"
+	"github.com/fadhilijuma/images/business/sys/fetch"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/business/sys/paging"
+	"github.com/fadhilijuma/images/foundation/docker"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+// Set of users from the seed data.
+const (
+	adminID = "5cf37266-3473-4006-984f-9325122678b7"
+	userID  = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+)
+
+// received is a delivery as the receiving end saw it.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func Test_Webhook(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testwebhook")
+	t.Cleanup(teardown)
+
+	var mu sync.Mutex
+	var deliveries []received
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		deliveries = append(deliveries, received{header: r.Header, body: body})
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	client := fetch.New(fetch.WithAllowedNetworks([]*net.IPNet{loopback}), fetch.WithMaxRedirects(0))
+	core := webhook.NewCore(log, db, webhook.WithClient(client), webhook.WithMaxAttempts(2))
+	queue := jobs.NewStore(log, db)
+
+	t.Log("Given the need to tell other systems about changes.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single Webhook.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+			nw := webhook.NewWebhook{
+				UserID: userID,
+				URL:    srv.URL + "/hooks",
+				Events: []string{webhook.EventImageCreated},
+			}
+
+			wh, err := core.Create(ctx, nw, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a webhook : %s.", dbtest.Failed, testID, err)
+			}
+			if len(wh.Secret) < 32 || wh.Scope != webhook.ScopeOwn || !wh.Active {
+				t.Fatalf("\t%s\tTest %d:\tShould give a webhook a secret and defaults : %+v.", dbtest.Failed, testID, wh)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a webhook.", dbtest.Success, testID)
+
+			saved, err := core.QueryByID(ctx, wh.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve webhook by ID : %s.", dbtest.Failed, testID, err)
+			}
+			if saved.Secret != "" || saved.URL != nw.URL {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT show the secret again : %+v.", dbtest.Failed, testID, saved)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve webhook by ID.", dbtest.Success, testID)
+
+			if _, err := core.Create(ctx, webhook.NewWebhook{UserID: userID, URL: "ftp://example.com/hooks"}, now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a webhook for another scheme.", dbtest.Failed, testID)
+			}
+			if _, err := core.Create(ctx, webhook.NewWebhook{UserID: userID, URL: nw.URL, Events: []string{"image.viewed"}}, now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to subscribe to an unknown event.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a webhook that isn't valid.", dbtest.Success, testID)
+
+			secret := "a new secret that is long enough"
+			uw := webhook.UpdateWebhook{
+				Events: &[]string{webhook.EventImageCreated, webhook.EventImagePublished},
+				Secret: &secret,
+			}
+			wh, err = core.Update(ctx, wh.ID, uw, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update webhook : %s.", dbtest.Failed, testID, err)
+			}
+			if len(wh.Events) != 2 || wh.Secret != secret {
+				t.Fatalf("\t%s\tTest %d:\tShould rotate the secret : %+v.", dbtest.Failed, testID, wh)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update webhook.", dbtest.Success, testID)
+
+			mine := userID
+			whs, _, err := core.QueryPage(ctx, webhook.QueryFilter{UserID: &mine}, paging.Page{})
+			if err != nil || len(whs) != 1 || whs[0].ID != wh.ID {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to list the user's webhooks : %+v : %v.", dbtest.Failed, testID, whs, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to list the user's webhooks.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen delivering events.", testID)
+		{
+			now := time.Date(2022, time.June, 2, 0, 0, 0, 0, time.UTC)
+
+			// The request that caused the event came in with a trace.
+			traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+			spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+			ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			}))
+
+			whs, _, err := core.QueryPage(ctx, webhook.QueryFilter{}, paging.Page{})
+			if err != nil || len(whs) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to find the webhook : %+v : %v.", dbtest.Failed, testID, whs, err)
+			}
+			wh := whs[0]
+			secret := "a new secret that is long enough"
+
+			images := image.NewCore(log, db, blob.NewMemory(), image.WithPresets(nil), image.WithNotifier(core))
+			ni := image.NewImage{
+				UserID:     userID,
+				Visibility: image.VisibilityPublic,
+			}
+			img, err := images.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 10, 10)), now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an image : %s.", dbtest.Failed, testID, err)
+			}
+
+			// Images of other users aren't delivered to a webhook scoped to
+			// its owner.
+			ni.UserID = adminID
+			if _, err := images.Create(ctx, ni, bytes.NewReader(dbtest.PNGContent(t, 20, 20)), now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an image : %s.", dbtest.Failed, testID, err)
+			}
+
+			dlvs, _, err := core.QueryDeliveries(ctx, wh.ID, webhook.DeliveryFilter{}, paging.Page{Sort: "date_created"})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query deliveries : %s.", dbtest.Failed, testID, err)
+			}
+			if len(dlvs) != 2 || dlvs[0].Status != webhook.StatusPending {
+				t.Fatalf("\t%s\tTest %d:\tShould queue a delivery for each subscribed event : %+v.", dbtest.Failed, testID, dlvs)
+			}
+			events := map[string]bool{dlvs[0].Event: true, dlvs[1].Event: true}
+			if !events[webhook.EventImageCreated] || !events[webhook.EventImagePublished] {
+				t.Fatalf("\t%s\tTest %d:\tShould report a public image as created and published : %+v.", dbtest.Failed, testID, events)
+			}
+			t.Logf("\t%s\tTest %d:\tShould queue a delivery for each subscribed event.", dbtest.Success, testID)
+
+			queued := webhook.Queue
+			pending, err := queue.Query(ctx, jobs.QueryFilter{Queue: &queued}, 10)
+			if err != nil || len(pending) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould enqueue a job for each delivery : %+v : %v.", dbtest.Failed, testID, pending, err)
+			}
+
+			// Run each job the way the pool would, failing first.
+			for _, job := range pending {
+				job.Attempts = 1
+				if err := core.Deliver(ctx, job); !errors.Is(err, webhook.ErrStatus) {
+					t.Fatalf("\t%s\tTest %d:\tShould fail the attempt when the webhook fails : %v.", dbtest.Failed, testID, err)
+				}
+			}
+
+			retrying := webhook.StatusRetrying
+			dlvs, _, err = core.QueryDeliveries(ctx, wh.ID, webhook.DeliveryFilter{Status: &retrying}, paging.Page{})
+			if err != nil || len(dlvs) != 2 || dlvs[0].Attempts != 1 || dlvs[0].ResponseStatus == nil || *dlvs[0].ResponseStatus != http.StatusServiceUnavailable {
+				t.Fatalf("\t%s\tTest %d:\tShould record the failed attempt : %+v : %v.", dbtest.Failed, testID, dlvs, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the failed attempt.", dbtest.Success, testID)
+
+			mu.Lock()
+			fail = false
+			mu.Unlock()
+
+			pending[0].Attempts = 2
+			if err := core.Deliver(ctx, pending[0]); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to deliver an event : %s.", dbtest.Failed, testID, err)
+			}
+
+			mu.Lock()
+			got := deliveries[len(deliveries)-1]
+			mu.Unlock()
+
+			var payload struct {
+				ID    string          `json:"id"`
+				Event string          `json:"event"`
+				Data  json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(got.body, &payload); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould post the event as JSON : %s.", dbtest.Failed, testID, err)
+			}
+			if payload.ID != got.header.Get(webhook.HeaderDelivery) || payload.Event != got.header.Get(webhook.HeaderEvent) || !bytes.Contains(payload.Data, []byte(img.ID)) {
+				t.Fatalf("\t%s\tTest %d:\tShould post the delivery ID, event and image : %s.", dbtest.Failed, testID, got.body)
+			}
+			if sig := webhook.Sign(secret, got.header.Get(webhook.HeaderTimestamp), got.body); got.header.Get(webhook.HeaderSignature) != sig {
+				t.Fatalf("\t%s\tTest %d:\tShould sign the delivery : got %s, exp %s.", dbtest.Failed, testID, got.header.Get(webhook.HeaderSignature), sig)
+			}
+			t.Logf("\t%s\tTest %d:\tShould post a signed delivery.", dbtest.Success, testID)
+
+			exp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			if tp := got.header.Get(webhook.HeaderTraceparent); tp != exp {
+				t.Fatalf("\t%s\tTest %d:\tShould propagate the trace of the request : got %q, exp %q.", dbtest.Failed, testID, tp, exp)
+			}
+			t.Logf("\t%s\tTest %d:\tShould propagate the trace of the request.", dbtest.Success, testID)
+
+			mu.Lock()
+			fail = true
+			mu.Unlock()
+
+			pending[1].Attempts = 2
+			if err := core.Deliver(ctx, pending[1]); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fail the last attempt when the webhook fails.", dbtest.Failed, testID)
+			}
+
+			dlvs, _, err = core.QueryDeliveries(ctx, wh.ID, webhook.DeliveryFilter{}, paging.Page{})
+			if err != nil || len(dlvs) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query deliveries : %+v : %v.", dbtest.Failed, testID, dlvs, err)
+			}
+			statuses := map[string]bool{dlvs[0].Status: true, dlvs[1].Status: true}
+			if !statuses[webhook.StatusSucceeded] || !statuses[webhook.StatusDead] {
+				t.Fatalf("\t%s\tTest %d:\tShould dead-letter a delivery out of attempts : %+v.", dbtest.Failed, testID, dlvs)
+			}
+			t.Logf("\t%s\tTest %d:\tShould dead-letter a delivery out of attempts.", dbtest.Success, testID)
+
+			if err := core.Delete(ctx, wh.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete webhook : %s.", dbtest.Failed, testID, err)
+			}
+			if err := core.Deliver(ctx, pending[0]); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould drop deliveries to a deleted webhook : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := core.QueryByID(ctx, wh.ID); !errors.Is(err, webhook.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted webhook : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete webhook.", dbtest.Success, testID)
+		}
+	}
+}
@@ -1,2 +1,22 @@
 DELETE FROM users;
-DELETE FROM images;
\ No newline at end of file
+DELETE FROM images;
+DELETE FROM image_renditions;
+DELETE FROM blobs;
+DELETE FROM duplicate_policies;
+DELETE FROM image_tags;
+DELETE FROM tags;
+DELETE FROM collection_items;
+DELETE FROM collection_shares;
+DELETE FROM collections;
+DELETE FROM quotas;
+DELETE FROM quota_usage;
+DELETE FROM uploads;
+DELETE FROM jobs;
+
+DELETE FROM webhook_deliveries;
+DELETE FROM webhooks;
+DELETE FROM events;
+DELETE FROM idempotency_keys;
+
+-- The audit log refuses deletes, so it's emptied with a truncate.
+TRUNCATE audit;
\ No newline at end of file
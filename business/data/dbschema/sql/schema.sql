@@ -24,3 +24,439 @@ CREATE TABLE images (
 	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 );
 
+
+-- Version: 1.3
+-- Description: Store image content in the blob store
+ALTER TABLE images
+	ADD COLUMN storage_key TEXT,
+	ADD COLUMN size        BIGINT,
+	ADD COLUMN mime_type   TEXT,
+	DROP COLUMN image_url;
+
+-- Version: 1.4
+-- Description: Store image content by checksum with reference counts
+ALTER TABLE images
+	ADD COLUMN checksum TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX images_user_id_checksum_idx ON images (user_id, checksum);
+
+CREATE TABLE blobs (
+	storage_key TEXT,
+	ref_count   INT NOT NULL,
+
+	PRIMARY KEY (storage_key)
+);
+
+INSERT INTO blobs (storage_key, ref_count)
+	SELECT storage_key, COUNT(*) FROM images WHERE storage_key IS NOT NULL GROUP BY storage_key;
+
+CREATE TABLE duplicate_policies (
+	user_id UUID,
+	policy  TEXT NOT NULL,
+
+	PRIMARY KEY (user_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 1.5
+-- Description: Create table image_renditions
+CREATE TABLE image_renditions (
+	image_id        UUID,
+	name            TEXT,
+	width           INT NOT NULL,
+	height          INT NOT NULL,
+	storage_key     TEXT NOT NULL,
+	size            BIGINT NOT NULL,
+	mime_type       TEXT NOT NULL,
+	source_checksum TEXT NOT NULL,
+	date_created    TIMESTAMP,
+
+	PRIMARY KEY (image_id, name),
+	FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);
+
+-- Version: 1.6
+-- Description: Add technical metadata to images
+ALTER TABLE images
+	ADD COLUMN width         INT NOT NULL DEFAULT 0,
+	ADD COLUMN height        INT NOT NULL DEFAULT 0,
+	ADD COLUMN format        TEXT NOT NULL DEFAULT '',
+	ADD COLUMN color_model   TEXT NOT NULL DEFAULT '',
+	ADD COLUMN orientation   INT NOT NULL DEFAULT 1,
+	ADD COLUMN date_captured TIMESTAMP NULL,
+	ADD COLUMN camera_make   TEXT NOT NULL DEFAULT '',
+	ADD COLUMN camera_model  TEXT NOT NULL DEFAULT '',
+	ADD COLUMN exposure_time TEXT NOT NULL DEFAULT '',
+	ADD COLUMN f_number      DOUBLE PRECISION NULL,
+	ADD COLUMN iso           INT NULL,
+	ADD COLUMN focal_length  DOUBLE PRECISION NULL,
+	ADD COLUMN gps_latitude  DOUBLE PRECISION NULL,
+	ADD COLUMN gps_longitude DOUBLE PRECISION NULL,
+	ADD COLUMN gps_altitude  DOUBLE PRECISION NULL,
+	ADD COLUMN exif          JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX images_date_captured_idx ON images (date_captured);
+CREATE INDEX images_camera_idx ON images (lower(camera_make), lower(camera_model));
+
+-- Version: 1.7
+-- Description: Add editorial metadata to images
+ALTER TABLE images
+	ADD COLUMN caption      TEXT NOT NULL DEFAULT '',
+	ADD COLUMN headline     TEXT NOT NULL DEFAULT '',
+	ADD COLUMN byline       TEXT NOT NULL DEFAULT '',
+	ADD COLUMN credit       TEXT NOT NULL DEFAULT '',
+	ADD COLUMN copyright    TEXT NOT NULL DEFAULT '',
+	ADD COLUMN keywords     TEXT[] NOT NULL DEFAULT '{}',
+	ADD COLUMN city         TEXT NOT NULL DEFAULT '',
+	ADD COLUMN country      TEXT NOT NULL DEFAULT '',
+	ADD COLUMN instructions TEXT NOT NULL DEFAULT '',
+	ADD COLUMN usage_terms  TEXT NOT NULL DEFAULT '';
+
+-- Version: 1.8
+-- Description: Add indexes for keyset pagination
+CREATE INDEX images_date_uploaded_idx ON images (date_uploaded, image_id);
+CREATE INDEX images_size_idx ON images (size, image_id);
+CREATE INDEX images_user_id_date_uploaded_idx ON images (user_id, date_uploaded, image_id);
+CREATE INDEX users_date_created_idx ON users (date_created, user_id);
+
+-- Version: 1.9
+-- Description: Create tables tags and image_tags
+CREATE TABLE tags (
+	tag_id       UUID,
+	name         TEXT NOT NULL,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (tag_id),
+	UNIQUE (name)
+);
+CREATE INDEX tags_name_prefix_idx ON tags (name text_pattern_ops);
+
+CREATE TABLE image_tags (
+	image_id     UUID,
+	tag_id       UUID,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (image_id, tag_id),
+	FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE,
+	FOREIGN KEY (tag_id) REFERENCES tags(tag_id) ON DELETE CASCADE
+);
+CREATE INDEX image_tags_tag_id_idx ON image_tags (tag_id);
+
+-- Version: 2.1
+-- Description: Create tables collections, collection_shares and collection_items
+CREATE TABLE collections (
+	collection_id UUID,
+	user_id       UUID NOT NULL,
+	name          TEXT NOT NULL,
+	description   TEXT NOT NULL DEFAULT '',
+	access        TEXT NOT NULL DEFAULT 'private',
+	date_created  TIMESTAMP,
+	date_updated  TIMESTAMP,
+
+	PRIMARY KEY (collection_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX collections_user_id_idx ON collections (user_id, date_created, collection_id);
+CREATE INDEX collections_date_created_idx ON collections (date_created, collection_id);
+
+CREATE TABLE collection_shares (
+	collection_id UUID,
+	user_id       UUID,
+	date_created  TIMESTAMP,
+
+	PRIMARY KEY (collection_id, user_id),
+	FOREIGN KEY (collection_id) REFERENCES collections(collection_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX collection_shares_user_id_idx ON collection_shares (user_id);
+
+CREATE TABLE collection_items (
+	collection_id UUID,
+	image_id      UUID,
+	position      INT NOT NULL,
+	date_added    TIMESTAMP,
+
+	PRIMARY KEY (collection_id, image_id),
+	FOREIGN KEY (collection_id) REFERENCES collections(collection_id) ON DELETE CASCADE,
+	FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);
+CREATE INDEX collection_items_image_id_idx ON collection_items (image_id);
+
+-- Version: 2.2
+-- Description: Add full-text search over editorial metadata
+CREATE FUNCTION images_keywords_text(keywords TEXT[]) RETURNS TEXT
+	LANGUAGE sql IMMUTABLE PARALLEL SAFE
+	AS $$ SELECT array_to_string(keywords, ' ') $$;
+
+ALTER TABLE images
+	ADD COLUMN language REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE images
+	ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector(language, headline), 'A') ||
+		setweight(to_tsvector(language, images_keywords_text(keywords)), 'B') ||
+		setweight(to_tsvector(language, caption), 'C') ||
+		setweight(to_tsvector(language, credit), 'D')
+	) STORED;
+CREATE INDEX images_search_vector_idx ON images USING GIN (search_vector);
+
+-- Version: 2.3
+-- Description: Add perceptual hashes to images
+ALTER TABLE images
+	ADD COLUMN dhash BIGINT NULL,
+	ADD COLUMN phash BIGINT NULL;
+
+-- Version: 2.4
+-- Description: Move deleted images to the trash
+ALTER TABLE images
+	ADD COLUMN deleted_at TIMESTAMP NULL,
+	ADD COLUMN deleted_by UUID NULL;
+CREATE INDEX images_deleted_at_idx ON images (deleted_at) WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE images
+	DROP CONSTRAINT IF EXISTS images_user_id_fkey;
+
+-- Version: 2.5
+-- Description: Keep a revision of every change to an image
+CREATE TABLE image_revisions (
+	image_id      UUID,
+	revision      INT,
+	user_id       UUID,
+	storage_key   TEXT NOT NULL,
+	checksum      TEXT NOT NULL,
+	size          BIGINT NOT NULL,
+	mime_type     TEXT NOT NULL,
+	editorial     JSONB NOT NULL,
+	actor_id      UUID,
+	reverted_from INT NULL,
+	date_created  TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (image_id, revision),
+	FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);
+
+INSERT INTO image_revisions
+	(image_id, revision, user_id, storage_key, checksum, size, mime_type, editorial, actor_id, date_created)
+SELECT image_id, 1, user_id, storage_key, checksum, COALESCE(size, 0), COALESCE(mime_type, ''),
+	jsonb_build_object(
+		'caption', caption, 'headline', headline, 'byline', byline, 'credit', credit,
+		'copyright', copyright, 'keywords', keywords, 'city', city, 'country', country,
+		'instructions', instructions, 'usage_terms', usage_terms, 'language', CAST(language AS TEXT)
+	),
+	user_id, COALESCE(date_uploaded, now())
+FROM images
+WHERE storage_key IS NOT NULL;
+
+UPDATE blobs SET
+	ref_count = blobs.ref_count + revisions.count
+FROM (SELECT storage_key, COUNT(*) AS count FROM image_revisions GROUP BY storage_key) AS revisions
+WHERE blobs.storage_key = revisions.storage_key;
+
+-- Version: 2.6
+-- Description: Add image visibility, user organizations and share links
+ALTER TABLE users
+	ADD COLUMN organization_id UUID NULL;
+
+ALTER TABLE images
+	ADD COLUMN organization_id UUID NULL,
+	ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private';
+
+CREATE INDEX images_organization_id_idx ON images (organization_id);
+
+CREATE TABLE image_shares (
+	share_id      UUID,
+	image_id      UUID NOT NULL,
+	user_id       UUID NOT NULL,
+	rendition     TEXT NOT NULL DEFAULT '',
+	max_downloads INT NULL,
+	downloads     INT NOT NULL DEFAULT 0,
+	expires_at    TIMESTAMP NOT NULL,
+	revoked_at    TIMESTAMP NULL,
+	date_created  TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (share_id),
+	FOREIGN KEY (image_id) REFERENCES images(image_id) ON DELETE CASCADE
+);
+
+CREATE INDEX image_shares_image_id_idx ON image_shares (image_id);
+
+-- Version: 2.7
+-- Description: Add storage quotas and the usage counted against them
+CREATE TABLE quotas (
+	scope        TEXT,
+	subject_id   UUID,
+	max_bytes    BIGINT NULL,
+	max_images   INT NULL,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (scope, subject_id)
+);
+
+CREATE TABLE quota_usage (
+	scope        TEXT,
+	subject_id   UUID,
+	bytes        BIGINT NOT NULL DEFAULT 0,
+	images       INT NOT NULL DEFAULT 0,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (scope, subject_id)
+);
+
+INSERT INTO quota_usage (scope, subject_id, bytes, images, date_updated)
+SELECT 'user', user_id, SUM(COALESCE(size, 0)), COUNT(*), now()
+FROM images
+WHERE deleted_at IS NULL
+GROUP BY user_id;
+
+INSERT INTO quota_usage (scope, subject_id, bytes, images, date_updated)
+SELECT 'organization', organization_id, SUM(COALESCE(size, 0)), COUNT(*), now()
+FROM images
+WHERE deleted_at IS NULL AND organization_id IS NOT NULL
+GROUP BY organization_id;
+
+-- Version: 2.8
+-- Description: Add resumable uploads
+CREATE TABLE uploads (
+	upload_id       UUID,
+	user_id         UUID NOT NULL,
+	organization_id UUID NULL,
+	length          BIGINT NOT NULL,
+	upload_offset   BIGINT NOT NULL DEFAULT 0,
+	metadata        JSONB NOT NULL DEFAULT '{}',
+	image_id        UUID NULL,
+	expires_at      TIMESTAMP NOT NULL,
+	date_created    TIMESTAMP NOT NULL,
+	date_updated    TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (upload_id)
+);
+
+CREATE INDEX uploads_expires_at_idx ON uploads (expires_at);
+
+-- Version: 2.9
+-- Description: Add background jobs
+CREATE TABLE jobs (
+	job_id       UUID,
+	queue        TEXT NOT NULL,
+	payload      JSONB NOT NULL DEFAULT '{}',
+	status       TEXT NOT NULL DEFAULT 'pending',
+	attempts     INT NOT NULL DEFAULT 0,
+	max_attempts INT NOT NULL,
+	run_at       TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP NULL,
+	last_error   TEXT NULL,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (job_id)
+);
+
+CREATE INDEX jobs_claim_idx ON jobs (queue, run_at) WHERE status IN ('pending', 'running');
+
+-- Version: 3.1
+-- Description: Add webhooks
+CREATE TABLE webhooks (
+	webhook_id   UUID,
+	user_id      UUID NOT NULL,
+	url          TEXT NOT NULL,
+	events       TEXT[] NOT NULL DEFAULT '{}',
+	secret       TEXT NOT NULL,
+	scope        TEXT NOT NULL DEFAULT 'own',
+	active       BOOLEAN NOT NULL DEFAULT TRUE,
+	date_created TIMESTAMP NOT NULL,
+	date_updated TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (webhook_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE webhook_deliveries (
+	delivery_id     UUID,
+	webhook_id      UUID NOT NULL,
+	event           TEXT NOT NULL,
+	payload         JSONB NOT NULL,
+	status          TEXT NOT NULL DEFAULT 'pending',
+	attempts        INT NOT NULL DEFAULT 0,
+	response_status INT NULL,
+	last_error      TEXT NULL,
+	traceparent     TEXT NULL,
+	date_created    TIMESTAMP NOT NULL,
+	date_updated    TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (delivery_id),
+	FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, date_created);
+
+-- Version: 3.2
+-- Description: Add the events outbox
+CREATE TABLE events (
+	event_id     BIGSERIAL,
+	event        TEXT NOT NULL,
+	user_id      UUID NULL,
+	data         JSONB NOT NULL DEFAULT '{}',
+	date_created TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (event_id)
+);
+
+CREATE INDEX events_user_idx ON events (user_id, event_id);
+
+-- Version: 3.3
+-- Description: Add the audit log
+CREATE TABLE audit (
+	audit_id     BIGSERIAL,
+	actor_id     UUID NULL,
+	actor_roles  TEXT[] NOT NULL DEFAULT '{}',
+	trace_id     TEXT NOT NULL DEFAULT '',
+	client_ip    TEXT NOT NULL DEFAULT '',
+	action       TEXT NOT NULL,
+	entity       TEXT NOT NULL,
+	entity_id    UUID NOT NULL,
+	diff         JSON NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+	prev_hash    TEXT NOT NULL,
+	hash         TEXT NOT NULL,
+
+	PRIMARY KEY (audit_id)
+);
+
+CREATE INDEX audit_actor_idx ON audit (actor_id, audit_id);
+CREATE INDEX audit_entity_idx ON audit (entity, entity_id, audit_id);
+CREATE INDEX audit_date_idx ON audit (date_created, audit_id);
+
+CREATE FUNCTION audit_append_only() RETURNS TRIGGER AS $$
+	BEGIN RAISE EXCEPTION 'audit log is append-only'; END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_append_only
+	BEFORE UPDATE OR DELETE ON audit
+	FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+
+-- Version: 3.4
+-- Description: Add versions to users and images for optimistic concurrency
+ALTER TABLE users ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE images ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+-- Version: 3.5
+-- Description: Add idempotency keys
+CREATE TABLE idempotency_keys (
+	user_id      UUID NOT NULL,
+	idem_key     TEXT NOT NULL,
+	fingerprint  TEXT NOT NULL,
+	status_code  INT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL DEFAULT '',
+	body         BYTEA NULL,
+	locked_until TIMESTAMP NULL,
+	expires_at   TIMESTAMP NOT NULL,
+	date_created TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (user_id, idem_key),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
+
+-- Version: 3.6
+-- Description: Index events by age so they can be pruned
+CREATE INDEX events_date_created_idx ON events (date_created);
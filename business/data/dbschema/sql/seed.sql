@@ -3,7 +3,16 @@ INSERT INTO users (user_id, name, email, roles, password_hash, date_created, dat
 	('45b5fbd3-755f-4379-8f07-a58d4a30fa2f', 'Getty User', 'user@getty.com', '{USER}', '$2a$10$9/XASPKBbJKVfCAZKDH.UuhsuALDr5vVm6VrYA9VFR8rccK86C1hW', '2022-03-24 00:00:00', '2022-03-24 00:00:00')
 	ON CONFLICT DO NOTHING;
 
-INSERT INTO images (image_id, image_url, user_id, date_uploaded) VALUES
-	('a2b0639f-2cc6-44b8-b97b-15d69dbb511e', 'images/afp.jpg', '5cf37266-3473-4006-984f-9325122678b7', '2022-01-01 00:00:01.000001+00'),
-	('72f8b983-3eb4-48db-9ed0-e45cc6bd716b', 'images/getty.jpg', '5cf37266-3473-4006-984f-9325122678b7', '2022-01-01 00:00:02.000001+00')
-	ON CONFLICT DO NOTHING;
\ No newline at end of file
+INSERT INTO images (image_id, user_id, storage_key, checksum, size, mime_type, date_uploaded) VALUES
+	('a2b0639f-2cc6-44b8-b97b-15d69dbb511e', '5cf37266-3473-4006-984f-9325122678b7', 'sha256/7b/2c/7b2c78c5cf294471ed74bcb6b1200fcad1d185ed9e8b7a78bd601a4a26a406cb', '7b2c78c5cf294471ed74bcb6b1200fcad1d185ed9e8b7a78bd601a4a26a406cb', 0, 'image/jpeg', '2022-01-01 00:00:01.000001+00'),
+	('72f8b983-3eb4-48db-9ed0-e45cc6bd716b', '5cf37266-3473-4006-984f-9325122678b7', 'sha256/30/10/30108abe35ac37fc665f33803b57f4d334e889221239dd104a1b03ec67c955e0', '30108abe35ac37fc665f33803b57f4d334e889221239dd104a1b03ec67c955e0', 0, 'image/jpeg', '2022-01-01 00:00:02.000001+00')
+	ON CONFLICT DO NOTHING;
+
+INSERT INTO blobs (storage_key, ref_count) VALUES
+	('sha256/7b/2c/7b2c78c5cf294471ed74bcb6b1200fcad1d185ed9e8b7a78bd601a4a26a406cb', 1),
+	('sha256/30/10/30108abe35ac37fc665f33803b57f4d334e889221239dd104a1b03ec67c955e0', 1)
+	ON CONFLICT DO NOTHING;
+
+INSERT INTO quota_usage (scope, subject_id, bytes, images, date_updated) VALUES
+	('user', '5cf37266-3473-4006-984f-9325122678b7', 0, 2, '2022-03-24 00:00:00')
+	ON CONFLICT DO NOTHING;
@@ -8,6 +8,9 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"testing"
 	"time"
 
@@ -204,3 +207,22 @@ func StringPointer(s string) *string {
 func IntPointer(i int) *int {
 	return &i
 }
+
+// PNGContent returns a PNG of a single color with the specified dimensions,
+// for tests that store images. Images of different dimensions have different
+// content.
+func PNGContent(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 80, B: 40, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding png: %s", err)
+	}
+
+	return buf.Bytes()
+}
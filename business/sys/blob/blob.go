@@ -0,0 +1,35 @@
+// Package blob provides support for storing and retrieving binary objects
+// such as image content.
+package blob
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// Set of error variables for blob operations.
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("blob key is not in its proper form")
+)
+
+// checkKey validates that a key is a relative, slash separated path that
+// can't escape the root of the store.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+
+	if path.Clean(key) != key {
+		return ErrInvalidKey
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return ErrInvalidKey
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/fadhilijuma/images/business/sys/blob"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// store is the behavior the tests expect from every blob store.
+type store interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Rename(ctx context.Context, oldKey string, newKey string) error
+	Delete(ctx context.Context, key string) error
+}
+
+func Test_Blob(t *testing.T) {
+	fs, err := blob.NewFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("Should be able to construct a file system store: %v", err)
+	}
+
+	stores := []struct {
+		name  string
+		store store
+	}{
+		{"fs", fs},
+		{"memory", blob.NewMemory()},
+	}
+
+	t.Log("Given the need to store and retrieve blobs.")
+	{
+		for testID, tt := range stores {
+			t.Logf("\tTest %d:\tWhen handling a single blob in the %s store.", testID, tt.name)
+			{
+				ctx := context.Background()
+				const tmpKey = "uploads/photo"
+				const key = "sha256/5c/f3/5cf37266"
+				const content = "some image bytes"
+
+				n, err := tt.store.Put(ctx, tmpKey, strings.NewReader(content))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to put a blob: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to put a blob.", success, testID)
+
+				if n != int64(len(content)) {
+					t.Fatalf("\t%s\tTest %d:\tShould report the bytes written: got %d, exp %d", failed, testID, n, len(content))
+				}
+				t.Logf("\t%s\tTest %d:\tShould report the bytes written.", success, testID)
+
+				if err := tt.store.Rename(ctx, tmpKey, key); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to rename the blob: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to rename the blob.", success, testID)
+
+				if _, err := tt.store.Open(ctx, tmpKey); !errors.Is(err, blob.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open the blob by its old key: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould NOT be able to open the blob by its old key.", success, testID)
+
+				obj, err := tt.store.Open(ctx, key)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to open the blob: %v", failed, testID, err)
+				}
+				data, err := io.ReadAll(obj)
+				obj.Close()
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to read the blob: %v", failed, testID, err)
+				}
+				if string(data) != content {
+					t.Fatalf("\t%s\tTest %d:\tShould get back the same content: got %q", failed, testID, data)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get back the same content.", success, testID)
+
+				if err := tt.store.Delete(ctx, key); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete the blob: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to delete the blob.", success, testID)
+
+				if _, err := tt.store.Open(ctx, key); !errors.Is(err, blob.ErrNotFound) {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT be able to open a deleted blob: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould NOT be able to open a deleted blob.", success, testID)
+
+				if err := tt.store.Delete(ctx, key); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to delete a missing blob: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to delete a missing blob.", success, testID)
+
+				for _, bad := range []string{"", "/etc/passwd", "../escape", "a/../../b", "a//b"} {
+					if _, err := tt.store.Put(ctx, bad, strings.NewReader(content)); !errors.Is(err, blob.ErrInvalidKey) {
+						t.Fatalf("\t%s\tTest %d:\tShould reject the key %q: %v", failed, testID, bad, err)
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould reject keys that escape the store.", success, testID)
+			}
+		}
+	}
+}
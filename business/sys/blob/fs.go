@@ -0,0 +1,131 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS stores blobs as files under a root directory on the local file system.
+type FS struct {
+	root string
+}
+
+// NewFS constructs a blob store rooted at the specified directory. The
+// directory is created if it doesn't exist.
+func NewFS(root string) (*FS, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("creating root[%s]: %w", root, err)
+	}
+
+	return &FS{root: root}, nil
+}
+
+// Put streams the content of r into the blob identified by key and returns
+// the number of bytes written. The content is written to a temporary file
+// first so a partially written blob is never visible under its key.
+func (f *FS) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	name := f.path(key)
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("creating dir[%s]: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".put-*")
+	if err != nil {
+		return 0, fmt.Errorf("creating temp file: %w", err)
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("writing key[%s]: %w", key, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("closing key[%s]: %w", key, err)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return 0, fmt.Errorf("renaming key[%s]: %w", key, err)
+	}
+
+	return n, nil
+}
+
+// Open returns a reader for the blob identified by key.
+func (f *FS) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(f.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("opening key[%s]: %w", key, err)
+	}
+
+	return file, nil
+}
+
+// Rename moves the blob identified by oldKey so it's identified by newKey,
+// replacing any blob already stored under newKey.
+func (f *FS) Rename(ctx context.Context, oldKey string, newKey string) error {
+	if err := checkKey(oldKey); err != nil {
+		return err
+	}
+	if err := checkKey(newKey); err != nil {
+		return err
+	}
+
+	name := f.path(newKey)
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating dir[%s]: %w", dir, err)
+	}
+
+	if err := os.Rename(f.path(oldKey), name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("renaming key[%s] to key[%s]: %w", oldKey, newKey, err)
+	}
+
+	return nil
+}
+
+// Delete removes the blob identified by key. Deleting a blob that doesn't
+// exist is not an error.
+func (f *FS) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing key[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// path converts a key into a file path under the root.
+func (f *FS) path(key string) string {
+	return filepath.Join(f.root, filepath.FromSlash(key))
+}
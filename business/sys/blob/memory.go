@@ -0,0 +1,116 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Memory stores blobs in memory. It's intended for tests.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemory constructs an empty in-memory blob store.
+func NewMemory() *Memory {
+	return &Memory{
+		blobs: make(map[string][]byte),
+	}
+}
+
+// Put reads the content of r into the blob identified by key and returns
+// the number of bytes written.
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("reading key[%s]: %w", key, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blobs[key] = data
+
+	return int64(len(data)), nil
+}
+
+// Open returns a reader for the blob identified by key.
+func (m *Memory) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, exists := m.blobs[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return memoryObject{bytes.NewReader(data)}, nil
+}
+
+// Rename moves the blob identified by oldKey so it's identified by newKey,
+// replacing any blob already stored under newKey.
+func (m *Memory) Rename(ctx context.Context, oldKey string, newKey string) error {
+	if err := checkKey(oldKey); err != nil {
+		return err
+	}
+	if err := checkKey(newKey); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, exists := m.blobs[oldKey]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(m.blobs, oldKey)
+	m.blobs[newKey] = data
+
+	return nil
+}
+
+// Delete removes the blob identified by key. Deleting a blob that doesn't
+// exist is not an error.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blobs, key)
+
+	return nil
+}
+
+// Len returns the number of blobs currently stored.
+func (m *Memory) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.blobs)
+}
+
+// memoryObject adds a no-op Close to a bytes.Reader.
+type memoryObject struct {
+	*bytes.Reader
+}
+
+// Close implements the io.Closer interface.
+func (memoryObject) Close() error {
+	return nil
+}
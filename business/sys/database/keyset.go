@@ -0,0 +1,58 @@
+package database
+
+import "fmt"
+
+// Keyset describes a page of rows ordered by a column and then by the ID
+// column of the table, which makes the order total. Rows start after the
+// position given by AfterKey and AfterID, or at the beginning when After is
+// false. The column names are written into the query as they are, so they
+// must never come from user input.
+type Keyset struct {
+	Column   string
+	IDColumn string
+	Desc     bool
+	Limit    int
+	After    bool
+	AfterKey any
+	AfterID  string
+}
+
+// Where returns the condition that selects the rows after the position,
+// adding the values it needs to the named query data. It returns an empty
+// string for the first page.
+func (k Keyset) Where(data map[string]any) string {
+	if !k.After {
+		return ""
+	}
+
+	op := ">"
+	if k.Desc {
+		op = "<"
+	}
+
+	data["after_id"] = k.AfterID
+
+	if k.Column == k.IDColumn {
+		return fmt.Sprintf("%s %s :after_id", k.IDColumn, op)
+	}
+
+	data["after_key"] = k.AfterKey
+	return fmt.Sprintf("(%s, %s) %s (:after_key, :after_id)", k.Column, k.IDColumn, op)
+}
+
+// OrderBy returns the ORDER BY and LIMIT clauses for the page, adding the
+// values it needs to the named query data.
+func (k Keyset) OrderBy(data map[string]any) string {
+	dir := "ASC"
+	if k.Desc {
+		dir = "DESC"
+	}
+
+	data["limit"] = k.Limit
+
+	if k.Column == k.IDColumn {
+		return fmt.Sprintf("\n\tORDER BY\n\t\t%s %s\n\tLIMIT :limit", k.IDColumn, dir)
+	}
+
+	return fmt.Sprintf("\n\tORDER BY\n\t\t%s %s, %s %s\n\tLIMIT :limit", k.Column, dir, k.IDColumn, dir)
+}
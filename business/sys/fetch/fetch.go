@@ -0,0 +1,370 @@
+// Package fetch provides support for downloading remote resources on behalf
+// of clients without letting them reach the internal network.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Set of error variables for fetching resources.
+var (
+	ErrInvalidURL       = errors.New("url is not in its proper form")
+	ErrBlockedAddress   = errors.New("address is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrStatus           = errors.New("remote responded with an error")
+	ErrTooLarge         = errors.New("resource is too large")
+	ErrUnsupportedType  = errors.New("resource is not of a supported content type")
+	ErrTimeout          = errors.New("remote took too long to respond")
+)
+
+// Set of defaults used when the client isn't configured otherwise.
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultMaxSize      = 50 << 20
+	DefaultMaxRedirects = 5
+)
+
+// DefaultContentTypes are the content types accepted by default.
+var DefaultContentTypes = []string{"image/"}
+
+// blockedNetworks are reserved ranges not covered by the checks the net
+// package provides.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",     // This network.
+	"100.64.0.0/10", // Carrier grade NAT.
+	"192.0.0.0/24",  // IETF protocol assignments.
+	"198.18.0.0/15", // Benchmarking.
+	"240.0.0.0/4",   // Reserved, including broadcast.
+	"64:ff9b::/96",  // NAT64, which can reach any IPv4 address.
+)
+
+// Options represent optional parameters.
+type Options struct {
+	timeout      time.Duration
+	maxSize      int64
+	maxRedirects int
+	contentTypes []string
+	allowed      []*net.IPNet
+}
+
+// WithTimeout sets how long a whole download, including reading the content,
+// may take.
+func WithTimeout(timeout time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.timeout = timeout
+	}
+}
+
+// WithMaxSize sets the largest resource that may be downloaded, in bytes.
+func WithMaxSize(maxSize int64) func(opts *Options) {
+	return func(opts *Options) {
+		opts.maxSize = maxSize
+	}
+}
+
+// WithMaxRedirects sets how many redirects are followed.
+func WithMaxRedirects(maxRedirects int) func(opts *Options) {
+	return func(opts *Options) {
+		opts.maxRedirects = maxRedirects
+	}
+}
+
+// WithContentTypes sets the content types accepted. A type ending in a slash
+// accepts every subtype.
+func WithContentTypes(contentTypes []string) func(opts *Options) {
+	return func(opts *Options) {
+		opts.contentTypes = contentTypes
+	}
+}
+
+// WithAllowedNetworks sets networks that may be reached even though they are
+// otherwise blocked, like a partner on a private link.
+func WithAllowedNetworks(networks []*net.IPNet) func(opts *Options) {
+	return func(opts *Options) {
+		opts.allowed = networks
+	}
+}
+
+// Client downloads resources from public addresses only. Every address is
+// checked as it's connected to, after the host name has been resolved, so a
+// name that resolves to an internal address is refused, as is a redirect to
+// one.
+type Client struct {
+	client       *http.Client
+	maxSize      int64
+	contentTypes []string
+	allowed      []*net.IPNet
+}
+
+// New constructs a client for downloading remote resources.
+func New(options ...func(opts *Options)) *Client {
+	opts := Options{
+		timeout:      DefaultTimeout,
+		maxSize:      DefaultMaxSize,
+		maxRedirects: DefaultMaxRedirects,
+		contentTypes: DefaultContentTypes,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	c := Client{
+		maxSize:      opts.maxSize,
+		contentTypes: opts.contentTypes,
+		allowed:      opts.allowed,
+	}
+
+	dialer := net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: c.control,
+	}
+
+	// No proxy is used, since a proxy would connect on our behalf to
+	// addresses that were never checked.
+	transport := http.Transport{
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		ForceAttemptHTTP2:      true,
+		TLSHandshakeTimeout:    10 * time.Second,
+		ResponseHeaderTimeout:  opts.timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		IdleConnTimeout:        90 * time.Second,
+	}
+
+	c.client = &http.Client{
+		Transport: &transport,
+		Timeout:   opts.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.maxRedirects {
+				return ErrTooManyRedirects
+			}
+			return c.checkURL(req.URL)
+		},
+	}
+
+	return &c
+}
+
+// Fetch downloads the resource at the URL. The content is checked against
+// the maximum size as it's read, so the caller sees ErrTooLarge from Read
+// when a resource is larger than its Content-Length claimed. The caller must
+// close the content.
+func (c *Client) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+
+	if err := c.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", strings.Join(c.accept(), ", "))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting: %w", timeout(err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+
+	if resp.ContentLength > c.maxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	if err := c.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return &body{ReadCloser: resp.Body, remaining: c.maxSize}, nil
+}
+
+// Do sends the request, such as a webhook delivery, with the same checks on
+// the addresses it reaches as Fetch. The response is returned whatever its
+// status and content, and the caller must close its body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, timeout(err)
+	}
+
+	return resp, nil
+}
+
+// checkURL makes sure the URL is one the client will request. Hosts given as
+// an address are checked here, but host names are only checked once they
+// are resolved.
+func (c *Client) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+
+	// Credentials in the URL would be sent to whoever the host is, and are
+	// a common way to disguise the host.
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil && c.blocked(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+
+	return nil
+}
+
+// control checks the address a connection is about to be made to. It runs
+// after name resolution for every connection, including those made to follow
+// redirects, so a host name can't point at an internal address.
+func (c *Client) control(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || c.blocked(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+
+	return nil
+}
+
+// blocked reports whether the address is loopback, private, link local or
+// otherwise not public, unless it's in an allowed network.
+func (c *Client) blocked(ip net.IP) bool {
+	for _, network := range c.allowed {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	switch {
+	case ip.IsLoopback(),
+		ip.IsPrivate(),
+		ip.IsLinkLocalUnicast(),
+		ip.IsLinkLocalMulticast(),
+		ip.IsInterfaceLocalMulticast(),
+		ip.IsMulticast(),
+		ip.IsUnspecified():
+		return true
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkContentType makes sure the content type is one of those accepted.
+func (c *Client) checkContentType(contentType string) error {
+	if contentType == "" {
+		return fmt.Errorf("%w: missing content type", ErrUnsupportedType)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, err)
+	}
+
+	for _, accepted := range c.contentTypes {
+		if mediaType == accepted || (strings.HasSuffix(accepted, "/") && strings.HasPrefix(mediaType, accepted)) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnsupportedType, mediaType)
+}
+
+// accept lists the accepted content types for the Accept header.
+func (c *Client) accept() []string {
+	accept := make([]string, len(c.contentTypes))
+	for i, contentType := range c.contentTypes {
+		if strings.HasSuffix(contentType, "/") {
+			contentType += "*"
+		}
+		accept[i] = contentType
+	}
+	return accept
+}
+
+// =============================================================================
+
+// body stops reading the content once it's larger than the maximum size.
+type body struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// Read implements the io.Reader interface.
+func (b *body) Read(p []byte) (int, error) {
+
+	// Reading one byte past the maximum is enough to know the content is
+	// too large.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, ErrTooLarge
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, timeout(err)
+	}
+
+	return n, err
+}
+
+// timeout marks errors caused by the download taking too long.
+func timeout(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return fmt.Errorf("%w: %s", ErrTimeout, err)
+	}
+	return err
+}
+
+// parseNetworks parses the networks in CIDR notation.
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
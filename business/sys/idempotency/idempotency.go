@@ -0,0 +1,235 @@
+// Package idempotency provides support for recording the responses to
+// requests made with an idempotency key, so a client retrying a request it
+// didn't see the response to gets the original response instead of making
+// the change twice. A key is reserved for the request carrying it before the
+// request is handled, which keeps duplicates sent at the same time from
+// being handled together.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for idempotency keys.
+var (
+	ErrMismatch = errors.New("idempotency key was used with a different request")
+	ErrInFlight = errors.New("request with this idempotency key is still in progress")
+)
+
+// ExpireQueue is the job queue expired keys are removed from.
+const ExpireQueue = "idempotency-expiry"
+
+// Key is an idempotency key along with the response to the request made
+// with it, once there is one.
+type Key struct {
+	UserID      string     `db:"user_id"`      // User who made the request.
+	Key         string     `db:"idem_key"`     // Key chosen by the client.
+	Fingerprint string     `db:"fingerprint"`  // Hash of the request made with the key.
+	StatusCode  int        `db:"status_code"`  // Status of the response, zero until there is one.
+	ContentType string     `db:"content_type"` // Content type of the response.
+	Body        []byte     `db:"body"`         // Body of the response.
+	LockedUntil *time.Time `db:"locked_until"` // When the request holding the key is given up on.
+	ExpiresAt   time.Time  `db:"expires_at"`   // When the key may be used for another request.
+	DateCreated time.Time  `db:"date_created"` // When the request was made.
+}
+
+// Completed reports whether the response to the request made with the key
+// was recorded.
+func (k Key) Completed() bool {
+	return k.StatusCode != 0
+}
+
+// NewKey is what we require to reserve a key for a request. The lease is how
+// long the request may hold the key before a retry may take it over and the
+// TTL how long the response is kept for.
+type NewKey struct {
+	UserID      string        `json:"user_id" validate:"required,uuid4"`
+	Key         string        `json:"key" validate:"required,max=255"`
+	Fingerprint string        `json:"fingerprint" validate:"required"`
+	Lease       time.Duration `json:"lease" validate:"required"`
+	TTL         time.Duration `json:"ttl" validate:"required"`
+}
+
+// =============================================================================
+
+// Store manages the set of APIs for idempotency key access.
+type Store struct {
+	log *zap.SugaredLogger
+	db  sqlx.ExtContext
+}
+
+// NewStore constructs a store for idempotency key access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		db:  db,
+	}
+}
+
+// Reserve takes the key for a request. It reports true when the key was
+// taken, in which case the caller handles the request and then completes or
+// releases the key. Otherwise it returns the key as it's held, for the
+// caller to replay its response or turn the request away. A key that
+// expired, or whose request was given up on, is taken over.
+func (s Store) Reserve(ctx context.Context, nk NewKey, now time.Time) (Key, bool, error) {
+	if err := validate.Check(nk); err != nil {
+		return Key{}, false, fmt.Errorf("validating data: %w", err)
+	}
+
+	lockedUntil := now.Add(nk.Lease)
+	k := Key{
+		UserID:      nk.UserID,
+		Key:         nk.Key,
+		Fingerprint: nk.Fingerprint,
+		LockedUntil: &lockedUntil,
+		ExpiresAt:   now.Add(nk.TTL),
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO idempotency_keys
+		(user_id, idem_key, fingerprint, locked_until, expires_at, date_created)
+	VALUES
+		(:user_id, :idem_key, :fingerprint, :locked_until, :expires_at, :date_created)
+	ON CONFLICT (user_id, idem_key) DO UPDATE SET
+		fingerprint = EXCLUDED.fingerprint,
+		status_code = 0,
+		content_type = '',
+		body = NULL,
+		locked_until = EXCLUDED.locked_until,
+		expires_at = EXCLUDED.expires_at,
+		date_created = EXCLUDED.date_created
+	WHERE
+		idempotency_keys.expires_at <= EXCLUDED.date_created OR
+		(idempotency_keys.status_code = 0 AND idempotency_keys.locked_until <= EXCLUDED.date_created)
+	RETURNING
+		*`
+
+	var reserved Key
+	err := database.NamedQueryStruct(ctx, s.log, s.db, q, k, &reserved)
+	switch {
+	case err == nil:
+		return reserved, true, nil
+	case !errors.Is(err, database.ErrDBNotFound):
+		return Key{}, false, fmt.Errorf("reserving key[%s]: %w", nk.Key, err)
+	}
+
+	held, err := s.QueryByKey(ctx, nk.UserID, nk.Key)
+	if err != nil {
+		return Key{}, false, err
+	}
+
+	return held, false, nil
+}
+
+// Complete records the response to the request a key was reserved for,
+// which releases the key's lock.
+func (s Store) Complete(ctx context.Context, k Key) error {
+	const q = `
+	UPDATE
+		idempotency_keys
+	SET
+		"status_code" = :status_code,
+		"content_type" = :content_type,
+		"body" = :body,
+		"locked_until" = NULL
+	WHERE
+		user_id = :user_id AND idem_key = :idem_key AND fingerprint = :fingerprint AND status_code = 0`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, k); err != nil {
+		return fmt.Errorf("completing key[%s]: %w", k.Key, err)
+	}
+
+	return nil
+}
+
+// Release removes a key reserved for a request that failed, so a retry
+// handles the request again.
+func (s Store) Release(ctx context.Context, userID string, key string) error {
+	data := struct {
+		UserID string `db:"user_id"`
+		Key    string `db:"idem_key"`
+	}{
+		UserID: userID,
+		Key:    key,
+	}
+
+	const q = `
+	DELETE FROM
+		idempotency_keys
+	WHERE
+		user_id = :user_id AND idem_key = :idem_key AND status_code = 0`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("releasing key[%s]: %w", key, err)
+	}
+
+	return nil
+}
+
+// QueryByKey gets the specified key of a user.
+func (s Store) QueryByKey(ctx context.Context, userID string, key string) (Key, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+		Key    string `db:"idem_key"`
+	}{
+		UserID: userID,
+		Key:    key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		idempotency_keys
+	WHERE
+		user_id = :user_id AND idem_key = :idem_key`
+
+	var k Key
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &k); err != nil {
+		return Key{}, fmt.Errorf("selecting key[%s]: %w", key, err)
+	}
+
+	return k, nil
+}
+
+// DeleteExpired removes the keys that expired before the specified time and
+// returns how many were removed.
+func (s Store) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	data := struct {
+		Before time.Time `db:"before"`
+	}{
+		Before: before,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			idempotency_keys
+		WHERE
+			expires_at <= :before
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	var deleted struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &deleted); err != nil {
+		return 0, fmt.Errorf("deleting expired keys: %w", err)
+	}
+
+	return deleted.Count, nil
+}
@@ -0,0 +1,132 @@
+package idempotency_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/idempotency"
+	"github.com/fadhilijuma/images/foundation/docker"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func Test_Idempotency(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testidempotency")
+	t.Cleanup(teardown)
+
+	store := idempotency.NewStore(log, db)
+
+	t.Log("Given the need to replay the responses to retried requests.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen reserving and completing a key.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			nk := idempotency.NewKey{
+				UserID:      "5cf37266-3473-4006-984f-9325122678b7",
+				Key:         "create-user-1",
+				Fingerprint: "a",
+				Lease:       time.Minute,
+				TTL:         time.Hour,
+			}
+
+			k, reserved, err := store.Reserve(ctx, nk, now)
+			if err != nil || !reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve the key : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve the key.", dbtest.Success, testID)
+
+			held, reserved, err := store.Reserve(ctx, nk, now.Add(time.Second))
+			if err != nil || reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reserve a key in flight : %v.", dbtest.Failed, testID, err)
+			}
+			if held.Completed() {
+				t.Fatalf("\t%s\tTest %d:\tShould get the key as in flight.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reserve a key in flight.", dbtest.Success, testID)
+
+			k.StatusCode = http.StatusCreated
+			k.ContentType = "application/json"
+			k.Body = []byte(`{"id":"1"}`)
+			if err := store.Complete(ctx, k); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to complete the key : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to complete the key.", dbtest.Success, testID)
+
+			// Well past the lease, a completed key is still held.
+			held, reserved, err = store.Reserve(ctx, nk, now.Add(10*time.Minute))
+			if err != nil || reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reserve a completed key : %v.", dbtest.Failed, testID, err)
+			}
+			if held.StatusCode != http.StatusCreated || string(held.Body) != `{"id":"1"}` || held.ContentType != "application/json" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the recorded response : %+v.", dbtest.Failed, testID, held)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the recorded response.", dbtest.Success, testID)
+
+			if _, reserved, err := store.Reserve(ctx, nk, now.Add(time.Hour)); err != nil || !reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve an expired key : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve an expired key.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen a request holding a key fails or is given up on.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			nk := idempotency.NewKey{
+				UserID:      "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+				Key:         "create-image-1",
+				Fingerprint: "a",
+				Lease:       time.Minute,
+				TTL:         time.Hour,
+			}
+
+			if _, reserved, err := store.Reserve(ctx, nk, now); err != nil || !reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve the key : %v.", dbtest.Failed, testID, err)
+			}
+
+			if err := store.Release(ctx, nk.UserID, nk.Key); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to release the key : %s.", dbtest.Failed, testID, err)
+			}
+
+			if _, reserved, err := store.Reserve(ctx, nk, now); err != nil || !reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reserve a released key : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reserve a released key.", dbtest.Success, testID)
+
+			if _, reserved, err := store.Reserve(ctx, nk, now.Add(2*time.Minute)); err != nil || !reserved {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to take over a key past its lease : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to take over a key past its lease.", dbtest.Success, testID)
+
+			expired, err := store.DeleteExpired(ctx, now.Add(3*time.Hour))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete expired keys : %s.", dbtest.Failed, testID, err)
+			}
+			if expired != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould delete every expired key : got %d.", dbtest.Failed, testID, expired)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete expired keys.", dbtest.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// Package jobs provides support for running work off the request path. Jobs
+// are kept in the database and claimed by workers with SKIP LOCKED, so any
+// number of processes can share a queue.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"go.uber.org/zap"
+)
+
+// Set of error variables for working with jobs.
+var (
+	ErrNotFound       = errors.New("job not found")
+	ErrInvalidID      = errors.New("ID is not in its proper form")
+	ErrNotRetryable   = errors.New("only dead or cancelled jobs can be retried")
+	ErrNotCancellable = errors.New("only pending or running jobs can be cancelled")
+)
+
+// Set of statuses a job moves through.
+const (
+	StatusPending   = "pending"   // Waiting for run_at to come around.
+	StatusRunning   = "running"   // Claimed by a worker until locked_until.
+	StatusSucceeded = "succeeded" // Finished without error.
+	StatusDead      = "dead"      // Out of attempts, or failed permanently.
+	StatusCancelled = "cancelled" // Cancelled before it finished.
+)
+
+// DefaultMaxAttempts is how many times a job is run when it isn't given a
+// number of attempts.
+const DefaultMaxAttempts = 5
+
+// Job is a unit of work in a queue.
+type Job struct {
+	ID          string         `db:"job_id" json:"id"`                 // Unique identifier.
+	Queue       string         `db:"queue" json:"queue"`               // Name of the queue the job is in.
+	Payload     types.JSONText `db:"payload" json:"payload"`           // What the handler needs to do the work.
+	Status      string         `db:"status" json:"status"`             // Where the job is in its life.
+	Attempts    int            `db:"attempts" json:"attempts"`         // Number of times the job has been claimed.
+	MaxAttempts int            `db:"max_attempts" json:"max_attempts"` // Number of times the job may be claimed.
+	RunAt       time.Time      `db:"run_at" json:"run_at"`             // When the job may next be claimed.
+	LockedUntil *time.Time     `db:"locked_until" json:"locked_until"` // When a claim runs out if the worker doesn't finish.
+	LastError   *string        `db:"last_error" json:"last_error"`     // Error from the last failed attempt.
+	DateCreated time.Time      `db:"date_created" json:"date_created"` // When the job was enqueued.
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"` // When the job last changed.
+}
+
+// NewJob is what we require to enqueue a job. The payload is stored as JSON.
+// A zero RunAt runs the job as soon as possible.
+type NewJob struct {
+	Queue       string    `json:"queue" validate:"required,max=64"`
+	Payload     any       `json:"payload"`
+	MaxAttempts int       `json:"max_attempts" validate:"omitempty,min=1,max=100"`
+	RunAt       time.Time `json:"run_at"`
+}
+
+// QueryFilter holds the fields jobs can be listed by. Nil fields are not
+// filtered on.
+type QueryFilter struct {
+	Queue  *string `json:"queue"`
+	Status *string `json:"status" validate:"omitempty,oneof=pending running succeeded dead cancelled"`
+}
+
+// =============================================================================
+
+// Store manages the set of APIs for job access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a store for job access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it. Enqueueing through it makes
+// the job part of the caller's transaction, so the job only runs if the work
+// that asked for it is committed.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Enqueue adds a job to its queue.
+func (s Store) Enqueue(ctx context.Context, nj NewJob, now time.Time) (Job, error) {
+	if err := validate.Check(nj); err != nil {
+		return Job{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	payload, err := json.Marshal(nj.Payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("encoding payload: %w", err)
+	}
+
+	job := Job{
+		ID:          validate.GenerateID(),
+		Queue:       nj.Queue,
+		Payload:     payload,
+		Status:      StatusPending,
+		MaxAttempts: nj.MaxAttempts,
+		RunAt:       nj.RunAt,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = DefaultMaxAttempts
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	const q = `
+	INSERT INTO jobs
+		(job_id, queue, payload, status, attempts, max_attempts, run_at, date_created, date_updated)
+	VALUES
+		(:job_id, :queue, :payload, :status, :attempts, :max_attempts, :run_at, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, job); err != nil {
+		return Job{}, fmt.Errorf("inserting job: %w", err)
+	}
+
+	return job, nil
+}
+
+// Schedule adds a job to its queue unless the queue already holds one that's
+// pending or running. It keeps a single job in a queue whose work repeats, no
+// matter how many processes schedule it, and reports whether one was added.
+func (s Store) Schedule(ctx context.Context, nj NewJob, now time.Time) (bool, error) {
+	data := struct {
+		Queue string `db:"queue"`
+	}{
+		Queue: nj.Queue,
+	}
+
+	var added bool
+	tran := func(tx sqlx.ExtContext) error {
+
+		// Without the lock two processes could both find the queue empty
+		// and both add a job.
+		const lock = `
+		SELECT
+			pg_advisory_xact_lock(hashtext('jobs:' || :queue))`
+
+		if err := database.NamedExecContext(ctx, s.log, tx, lock, data); err != nil {
+			return fmt.Errorf("locking queue[%s]: %w", nj.Queue, err)
+		}
+
+		const q = `
+		SELECT
+			COUNT(*) AS count
+		FROM
+			jobs
+		WHERE
+			queue = :queue AND
+			status IN ('pending', 'running')`
+
+		var queued struct {
+			Count int `db:"count"`
+		}
+		if err := database.NamedQueryStruct(ctx, s.log, tx, q, data, &queued); err != nil {
+			return fmt.Errorf("counting jobs in queue[%s]: %w", nj.Queue, err)
+		}
+		if queued.Count > 0 {
+			return nil
+		}
+
+		if _, err := s.Tran(tx).Enqueue(ctx, nj, now); err != nil {
+			return err
+		}
+		added = true
+
+		return nil
+	}
+
+	if err := s.WithinTran(ctx, tran); err != nil {
+		return false, fmt.Errorf("tran: %w", err)
+	}
+
+	return added, nil
+}
+
+// QueryByID gets the specified job from the database.
+func (s Store) QueryByID(ctx context.Context, jobID string) (Job, error) {
+	if err := validate.CheckID(jobID); err != nil {
+		return Job{}, ErrInvalidID
+	}
+
+	data := struct {
+		ID string `db:"job_id"`
+	}{
+		ID: jobID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		jobs
+	WHERE
+		job_id = :job_id`
+
+	var job Job
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &job); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Job{}, ErrNotFound
+		}
+		return Job{}, fmt.Errorf("selecting jobID[%q]: %w", jobID, err)
+	}
+
+	return job, nil
+}
+
+// Query gets up to limit jobs matching the filter, most recently enqueued
+// first.
+func (s Store) Query(ctx context.Context, filter QueryFilter, limit int) ([]Job, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, fmt.Errorf("validating filter: %w", err)
+	}
+
+	data := struct {
+		Queue  *string `db:"queue"`
+		Status *string `db:"status"`
+		Limit  int     `db:"limit"`
+	}{
+		Queue:  filter.Queue,
+		Status: filter.Status,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		jobs
+	WHERE
+		(CAST(:queue AS TEXT) IS NULL OR queue = :queue) AND
+		(CAST(:status AS TEXT) IS NULL OR status = :status)
+	ORDER BY
+		date_created DESC, job_id
+	LIMIT :limit`
+
+	var jobs []Job
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &jobs); err != nil {
+		return nil, fmt.Errorf("selecting jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// Retry puts a dead or cancelled job back in its queue with a fresh set of
+// attempts, to run straight away.
+func (s Store) Retry(ctx context.Context, jobID string, now time.Time) (Job, error) {
+	const q = `
+	UPDATE
+		jobs
+	SET
+		status = 'pending',
+		attempts = 0,
+		run_at = :now,
+		locked_until = NULL,
+		date_updated = :now
+	WHERE
+		job_id = :job_id AND
+		status IN ('dead', 'cancelled')
+	RETURNING
+		*`
+
+	return s.transition(ctx, q, jobID, ErrNotRetryable, now)
+}
+
+// Cancel stops a pending job from running. A running job is marked cancelled
+// too, but its handler isn't interrupted; whatever it returns is ignored.
+func (s Store) Cancel(ctx context.Context, jobID string, now time.Time) (Job, error) {
+	const q = `
+	UPDATE
+		jobs
+	SET
+		status = 'cancelled',
+		locked_until = NULL,
+		date_updated = :now
+	WHERE
+		job_id = :job_id AND
+		status IN ('pending', 'running')
+	RETURNING
+		*`
+
+	return s.transition(ctx, q, jobID, ErrNotCancellable, now)
+}
+
+// transition runs an update that moves a job from one of a set of statuses.
+// When nothing is updated it tells a missing job from one in another status.
+func (s Store) transition(ctx context.Context, q string, jobID string, errStatus error, now time.Time) (Job, error) {
+	if err := validate.CheckID(jobID); err != nil {
+		return Job{}, ErrInvalidID
+	}
+
+	data := struct {
+		ID  string    `db:"job_id"`
+		Now time.Time `db:"now"`
+	}{
+		ID:  jobID,
+		Now: now,
+	}
+
+	var job Job
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &job); err != nil {
+		if !errors.Is(err, database.ErrDBNotFound) {
+			return Job{}, fmt.Errorf("updating jobID[%q]: %w", jobID, err)
+		}
+
+		if _, err := s.QueryByID(ctx, jobID); err != nil {
+			return Job{}, err
+		}
+		return Job{}, errStatus
+	}
+
+	return job, nil
+}
+
+// claim takes the next job in the queue that's due, or whose last claim ran
+// out, hiding it from other workers until the visibility timeout passes.
+// Rows other workers are claiming are skipped rather than waited on. It
+// returns ErrNotFound when there's nothing to do.
+func (s Store) claim(ctx context.Context, queue string, visibility time.Duration, now time.Time) (Job, error) {
+	data := struct {
+		Queue       string    `db:"queue"`
+		LockedUntil time.Time `db:"locked_until"`
+		Now         time.Time `db:"now"`
+	}{
+		Queue:       queue,
+		LockedUntil: now.Add(visibility),
+		Now:         now,
+	}
+
+	const q = `
+	UPDATE
+		jobs
+	SET
+		status = 'running',
+		attempts = attempts + 1,
+		locked_until = :locked_until,
+		date_updated = :now
+	WHERE
+		job_id = (
+			SELECT
+				job_id
+			FROM
+				jobs
+			WHERE
+				queue = :queue AND (
+					(status = 'pending' AND run_at <= :now) OR
+					(status = 'running' AND locked_until <= :now))
+			ORDER BY
+				run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+	RETURNING
+		*`
+
+	var job Job
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &job); err != nil {
+		if errors.Is(err, database.ErrDBNotFound) {
+			return Job{}, ErrNotFound
+		}
+		return Job{}, fmt.Errorf("claiming job in queue[%s]: %w", queue, err)
+	}
+
+	return job, nil
+}
+
+// finish records the outcome of running a claimed job. Nothing is changed if
+// the claim no longer holds, because the job was cancelled or its claim ran
+// out and another worker took it.
+func (s Store) finish(ctx context.Context, claimed Job, job Job) error {
+	data := struct {
+		Job
+		ClaimedUntil *time.Time `db:"claimed_until"`
+	}{
+		Job:          job,
+		ClaimedUntil: claimed.LockedUntil,
+	}
+
+	const q = `
+	UPDATE
+		jobs
+	SET
+		status = :status,
+		attempts = :attempts,
+		run_at = :run_at,
+		locked_until = :locked_until,
+		last_error = :last_error,
+		date_updated = :date_updated
+	WHERE
+		job_id = :job_id AND
+		status = 'running' AND
+		locked_until = :claimed_until`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating jobID[%s]: %w", job.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/jobs"
+	"github.com/fadhilijuma/images/foundation/docker"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func Test_Jobs(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testjobs")
+	t.Cleanup(teardown)
+
+	store := jobs.NewStore(log, db)
+
+	t.Log("Given the need to run work off the request path.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen running jobs in a pool.", testID)
+		{
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			flaky, err := store.Enqueue(ctx, jobs.NewJob{Queue: "test", Payload: map[string]string{"name": "flaky"}, MaxAttempts: 3}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enqueue a job : %s.", dbtest.Failed, testID, err)
+			}
+			broken, err := store.Enqueue(ctx, jobs.NewJob{Queue: "test", Payload: map[string]string{"name": "broken"}}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enqueue a job : %s.", dbtest.Failed, testID, err)
+			}
+			later, err := store.Enqueue(ctx, jobs.NewJob{Queue: "test", Payload: map[string]string{"name": "later"}, RunAt: now.Add(time.Hour)}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enqueue a job : %s.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.Enqueue(ctx, jobs.NewJob{}, now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to enqueue a job without a queue.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to enqueue jobs.", dbtest.Success, testID)
+
+			var mu sync.Mutex
+			runs := make(map[string]int)
+
+			pool := jobs.NewPool(log, db, jobs.WithPollInterval(10*time.Millisecond))
+			pool.Register("test", func(ctx context.Context, job jobs.Job) error {
+				mu.Lock()
+				runs[job.ID]++
+				n := runs[job.ID]
+				mu.Unlock()
+
+				switch job.ID {
+				case flaky.ID:
+					if n == 1 {
+						return errors.New("remote unavailable")
+					}
+					return nil
+				default:
+					return jobs.Permanent(errors.New("payload is not valid"))
+				}
+			}, jobs.QueueConfig{Concurrency: 2, BackoffBase: 10 * time.Millisecond})
+			pool.Start()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				f, err1 := store.QueryByID(ctx, flaky.ID)
+				b, err2 := store.QueryByID(ctx, broken.ID)
+				if err1 != nil || err2 != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query jobs : %v : %v.", dbtest.Failed, testID, err1, err2)
+				}
+				if f.Status == jobs.StatusSucceeded && b.Status == jobs.StatusDead {
+					if f.Attempts != 2 || b.Attempts != 1 || b.LastError == nil {
+						t.Fatalf("\t%s\tTest %d:\tShould record the attempts : %+v : %+v.", dbtest.Failed, testID, f, b)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("\t%s\tTest %d:\tShould run the jobs : %+v : %+v.", dbtest.Failed, testID, f, b)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			t.Logf("\t%s\tTest %d:\tShould retry failed jobs and dead-letter permanent failures.", dbtest.Success, testID)
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if err := pool.Shutdown(shutdownCtx); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to shut the pool down : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to shut the pool down.", dbtest.Success, testID)
+
+			if l, err := store.QueryByID(ctx, later.ID); err != nil || l.Status != jobs.StatusPending || runs[later.ID] != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT run a job before its time : %+v : %v.", dbtest.Failed, testID, l, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT run a job before its time.", dbtest.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen managing jobs.", testID)
+		{
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			dead := jobs.StatusDead
+			found, err := store.Query(ctx, jobs.QueryFilter{Status: &dead}, 10)
+			if err != nil || len(found) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to list dead jobs : %+v : %v.", dbtest.Failed, testID, found, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to list dead jobs.", dbtest.Success, testID)
+
+			job, err := store.Retry(ctx, found[0].ID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retry a dead job : %s.", dbtest.Failed, testID, err)
+			}
+			if job.Status != jobs.StatusPending || job.Attempts != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould give a retried job a fresh set of attempts : %+v.", dbtest.Failed, testID, job)
+			}
+			if _, err := store.Retry(ctx, job.ID, now); !errors.Is(err, jobs.ErrNotRetryable) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retry a pending job : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retry a dead job.", dbtest.Success, testID)
+
+			job, err = store.Cancel(ctx, job.ID, now)
+			if err != nil || job.Status != jobs.StatusCancelled {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to cancel a pending job : %+v : %v.", dbtest.Failed, testID, job, err)
+			}
+			if _, err := store.Cancel(ctx, job.ID, now); !errors.Is(err, jobs.ErrNotCancellable) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to cancel a cancelled job : %v.", dbtest.Failed, testID, err)
+			}
+			if _, err := store.Cancel(ctx, "f3d2b4a1-3c44-4b39-9a5e-4d0f4a9f6a10", now); !errors.Is(err, jobs.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to cancel a missing job : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to cancel a pending job.", dbtest.Success, testID)
+
+			qc := jobs.QueueConfig{BackoffBase: time.Second, BackoffMax: 10 * time.Second}
+			for attempt, exp := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+				if got := qc.Backoff(attempt); got != exp {
+					t.Fatalf("\t%s\tTest %d:\tShould back off exponentially up to the maximum : attempt %d : got %s, exp %s.", dbtest.Failed, testID, attempt, got, exp)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould back off exponentially up to the maximum.", dbtest.Success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen running periodic work.", testID)
+		{
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			nj := jobs.NewJob{Queue: "periodic", MaxAttempts: 1}
+			if added, err := store.Schedule(ctx, nj, now); err != nil || !added {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to schedule a job : %v.", dbtest.Failed, testID, err)
+			}
+			if added, err := store.Schedule(ctx, nj, now); err != nil || added {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT schedule a job while one is pending : %v.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep a single job in the queue.", dbtest.Success, testID)
+
+			var mu sync.Mutex
+			var runs int
+
+			pool := jobs.NewPool(log, db, jobs.WithPollInterval(10*time.Millisecond))
+			pool.RegisterPeriodic("periodic", func(ctx context.Context, job jobs.Job) error {
+				mu.Lock()
+				defer mu.Unlock()
+				runs++
+				return nil
+			}, time.Hour, jobs.QueueConfig{})
+			pool.Start()
+
+			queue := "periodic"
+			pending := jobs.StatusPending
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				found, err := store.Query(ctx, jobs.QueryFilter{Queue: &queue, Status: &pending}, 10)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to query jobs : %s.", dbtest.Failed, testID, err)
+				}
+				mu.Lock()
+				n := runs
+				mu.Unlock()
+				if n == 1 && len(found) == 1 {
+					if found[0].RunAt.Before(now.Add(59 * time.Minute)) {
+						t.Fatalf("\t%s\tTest %d:\tShould schedule the next run an interval later : %v.", dbtest.Failed, testID, found[0].RunAt)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("\t%s\tTest %d:\tShould run the job once : got %d runs, %d pending.", dbtest.Failed, testID, n, len(found))
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			t.Logf("\t%s\tTest %d:\tShould run the job and schedule the next run.", dbtest.Success, testID)
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if err := pool.Shutdown(shutdownCtx); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to shut the pool down : %s.", dbtest.Failed, testID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Handler does the work of a job. Returning an error fails the attempt and
+// the job is retried after a backoff until it runs out of attempts. The
+// context is cancelled when the job's visibility timeout passes.
+type Handler func(ctx context.Context, job Job) error
+
+// permanentError marks an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+// Permanent wraps an error returned by a handler so the job is dead-lettered
+// straight away instead of being retried.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Error implements the error interface.
+func (pe *permanentError) Error() string {
+	return pe.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (pe *permanentError) Unwrap() error {
+	return pe.err
+}
+
+// QueueConfig configures how the jobs in a queue are run.
+type QueueConfig struct {
+	Concurrency int           // Jobs from the queue this process runs at the same time.
+	Visibility  time.Duration // How long a claimed job is hidden from other workers.
+	BackoffBase time.Duration // Wait before the first retry, doubled for every retry after.
+	BackoffMax  time.Duration // Longest wait between retries.
+}
+
+// DefaultQueueConfig is used for the settings a queue leaves as zero.
+var DefaultQueueConfig = QueueConfig{
+	Concurrency: 1,
+	Visibility:  5 * time.Minute,
+	BackoffBase: 10 * time.Second,
+	BackoffMax:  time.Hour,
+}
+
+// Backoff returns how long to wait before retrying a job that failed the
+// specified attempt, starting from 1.
+func (qc QueueConfig) Backoff(attempt int) time.Duration {
+	backoff := qc.BackoffBase
+	for i := 1; i < attempt && backoff < qc.BackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > qc.BackoffMax {
+		backoff = qc.BackoffMax
+	}
+	return backoff
+}
+
+// withDefaults fills in the settings left as zero.
+func (qc QueueConfig) withDefaults() QueueConfig {
+	if qc.Concurrency <= 0 {
+		qc.Concurrency = DefaultQueueConfig.Concurrency
+	}
+	if qc.Visibility <= 0 {
+		qc.Visibility = DefaultQueueConfig.Visibility
+	}
+	if qc.BackoffBase <= 0 {
+		qc.BackoffBase = DefaultQueueConfig.BackoffBase
+	}
+	if qc.BackoffMax <= 0 {
+		qc.BackoffMax = DefaultQueueConfig.BackoffMax
+	}
+	return qc
+}
+
+// =============================================================================
+
+// DefaultPollInterval is how long an idle worker waits before looking for
+// work again.
+const DefaultPollInterval = time.Second
+
+// Options represent optional parameters.
+type Options struct {
+	poll time.Duration
+}
+
+// WithPollInterval sets how long an idle worker waits before looking for work
+// again.
+func WithPollInterval(poll time.Duration) func(opts *Options) {
+	return func(opts *Options) {
+		opts.poll = poll
+	}
+}
+
+// queue is a registered queue and the handler for its jobs. A periodic queue
+// runs its handler every interval.
+type queue struct {
+	handler Handler
+	config  QueueConfig
+	every   time.Duration
+}
+
+// Pool runs the jobs in the registered queues, each with its own number of
+// workers.
+type Pool struct {
+	log    *zap.SugaredLogger
+	store  Store
+	poll   time.Duration
+	queues map[string]queue
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewPool constructs a pool of workers. Queues are registered before the
+// pool is started.
+func NewPool(log *zap.SugaredLogger, db *sqlx.DB, options ...func(opts *Options)) *Pool {
+	opts := Options{
+		poll: DefaultPollInterval,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pool{
+		log:    log,
+		store:  NewStore(log, db),
+		poll:   opts.poll,
+		queues: make(map[string]queue),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Register sets the handler for the jobs in the named queue.
+func (p *Pool) Register(name string, handler Handler, config QueueConfig) {
+	p.queues[name] = queue{
+		handler: handler,
+		config:  config.withDefaults(),
+	}
+}
+
+// RegisterPeriodic sets a handler to run every interval, for work that isn't
+// asked for by anything else, like clearing out expired rows. The queue holds
+// a single job, run as soon as the pool starts and then again an interval
+// after each run finishes, however many processes share the queue. A run
+// that fails isn't retried, since the next one comes around anyway.
+func (p *Pool) RegisterPeriodic(name string, handler Handler, every time.Duration, config QueueConfig) {
+	p.queues[name] = queue{
+		handler: handler,
+		config:  config.withDefaults(),
+		every:   every,
+	}
+}
+
+// Start starts the workers for every registered queue, scheduling the first
+// run of the periodic ones.
+func (p *Pool) Start() {
+	for name, q := range p.queues {
+		if q.every > 0 {
+			p.schedule(name, time.Now().UTC())
+		}
+
+		p.log.Infow("jobs", "status", "workers started", "queue", name, "concurrency", q.config.Concurrency)
+
+		p.wg.Add(q.config.Concurrency)
+		for i := 0; i < q.config.Concurrency; i++ {
+			go p.work(name, q)
+		}
+	}
+}
+
+// Shutdown stops the workers claiming jobs and waits for the jobs they are
+// running to finish. If the context ends first, the running jobs are
+// cancelled and put back in their queues, and the context's error is
+// returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return fmt.Errorf("waiting for running jobs: %w", ctx.Err())
+	}
+}
+
+// work claims and runs jobs from the queue until the pool is stopped,
+// waiting for the poll interval whenever the queue is empty.
+func (p *Pool) work(name string, q queue) {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-timer.C:
+		}
+
+		job, err := p.store.claim(p.ctx, name, q.config.Visibility, time.Now().UTC())
+		switch {
+		case err == nil:
+			p.run(q, job)
+			timer.Reset(0)
+			continue
+		case !errors.Is(err, ErrNotFound) && p.ctx.Err() == nil:
+			p.log.Errorw("jobs", "status", "claiming job", "queue", name, "ERROR", err)
+		}
+
+		timer.Reset(p.poll)
+	}
+}
+
+// run runs a claimed job and records how it went.
+func (p *Pool) run(q queue, job Job) {
+	claimed := job
+
+	switch {
+	case job.Attempts > job.MaxAttempts:
+
+		// The last attempt ran out its claim without finishing, so there
+		// is nothing left to try.
+		job.Status = StatusDead
+		job.LastError = errorString(errors.New("visibility timeout passed on the last attempt"))
+
+	default:
+		ctx, cancel := context.WithTimeout(p.ctx, q.config.Visibility)
+		err := call(ctx, q.handler, job)
+		cancel()
+
+		switch {
+		case err == nil:
+			job.Status = StatusSucceeded
+			job.LastError = nil
+
+		case p.ctx.Err() != nil:
+
+			// The pool is shutting down, which isn't the job's fault, so the
+			// attempt is given back.
+			job.Status = StatusPending
+			job.Attempts--
+			job.RunAt = time.Now().UTC()
+			job.LastError = errorString(err)
+
+		default:
+			var pe *permanentError
+			job.LastError = errorString(err)
+			job.Status = StatusDead
+			if !errors.As(err, &pe) && job.Attempts < job.MaxAttempts {
+				job.Status = StatusPending
+				job.RunAt = time.Now().UTC().Add(q.config.Backoff(job.Attempts))
+			}
+		}
+	}
+
+	job.LockedUntil = nil
+	job.DateUpdated = time.Now().UTC()
+
+	switch job.Status {
+	case StatusSucceeded:
+		p.log.Infow("jobs", "status", "job succeeded", "queue", job.Queue, "id", job.ID, "attempt", claimed.Attempts)
+	case StatusDead:
+		p.log.Errorw("jobs", "status", "job dead", "queue", job.Queue, "id", job.ID, "attempt", claimed.Attempts, "ERROR", *job.LastError)
+	default:
+		p.log.Infow("jobs", "status", "job failed", "queue", job.Queue, "id", job.ID, "attempt", claimed.Attempts, "retry", job.RunAt, "ERROR", *job.LastError)
+	}
+
+	// The outcome is recorded even while shutting down, so it doesn't use
+	// the pool's context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.store.finish(ctx, claimed, job); err != nil {
+		p.log.Errorw("jobs", "status", "recording outcome", "queue", job.Queue, "id", job.ID, "ERROR", err)
+	}
+
+	if q.every > 0 && job.Status != StatusPending {
+		p.schedule(job.Queue, time.Now().UTC().Add(q.every))
+	}
+}
+
+// schedule adds the next run of a periodic queue, unless the queue already
+// holds one.
+func (p *Pool) schedule(name string, runAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nj := NewJob{
+		Queue:       name,
+		Payload:     struct{}{},
+		MaxAttempts: 1,
+		RunAt:       runAt,
+	}
+
+	if _, err := p.store.Schedule(ctx, nj, time.Now().UTC()); err != nil {
+		p.log.Errorw("jobs", "status", "scheduling next run", "queue", name, "ERROR", err)
+	}
+}
+
+// call runs the handler, turning a panic into an error so one bad job can't
+// take the service down.
+func call(ctx context.Context, handler Handler, job Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("PANIC [%v]", rec)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// errorString returns the message of an error for the last_error column.
+func errorString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	s := err.Error()
+	return &s
+}
@@ -0,0 +1,218 @@
+// Package outbox provides support for recording the changes made to the
+// system as events, in the same transaction as the changes themselves, so an
+// event exists exactly when its change was committed. Events are numbered in
+// the order they were committed, which lets readers tail them by ID.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/database"
+	"github.com/fadhilijuma/images/business/sys/validate"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"go.uber.org/zap"
+)
+
+// PruneQueue is the job queue events past their retention are removed from.
+const PruneQueue = "events-prune"
+
+// Event is a change recorded in the outbox.
+type Event struct {
+	ID          int64          `db:"event_id" json:"id"`               // Position of the event in commit order.
+	Event       string         `db:"event" json:"event"`               // Name of the event, such as image.created.
+	UserID      *string        `db:"user_id" json:"user_id"`           // User the change is about or who owns what changed.
+	Data        types.JSONText `db:"data" json:"data"`                 // What the event is about, such as the image.
+	DateCreated time.Time      `db:"date_created" json:"date_created"` // When the change was made.
+}
+
+// NewEvent is what we require to record an event. The data is stored as
+// JSON.
+type NewEvent struct {
+	Event  string `json:"event" validate:"required,max=64"`
+	UserID string `json:"user_id" validate:"omitempty,uuid4"`
+	Data   any    `json:"data"`
+}
+
+// QueryFilter holds the fields events can be read by. Nil fields are not
+// filtered on.
+type QueryFilter struct {
+	UserID *string `json:"user_id" validate:"omitempty,uuid4"`
+}
+
+// =============================================================================
+
+// Store manages the set of APIs for outbox access.
+type Store struct {
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+}
+
+// NewStore constructs a store for outbox access.
+func NewStore(log *zap.SugaredLogger, db *sqlx.DB) Store {
+	return Store{
+		log: log,
+		tr:  db,
+		db:  db,
+	}
+}
+
+// WithinTran runs passed function and do commit/rollback at the end.
+func (s Store) WithinTran(ctx context.Context, fn func(sqlx.ExtContext) error) error {
+	if s.isWithinTran {
+		return fn(s.db)
+	}
+	return database.WithinTran(ctx, s.log, s.tr, fn)
+}
+
+// Tran return new Store with transaction in it. Appending through it makes
+// the event part of the caller's transaction, so the event is only recorded
+// if the change it's about is committed.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+	}
+}
+
+// Append records an event. It should be the last statement of the
+// transaction, because it holds a lock until the transaction ends: events
+// are numbered under the lock, so they're numbered in the order their
+// transactions commit and a reader that has seen an event has seen every
+// event before it.
+func (s Store) Append(ctx context.Context, ne NewEvent, now time.Time) error {
+	if err := validate.Check(ne); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	data, err := json.Marshal(ne.Data)
+	if err != nil {
+		return fmt.Errorf("encoding data: %w", err)
+	}
+
+	evt := Event{
+		Event:       ne.Event,
+		Data:        data,
+		DateCreated: now,
+	}
+	if ne.UserID != "" {
+		evt.UserID = &ne.UserID
+	}
+
+	const lock = `
+	SELECT
+		pg_advisory_xact_lock(hashtext('events'))`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, lock, struct{}{}); err != nil {
+		return fmt.Errorf("locking events: %w", err)
+	}
+
+	const q = `
+	INSERT INTO events
+		(event, user_id, data, date_created)
+	VALUES
+		(:event, :user_id, :data, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, evt); err != nil {
+		return fmt.Errorf("inserting event: %w", err)
+	}
+
+	return nil
+}
+
+// QueryAfter gets up to limit events matching the filter that were recorded
+// after the specified event, oldest first.
+func (s Store) QueryAfter(ctx context.Context, filter QueryFilter, after int64, limit int) ([]Event, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, fmt.Errorf("validating filter: %w", err)
+	}
+
+	data := struct {
+		UserID *string `db:"user_id"`
+		After  int64   `db:"after"`
+		Limit  int     `db:"limit"`
+	}{
+		UserID: filter.UserID,
+		After:  after,
+		Limit:  limit,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		events
+	WHERE
+		event_id > :after AND
+		(CAST(:user_id AS UUID) IS NULL OR user_id = :user_id)
+	ORDER BY
+		event_id
+	LIMIT :limit`
+
+	var evts []Event
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &evts); err != nil {
+		return nil, fmt.Errorf("selecting events after[%d]: %w", after, err)
+	}
+
+	return evts, nil
+}
+
+// QueryLastID gets the ID of the last event recorded, or zero when there are
+// none. Tailing from it reads the events recorded from now on.
+func (s Store) QueryLastID(ctx context.Context) (int64, error) {
+	const q = `
+	SELECT
+		COALESCE(MAX(event_id), 0) AS event_id
+	FROM
+		events`
+
+	var last struct {
+		ID int64 `db:"event_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, struct{}{}, &last); err != nil {
+		return 0, fmt.Errorf("selecting last event: %w", err)
+	}
+
+	return last.ID, nil
+}
+
+// DeleteBefore removes the events recorded before the specified time and
+// returns how many were removed. Readers resuming from a removed event pick
+// up at the oldest event left.
+func (s Store) DeleteBefore(ctx context.Context, before time.Time) (int, error) {
+	data := struct {
+		Before time.Time `db:"before"`
+	}{
+		Before: before,
+	}
+
+	const q = `
+	WITH deleted AS (
+		DELETE FROM
+			events
+		WHERE
+			date_created < :before
+		RETURNING
+			1
+	)
+	SELECT
+		COUNT(*) AS count
+	FROM
+		deleted`
+
+	var deleted struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &deleted); err != nil {
+		return 0, fmt.Errorf("deleting events: %w", err)
+	}
+
+	return deleted.Count, nil
+}
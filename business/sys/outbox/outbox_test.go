@@ -0,0 +1,138 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/business/data/dbtest"
+	"github.com/fadhilijuma/images/business/sys/outbox"
+	"github.com/fadhilijuma/images/foundation/docker"
+	"github.com/jmoiron/sqlx"
+)
+
+var c *docker.Container
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = dbtest.StartDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dbtest.StopDB(c)
+
+	m.Run()
+}
+
+func Test_Outbox(t *testing.T) {
+	log, db, teardown := dbtest.NewUnit(t, c, "testoutbox")
+	t.Cleanup(teardown)
+
+	store := outbox.NewStore(log, db)
+
+	t.Log("Given the need to record changes as events.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen appending events in transactions.", testID)
+		{
+			ctx := context.Background()
+			now := time.Now().UTC()
+
+			const (
+				userID  = "5cf37266-3473-4006-984f-9325122678b7"
+				otherID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			)
+
+			start, err := store.QueryLastID(ctx)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the last event : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to query the last event.", dbtest.Success, testID)
+
+			committed := func(tx sqlx.ExtContext) error {
+				tran := store.Tran(tx)
+				if err := tran.Append(ctx, outbox.NewEvent{Event: "image.created", UserID: userID, Data: map[string]string{"id": "1"}}, now); err != nil {
+					return err
+				}
+				return tran.Append(ctx, outbox.NewEvent{Event: "image.created", UserID: otherID, Data: map[string]string{"id": "2"}}, now)
+			}
+			if err := store.WithinTran(ctx, committed); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to append events : %s.", dbtest.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to append events.", dbtest.Success, testID)
+
+			errRollback := errors.New("change failed")
+			rolledBack := func(tx sqlx.ExtContext) error {
+				if err := store.Tran(tx).Append(ctx, outbox.NewEvent{Event: "image.deleted", UserID: userID}, now); err != nil {
+					return err
+				}
+				return errRollback
+			}
+			if err := store.WithinTran(ctx, rolledBack); !errors.Is(err, errRollback) {
+				t.Fatalf("\t%s\tTest %d:\tShould get the error that rolled back the change : %v.", dbtest.Failed, testID, err)
+			}
+
+			if err := store.Append(ctx, outbox.NewEvent{}, now); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to append an event without a name.", dbtest.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to append an event without a name.", dbtest.Success, testID)
+
+			evts, err := store.QueryAfter(ctx, outbox.QueryFilter{}, start, 10)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query events : %s.", dbtest.Failed, testID, err)
+			}
+			if len(evts) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould only see the committed events : got %d.", dbtest.Failed, testID, len(evts))
+			}
+			if evts[0].ID >= evts[1].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould see events in the order they were recorded : %d, %d.", dbtest.Failed, testID, evts[0].ID, evts[1].ID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only see the committed events, in order.", dbtest.Success, testID)
+
+			last, err := store.QueryLastID(ctx)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query the last event : %s.", dbtest.Failed, testID, err)
+			}
+			if last != evts[1].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould get the last event committed : got %d, exp %d.", dbtest.Failed, testID, last, evts[1].ID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the last event committed.", dbtest.Success, testID)
+
+			uid := userID
+			evts, err = store.QueryAfter(ctx, outbox.QueryFilter{UserID: &uid}, start, 10)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to query events by user : %s.", dbtest.Failed, testID, err)
+			}
+			if len(evts) != 1 || evts[0].UserID == nil || *evts[0].UserID != userID {
+				t.Fatalf("\t%s\tTest %d:\tShould only see the user's events : %+v.", dbtest.Failed, testID, evts)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only see the user's events.", dbtest.Success, testID)
+
+			evts, err = store.QueryAfter(ctx, outbox.QueryFilter{}, last, 10)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to resume after the last event : %s.", dbtest.Failed, testID, err)
+			}
+			if len(evts) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould see no events after the last : got %d.", dbtest.Failed, testID, len(evts))
+			}
+			t.Logf("\t%s\tTest %d:\tShould see no events after the last.", dbtest.Success, testID)
+
+			if deleted, err := store.DeleteBefore(ctx, now.Add(-time.Second)); err != nil || deleted != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the events within the retention : %d : %v.", dbtest.Failed, testID, deleted, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the events within the retention.", dbtest.Success, testID)
+
+			deleted, err := store.DeleteBefore(ctx, now.Add(time.Second))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete old events : %s.", dbtest.Failed, testID, err)
+			}
+			if deleted != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould delete every old event : got %d.", dbtest.Failed, testID, deleted)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete old events.", dbtest.Success, testID)
+		}
+	}
+}
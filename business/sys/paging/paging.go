@@ -0,0 +1,126 @@
+// Package paging provides support for keyset pagination with opaque cursors.
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Set of error variables for paging.
+var (
+	ErrInvalidCursor = errors.New("cursor is not valid")
+	ErrInvalidSort   = errors.New("sort is not valid")
+	ErrInvalidLimit  = errors.New("limit is not valid")
+)
+
+// Set of limits on the number of rows in a page.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Page describes the page of rows a client asks for. Sort names the field to
+// order by, prefixed with "-" for descending order. An empty cursor asks for
+// the first page.
+type Page struct {
+	Limit  int
+	Sort   string
+	Cursor string
+}
+
+// Order is the parsed sort order of a page.
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// String returns the order in the form used by Page.Sort.
+func (o Order) String() string {
+	if o.Desc {
+		return "-" + o.Field
+	}
+	return o.Field
+}
+
+// Cursor marks the last row of a page. Rows are ordered by the sort field
+// and then by ID, so the pair identifies a unique position.
+type Cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+// Parse validates the page against the fields that can be sorted on and
+// returns the order, the limit and the decoded cursor. The order defaults to
+// the specified default when the page doesn't name one. A nil cursor means
+// the first page.
+func Parse(page Page, defaultSort string, fields ...string) (Order, int, *Cursor, error) {
+	sort := page.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+
+	order := Order{Field: strings.TrimPrefix(sort, "-"), Desc: strings.HasPrefix(sort, "-")}
+	if !contains(fields, order.Field) {
+		return Order{}, 0, nil, fmt.Errorf("%w: %q, must be one of %s", ErrInvalidSort, sort, strings.Join(fields, ", "))
+	}
+
+	limit := page.Limit
+	switch {
+	case limit == 0:
+		limit = DefaultLimit
+	case limit < 0 || limit > MaxLimit:
+		return Order{}, 0, nil, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidLimit, MaxLimit)
+	}
+
+	if page.Cursor == "" {
+		return order, limit, nil, nil
+	}
+
+	cursor, err := decode(page.Cursor)
+	if err != nil {
+		return Order{}, 0, nil, err
+	}
+
+	// A cursor only has meaning for the order it was made in.
+	if cursor.Sort != order.String() {
+		return Order{}, 0, nil, fmt.Errorf("%w: made for sort %q", ErrInvalidCursor, cursor.Sort)
+	}
+
+	return order, limit, &cursor, nil
+}
+
+// Next returns the cursor for the page after the one whose last row has the
+// specified sort key and ID.
+func Next(order Order, key string, id string) string {
+	data, _ := json.Marshal(Cursor{Sort: order.String(), Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decode reads a cursor token.
+func decode(token string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// contains reports whether the value is in the list.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package paging_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fadhilijuma/images/business/sys/paging"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Parse(t *testing.T) {
+	fields := []string{"date_uploaded", "id", "size"}
+
+	t.Log("Given the need to parse a page of a keyset.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling the first page.", testID)
+		{
+			order, limit, cursor, err := paging.Parse(paging.Page{}, "-date_uploaded", fields...)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the page : %s.", failed, testID, err)
+			}
+			if order.Field != "date_uploaded" || !order.Desc || limit != paging.DefaultLimit || cursor != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould get the defaults : got %+v %d %v.", failed, testID, order, limit, cursor)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the defaults.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen handling the next page.", testID)
+		{
+			order := paging.Order{Field: "size"}
+			token := paging.Next(order, "1024", "a2b0639f-2cc6-44b8-b97b-15d69dbb511e")
+
+			_, _, cursor, err := paging.Parse(paging.Page{Sort: "size", Cursor: token}, "-date_uploaded", fields...)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to parse the page : %s.", failed, testID, err)
+			}
+			if cursor == nil || cursor.Key != "1024" || cursor.ID != "a2b0639f-2cc6-44b8-b97b-15d69dbb511e" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the cursor : got %+v.", failed, testID, cursor)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the cursor.", success, testID)
+
+			if _, _, _, err := paging.Parse(paging.Page{Sort: "-size", Cursor: token}, "-date_uploaded", fields...); !errors.Is(err, paging.ErrInvalidCursor) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use the cursor with another sort : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use the cursor with another sort.", success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen handling pages that aren't valid.", testID)
+		{
+			tests := []struct {
+				page paging.Page
+				err  error
+			}{
+				{paging.Page{Sort: "name"}, paging.ErrInvalidSort},
+				{paging.Page{Limit: -1}, paging.ErrInvalidLimit},
+				{paging.Page{Limit: paging.MaxLimit + 1}, paging.ErrInvalidLimit},
+				{paging.Page{Cursor: "not a cursor!"}, paging.ErrInvalidCursor},
+				{paging.Page{Cursor: "bm90IGpzb24"}, paging.ErrInvalidCursor},
+			}
+
+			for _, tt := range tests {
+				if _, _, _, err := paging.Parse(tt.page, "id", fields...); !errors.Is(err, tt.err) {
+					t.Fatalf("\t%s\tTest %d:\tShould reject %+v : got %v.", failed, testID, tt.page, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the pages.", success, testID)
+		}
+	}
+}
@@ -16,7 +16,8 @@ const (
 // Claims represents the authorization claims transmitted via a JWT.
 type Claims struct {
 	jwt.RegisteredClaims
-	Roles []string `json:"roles"`
+	Roles          []string `json:"roles"`
+	OrganizationID string   `json:"org_id,omitempty"`
 }
 
 // Authorized returns true if the claims has at least one of the provided roles.
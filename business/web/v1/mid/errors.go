@@ -2,9 +2,12 @@ package mid
 
 import (
 	"context"
+	"net/http"
+
+	"github.com/fadhilijuma/images/business/sys/validate"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
 	"github.com/fadhilijuma/images/foundation/web"
 	"go.uber.org/zap"
-	"net/http"
 )
 
 // Errors handles errors coming out of the call chain. It detects normal
@@ -31,7 +34,45 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 				// Log the error.
 				log.Errorw("ERROR", "traceid", v.TraceID, "message", err)
 
+				// Build out the error response.
+				var er v1Web.ErrorResponse
+				var status int
+				switch {
+				case validate.IsFieldErrors(err):
+					fieldErrors := validate.GetFieldErrors(err)
+					er = v1Web.ErrorResponse{
+						Error:  "data validation error",
+						Fields: fieldErrors.Fields(),
+					}
+					status = http.StatusBadRequest
+
+				case v1Web.IsRequestError(err):
+					reqErr := v1Web.GetRequestError(err)
+					er = v1Web.ErrorResponse{
+						Error:  reqErr.Error(),
+						Fields: reqErr.Fields,
+					}
+					status = reqErr.Status
+
+				default:
+					er = v1Web.ErrorResponse{
+						Error: http.StatusText(http.StatusInternalServerError),
+					}
+					status = http.StatusInternalServerError
+				}
+
+				// Respond with the error back to the client.
+				if err := web.Respond(ctx, w, er, status); err != nil {
+					return err
+				}
+
+				// If we receive the shutdown err we need to return it
+				// back to the base handler to shut down the service.
+				if web.IsShutdown(err) {
+					return err
+				}
 			}
+
 			return nil
 		}
 
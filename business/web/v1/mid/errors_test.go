@@ -0,0 +1,88 @@
+package mid_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/fadhilijuma/images/business/sys/validate"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/business/web/v1/mid"
+	"github.com/fadhilijuma/images/foundation/web"
+	"go.uber.org/zap"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		resp   v1Web.ErrorResponse
+	}{
+		{
+			"a request error",
+			v1Web.NewRequestError(errors.New("image not found"), http.StatusNotFound),
+			http.StatusNotFound,
+			v1Web.ErrorResponse{Error: "image not found"},
+		},
+		{
+			"field errors",
+			validate.FieldErrors{{Field: "name", Error: "name is a required field"}},
+			http.StatusBadRequest,
+			v1Web.ErrorResponse{Error: "data validation error", Fields: map[string]string{"name": "name is a required field"}},
+		},
+		{
+			"an unexpected error",
+			errors.New("connection refused"),
+			http.StatusInternalServerError,
+			v1Web.ErrorResponse{Error: http.StatusText(http.StatusInternalServerError)},
+		},
+	}
+
+	t.Log("Given the need to respond to the errors handlers return.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen a handler returns %s.", testID, tt.name)
+			{
+				app := web.NewApp(make(chan os.Signal, 1), mid.Errors(zap.NewNop().Sugar()))
+
+				h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+					return tt.err
+				}
+				app.Handle(http.MethodGet, "", "/", h)
+
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				if w.Code != tt.status {
+					t.Fatalf("\t%s\tTest %d:\tShould get a %d status : got %d.", failed, testID, tt.status, w.Code)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get a %d status.", success, testID, tt.status)
+
+				var resp v1Web.ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the response : %s.", failed, testID, err)
+				}
+				if resp.Error != tt.resp.Error || len(resp.Fields) != len(tt.resp.Fields) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the error response : got %+v.", failed, testID, resp)
+				}
+				for field, msg := range tt.resp.Fields {
+					if resp.Fields[field] != msg {
+						t.Fatalf("\t%s\tTest %d:\tShould get the error for field %q : got %q.", failed, testID, field, resp.Fields[field])
+					}
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the error response.", success, testID)
+			}
+		}
+	}
+}
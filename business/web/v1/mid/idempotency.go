@@ -0,0 +1,226 @@
+package mid
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fadhilijuma/images/business/sys/idempotency"
+	"github.com/fadhilijuma/images/business/web/auth"
+	v1Web "github.com/fadhilijuma/images/business/web/v1"
+	"github.com/fadhilijuma/images/foundation/web"
+	"go.uber.org/zap"
+)
+
+// errBodyTooLarge is returned when a request made with an idempotency key has
+// a body too large to keep a copy of.
+var errBodyTooLarge = errors.New("request body is too large to be retried safely")
+
+// idempotencyTimeout bounds recording the outcome of a request. It's
+// recorded even when the client has gone, since the client going away is
+// usually why it retries.
+const idempotencyTimeout = 5 * time.Second
+
+// Idempotency makes the requests carrying an Idempotency-Key header safe to
+// retry. The response to the first request made with a key is kept for the
+// TTL and replayed to every retry with the same request, while a retry with
+// a different request is refused with 422. A retry sent while the first
+// request is still being handled is refused with 409, unless the first
+// request held the key longer than the lease and is given up on. Requests
+// that fail aren't kept, so retrying them handles them again. Requests with a
+// key and a body larger than maxBody are refused with 413. It requires the
+// claims set by Authenticate, since keys are kept per user.
+func Idempotency(log *zap.SugaredLogger, keys idempotency.Store, ttl time.Duration,
+	lease time.Duration, maxBody int64) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	m := func(handler web.Handler) web.Handler {
+
+		// Create the handler that will be attached in the middleware chain.
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				return handler(ctx, w, r)
+			}
+
+			v, err := web.GetValues(ctx)
+			if err != nil {
+				return web.NewShutdownError("web value missing from context")
+			}
+
+			claims, err := auth.GetClaims(ctx)
+			if err != nil {
+				return v1Web.NewRequestError(auth.ErrForbidden, http.StatusForbidden)
+			}
+
+			fingerprint, err := fingerprintRequest(w, r, maxBody)
+			if err != nil {
+				if errors.Is(err, errBodyTooLarge) {
+					return v1Web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+				}
+				return fmt.Errorf("fingerprinting request: %w", err)
+			}
+			defer r.Body.Close()
+
+			nk := idempotency.NewKey{
+				UserID:      claims.Subject,
+				Key:         key,
+				Fingerprint: fingerprint,
+				Lease:       lease,
+				TTL:         ttl,
+			}
+
+			held, reserved, err := keys.Reserve(ctx, nk, v.Now)
+			if err != nil {
+				return err
+			}
+
+			if !reserved {
+				switch {
+				case held.Fingerprint != fingerprint:
+					return v1Web.NewRequestError(idempotency.ErrMismatch, http.StatusUnprocessableEntity)
+
+				case !held.Completed():
+					retry := 1
+					if held.LockedUntil != nil {
+						retry = int(math.Ceil(held.LockedUntil.Sub(v.Now).Seconds()))
+						if retry < 1 {
+							retry = 1
+						}
+					}
+					w.Header().Set("Retry-After", strconv.Itoa(retry))
+					return v1Web.NewRequestError(idempotency.ErrInFlight, http.StatusConflict)
+				}
+
+				return replay(ctx, w, held)
+			}
+
+			rw := recordingWriter{ResponseWriter: w}
+			err = handler(ctx, &rw, r)
+
+			rctx, cancel := context.WithTimeout(context.Background(), idempotencyTimeout)
+			defer cancel()
+
+			if err != nil || rw.status == 0 || rw.status >= http.StatusInternalServerError {
+				if err := keys.Release(rctx, claims.Subject, key); err != nil {
+					log.Errorw("idempotency", "traceid", v.TraceID, "status", "releasing key", "ERROR", err)
+				}
+				return err
+			}
+
+			held.StatusCode = rw.status
+			held.ContentType = rw.Header().Get("Content-Type")
+			held.Body = rw.body.Bytes()
+
+			if err := keys.Complete(rctx, held); err != nil {
+				log.Errorw("idempotency", "traceid", v.TraceID, "status", "completing key", "ERROR", err)
+			}
+
+			return nil
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// fingerprintRequest returns the hex encoded SHA-256 of the method, path,
+// query, content type and body of a request. The content type is included
+// since it holds the boundary a multipart body is split on. The body is read
+// to the end to hash it, so it's spooled to a temporary file the request
+// reads from instead, which is removed when the body is closed. A body
+// larger than maxBody returns errBodyTooLarge.
+func fingerprintRequest(w http.ResponseWriter, r *http.Request, maxBody int64) (string, error) {
+	f, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return "", err
+	}
+	body := spooledBody{File: f}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	fmt.Fprintf(h, "Content-Type: %s\n", r.Header.Get("Content-Type"))
+
+	n, err := io.Copy(io.MultiWriter(f, h), http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		body.Close()
+		if n >= maxBody {
+			return "", errBodyTooLarge
+		}
+		return "", err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return "", err
+	}
+	r.Body = body
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replay sends the response recorded for a key again.
+func replay(ctx context.Context, w http.ResponseWriter, k idempotency.Key) error {
+	web.SetStatusCode(ctx, k.StatusCode)
+
+	if k.ContentType != "" {
+		w.Header().Set("Content-Type", k.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(k.StatusCode)
+
+	if _, err := w.Write(k.Body); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// spooledBody is a request body read from a temporary file, which is removed
+// when the body is closed.
+type spooledBody struct {
+	*os.File
+}
+
+// Close closes and removes the file.
+func (sb spooledBody) Close() error {
+	err := sb.File.Close()
+	os.Remove(sb.File.Name())
+	return err
+}
+
+// recordingWriter records the status and body of a response as it's written.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status before writing it.
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body before writing it.
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,77 @@
+package mid
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_FingerprintRequest(t *testing.T) {
+	newRequest := func(target string, contentType string, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		r     *http.Request
+		match bool
+	}{
+		{"the same request", newRequest("/v1/images/raw?visibility=private", "image/png", "content"), true},
+		{"a different query", newRequest("/v1/images/raw?visibility=public", "image/png", "content"), false},
+		{"a different content type", newRequest("/v1/images/raw?visibility=private", "image/jpeg", "content"), false},
+		{"a different body", newRequest("/v1/images/raw?visibility=private", "image/png", "other content"), false},
+	}
+
+	t.Log("Given the need to tell retries from different requests made with a key.")
+	{
+		original := newRequest("/v1/images/raw?visibility=private", "image/png", "content")
+		want, err := fingerprintRequest(httptest.NewRecorder(), original, 1024)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to fingerprint a request : %s.", failed, err)
+		}
+		defer original.Body.Close()
+
+		body, err := io.ReadAll(original.Body)
+		if err != nil || string(body) != "content" {
+			t.Fatalf("\t%s\tShould still be able to read the body : %q : %v.", failed, body, err)
+		}
+		t.Logf("\t%s\tShould still be able to read the body.", success)
+
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen fingerprinting %s.", testID, tt.name)
+			{
+				got, err := fingerprintRequest(httptest.NewRecorder(), tt.r, 1024)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to fingerprint the request : %s.", failed, testID, err)
+				}
+				tt.r.Body.Close()
+
+				if (got == want) != tt.match {
+					t.Fatalf("\t%s\tTest %d:\tShould match the original only when it's the same request : match %t.", failed, testID, got == want)
+				}
+				t.Logf("\t%s\tTest %d:\tShould match the original only when it's the same request.", success, testID)
+			}
+		}
+
+		testID := len(tests)
+		t.Logf("\tTest %d:\tWhen fingerprinting a request with a body over the limit.", testID)
+		{
+			r := newRequest("/v1/images/raw", "image/png", strings.Repeat("x", 2048))
+			if _, err := fingerprintRequest(httptest.NewRecorder(), r, 1024); !errors.Is(err, errBodyTooLarge) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to fingerprint the request : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to fingerprint the request.", success, testID)
+		}
+	}
+}
@@ -1,7 +1,16 @@
 // Package v1 represents types used by the web application for v1.
 package v1
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fadhilijuma/images/business/sys/paging"
+)
 
 // ErrorResponse is the form used for API responses from failures in the API.
 type ErrorResponse struct {
@@ -14,12 +23,19 @@ type ErrorResponse struct {
 type RequestError struct {
 	Err    error
 	Status int
+	Fields map[string]string
 }
 
 // NewRequestError wraps a provided error with an HTTP status code. This
 // function should be used when handlers encounter expected errors.
 func NewRequestError(err error, status int) error {
-	return &RequestError{err, status}
+	return &RequestError{Err: err, Status: status}
+}
+
+// NewRequestErrorFields wraps a provided error with an HTTP status code and
+// a set of fields that give the client more context about the failure.
+func NewRequestErrorFields(err error, status int, fields map[string]string) error {
+	return &RequestError{Err: err, Status: status, Fields: fields}
 }
 
 // Error implements the error interface. It uses the default message of the
@@ -42,3 +58,115 @@ func GetRequestError(err error) *RequestError {
 	}
 	return re
 }
+
+// ParsePage reads the limit, sort and cursor query parameters that select a
+// page of a keyset.
+func ParsePage(r *http.Request) (paging.Page, error) {
+	values := r.URL.Query()
+
+	page := paging.Page{
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return paging.Page{}, NewRequestError(fmt.Errorf("invalid limit format, limit[%s]", v), http.StatusBadRequest)
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
+
+// ErrPreconditionFailed is returned when a change is conditional on a
+// version of a resource that isn't its current one.
+var ErrPreconditionFailed = errors.New("resource has changed since the version specified")
+
+// ETag returns the entity tag identifying a version of a resource.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ParseIfMatch reads the version of a resource a change is conditional on
+// from the If-Match header. It returns nil when the header is missing or is
+// a *, since the change then applies to any version. Tags that can't match
+// any version, such as weak ones, fail the precondition right away.
+func ParseIfMatch(r *http.Request) (*int, error) {
+	tag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return nil, nil
+	}
+
+	if strings.Contains(tag, ",") {
+		return nil, NewRequestError(errors.New("If-Match must hold a single entity tag"), http.StatusBadRequest)
+	}
+
+	if strings.HasPrefix(tag, "W/") {
+		return nil, NewRequestError(ErrPreconditionFailed, http.StatusPreconditionFailed)
+	}
+
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return nil, NewRequestError(fmt.Errorf("invalid If-Match format, tag[%s]", tag), http.StatusBadRequest)
+	}
+
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil {
+		return nil, NewRequestError(ErrPreconditionFailed, http.StatusPreconditionFailed)
+	}
+
+	return &version, nil
+}
+
+// IsPagingError checks if an error is caused by a page that isn't valid.
+func IsPagingError(err error) bool {
+	return errors.Is(err, paging.ErrInvalidCursor) || errors.Is(err, paging.ErrInvalidSort) || errors.Is(err, paging.ErrInvalidLimit)
+}
+
+// PageDocument is the form used for API responses holding a page of a
+// keyset. NextCursor is empty on the last page.
+type PageDocument[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// NewPageDocument constructs the response for a page, adding an RFC 8288
+// Link header that points to the next page when there is one.
+func NewPageDocument[T any](w http.ResponseWriter, r *http.Request, items []T, nextCursor string) PageDocument[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	if nextCursor != "" {
+		w.Header().Add("Link", "<"+nextURL(r, nextCursor)+`>; rel="next"`)
+	}
+
+	return PageDocument[T]{
+		Items:      items,
+		NextCursor: nextCursor,
+	}
+}
+
+// nextURL returns the URL of the request with its cursor replaced.
+func nextURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	u := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
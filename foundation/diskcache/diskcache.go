@@ -0,0 +1,218 @@
+// Package diskcache provides a size bounded cache of files on the local file
+// system that evicts the least recently used entries first.
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrMiss is returned when a key isn't in the cache.
+var ErrMiss = errors.New("cache miss")
+
+// tmpPrefix marks files that are still being written.
+const tmpPrefix = ".tmp-"
+
+// entry is a file held by the cache.
+type entry struct {
+	name string
+	size int64
+}
+
+// Cache is a size bounded cache of files in a directory.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// New constructs a cache that keeps at most maxBytes of files in dir. Files
+// already in the directory are adopted, oldest first, so the cache survives
+// restarts.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating dir[%s]: %w", dir, err)
+	}
+
+	c := Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading dir[%s]: %w", dir, err)
+	}
+
+	var infos []fs.FileInfo
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+
+		// Remove files left behind by writes that never finished.
+		if strings.HasPrefix(de.Name(), tmpPrefix) {
+			os.Remove(filepath.Join(dir, de.Name()))
+			continue
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, info := range infos {
+		c.entries[info.Name()] = c.lru.PushFront(&entry{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+
+	return &c, nil
+}
+
+// Get returns a reader for the cached file stored under key. The caller must
+// close the reader.
+func (c *Cache) Get(key string) (io.ReadSeekCloser, error) {
+	name := fileName(key)
+
+	c.mu.Lock()
+	el, exists := c.entries[name]
+	if exists {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+
+	if !exists {
+		return nil, ErrMiss
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.remove(name)
+			return nil, ErrMiss
+		}
+		return nil, fmt.Errorf("opening key[%s]: %w", key, err)
+	}
+
+	return f, nil
+}
+
+// Put stores data under key, evicting the least recently used files if the
+// cache grows beyond its size bound. Data bigger than the bound isn't cached.
+func (c *Cache) Put(key string, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	name := fileName(key)
+
+	tmp, err := os.CreateTemp(c.dir, tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing key[%s]: %w", key, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("closing key[%s]: %w", key, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("renaming key[%s]: %w", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, exists := c.entries[name]; exists {
+		e := el.Value.(*entry)
+		c.size += size - e.size
+		e.size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[name] = c.lru.PushFront(&entry{name: name, size: size})
+		c.size += size
+	}
+	c.evict()
+
+	return nil
+}
+
+// Size returns the number of bytes held by the cache.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// =============================================================================
+
+// evict removes the least recently used files until the cache fits inside
+// its size bound. The caller must hold the lock.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+
+		e := el.Value.(*entry)
+		c.lru.Remove(el)
+		delete(c.entries, e.name)
+		c.size -= e.size
+
+		os.Remove(filepath.Join(c.dir, e.name))
+	}
+}
+
+// remove forgets a file that has disappeared from the directory.
+func (c *Cache) remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, exists := c.entries[name]; exists {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+		delete(c.entries, name)
+	}
+}
+
+// fileName converts a key into a safe file name.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
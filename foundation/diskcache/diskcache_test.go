@@ -0,0 +1,94 @@
+package diskcache_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/fadhilijuma/images/foundation/diskcache"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Cache(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Log("Given the need to cache files with a size bound.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the cache fills up.", testID)
+		{
+			c, err := diskcache.New(dir, 10)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to construct a cache: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to construct a cache.", success, testID)
+
+			for _, key := range []string{"a", "b"} {
+				if err := c.Put(key, []byte(strings.Repeat(key, 4))); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to put key %q: %v", failed, testID, key, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to put keys.", success, testID)
+
+			// Reading "a" makes "b" the least recently used.
+			f, err := c.Get("a")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to get key \"a\": %v", failed, testID, err)
+			}
+			data, _ := io.ReadAll(f)
+			f.Close()
+			if string(data) != "aaaa" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same data: got %q", failed, testID, data)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the same data.", success, testID)
+
+			if err := c.Put("c", []byte("cccc")); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to put key \"c\": %v", failed, testID, err)
+			}
+
+			if _, err := c.Get("b"); !errors.Is(err, diskcache.ErrMiss) {
+				t.Fatalf("\t%s\tTest %d:\tShould evict the least recently used key: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould evict the least recently used key.", success, testID)
+
+			if c.Size() != 8 {
+				t.Fatalf("\t%s\tTest %d:\tShould stay inside the bound: got %d bytes", failed, testID, c.Size())
+			}
+			t.Logf("\t%s\tTest %d:\tShould stay inside the bound.", success, testID)
+
+			if err := c.Put("big", []byte(strings.Repeat("x", 11))); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould ignore data bigger than the bound: %v", failed, testID, err)
+			}
+			if _, err := c.Get("big"); !errors.Is(err, diskcache.ErrMiss) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT cache data bigger than the bound: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT cache data bigger than the bound.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the cache is reopened.", testID)
+		{
+			c, err := diskcache.New(dir, 10)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to construct a cache: %v", failed, testID, err)
+			}
+
+			f, err := c.Get("c")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the files already cached: %v", failed, testID, err)
+			}
+			f.Close()
+
+			if c.Size() != 8 {
+				t.Fatalf("\t%s\tTest %d:\tShould account for the files already cached: got %d bytes", failed, testID, c.Size())
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the files already cached.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+// Package exif provides support for reading EXIF metadata from the TIFF
+// structure a JPEG carries in its APP1 segment.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Set of error variables for reading EXIF data.
+var (
+	ErrNotFound = errors.New("no exif data found")
+	ErrInvalid  = errors.New("exif data is not valid")
+)
+
+// maxEntries bounds the number of entries read from a single IFD so corrupt
+// data can't make the parser do unbounded work.
+const maxEntries = 1000
+
+// exifHeader starts the APP1 segment holding the EXIF data.
+var exifHeader = []byte("Exif\x00\x00")
+
+// Set of JPEG markers the segment scanner needs to know about.
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+)
+
+// Set of TIFF field types.
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeSByte     = 6
+	typeUndefined = 7
+	typeSShort    = 8
+	typeSLong     = 9
+	typeSRational = 10
+	typeFloat     = 11
+	typeDouble    = 12
+)
+
+// typeSizes maps a TIFF field type to the size of one value in bytes.
+var typeSizes = map[uint16]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeSByte:     1,
+	typeUndefined: 1,
+	typeSShort:    2,
+	typeSLong:     4,
+	typeSRational: 8,
+	typeFloat:     4,
+	typeDouble:    8,
+}
+
+// Set of tags that point to other IFDs.
+const (
+	tagExifIFD = 0x8769
+	tagGPSIFD  = 0x8825
+)
+
+// Rational is a TIFF rational number.
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+// Float returns the value of the rational.
+func (r Rational) Float() float64 {
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String returns the rational as a fraction in its lowest terms, or as a
+// whole number when the denominator is one.
+func (r Rational) String() string {
+	num, den := r.Num, r.Den
+	if d := gcd(num, den); d > 1 {
+		num, den = num/d, den/d
+	}
+
+	if den == 1 {
+		return fmt.Sprintf("%d", num)
+	}
+	return fmt.Sprintf("%d/%d", num, den)
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a int64, b int64) int64 {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r Rational) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Float())
+}
+
+// Exif holds the tags read from an image by name. Values are a string, an
+// int64, a Rational or a float64, or a slice of one of the numeric types when
+// the tag holds more than one value. Tags this package doesn't know by name
+// are not kept.
+type Exif map[string]any
+
+// Decode scans a JPEG for its EXIF segment and reads the tags from it. It
+// returns ErrNotFound when the image has no EXIF data.
+func Decode(r io.Reader) (Exif, error) {
+	segment, err := findSegment(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(segment)
+}
+
+// Parse reads the tags from a TIFF structure, such as the one following the
+// EXIF header in a JPEG APP1 segment.
+func Parse(data []byte) (Exif, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalid
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrInvalid
+	}
+
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, ErrInvalid
+	}
+
+	p := parser{
+		data:    data,
+		order:   order,
+		visited: make(map[uint32]bool),
+		exif:    make(Exif),
+	}
+
+	if err := p.readIFD(order.Uint32(data[4:8]), mainTags); err != nil {
+		return nil, err
+	}
+
+	return p.exif, nil
+}
+
+// =============================================================================
+
+// String returns the named tag as a string.
+func (x Exif) String(name string) (string, bool) {
+	s, ok := x[name].(string)
+	return s, ok && s != ""
+}
+
+// Int returns the named tag as an integer. For tags with several values the
+// first one is returned.
+func (x Exif) Int(name string) (int64, bool) {
+	switch v := x[name].(type) {
+	case int64:
+		return v, true
+	case []int64:
+		return v[0], true
+	}
+	return 0, false
+}
+
+// Rational returns the named tag as a rational. For tags with several values
+// the first one is returned.
+func (x Exif) Rational(name string) (Rational, bool) {
+	switch v := x[name].(type) {
+	case Rational:
+		return v, true
+	case []Rational:
+		return v[0], true
+	}
+	return Rational{}, false
+}
+
+// Float returns the named tag as a floating point number.
+func (x Exif) Float(name string) (float64, bool) {
+	if r, ok := x.Rational(name); ok {
+		return r.Float(), true
+	}
+	switch v := x[name].(type) {
+	case float64:
+		return v, true
+	case []float64:
+		return v[0], true
+	}
+	if n, ok := x.Int(name); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// Orientation returns how the image must be rotated or flipped for display,
+// as a value between 1 and 8. Images without the tag are upright.
+func (x Exif) Orientation() int {
+	if n, ok := x.Int("Orientation"); ok && n >= 1 && n <= 8 {
+		return int(n)
+	}
+	return 1
+}
+
+// DateTimeOriginal returns when the image was captured. EXIF times are local
+// to the camera, so the time is only placed in a zone when the image records
+// its offset. Otherwise it's returned as UTC.
+func (x Exif) DateTimeOriginal() (time.Time, bool) {
+	for _, tag := range [][2]string{
+		{"DateTimeOriginal", "OffsetTimeOriginal"},
+		{"DateTimeDigitized", "OffsetTimeDigitized"},
+		{"DateTime", "OffsetTime"},
+	} {
+		s, ok := x.String(tag[0])
+		if !ok {
+			continue
+		}
+
+		if offset, ok := x.String(tag[1]); ok {
+			if t, err := time.Parse("2006:01:02 15:04:05-07:00", s+offset); err == nil {
+				return t, true
+			}
+		}
+
+		if t, err := time.Parse("2006:01:02 15:04:05", s); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// LatLong returns the GPS position of the image in decimal degrees.
+func (x Exif) LatLong() (float64, float64, bool) {
+	lat, ok := x.degrees("GPSLatitude", "S")
+	if !ok {
+		return 0, 0, false
+	}
+
+	long, ok := x.degrees("GPSLongitude", "W")
+	if !ok {
+		return 0, 0, false
+	}
+
+	return lat, long, true
+}
+
+// Altitude returns the GPS altitude of the image in meters. Altitudes below
+// sea level are negative.
+func (x Exif) Altitude() (float64, bool) {
+	alt, ok := x.Rational("GPSAltitude")
+	if !ok {
+		return 0, false
+	}
+
+	if ref, ok := x.Int("GPSAltitudeRef"); ok && ref == 1 {
+		return -alt.Float(), true
+	}
+
+	return alt.Float(), true
+}
+
+// degrees converts a GPS coordinate held as degrees, minutes and seconds to
+// decimal degrees. The coordinate is negative when its reference tag matches
+// the negative hemisphere.
+func (x Exif) degrees(name string, negative string) (float64, bool) {
+	dms, ok := x[name].([]Rational)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+
+	v := dms[0].Float() + dms[1].Float()/60 + dms[2].Float()/3600
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+
+	if ref, _ := x.String(name + "Ref"); strings.EqualFold(ref, negative) {
+		v = -v
+	}
+
+	return v, true
+}
+
+// =============================================================================
+
+// findSegment returns the TIFF structure from the EXIF APP1 segment of a
+// JPEG. Scanning stops at the start of the image data.
+func findSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return nil, ErrNotFound
+	}
+
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+
+		switch {
+		case marker == markerSOS || marker == markerEOI:
+			return nil, ErrNotFound
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01:
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, ErrNotFound
+		}
+
+		length := int(binary.BigEndian.Uint16(size[:])) - 2
+		if length < 0 {
+			return nil, ErrInvalid
+		}
+
+		if marker != markerAPP1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, ErrNotFound
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrNotFound
+		}
+
+		// APP1 also holds XMP packets so keep looking when this isn't EXIF.
+		if bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):], nil
+		}
+	}
+}
+
+// readMarker reads the next marker, skipping any fill bytes.
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, ErrInvalid
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0xff {
+			return b, nil
+		}
+	}
+}
+
+// parser reads the IFDs of a TIFF structure.
+type parser struct {
+	data    []byte
+	order   binary.ByteOrder
+	visited map[uint32]bool
+	exif    Exif
+}
+
+// readIFD reads the entries of the IFD at the offset, following the pointers
+// to the EXIF and GPS IFDs. Only the first IFD in a chain is read since the
+// next one describes the embedded thumbnail.
+func (p *parser) readIFD(offset uint32, names map[uint16]string) error {
+	if p.visited[offset] {
+		return nil
+	}
+	p.visited[offset] = true
+
+	if uint64(offset)+2 > uint64(len(p.data)) {
+		return ErrInvalid
+	}
+
+	count := uint32(p.order.Uint16(p.data[offset:]))
+	if count > maxEntries || uint64(offset)+2+uint64(count)*12 > uint64(len(p.data)) {
+		return ErrInvalid
+	}
+
+	for i := uint32(0); i < count; i++ {
+		entry := p.data[offset+2+i*12 : offset+2+(i+1)*12]
+
+		tag := p.order.Uint16(entry[0:2])
+		typ := p.order.Uint16(entry[2:4])
+		n := p.order.Uint32(entry[4:8])
+
+		raw, ok := p.value(typ, n, entry[8:12])
+		if !ok {
+			continue
+		}
+
+		if tag == tagExifIFD || tag == tagGPSIFD {
+			if typ != typeLong || n != 1 {
+				continue
+			}
+
+			sub := exifTags
+			if tag == tagGPSIFD {
+				sub = gpsTags
+			}
+
+			// A corrupt sub-IFD loses its own tags but keeps the ones
+			// already read.
+			if err := p.readIFD(p.order.Uint32(raw), sub); err != nil && !errors.Is(err, ErrInvalid) {
+				return err
+			}
+			continue
+		}
+
+		name, ok := names[tag]
+		if !ok {
+			continue
+		}
+
+		if v, ok := p.decode(typ, n, raw); ok {
+			p.exif[name] = v
+		}
+	}
+
+	return nil
+}
+
+// value returns the bytes of an entry's value. Values of four bytes or less
+// are held in the entry itself, otherwise the entry holds their offset.
+func (p *parser) value(typ uint16, n uint32, field []byte) ([]byte, bool) {
+	size, ok := typeSizes[typ]
+	if !ok {
+		return nil, false
+	}
+
+	total := uint64(size) * uint64(n)
+	if total <= 4 {
+		return field[:total], true
+	}
+
+	offset := uint64(p.order.Uint32(field))
+	if offset+total > uint64(len(p.data)) {
+		return nil, false
+	}
+
+	return p.data[offset : offset+total], true
+}
+
+// decode converts the bytes of a value to its Go representation.
+func (p *parser) decode(typ uint16, n uint32, raw []byte) (any, bool) {
+	if n == 0 {
+		return nil, false
+	}
+
+	switch typ {
+	case typeASCII:
+		return strings.TrimRight(string(raw), "\x00 "), true
+
+	case typeUndefined:
+
+		// Undefined values are mostly binary, but a few tags such as the
+		// EXIF version hold printable text.
+		for _, b := range raw {
+			if b < 0x20 || b > 0x7e {
+				return nil, false
+			}
+		}
+		return string(raw), true
+
+	case typeRational, typeSRational:
+		vs := make([]Rational, n)
+		for i := range vs {
+			num, den := p.order.Uint32(raw[i*8:]), p.order.Uint32(raw[i*8+4:])
+			if den == 0 {
+				return nil, false
+			}
+			if typ == typeRational {
+				vs[i] = Rational{Num: int64(num), Den: int64(den)}
+			} else {
+				vs[i] = Rational{Num: int64(int32(num)), Den: int64(int32(den))}
+			}
+		}
+		if n == 1 {
+			return vs[0], true
+		}
+		return vs, true
+
+	case typeFloat, typeDouble:
+		vs := make([]float64, n)
+		for i := range vs {
+			if typ == typeFloat {
+				vs[i] = float64(math.Float32frombits(p.order.Uint32(raw[i*4:])))
+			} else {
+				vs[i] = math.Float64frombits(p.order.Uint64(raw[i*8:]))
+			}
+			if math.IsNaN(vs[i]) || math.IsInf(vs[i], 0) {
+				return nil, false
+			}
+		}
+		if n == 1 {
+			return vs[0], true
+		}
+		return vs, true
+	}
+
+	vs := make([]int64, n)
+	for i := range vs {
+		switch typ {
+		case typeByte:
+			vs[i] = int64(raw[i])
+		case typeSByte:
+			vs[i] = int64(int8(raw[i]))
+		case typeShort:
+			vs[i] = int64(p.order.Uint16(raw[i*2:]))
+		case typeSShort:
+			vs[i] = int64(int16(p.order.Uint16(raw[i*2:])))
+		case typeLong:
+			vs[i] = int64(p.order.Uint32(raw[i*4:]))
+		case typeSLong:
+			vs[i] = int64(int32(p.order.Uint32(raw[i*4:])))
+		}
+	}
+	if n == 1 {
+		return vs[0], true
+	}
+	return vs, true
+}
@@ -0,0 +1,224 @@
+package exif_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fadhilijuma/images/foundation/exif"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Decode(t *testing.T) {
+	t.Log("Given the need to read EXIF data from a JPEG.")
+	{
+		for testID, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			t.Logf("\tTest %d:\tWhen handling %s EXIF data.", testID, order)
+			{
+				x, err := exif.Decode(bytes.NewReader(jpegContent(t, tiffContent(order))))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the EXIF data : %s.", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to decode the EXIF data.", success, testID)
+
+				if s, _ := x.String("Make"); s != "Acme" {
+					t.Fatalf("\t%s\tTest %d:\tShould get the camera make : got %q.", failed, testID, s)
+				}
+				if s, _ := x.String("Model"); s != "Snapper 3000" {
+					t.Fatalf("\t%s\tTest %d:\tShould get the camera model : got %q.", failed, testID, s)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the camera make and model.", success, testID)
+
+				if o := x.Orientation(); o != 6 {
+					t.Fatalf("\t%s\tTest %d:\tShould get the orientation : got %d.", failed, testID, o)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the orientation.", success, testID)
+
+				exp := time.Date(2021, time.June, 5, 14, 30, 0, 0, time.FixedZone("", 2*60*60))
+				if got, ok := x.DateTimeOriginal(); !ok || !got.Equal(exp) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the capture time : got %v.", failed, testID, got)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the capture time.", success, testID)
+
+				r, _ := x.Rational("ExposureTime")
+				f, _ := x.Float("FNumber")
+				iso, _ := x.Int("ISOSpeedRatings")
+				if r.String() != "1/250" || f != 2.8 || iso != 400 {
+					t.Fatalf("\t%s\tTest %d:\tShould get the exposure : got %s f/%v ISO %d.", failed, testID, r, f, iso)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the exposure.", success, testID)
+
+				lat, long, ok := x.LatLong()
+				if !ok || math.Abs(lat-(-33.8568)) > 1e-4 || math.Abs(long-151.2153) > 1e-4 {
+					t.Fatalf("\t%s\tTest %d:\tShould get the GPS position : got %v,%v.", failed, testID, lat, long)
+				}
+				if alt, ok := x.Altitude(); !ok || alt != 12.5 {
+					t.Fatalf("\t%s\tTest %d:\tShould get the GPS altitude : got %v.", failed, testID, alt)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the GPS position.", success, testID)
+			}
+		}
+
+		testID := 2
+		t.Logf("\tTest %d:\tWhen handling images without EXIF data.", testID)
+		{
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+				t.Fatalf("encoding jpeg: %s", err)
+			}
+
+			if _, err := exif.Decode(bytes.NewReader(buf.Bytes())); !errors.Is(err, exif.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould get ErrNotFound for a JPEG without EXIF : %v.", failed, testID, err)
+			}
+			if _, err := exif.Decode(bytes.NewReader([]byte("\x89PNG\r\n\x1a\n"))); !errors.Is(err, exif.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould get ErrNotFound for a PNG : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get ErrNotFound.", success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen handling corrupt EXIF data.", testID)
+		{
+			data := tiffContent(binary.BigEndian)
+			for i := range data {
+				exif.Parse(data[:i])
+			}
+
+			corrupt := append([]byte(nil), data...)
+			for i := 8; i < len(corrupt); i += 7 {
+				corrupt[i] = 0xff
+			}
+			exif.Parse(corrupt)
+			t.Logf("\t%s\tTest %d:\tShould not panic on truncated or corrupt data.", success, testID)
+		}
+	}
+}
+
+// =============================================================================
+
+// entry is an IFD entry for building test data.
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+// tiffContent builds a TIFF structure with an EXIF and a GPS IFD.
+func tiffContent(order binary.ByteOrder) []byte {
+	u16 := func(v uint16) []byte { b := make([]byte, 2); order.PutUint16(b, v); return b }
+	u32 := func(v uint32) []byte { b := make([]byte, 4); order.PutUint32(b, v); return b }
+	rat := func(vs ...uint32) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = append(b, u32(v)...)
+		}
+		return b
+	}
+	ascii := func(s string) entry { return entry{typ: 2, count: uint32(len(s) + 1), data: []byte(s + "\x00")} }
+	withTag := func(tag uint16, e entry) entry { e.tag = tag; return e }
+
+	exifIFD := []entry{
+		{tag: 0x829a, typ: 5, count: 1, data: rat(10, 2500)},
+		{tag: 0x829d, typ: 5, count: 1, data: rat(28, 10)},
+		{tag: 0x8827, typ: 3, count: 1, data: u16(400)},
+		withTag(0x9003, ascii("2021:06:05 14:30:00")),
+		withTag(0x9011, ascii("+02:00")),
+		{tag: 0x927c, typ: 7, count: 6, data: []byte{0, 1, 2, 3, 4, 5}},
+	}
+	gpsIFD := []entry{
+		withTag(0x0001, ascii("S")),
+		{tag: 0x0002, typ: 5, count: 3, data: rat(33, 1, 51, 1, 2448, 100)},
+		withTag(0x0003, ascii("E")),
+		{tag: 0x0004, typ: 5, count: 3, data: rat(151, 1, 12, 1, 5508, 100)},
+		{tag: 0x0005, typ: 1, count: 1, data: []byte{0}},
+		{tag: 0x0006, typ: 5, count: 1, data: rat(25, 2)},
+	}
+	mainIFD := []entry{
+		withTag(0x010f, ascii("Acme")),
+		withTag(0x0110, ascii("Snapper 3000")),
+		{tag: 0x0112, typ: 3, count: 1, data: u16(6)},
+		{tag: 0x8769, typ: 4, count: 1},
+		{tag: 0x8825, typ: 4, count: 1},
+	}
+
+	size := func(entries []entry) uint32 {
+		n := uint32(2 + len(entries)*12 + 4)
+		for _, e := range entries {
+			if len(e.data) > 4 {
+				n += uint32(len(e.data))
+			}
+		}
+		return n
+	}
+
+	mainOffset := uint32(8)
+	exifOffset := mainOffset + size(mainIFD)
+	gpsOffset := exifOffset + size(exifIFD)
+	mainIFD[3].data = u32(exifOffset)
+	mainIFD[4].data = u32(gpsOffset)
+
+	write := func(buf []byte, offset uint32, entries []entry) []byte {
+		dataOffset := offset + uint32(2+len(entries)*12+4)
+		buf = append(buf, u16(uint16(len(entries)))...)
+		var data []byte
+		for _, e := range entries {
+			buf = append(buf, u16(e.tag)...)
+			buf = append(buf, u16(e.typ)...)
+			buf = append(buf, u32(e.count)...)
+			if len(e.data) > 4 {
+				buf = append(buf, u32(dataOffset+uint32(len(data)))...)
+				data = append(data, e.data...)
+				continue
+			}
+			field := make([]byte, 4)
+			copy(field, e.data)
+			buf = append(buf, field...)
+		}
+		buf = append(buf, u32(0)...)
+		return append(buf, data...)
+	}
+
+	buf := []byte("MM")
+	if order == binary.LittleEndian {
+		buf = []byte("II")
+	}
+	buf = append(buf, u16(42)...)
+	buf = append(buf, u32(mainOffset)...)
+	buf = write(buf, mainOffset, mainIFD)
+	buf = write(buf, exifOffset, exifIFD)
+	buf = write(buf, gpsOffset, gpsIFD)
+
+	return buf
+}
+
+// jpegContent encodes a small JPEG with the TIFF structure as its EXIF
+// segment.
+func jpegContent(t *testing.T, tiff []byte) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encoding jpeg: %s", err)
+	}
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(segment)+2))
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xd8, 0xff, 0xe1})
+	buf.Write(length)
+	buf.Write(segment)
+	buf.Write(img.Bytes()[2:])
+
+	return buf.Bytes()
+}
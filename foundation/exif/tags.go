@@ -0,0 +1,74 @@
+package exif
+
+// mainTags names the tags read from the first IFD.
+var mainTags = map[uint16]string{
+	0x0100: "ImageWidth",
+	0x0101: "ImageLength",
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x011a: "XResolution",
+	0x011b: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+}
+
+// exifTags names the tags read from the EXIF IFD.
+var exifTags = map[uint16]string{
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9207: "MeteringMode",
+	0x9209: "Flash",
+	0x920a: "FocalLength",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xa001: "ColorSpace",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa402: "ExposureMode",
+	0xa403: "WhiteBalance",
+	0xa404: "DigitalZoomRatio",
+	0xa405: "FocalLengthIn35mmFilm",
+	0xa406: "SceneCaptureType",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa432: "LensSpecification",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+	0xa435: "LensSerialNumber",
+}
+
+// gpsTags names the tags read from the GPS IFD.
+var gpsTags = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x000c: "GPSSpeedRef",
+	0x000d: "GPSSpeed",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x001d: "GPSDateStamp",
+}
@@ -0,0 +1,155 @@
+// Package imaging provides support for decoding, resizing and encoding images
+// using only the standard library.
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Set of supported image formats.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// MaxPixels is the largest image, in pixels, Decode will accept. It protects
+// the service from small files that decompress into huge images.
+const MaxPixels = 100_000_000
+
+// Set of error variables for imaging operations.
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// Config describes an image without its pixels.
+type Config struct {
+	Width      int
+	Height     int
+	Format     string
+	ColorModel string
+}
+
+// DecodeConfig reads the dimensions, format and color model of a JPEG, PNG
+// or GIF image without decoding the pixels.
+func DecodeConfig(r io.Reader) (Config, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return Config{}, ErrUnsupportedFormat
+		}
+		return Config{}, fmt.Errorf("decoding config: %w", err)
+	}
+
+	c := Config{
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		Format:     format,
+		ColorModel: colorModelName(cfg.ColorModel),
+	}
+
+	return c, nil
+}
+
+// colorModelName returns a short name for the color model of an image.
+func colorModelName(m color.Model) string {
+	if _, ok := m.(color.Palette); ok {
+		return "paletted"
+	}
+
+	switch m {
+	case color.RGBAModel, color.NRGBAModel:
+		return "rgba"
+	case color.RGBA64Model, color.NRGBA64Model:
+		return "rgba64"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+
+	return "unknown"
+}
+
+// Decode reads a JPEG, PNG or GIF image. The dimensions are checked before
+// the pixels are decoded. For animated GIFs only the first frame is returned.
+func Decode(r io.ReadSeeker) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedFormat
+		}
+		return nil, "", fmt.Errorf("decoding config: %w", err)
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("seeking: %w", err)
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", format, err)
+	}
+
+	return img, format, nil
+}
+
+// Encode writes the image in the specified format. The quality, between 1
+// and 100, is only used for JPEG.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	}
+
+	return ErrUnsupportedFormat
+}
+
+// MimeType returns the MIME type for the specified format.
+func MimeType(format string) string {
+	return "image/" + format
+}
+
+// Fit returns the largest dimensions that fit inside maxWidth x maxHeight
+// while keeping the aspect ratio of width x height. Images are never scaled
+// up, so dimensions that already fit are returned unchanged.
+func Fit(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	// Scale by whichever side is the most constrained.
+	if width*maxHeight > height*maxWidth {
+		h := (height*maxWidth + width/2) / width
+		if h < 1 {
+			h = 1
+		}
+		return maxWidth, h
+	}
+
+	w := (width*maxHeight + height/2) / height
+	if w < 1 {
+		w = 1
+	}
+	return w, maxHeight
+}
@@ -0,0 +1,174 @@
+package imaging_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/fadhilijuma/images/foundation/imaging"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Fit(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		expWidth, expHeight                int
+	}{
+		{4000, 3000, 640, 640, 640, 480},
+		{3000, 4000, 640, 640, 480, 640},
+		{100, 50, 640, 640, 100, 50},
+		{1000, 1, 100, 100, 100, 1},
+		{2000, 1000, 200, 50, 100, 50},
+	}
+
+	t.Log("Given the need to fit images inside a bounding box.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen fitting %dx%d inside %dx%d.", testID, tt.width, tt.height, tt.maxWidth, tt.maxHeight)
+			{
+				w, h := imaging.Fit(tt.width, tt.height, tt.maxWidth, tt.maxHeight)
+				if w != tt.expWidth || h != tt.expHeight {
+					t.Fatalf("\t%s\tTest %d:\tShould get %dx%d: got %dx%d", failed, testID, tt.expWidth, tt.expHeight, w, h)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get %dx%d.", success, testID, tt.expWidth, tt.expHeight)
+			}
+		}
+	}
+}
+
+func Test_Resize(t *testing.T) {
+	t.Log("Given the need to resize images.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen resizing a solid image.", testID)
+		{
+			want := color.RGBA{R: 200, G: 80, B: 40, A: 255}
+			src := solid(300, 200, want)
+
+			for _, size := range [][2]int{{150, 100}, {37, 11}, {600, 400}} {
+				dst := imaging.Resize(src, size[0], size[1])
+
+				if b := dst.Bounds(); b.Dx() != size[0] || b.Dy() != size[1] {
+					t.Fatalf("\t%s\tTest %d:\tShould get a %dx%d image: got %v", failed, testID, size[0], size[1], b)
+				}
+
+				for _, pt := range []image.Point{{0, 0}, {size[0] / 2, size[1] / 2}, {size[0] - 1, size[1] - 1}} {
+					if got := dst.RGBAAt(pt.X, pt.Y); got != want {
+						t.Fatalf("\t%s\tTest %d:\tShould keep the color at %v: got %v", failed, testID, pt, got)
+					}
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the requested size and keep the color.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen reducing an image with two halves.", testID)
+		{
+			src := solid(100, 10, color.RGBA{A: 255})
+			for y := 0; y < 10; y++ {
+				for x := 50; x < 100; x++ {
+					src.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+				}
+			}
+
+			dst := imaging.Resize(src, 10, 1)
+			if l, r := dst.RGBAAt(0, 0).R, dst.RGBAAt(9, 0).R; l != 0 || r != 255 {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the halves apart: got %d and %d", failed, testID, l, r)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the halves apart.", success, testID)
+		}
+	}
+}
+
+func Test_Cover(t *testing.T) {
+	t.Log("Given the need to crop images to cover a size.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen covering a square with a wide image.", testID)
+		{
+			// The outer quarters are black and the middle half is white, so
+			// cropping the sides away should leave only white.
+			src := solid(400, 100, color.RGBA{A: 255})
+			for y := 0; y < 100; y++ {
+				for x := 100; x < 300; x++ {
+					src.Set(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+				}
+			}
+
+			dst := imaging.Cover(src, 50, 50)
+			if b := dst.Bounds(); b.Dx() != 50 || b.Dy() != 50 {
+				t.Fatalf("\t%s\tTest %d:\tShould get a 50x50 image: got %v", failed, testID, b)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a 50x50 image.", success, testID)
+
+			for _, x := range []int{0, 25, 49} {
+				if got := dst.RGBAAt(x, 25).R; got != 255 {
+					t.Fatalf("\t%s\tTest %d:\tShould crop the sides away: got %d at x %d", failed, testID, got, x)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould crop the sides away.", success, testID)
+		}
+	}
+}
+
+func Test_Codec(t *testing.T) {
+	t.Log("Given the need to encode and decode images.")
+	{
+		for testID, format := range []string{imaging.FormatJPEG, imaging.FormatPNG, imaging.FormatGIF} {
+			t.Logf("\tTest %d:\tWhen handling the %s format.", testID, format)
+			{
+				var buf bytes.Buffer
+				if err := imaging.Encode(&buf, solid(40, 30, color.RGBA{R: 10, G: 20, B: 30, A: 255}), format, 80); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to encode: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to encode.", success, testID)
+
+				img, got, err := imaging.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode: %v", failed, testID, err)
+				}
+				if got != format || img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+					t.Fatalf("\t%s\tTest %d:\tShould get back a 40x30 %s: got %s %v", failed, testID, format, got, img.Bounds())
+				}
+				t.Logf("\t%s\tTest %d:\tShould get back a 40x30 %s.", success, testID, format)
+
+				cfg, err := imaging.DecodeConfig(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the config: %v", failed, testID, err)
+				}
+				if cfg.Format != format || cfg.Width != 40 || cfg.Height != 30 || cfg.ColorModel == "unknown" {
+					t.Fatalf("\t%s\tTest %d:\tShould get back the config of a 40x30 %s: got %+v", failed, testID, format, cfg)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get back the config of a 40x30 %s.", success, testID, format)
+			}
+		}
+
+		testID := 3
+		t.Logf("\tTest %d:\tWhen handling content that isn't an image.", testID)
+		{
+			if _, _, err := imaging.Decode(strings.NewReader("plain text")); !errors.Is(err, imaging.ErrUnsupportedFormat) {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the content: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the content.", success, testID)
+		}
+	}
+}
+
+// solid constructs an image of a single color.
+func solid(width int, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
@@ -0,0 +1,175 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Resize scales the image to width x height. It uses a triangle filter that
+// widens with the scale factor, so every source pixel contributes when an
+// image is reduced and edges stay smooth when it's enlarged.
+func Resize(src image.Image, width int, height int) *image.RGBA {
+	b := src.Bounds()
+
+	// Work from premultiplied RGBA pixels regardless of the source model.
+	rgba, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	}
+
+	// Scale horizontally into an intermediate image and then vertically.
+	tmp := resizeAxis(rgba, width, b.Dy(), true)
+	return resizeAxis(tmp, width, height, false)
+}
+
+// weight is the contribution of a source pixel to a destination pixel.
+type weight struct {
+	index  int
+	weight float64
+}
+
+// resizeAxis resamples the image along one axis.
+func resizeAxis(src *image.RGBA, width int, height int, horizontal bool) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	srcLen, dstLen := src.Bounds().Dy(), height
+	if horizontal {
+		srcLen, dstLen = src.Bounds().Dx(), width
+	}
+
+	weights := filterWeights(srcLen, dstLen)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float64
+
+			pos := y
+			if horizontal {
+				pos = x
+			}
+
+			for _, w := range weights[pos] {
+				var off int
+				if horizontal {
+					off = src.PixOffset(w.index, y)
+				} else {
+					off = src.PixOffset(x, w.index)
+				}
+				p := src.Pix[off : off+4 : off+4]
+				r += float64(p[0]) * w.weight
+				g += float64(p[1]) * w.weight
+				b += float64(p[2]) * w.weight
+				a += float64(p[3]) * w.weight
+			}
+
+			off := dst.PixOffset(x, y)
+			p := dst.Pix[off : off+4 : off+4]
+			p[0] = clamp(r)
+			p[1] = clamp(g)
+			p[2] = clamp(b)
+			p[3] = clamp(a)
+		}
+	}
+
+	return dst
+}
+
+// filterWeights calculates, for every destination position, the normalized
+// triangle filter weights of the source positions that contribute to it.
+func filterWeights(srcLen int, dstLen int) [][]weight {
+	scale := float64(srcLen) / float64(dstLen)
+	support := math.Max(scale, 1)
+
+	weights := make([][]weight, dstLen)
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+
+		var sum float64
+		var ws []weight
+		for j := first; j <= last; j++ {
+			w := 1 - math.Abs(float64(j)-center)/support
+			if w <= 0 {
+				continue
+			}
+
+			// Clamp to the edge so border pixels keep their full weight.
+			idx := j
+			if idx < 0 {
+				idx = 0
+			}
+			if idx >= srcLen {
+				idx = srcLen - 1
+			}
+
+			ws = append(ws, weight{index: idx, weight: w})
+			sum += w
+		}
+
+		// A destination pixel always takes at least the nearest source pixel.
+		if len(ws) == 0 {
+			idx := int(math.Round(center))
+			if idx < 0 {
+				idx = 0
+			}
+			if idx >= srcLen {
+				idx = srcLen - 1
+			}
+			ws = append(ws, weight{index: idx, weight: 1})
+			sum = 1
+		}
+
+		for k := range ws {
+			ws[k].weight /= sum
+		}
+		weights[i] = ws
+	}
+
+	return weights
+}
+
+// clamp converts an accumulated channel value back to a byte.
+func clamp(v float64) uint8 {
+	v = math.Round(v)
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return uint8(v)
+}
+
+// Cover scales the image so it covers width x height and crops the overflow
+// evenly from both sides, so the result is exactly width x height.
+func Cover(src image.Image, width int, height int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	// Find the largest centered region of the source with the aspect ratio
+	// of the result.
+	cw, ch := sw, sh
+	if sw*height > sh*width {
+		cw = (sh*width + height/2) / height
+	} else {
+		ch = (sw*height + width/2) / width
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+
+	x0 := b.Min.X + (sw-cw)/2
+	y0 := b.Min.Y + (sh-ch)/2
+
+	crop := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(crop, crop.Bounds(), src, image.Pt(x0, y0), draw.Src)
+
+	return Resize(crop, width, height)
+}
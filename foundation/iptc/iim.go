@@ -0,0 +1,288 @@
+package iptc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// Set of Photoshop image resources the package works with.
+const (
+	resourceIIM       = 0x0404
+	resourceIIMDigest = 0x0425
+)
+
+// Set of IIM datasets in the application record.
+const (
+	dsRecordVersion = 0
+	dsKeywords      = 25
+	dsInstructions  = 40
+	dsByline        = 80
+	dsCity          = 90
+	dsCountry       = 101
+	dsHeadline      = 105
+	dsCredit        = 110
+	dsCopyright     = 116
+	dsCaption       = 120
+)
+
+// Set of IIM records.
+const (
+	recordEnvelope    = 1
+	recordApplication = 2
+)
+
+// dsCharset is the envelope dataset declaring the character set.
+const dsCharset = 90
+
+// charsetUTF8 is the ISO 2022 escape sequence declaring UTF-8 text.
+var charsetUTF8 = []byte{0x1b, 0x25, 0x47}
+
+// resource is a Photoshop image resource block.
+type resource struct {
+	id   uint16
+	name []byte
+	data []byte
+}
+
+// parseResources reads the image resource blocks of a Photoshop APP13
+// segment. Reading stops at the first block that isn't well formed.
+func parseResources(data []byte) []resource {
+	var resources []resource
+
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:6])
+
+		// The name is a Pascal string padded to an even length.
+		nameLen := int(data[6])
+		nameSize := 1 + nameLen
+		if nameSize%2 != 0 {
+			nameSize++
+		}
+		if 6+nameSize+4 > len(data) {
+			break
+		}
+		name := data[7 : 7+nameLen]
+
+		pos := 6 + nameSize
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			break
+		}
+
+		resources = append(resources, resource{id: id, name: name, data: data[pos : pos+size]})
+
+		pos += size
+		if size%2 != 0 {
+			pos++
+		}
+		if pos > len(data) {
+			break
+		}
+		data = data[pos:]
+	}
+
+	return resources
+}
+
+// encodeResources writes image resource blocks.
+func encodeResources(resources []resource) []byte {
+	var buf bytes.Buffer
+
+	for _, res := range resources {
+		buf.WriteString("8BIM")
+		binary.Write(&buf, binary.BigEndian, res.id)
+
+		buf.WriteByte(byte(len(res.name)))
+		buf.Write(res.name)
+		if (1+len(res.name))%2 != 0 {
+			buf.WriteByte(0)
+		}
+
+		binary.Write(&buf, binary.BigEndian, uint32(len(res.data)))
+		buf.Write(res.data)
+		if len(res.data)%2 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// replaceIIM returns the resources with the IIM data replaced. The digest
+// Photoshop keeps of the IIM data is updated so readers know the IIM and XMP
+// were written together.
+func replaceIIM(resources []resource, iim []byte) []resource {
+	digest := md5.Sum(iim)
+
+	out := []resource{
+		{id: resourceIIM, data: iim},
+		{id: resourceIIMDigest, data: digest[:]},
+	}
+	for _, res := range resources {
+		if res.id != resourceIIM && res.id != resourceIIMDigest {
+			out = append(out, res)
+		}
+	}
+
+	return out
+}
+
+// dataset is a single IIM record.
+type dataset struct {
+	record byte
+	number byte
+	data   []byte
+}
+
+// parseIIM reads the datasets of IIM data. Reading stops at the first
+// dataset that isn't well formed.
+func parseIIM(data []byte) []dataset {
+	var datasets []dataset
+
+	for len(data) >= 5 && data[0] == 0x1c {
+		record, number := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+		pos := 5
+
+		// The top bit marks an extended dataset, where the size gives the
+		// number of bytes holding the real length.
+		if size&0x8000 != 0 {
+			n := size & 0x7fff
+			if n > 4 || pos+n > len(data) {
+				break
+			}
+			size = 0
+			for _, b := range data[pos : pos+n] {
+				size = size<<8 | int(b)
+			}
+			pos += n
+		}
+
+		if size < 0 || pos+size > len(data) {
+			break
+		}
+
+		datasets = append(datasets, dataset{record: record, number: number, data: data[pos : pos+size]})
+		data = data[pos+size:]
+	}
+
+	return datasets
+}
+
+// decodeIIM maps IIM datasets to the metadata fields.
+func decodeIIM(data []byte) Metadata {
+	datasets := parseIIM(data)
+
+	utf8Text := false
+	for _, ds := range datasets {
+		if ds.record == recordEnvelope && ds.number == dsCharset && bytes.Equal(ds.data, charsetUTF8) {
+			utf8Text = true
+		}
+	}
+
+	var md Metadata
+	var bylines []string
+
+	for _, ds := range datasets {
+		if ds.record != recordApplication {
+			continue
+		}
+
+		v := text(ds.data, utf8Text)
+		switch ds.number {
+		case dsCaption:
+			md.Caption = v
+		case dsHeadline:
+			md.Headline = v
+		case dsByline:
+			bylines = append(bylines, v)
+		case dsCredit:
+			md.Credit = v
+		case dsCopyright:
+			md.Copyright = v
+		case dsKeywords:
+			if v != "" {
+				md.Keywords = append(md.Keywords, v)
+			}
+		case dsCity:
+			md.City = v
+		case dsCountry:
+			md.Country = v
+		case dsInstructions:
+			md.Instructions = v
+		}
+	}
+
+	md.Byline = strings.Join(bylines, ", ")
+
+	return md
+}
+
+// encodeIIM writes the metadata fields as IIM datasets in UTF-8. IIM has no
+// dataset for usage terms so they are only written to XMP.
+func encodeIIM(md Metadata) []byte {
+	var buf bytes.Buffer
+
+	writeDataset(&buf, recordEnvelope, dsCharset, charsetUTF8)
+	writeDataset(&buf, recordApplication, dsRecordVersion, []byte{0, 4})
+
+	for _, f := range []struct {
+		number byte
+		value  string
+	}{
+		{dsHeadline, md.Headline},
+		{dsCaption, md.Caption},
+		{dsByline, md.Byline},
+		{dsCredit, md.Credit},
+		{dsCopyright, md.Copyright},
+		{dsCity, md.City},
+		{dsCountry, md.Country},
+		{dsInstructions, md.Instructions},
+	} {
+		if f.value != "" {
+			writeDataset(&buf, recordApplication, f.number, []byte(f.value))
+		}
+	}
+
+	for _, kw := range md.Keywords {
+		writeDataset(&buf, recordApplication, dsKeywords, []byte(kw))
+	}
+
+	return buf.Bytes()
+}
+
+// writeDataset writes a single IIM dataset, using the extended form for
+// data too long for a standard one.
+func writeDataset(buf *bytes.Buffer, record byte, number byte, data []byte) {
+	buf.Write([]byte{0x1c, record, number})
+
+	if len(data) < 0x8000 {
+		binary.Write(buf, binary.BigEndian, uint16(len(data)))
+	} else {
+		binary.Write(buf, binary.BigEndian, uint16(0x8004))
+		binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	}
+
+	buf.Write(data)
+}
+
+// text converts IIM data to a string. Data not declared as UTF-8 is read as
+// UTF-8 when it's valid and as Latin-1 otherwise, which covers what most
+// tools write.
+func text(data []byte, utf8Text bool) string {
+	data = bytes.TrimRight(data, "\x00")
+
+	if utf8Text || utf8.Valid(data) {
+		return strings.TrimSpace(string(data))
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.TrimSpace(string(runes))
+}
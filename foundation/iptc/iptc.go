@@ -0,0 +1,263 @@
+// Package iptc provides support for reading and writing the IPTC photo
+// metadata a JPEG carries. The metadata is held both as IPTC-IIM records in
+// the Photoshop APP13 segment and as XMP in an APP1 segment.
+package iptc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Set of error variables for reading and writing metadata.
+var (
+	ErrNotJPEG  = errors.New("content is not a jpeg")
+	ErrTooLarge = errors.New("metadata is too large for a jpeg segment")
+)
+
+// Set of JPEG markers the segment scanner needs to know about.
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP13 = 0xed
+)
+
+// maxSegment is the largest payload a JPEG segment can hold.
+const maxSegment = 0xffff - 2
+
+// Set of headers that identify the segments holding metadata.
+var (
+	photoshopHeader   = []byte("Photoshop 3.0\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// Metadata holds the editorial fields of the IPTC photo metadata standard.
+type Metadata struct {
+	Caption      string
+	Headline     string
+	Byline       string
+	Credit       string
+	Copyright    string
+	Keywords     []string
+	City         string
+	Country      string
+	Instructions string
+	UsageTerms   string
+}
+
+// Decode reads the IPTC-IIM and XMP metadata from a JPEG. When a field is
+// set in both, the XMP value is used since it's the newer of the two
+// standards and the one most tools keep up to date. A JPEG without metadata
+// returns an empty Metadata.
+func Decode(r io.Reader) (Metadata, error) {
+	var iim, xmp Metadata
+
+	fn := func(marker byte, payload []byte) error {
+		switch {
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			for _, res := range parseResources(payload[len(photoshopHeader):]) {
+				if res.id == resourceIIM {
+					iim = decodeIIM(res.data)
+				}
+			}
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			md, err := decodeXMP(payload[len(xmpHeader):])
+			if err != nil {
+				return fmt.Errorf("decoding xmp: %w", err)
+			}
+			xmp = md
+		}
+		return nil
+	}
+
+	if err := scan(bufio.NewReader(r), fn); err != nil {
+		return Metadata{}, err
+	}
+
+	return merge(iim, xmp), nil
+}
+
+// Embed copies a JPEG from r to w with its metadata replaced by md. Both an
+// IPTC-IIM and an XMP representation are written. Other Photoshop resources
+// in the APP13 segment are kept, but the XMP packet is written from md alone
+// so XMP properties this package doesn't know about are not carried over.
+func Embed(w io.Writer, r io.Reader, md Metadata) error {
+	xmp := append(append([]byte(nil), xmpHeader...), encodeXMP(md)...)
+	if len(xmp) > maxSegment {
+		return ErrTooLarge
+	}
+
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var resources []resource
+	var segments []segment
+
+	fn := func(marker byte, payload []byte) error {
+		switch {
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			if resources == nil {
+				resources = parseResources(payload[len(photoshopHeader):])
+			}
+		case marker == markerAPP1 && (bytes.HasPrefix(payload, xmpHeader) || bytes.HasPrefix(payload, xmpExtendedHeader)):
+		default:
+			segments = append(segments, segment{marker: marker, payload: append([]byte(nil), payload...)})
+		}
+		return nil
+	}
+
+	if err := scan(br, fn); err != nil {
+		return err
+	}
+
+	app13 := append(append([]byte(nil), photoshopHeader...), encodeResources(replaceIIM(resources, encodeIIM(md)))...)
+	if len(app13) > maxSegment {
+		return ErrTooLarge
+	}
+
+	bw.Write([]byte{0xff, markerSOI})
+
+	// The metadata goes after the leading APPn segments, which keeps any
+	// JFIF or EXIF segment at the front where readers expect it.
+	written := false
+	for _, seg := range segments {
+		if !written && (seg.marker < 0xe0 || seg.marker > 0xef) {
+			writeSegment(bw, markerAPP1, xmp)
+			writeSegment(bw, markerAPP13, app13)
+			written = true
+		}
+
+		if seg.marker == markerSOS {
+			bw.Write([]byte{0xff, seg.marker})
+			bw.Write(seg.payload)
+			continue
+		}
+		writeSegment(bw, seg.marker, seg.payload)
+	}
+
+	if _, err := io.Copy(bw, br); err != nil {
+		return fmt.Errorf("copying image data: %w", err)
+	}
+
+	return bw.Flush()
+}
+
+// =============================================================================
+
+// merge returns the IIM metadata with the fields set in the XMP metadata
+// taking precedence.
+func merge(iim Metadata, xmp Metadata) Metadata {
+	md := iim
+
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&md.Caption, xmp.Caption},
+		{&md.Headline, xmp.Headline},
+		{&md.Byline, xmp.Byline},
+		{&md.Credit, xmp.Credit},
+		{&md.Copyright, xmp.Copyright},
+		{&md.City, xmp.City},
+		{&md.Country, xmp.Country},
+		{&md.Instructions, xmp.Instructions},
+		{&md.UsageTerms, xmp.UsageTerms},
+	} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+
+	if len(xmp.Keywords) > 0 {
+		md.Keywords = xmp.Keywords
+	}
+
+	return md
+}
+
+// segment is a JPEG segment read ahead of the image data. The start of scan
+// segment holds its header in the payload with the length included.
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// scan calls fn for every segment of a JPEG up to and including the start of
+// scan segment, after which r is positioned at the entropy coded image data.
+func scan(r *bufio.Reader, fn func(marker byte, payload []byte) error) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return ErrNotJPEG
+	}
+
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return ErrNotJPEG
+		}
+
+		if marker == markerEOI || (marker >= 0xd0 && marker <= 0xd7) || marker == 0x01 {
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return ErrNotJPEG
+		}
+
+		length := int(binary.BigEndian.Uint16(size[:])) - 2
+		if length < 0 {
+			return ErrNotJPEG
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return ErrNotJPEG
+		}
+
+		if marker == markerSOS {
+			return fn(marker, append(size[:], payload...))
+		}
+
+		if err := fn(marker, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// readMarker reads the next marker, skipping any fill bytes.
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, ErrNotJPEG
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != 0xff {
+			return b, nil
+		}
+	}
+}
+
+// writeSegment writes a marker segment with its length.
+func writeSegment(w *bufio.Writer, marker byte, payload []byte) {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(payload)+2))
+
+	w.Write([]byte{0xff, marker})
+	w.Write(size[:])
+	w.Write(payload)
+}
@@ -0,0 +1,167 @@
+package iptc_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/fadhilijuma/images/foundation/iptc"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Embed(t *testing.T) {
+	t.Log("Given the need to write editorial metadata into a JPEG.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen embedding metadata and reading it back.", testID)
+		{
+			md := iptc.Metadata{
+				Caption:      "Crowds gather in the Place de la Concorde <Paris> & beyond.",
+				Headline:     "Crowds gather",
+				Byline:       "Jean Dupont",
+				Credit:       "AFP",
+				Copyright:    "© 2022 AFP",
+				Keywords:     []string{"france", "protest", "société"},
+				City:         "Paris",
+				Country:      "France",
+				Instructions: "EMBARGOED until 06:00 GMT",
+				UsageTerms:   "Editorial use only",
+			}
+
+			var buf bytes.Buffer
+			if err := iptc.Embed(&buf, bytes.NewReader(jpegContent(t)), md); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to embed the metadata : %s.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to embed the metadata.", success, testID)
+
+			if _, err := jpeg.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still be a valid JPEG : %s.", failed, testID, err)
+			}
+			if !bytes.Contains(buf.Bytes(), []byte("Exif\x00\x00")) {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the EXIF segment.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould still be a valid JPEG with its EXIF segment.", success, testID)
+
+			got, err := iptc.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the metadata : %s.", failed, testID, err)
+			}
+			if diff := cmp.Diff(md, got); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same metadata. Diff:\n%s", failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the same metadata.", success, testID)
+
+			md.Caption = "Corrected caption"
+			md.Keywords = nil
+
+			var again bytes.Buffer
+			if err := iptc.Embed(&again, bytes.NewReader(buf.Bytes()), md); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to embed the metadata again : %s.", failed, testID, err)
+			}
+
+			got, err = iptc.Decode(bytes.NewReader(again.Bytes()))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the metadata again : %s.", failed, testID, err)
+			}
+			if diff := cmp.Diff(md, got); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould replace the existing metadata. Diff:\n%s", failed, testID, diff)
+			}
+			if n := bytes.Count(again.Bytes(), []byte("Photoshop 3.0")); n != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould hold a single APP13 segment : got %d.", failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould replace the existing metadata.", success, testID)
+		}
+	}
+}
+
+func Test_Decode(t *testing.T) {
+	t.Log("Given the need to read editorial metadata from a JPEG.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the XMP properties are written as attributes.", testID)
+		{
+			packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"
+ photoshop:City="Lyon" photoshop:Credit="Getty Images">
+ <dc:description><rdf:Alt><rdf:li xml:lang="fr">Légende</rdf:li><rdf:li xml:lang="x-default">Caption</rdf:li></rdf:Alt></dc:description>
+ <dc:creator><rdf:Seq><rdf:li>A. Smith</rdf:li><rdf:li>B. Jones</rdf:li></rdf:Seq></dc:creator>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+			got, err := iptc.Decode(bytes.NewReader(withSegment(t, 0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), packet...))))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the metadata : %s.", failed, testID, err)
+			}
+
+			exp := iptc.Metadata{Caption: "Caption", Byline: "A. Smith, B. Jones", Credit: "Getty Images", City: "Lyon"}
+			if diff := cmp.Diff(exp, got); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the metadata. Diff:\n%s", failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the metadata.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the IIM records are in Latin-1.", testID)
+		{
+			iim := []byte{0x1c, 2, 90, 0, 6}
+			iim = append(iim, "Z\xfcrich"...)
+			iim = append(iim, 0x1c, 2, 25, 0, 4)
+			iim = append(iim, "snow"...)
+
+			app13 := []byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00")
+			app13 = append(app13, 0, 0, 0, byte(len(iim)))
+			app13 = append(app13, iim...)
+
+			got, err := iptc.Decode(bytes.NewReader(withSegment(t, 0xed, app13)))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the metadata : %s.", failed, testID, err)
+			}
+			if got.City != "Zürich" || len(got.Keywords) != 1 || got.Keywords[0] != "snow" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the metadata : got %+v.", failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the metadata.", success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the content isn't a JPEG.", testID)
+		{
+			if _, err := iptc.Decode(strings.NewReader("\x89PNG\r\n\x1a\n")); !errors.Is(err, iptc.ErrNotJPEG) {
+				t.Fatalf("\t%s\tTest %d:\tShould get ErrNotJPEG : %v.", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get ErrNotJPEG.", success, testID)
+		}
+	}
+}
+
+// =============================================================================
+
+// jpegContent encodes a small JPEG with an EXIF segment.
+func jpegContent(t *testing.T) []byte {
+	return withSegment(t, 0xe1, []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"))
+}
+
+// withSegment encodes a small JPEG with the segment added after the start of
+// image marker.
+func withSegment(t *testing.T, marker byte, payload []byte) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encoding jpeg: %s", err)
+	}
+
+	size := len(payload) + 2
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xd8, 0xff, marker, byte(size >> 8), byte(size)})
+	buf.Write(payload)
+	buf.Write(img.Bytes()[2:])
+
+	return buf.Bytes()
+}
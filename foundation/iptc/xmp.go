@@ -0,0 +1,260 @@
+package iptc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Set of XML namespaces used by the XMP properties the package works with.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsXMPRights = "http://ns.adobe.com/xap/1.0/rights/"
+)
+
+// property is an XMP property the package maps to a metadata field.
+type property struct {
+	name  xml.Name
+	kind  string
+	field func(md *Metadata) *string
+}
+
+// Set of kinds of XMP property values.
+const (
+	kindText = ""
+	kindAlt  = "Alt"
+	kindSeq  = "Seq"
+	kindBag  = "Bag"
+)
+
+// properties maps XMP properties to the metadata fields in the order they
+// are written. Keywords are handled on their own since they're a list.
+var properties = []property{
+	{xml.Name{Space: nsDC, Local: "description"}, kindAlt, func(md *Metadata) *string { return &md.Caption }},
+	{xml.Name{Space: nsPhotoshop, Local: "Headline"}, kindText, func(md *Metadata) *string { return &md.Headline }},
+	{xml.Name{Space: nsDC, Local: "creator"}, kindSeq, func(md *Metadata) *string { return &md.Byline }},
+	{xml.Name{Space: nsPhotoshop, Local: "Credit"}, kindText, func(md *Metadata) *string { return &md.Credit }},
+	{xml.Name{Space: nsDC, Local: "rights"}, kindAlt, func(md *Metadata) *string { return &md.Copyright }},
+	{xml.Name{Space: nsPhotoshop, Local: "City"}, kindText, func(md *Metadata) *string { return &md.City }},
+	{xml.Name{Space: nsPhotoshop, Local: "Country"}, kindText, func(md *Metadata) *string { return &md.Country }},
+	{xml.Name{Space: nsPhotoshop, Local: "Instructions"}, kindText, func(md *Metadata) *string { return &md.Instructions }},
+	{xml.Name{Space: nsXMPRights, Local: "UsageTerms"}, kindAlt, func(md *Metadata) *string { return &md.UsageTerms }},
+}
+
+// keywords is the XMP property holding the keywords.
+var keywords = xml.Name{Space: nsDC, Local: "subject"}
+
+// prefixes maps the namespaces to the prefixes used when writing XMP.
+var prefixes = []struct {
+	prefix string
+	space  string
+}{
+	{"dc", nsDC},
+	{"photoshop", nsPhotoshop},
+	{"xmpRights", nsXMPRights},
+}
+
+// decodeXMP reads the metadata fields from an XMP packet. Properties can be
+// written as attributes of an rdf:Description or as child elements holding
+// either text or an rdf:Alt, rdf:Seq or rdf:Bag of rdf:li items.
+func decodeXMP(packet []byte) (Metadata, error) {
+	values := make(map[xml.Name][]string)
+
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	d.Strict = false
+
+	var (
+		depth     int
+		descDepth int
+		prop      *xml.Name
+		propDepth int
+		text      strings.Builder
+		inItem    bool
+		isDefault bool
+	)
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return Metadata{}, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+
+			switch {
+			case t.Name.Space == nsRDF && t.Name.Local == "Description" && prop == nil:
+				descDepth = depth
+				for _, attr := range t.Attr {
+					values[attr.Name] = append(values[attr.Name], strings.TrimSpace(attr.Value))
+				}
+
+			case descDepth != 0 && depth == descDepth+1:
+				name := t.Name
+				prop = &name
+				propDepth = depth
+				text.Reset()
+
+			case prop != nil && t.Name.Space == nsRDF && t.Name.Local == "li":
+				inItem = true
+				isDefault = false
+				text.Reset()
+				for _, attr := range t.Attr {
+					if attr.Name.Space == nsXML && attr.Name.Local == "lang" && attr.Value == "x-default" {
+						isDefault = true
+					}
+				}
+			}
+
+		case xml.CharData:
+			if prop != nil {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			switch {
+			case inItem && t.Name.Space == nsRDF && t.Name.Local == "li":
+				v := strings.TrimSpace(text.String())
+				if isDefault {
+					values[*prop] = append([]string{v}, values[*prop]...)
+				} else {
+					values[*prop] = append(values[*prop], v)
+				}
+				inItem = false
+				text.Reset()
+
+			case prop != nil && depth == propDepth:
+				if _, ok := values[*prop]; !ok {
+					if v := strings.TrimSpace(text.String()); v != "" {
+						values[*prop] = []string{v}
+					}
+				}
+				prop = nil
+
+			case depth == descDepth:
+				descDepth = 0
+			}
+
+			depth--
+		}
+	}
+
+	var md Metadata
+
+	for _, p := range properties {
+		vs := values[p.name]
+		if len(vs) == 0 {
+			continue
+		}
+
+		if p.kind == kindSeq {
+			*p.field(&md) = strings.Join(nonEmpty(vs), ", ")
+			continue
+		}
+		*p.field(&md) = vs[0]
+	}
+
+	md.Keywords = nonEmpty(values[keywords])
+
+	return md, nil
+}
+
+// encodeXMP writes the metadata fields as an XMP packet.
+func encodeXMP(md Metadata) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	buf.WriteString(" <rdf:RDF xmlns:rdf=\"" + nsRDF + "\">\n")
+	buf.WriteString("  <rdf:Description rdf:about=\"\"")
+	for _, p := range prefixes {
+		buf.WriteString("\n    xmlns:" + p.prefix + "=\"" + p.space + "\"")
+	}
+	buf.WriteString(">\n")
+
+	for _, p := range properties {
+		v := *p.field(&md)
+		if v == "" {
+			continue
+		}
+
+		switch p.kind {
+		case kindAlt:
+			writeContainer(&buf, p.name, kindAlt, []string{v})
+		case kindSeq:
+			writeContainer(&buf, p.name, kindSeq, []string{v})
+		default:
+			writeElement(&buf, p.name, v)
+		}
+	}
+
+	if len(md.Keywords) > 0 {
+		writeContainer(&buf, keywords, kindBag, md.Keywords)
+	}
+
+	buf.WriteString("  </rdf:Description>\n")
+	buf.WriteString(" </rdf:RDF>\n")
+	buf.WriteString("</x:xmpmeta>\n")
+	buf.WriteString("<?xpacket end=\"w\"?>")
+
+	return buf.Bytes()
+}
+
+// writeElement writes a simple text property.
+func writeElement(buf *bytes.Buffer, name xml.Name, value string) {
+	tag := qualified(name)
+
+	buf.WriteString("   <" + tag + ">")
+	xml.EscapeText(buf, []byte(value))
+	buf.WriteString("</" + tag + ">\n")
+}
+
+// writeContainer writes a property holding an rdf:Alt, rdf:Seq or rdf:Bag.
+// Alternatives are written in the default language.
+func writeContainer(buf *bytes.Buffer, name xml.Name, kind string, items []string) {
+	tag := qualified(name)
+
+	buf.WriteString("   <" + tag + ">\n")
+	buf.WriteString("    <rdf:" + kind + ">\n")
+	for _, item := range items {
+		if kind == kindAlt {
+			buf.WriteString("     <rdf:li xml:lang=\"x-default\">")
+		} else {
+			buf.WriteString("     <rdf:li>")
+		}
+		xml.EscapeText(buf, []byte(item))
+		buf.WriteString("</rdf:li>\n")
+	}
+	buf.WriteString("    </rdf:" + kind + ">\n")
+	buf.WriteString("   </" + tag + ">\n")
+}
+
+// qualified returns the prefixed name of an element.
+func qualified(name xml.Name) string {
+	for _, p := range prefixes {
+		if p.space == name.Space {
+			return p.prefix + ":" + name.Local
+		}
+	}
+	return name.Local
+}
+
+// nonEmpty returns the values that aren't empty.
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,168 @@
+package phash
+
+import "sort"
+
+// Item is a hash along with the ID of what was hashed.
+type Item struct {
+	ID   string
+	Hash uint64
+}
+
+// Match is an item found near a hash.
+type Match struct {
+	Item
+	Distance int
+}
+
+// BKTree indexes hashes by their Hamming distance so the hashes near a given
+// one are found without comparing against all of them. Every child of a node
+// sits at a known distance from it, and by the triangle inequality only the
+// children within the search distance of that can hold matches. A BKTree is
+// not safe for concurrent use.
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+// bkNode holds the items with one hash and the subtrees of hashes at each
+// distance from it.
+type bkNode struct {
+	hash     uint64
+	ids      []string
+	children map[int]*bkNode
+}
+
+// NewBKTree constructs a tree holding the items.
+func NewBKTree(items ...Item) *BKTree {
+	var t BKTree
+	for _, item := range items {
+		t.Add(item)
+	}
+	return &t
+}
+
+// Len returns the number of items in the tree.
+func (t *BKTree) Len() int {
+	return t.size
+}
+
+// Add puts an item into the tree.
+func (t *BKTree) Add(item Item) {
+	t.size++
+
+	if t.root == nil {
+		t.root = &bkNode{hash: item.Hash, ids: []string{item.ID}}
+		return
+	}
+
+	node := t.root
+	for {
+		d := Distance(node.hash, item.Hash)
+		if d == 0 {
+			node.ids = append(node.ids, item.ID)
+			return
+		}
+
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{hash: item.Hash, ids: []string{item.ID}}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns the items within maxDistance of the hash, nearest first.
+func (t *BKTree) Search(hash uint64, maxDistance int) []Match {
+	if t.root == nil {
+		return nil
+	}
+
+	var matches []Match
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := Distance(node.hash, hash)
+		if d <= maxDistance {
+			for _, id := range node.ids {
+				matches = append(matches, Match{Item: Item{ID: id, Hash: node.hash}, Distance: d})
+			}
+		}
+
+		for cd, child := range node.children {
+			if cd >= d-maxDistance && cd <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	return matches
+}
+
+// Cluster groups the items into sets of near-duplicates. Two items are in the
+// same set when a chain of items, each within maxDistance of the next, links
+// them. Items with nothing near them are left out. Sets are returned largest
+// first and keep the order of the items.
+func Cluster(items []Item, maxDistance int) [][]Item {
+	index := make(map[string]int, len(items))
+	for i, item := range items {
+		index[item.ID] = i
+	}
+
+	// Union the sets of every pair of items near each other.
+	parent := make([]int, len(items))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	tree := NewBKTree(items...)
+	for i, item := range items {
+		for _, m := range tree.Search(item.Hash, maxDistance) {
+			if a, b := find(i), find(index[m.ID]); a != b {
+				parent[b] = a
+			}
+		}
+	}
+
+	sets := make(map[int][]Item)
+	var roots []int
+	for i, item := range items {
+		root := find(i)
+		if _, ok := sets[root]; !ok {
+			roots = append(roots, root)
+		}
+		sets[root] = append(sets[root], item)
+	}
+
+	var clusters [][]Item
+	for _, root := range roots {
+		if len(sets[root]) > 1 {
+			clusters = append(clusters, sets[root])
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i]) > len(clusters[j])
+	})
+
+	return clusters
+}
@@ -0,0 +1,141 @@
+// Package phash computes perceptual hashes of images and finds the hashes
+// that are near each other. Unlike a checksum, a perceptual hash barely
+// changes when an image is resized, recompressed or color corrected, so the
+// number of bits two hashes differ in tells how alike the images look.
+package phash
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/fadhilijuma/images/foundation/imaging"
+)
+
+// Set of hash sizes. Images are reduced to a small gray square before they
+// are hashed, which throws away the detail that recompression changes.
+const (
+	dhashSize = 8
+	phashSize = 32
+	phashLow  = 8
+)
+
+// DHash returns the difference hash of the image. Each bit records whether a
+// pixel of a 9x8 gray reduction is brighter than its right neighbour, which
+// follows the gradients of the image.
+func DHash(img image.Image) uint64 {
+	gray := reduce(img, dhashSize+1, dhashSize)
+
+	var hash uint64
+	for y := 0; y < dhashSize; y++ {
+		for x := 0; x < dhashSize; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// PHash returns the DCT hash of the image. The image is reduced to a 32x32
+// gray square and transformed into frequencies, and each bit records whether
+// one of the 8x8 lowest frequencies is above their median. Low frequencies
+// hold the structure of the image, so the hash survives changes to contrast,
+// color and fine detail.
+func PHash(img image.Image) uint64 {
+	gray := reduce(img, phashSize, phashSize)
+
+	// The DCT is separable, so transform the rows and then the columns of the
+	// low frequencies that are kept.
+	rows := make([][]float64, phashSize)
+	for y := range rows {
+		rows[y] = dct(gray[y], phashLow)
+	}
+
+	var coeffs []float64
+	col := make([]float64, phashSize)
+	low := make([][]float64, phashLow)
+	for x := 0; x < phashLow; x++ {
+		for y := 0; y < phashSize; y++ {
+			col[y] = rows[y][x]
+		}
+		low[x] = dct(col, phashLow)
+	}
+	for v := 0; v < phashLow; v++ {
+		for u := 0; u < phashLow; u++ {
+			coeffs = append(coeffs, low[u][v])
+		}
+	}
+
+	// The first coefficient is the average brightness, which would skew the
+	// median, so it's left out.
+	median := medianOf(coeffs[1:])
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+
+	return hash
+}
+
+// Distance returns the number of bits the hashes differ in, from 0 for the
+// same hash to 64.
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// =============================================================================
+
+// reduce scales the image to width x height and returns the luma of every
+// pixel, by row.
+func reduce(img image.Image, width int, height int) [][]float64 {
+	small := imaging.Resize(img, width, height)
+
+	gray := make([][]float64, height)
+	for y := range gray {
+		gray[y] = make([]float64, width)
+		for x := range gray[y] {
+			off := small.PixOffset(x, y)
+			p := small.Pix[off : off+3 : off+3]
+			gray[y][x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+
+	return gray
+}
+
+// dct returns the first n coefficients of the type II discrete cosine
+// transform of the values.
+func dct(values []float64, n int) []float64 {
+	size := float64(len(values))
+
+	coeffs := make([]float64, n)
+	for k := range coeffs {
+		var sum float64
+		for i, v := range values {
+			sum += v * math.Cos(math.Pi/size*(float64(i)+0.5)*float64(k))
+		}
+		coeffs[k] = sum
+	}
+
+	return coeffs
+}
+
+// medianOf returns the median of the values without reordering them.
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
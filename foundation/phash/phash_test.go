@@ -0,0 +1,177 @@
+package phash_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/fadhilijuma/images/foundation/imaging"
+	"github.com/fadhilijuma/images/foundation/phash"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_Hash(t *testing.T) {
+	original := scene(640, 480, 0)
+
+	// A smaller, recompressed copy of the same scene.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, imaging.Resize(original, 320, 240), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatalf("encoding jpeg: %s", err)
+	}
+	copied, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decoding jpeg: %s", err)
+	}
+
+	other := scene(640, 480, 1)
+
+	hashes := []struct {
+		name string
+		fn   func(image.Image) uint64
+	}{
+		{"dhash", phash.DHash},
+		{"phash", phash.PHash},
+	}
+
+	t.Log("Given the need to hash images by how they look.")
+	{
+		for testID, h := range hashes {
+			t.Logf("\tTest %d:\tWhen using the %s.", testID, h.name)
+			{
+				if d := phash.Distance(h.fn(original), h.fn(copied)); d > 6 {
+					t.Fatalf("\t%s\tTest %d:\tShould hash a resized and recompressed copy closely : distance %d.", failed, testID, d)
+				}
+				t.Logf("\t%s\tTest %d:\tShould hash a resized and recompressed copy closely.", success, testID)
+
+				if d := phash.Distance(h.fn(original), h.fn(other)); d < 16 {
+					t.Fatalf("\t%s\tTest %d:\tShould hash a different image apart : distance %d.", failed, testID, d)
+				}
+				t.Logf("\t%s\tTest %d:\tShould hash a different image apart.", success, testID)
+			}
+		}
+	}
+}
+
+func Test_BKTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	items := make([]phash.Item, 2000)
+	for i := range items {
+		items[i] = phash.Item{ID: fmt.Sprint(i), Hash: rnd.Uint64()}
+	}
+
+	// Add near copies of the first item so there's something to find.
+	for i := 0; i < 5; i++ {
+		items = append(items, phash.Item{ID: fmt.Sprintf("copy%d", i), Hash: items[0].Hash ^ (1 << (i * 7))})
+	}
+
+	tree := phash.NewBKTree(items...)
+
+	t.Log("Given the need to find the hashes near a hash.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen searching a tree.", testID)
+		{
+			if tree.Len() != len(items) {
+				t.Fatalf("\t%s\tTest %d:\tShould hold every item : got %d.", failed, testID, tree.Len())
+			}
+
+			for _, maxDistance := range []int{0, 1, 10, 24} {
+				want := make(map[string]int)
+				for _, item := range items {
+					if d := phash.Distance(item.Hash, items[0].Hash); d <= maxDistance {
+						want[item.ID] = d
+					}
+				}
+
+				matches := tree.Search(items[0].Hash, maxDistance)
+				if len(matches) != len(want) {
+					t.Fatalf("\t%s\tTest %d:\tShould find %d items within %d : got %d.", failed, testID, len(want), maxDistance, len(matches))
+				}
+				for i, m := range matches {
+					if d, ok := want[m.ID]; !ok || d != m.Distance {
+						t.Fatalf("\t%s\tTest %d:\tShould find %s at distance %d : got %d.", failed, testID, m.ID, d, m.Distance)
+					}
+					if i > 0 && matches[i-1].Distance > m.Distance {
+						t.Fatalf("\t%s\tTest %d:\tShould order the matches nearest first.", failed, testID)
+					}
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the same items as comparing against all of them.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen clustering items.", testID)
+		{
+			items := []phash.Item{
+				{ID: "a", Hash: 0x0},
+				{ID: "b", Hash: 0xFFFF_FFFF_FFFF_FFFF},
+				{ID: "c", Hash: 0x3},
+				{ID: "d", Hash: 0xF},
+				{ID: "e", Hash: 0xFFFF_FFFF_FFFF_FFFE},
+				{ID: "f", Hash: 0x00FF_00FF_00FF_00FF},
+			}
+
+			clusters := phash.Cluster(items, 2)
+			if len(clusters) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould get 2 clusters : got %d.", failed, testID, len(clusters))
+			}
+			if got := ids(clusters[0]); got != "a,c,d" {
+				t.Fatalf("\t%s\tTest %d:\tShould chain items near each other : got %s.", failed, testID, got)
+			}
+			if got := ids(clusters[1]); got != "b,e" {
+				t.Fatalf("\t%s\tTest %d:\tShould cluster b and e : got %s.", failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould cluster items near each other.", success, testID)
+		}
+	}
+}
+
+// scene draws a picture of shapes that differs with the variant.
+func scene(width int, height int, variant int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+
+			var v float64
+			switch variant {
+			case 0:
+				v = fx*0.6 + fy*0.4
+				if (fx-0.3)*(fx-0.3)+(fy-0.5)*(fy-0.5) < 0.04 {
+					v = 1 - v
+				}
+			default:
+				v = 1 - fy
+				if fx > 0.6 && fy > 0.2 && fy < 0.7 {
+					v = 0.1
+				}
+			}
+
+			c := uint8(v * 255)
+			img.Set(x, y, color.RGBA{R: c, G: c / 2, B: 255 - c, A: 255})
+		}
+	}
+	return img
+}
+
+// ids joins the IDs of the items.
+func ids(items []phash.Item) string {
+	var s string
+	for i, item := range items {
+		if i > 0 {
+			s += ","
+		}
+		s += item.ID
+	}
+	return s
+}
@@ -15,6 +15,7 @@ const key ctxKey = 1
 // Values represent state for each request.
 type Values struct {
 	TraceID    string
+	ClientIP   string
 	Now        time.Time
 	StatusCode int
 }
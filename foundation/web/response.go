@@ -3,7 +3,9 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
+	"time"
 )
 
 // Respond converts a Go value to JSON and sends it to the client.
@@ -35,3 +37,46 @@ func Respond(ctx context.Context, w http.ResponseWriter, data any, statusCode in
 
 	return nil
 }
+
+// RespondStream copies content to the client with the specified content type.
+func RespondStream(ctx context.Context, w http.ResponseWriter, content io.Reader, contentType string, statusCode int) error {
+	// Set the status code for the request logger middleware.
+	SetStatusCode(ctx, statusCode)
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+
+	if _, err := io.Copy(w, content); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RespondContent serves content that supports HTTP caching. Validators such
+// as the ETag header must be set by the caller before calling. The response
+// honors If-None-Match, If-Modified-Since and If-Range with 304 responses,
+// and single or multiple byte ranges with 206 responses.
+func RespondContent(ctx context.Context, w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType string, modTime time.Time) error {
+	w.Header().Set("Content-Type", contentType)
+
+	sw := statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	http.ServeContent(&sw, r, "", modTime, content)
+
+	// Set the status code for the request logger middleware.
+	SetStatusCode(ctx, sw.statusCode)
+
+	return nil
+}
+
+// statusWriter records the status code written to a response.
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	sw.statusCode = statusCode
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Success and failure markers.
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func Test_RespondContent(t *testing.T) {
+	const content = "0123456789abcdef"
+	const etag = `"abc123"`
+	modTime := time.Date(2022, time.March, 24, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		statusCode int
+		body       string
+		mediaType  string
+	}{
+		{"full content", nil, http.StatusOK, content, "image/jpeg"},
+		{"matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified, "", ""},
+		{"other etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK, content, "image/jpeg"},
+		{"not modified since", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, http.StatusNotModified, "", ""},
+		{"modified since", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, content, "image/jpeg"},
+		{"single range", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345", "image/jpeg"},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "def", "image/jpeg"},
+		{"multiple ranges", map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusPartialContent, "", "multipart/byteranges"},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=100-200"}, http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"stale if-range", map[string]string{"Range": "bytes=2-5", "If-Range": `"other"`}, http.StatusOK, content, "image/jpeg"},
+	}
+
+	t.Log("Given the need to serve content with HTTP caching.")
+	{
+		for testID, tt := range tests {
+			t.Logf("\tTest %d:\tWhen handling a request for %s.", testID, tt.name)
+			{
+				v := Values{Now: time.Now()}
+				ctx := context.WithValue(context.Background(), key, &v)
+
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				for k, hv := range tt.headers {
+					r.Header.Set(k, hv)
+				}
+				w := httptest.NewRecorder()
+				w.Header().Set("ETag", etag)
+
+				if err := RespondContent(ctx, w, r, strings.NewReader(content), "image/jpeg", modTime); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to respond : %s.", failed, testID, err)
+				}
+
+				if w.Code != tt.statusCode || v.StatusCode != tt.statusCode {
+					t.Fatalf("\t%s\tTest %d:\tShould get a %d status : got %d, recorded %d.", failed, testID, tt.statusCode, w.Code, v.StatusCode)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get a %d status.", success, testID, tt.statusCode)
+
+				if tt.body != "" && w.Body.String() != tt.body {
+					t.Fatalf("\t%s\tTest %d:\tShould get the content : got %q.", failed, testID, w.Body.String())
+				}
+				if tt.mediaType != "" && !strings.HasPrefix(w.Header().Get("Content-Type"), tt.mediaType) {
+					t.Fatalf("\t%s\tTest %d:\tShould get a %s response : got %s.", failed, testID, tt.mediaType, w.Header().Get("Content-Type"))
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected content.", success, testID)
+			}
+		}
+	}
+}
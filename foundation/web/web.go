@@ -3,6 +3,7 @@ package web
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"syscall"
@@ -84,8 +85,9 @@ func (a *App) Handle(method string, group string, path string, handler Handler,
 		// Set the context with the required values to
 		// process the request.
 		v := Values{
-			TraceID: span.SpanContext().TraceID().String(),
-			Now:     time.Now().UTC(),
+			TraceID:  span.SpanContext().TraceID().String(),
+			ClientIP: clientIP(r),
+			Now:      time.Now().UTC(),
 		}
 		ctx = context.WithValue(ctx, key, &v)
 
@@ -102,3 +104,13 @@ func (a *App) Handle(method string, group string, path string, handler Handler,
 	}
 	a.mux.Handle(method, finalPath, h)
 }
+
+// clientIP returns the address of the client connected to the server. Headers
+// such as X-Forwarded-For are not honored, since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}